@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var apiTokensDir = flag.String("api-tokens-dir", "", "Directory with service API tokens, name of the file being the client name and its contents the token. If empty, API is not authenticated.")
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	// Recorded as the actor in the audit log if API is not authenticated.
+	anonymousAPIClient = "api"
+)
+
+type apiClientKey struct{}
+
+// authenticateAPI checks bearer token of the request against the tokens
+// of the known clients. Tokens are read on every request so that they can
+// be rotated by updating the mounted secret.
+func authenticateAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *apiTokensDir == "" {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiClientKey{}, anonymousAPIClient)))
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			writeAPIError(w, errUnauthorized)
+			return
+		}
+		client, err := findAPIClient(*apiTokensDir, token)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		if client == "" {
+			writeAPIError(w, errUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiClientKey{}, client)))
+	})
+}
+
+var errUnauthorized = &requestError{http.StatusUnauthorized, "unauthorized"}
+
+// findAPIClient returns name of the client the token was issued to. Missing
+// directory means no tokens were issued and the API is disabled.
+func findAPIClient(dir, token string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		// Skip bookkeeping entries of the mounted Kubernetes secrets.
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		expected, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return "", err
+		}
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(string(expected))), []byte(token)) == 1 {
+			return e.Name(), nil
+		}
+	}
+	return "", nil
+}
+
+func apiClient(r *http.Request) string {
+	if c, ok := r.Context().Value(apiClientKey{}).(string); ok {
+		return c
+	}
+	return anonymousAPIClient
+}
+
+func (s *Server) registerAPIHandlers(r *mux.Router) {
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(authenticateAPI)
+	api.HandleFunc("/audit", s.apiAuditHandler).Methods(http.MethodGet)
+	api.HandleFunc("/groups", s.apiListGroupsHandler).Methods(http.MethodGet)
+	api.HandleFunc("/groups", s.apiCreateGroupHandler).Methods(http.MethodPost)
+	api.HandleFunc("/groups/{group}", s.apiGetGroupHandler).Methods(http.MethodGet)
+	api.HandleFunc("/groups/{group}/members", s.apiAddUserHandler(Member)).Methods(http.MethodPost)
+	api.HandleFunc("/groups/{group}/members/{user}", s.apiRemoveUserHandler(Member)).Methods(http.MethodDelete)
+	api.HandleFunc("/groups/{group}/owners", s.apiAddUserHandler(Owner)).Methods(http.MethodPost)
+	api.HandleFunc("/groups/{group}/owners/{user}", s.apiRemoveUserHandler(Owner)).Methods(http.MethodDelete)
+	api.HandleFunc("/groups/{group}/child-groups", s.apiAddChildGroupHandler).Methods(http.MethodPost)
+	api.HandleFunc("/groups/{group}/child-groups/{child}", s.apiRemoveChildGroupHandler).Methods(http.MethodDelete)
+	api.HandleFunc("/groups/{group}/owner-groups", s.apiAddOwnerGroupHandler).Methods(http.MethodPost)
+	api.HandleFunc("/groups/{group}/join-requests", s.apiGetJoinRequestsHandler).Methods(http.MethodGet)
+	api.HandleFunc("/groups/{group}/join-requests", s.apiAddJoinRequestHandler).Methods(http.MethodPost)
+	api.HandleFunc("/groups/{group}/join-requests/{user}/approve", s.apiApproveJoinRequestHandler).Methods(http.MethodPost)
+	api.HandleFunc("/groups/{group}/join-requests/{user}", s.apiDenyJoinRequestHandler).Methods(http.MethodDelete)
+	api.HandleFunc("/groups/{group}/invitations", s.apiGetInvitationsHandler).Methods(http.MethodGet)
+	api.HandleFunc("/groups/{group}/invitations", s.apiCreateInvitationHandler).Methods(http.MethodPost)
+	api.HandleFunc("/groups/{group}/invitations/{token}", s.apiRevokeInvitationHandler).Methods(http.MethodDelete)
+	api.HandleFunc("/invitations/{token}/accept", s.apiAcceptInvitationHandler).Methods(http.MethodPost)
+	api.HandleFunc("/users/{user}/groups", s.apiUserGroupsHandler).Methods(http.MethodGet)
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	writeJSON(w, errorStatus(err), apiError{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// nonNil makes sure empty lists are encoded as [] rather than null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+func decodeRequest(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errInvalid("invalid request: %s", err)
+	}
+	return nil
+}
+
+// decodeOptionalRequest leaves v untouched if request has no body.
+func decodeOptionalRequest(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return errInvalid("invalid request: %s", err)
+	}
+	return nil
+}
+
+// requireGroup fails with not found error, as store does not enforce
+// foreign keys on every backend.
+func (s *Server) requireGroup(group string) error {
+	if err := isValidGroupName(group); err != nil {
+		return errInvalid("%s", err)
+	}
+	exists, err := s.store.DoesGroupExist(group)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errNotFound("group with the name '%s' not found", group)
+	}
+	return nil
+}
+
+type groupList struct {
+	Groups []Group `json:"groups"`
+	Total  int     `json:"total"`
+	// Offset to request the next page from, omitted on the last page.
+	Next int `json:"next,omitempty"`
+}
+
+func parsePage(r *http.Request) (int, int, error) {
+	offset, limit := 0, defaultPageSize
+	if v := r.FormValue("offset"); v != "" {
+		o, err := strconv.Atoi(v)
+		if err != nil || o < 0 {
+			return 0, 0, errInvalid("invalid offset: %s", v)
+		}
+		offset = o
+	}
+	if v := r.FormValue("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 {
+			return 0, 0, errInvalid("invalid limit: %s", v)
+		}
+		limit = min(l, maxPageSize)
+	}
+	return offset, limit, nil
+}
+
+func (s *Server) apiListGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	groups, total, err := s.store.ListGroups(offset, limit)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	resp := groupList{Groups: groups, Total: total}
+	if offset+len(groups) < total {
+		resp.Next = offset + len(groups)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type createGroupRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Owner       string `json:"owner"`
+}
+
+func (s *Server) apiCreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	var req createGroupRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if err := isValidGroupName(req.Name); err != nil {
+		writeAPIError(w, errInvalid("%s", err))
+		return
+	}
+	owner := strings.ToLower(req.Owner)
+	if owner == "" {
+		writeAPIError(w, errInvalid("owner is required"))
+		return
+	}
+	group := Group{req.Name, req.Description}
+	if err := s.audit(apiClient(r), ActionCreateGroup, group.Name, group.Name, func(st Store) error {
+		return st.CreateGroup(owner, group)
+	}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, group)
+}
+
+type groupDetails struct {
+	Group
+	Owners  []string `json:"owners"`
+	Members []string `json:"members"`
+	// Active memberships along with their expiry dates.
+	Memberships      []Membership `json:"memberships"`
+	OwnerGroups      []Group      `json:"ownerGroups"`
+	ChildGroups      []Group      `json:"childGroups"`
+	ParentGroups     []Group      `json:"parentGroups"`
+	TransitiveGroups []Group      `json:"transitiveGroups"`
+}
+
+func (s *Server) apiGetGroupHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["group"]
+	if err := s.requireGroup(name); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	ret := groupDetails{Group: Group{Name: name}}
+	var err error
+	if ret.Description, err = s.store.GetGroupDescription(name); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if ret.Owners, err = s.store.GetGroupOwners(name); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if ret.Memberships, err = s.store.GetGroupMemberships(name); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	for _, m := range ret.Memberships {
+		ret.Members = append(ret.Members, m.User)
+	}
+	if ret.OwnerGroups, err = s.store.GetGroupOwnerGroups(name); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if ret.ChildGroups, err = s.store.GetDirectChildrenGroups(name); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if ret.ParentGroups, err = s.store.GetGroupsGroupBelongsTo(name); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if ret.TransitiveGroups, err = s.store.GetAllTransitiveGroupsForGroup(name); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	ret.Owners = nonNil(ret.Owners)
+	ret.Members = nonNil(ret.Members)
+	ret.Memberships = nonNil(ret.Memberships)
+	ret.ParentGroups = nonNil(ret.ParentGroups)
+	ret.ChildGroups = nonNil(ret.ChildGroups)
+	ret.TransitiveGroups = nonNil(ret.TransitiveGroups)
+	writeJSON(w, http.StatusOK, ret)
+}
+
+type userRequest struct {
+	User string `json:"user"`
+	// Optional, only memberships can expire.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (s *Server) apiAddUserHandler(status Status) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		group := mux.Vars(r)["group"]
+		if err := s.requireGroup(group); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		var req userRequest
+		if err := decodeRequest(r, &req); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		user := strings.ToLower(req.User)
+		if user == "" {
+			writeAPIError(w, errInvalid("user is required"))
+			return
+		}
+		expiresAt, err := requestExpiresAt(req.ExpiresAt)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		var action AuditAction
+		var add func(st Store) error
+		switch status {
+		case Owner:
+			if !expiresAt.IsZero() {
+				writeAPIError(w, errInvalid("only memberships can expire"))
+				return
+			}
+			add = func(st Store) error { return st.AddGroupOwner(user, group) }
+			action = ActionAddOwner
+		case Member:
+			add = func(st Store) error { return st.AddGroupMember(user, group, expiresAt) }
+			action = ActionAddMember
+		}
+		if err := s.audit(apiClient(r), action, user, group, add); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (s *Server) apiRemoveUserHandler(status Status) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		group := vars["group"]
+		user := strings.ToLower(vars["user"])
+		if err := s.requireGroup(group); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		table, action := "user_to_group", ActionRemoveMember
+		if status == Owner {
+			table, action = "owners", ActionRemoveOwner
+		}
+		if err := s.audit(apiClient(r), action, user, group, func(st Store) error {
+			return st.RemoveUserFromTable(user, group, table)
+		}); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type groupRequest struct {
+	Group string `json:"group"`
+}
+
+func (s *Server) apiAddChildGroupHandler(w http.ResponseWriter, r *http.Request) {
+	parent := mux.Vars(r)["group"]
+	var req groupRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if err := isValidGroupName(req.Group); err != nil {
+		writeAPIError(w, errInvalid("%s", err))
+		return
+	}
+	if err := s.audit(apiClient(r), ActionAddChildGroup, req.Group, parent, func(st Store) error {
+		return st.AddChildGroup(parent, req.Group)
+	}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) apiRemoveChildGroupHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	parent, child := vars["group"], vars["child"]
+	if err := s.audit(apiClient(r), ActionRemoveChildGroup, child, parent, func(st Store) error {
+		return st.RemoveFromGroupToGroup(parent, child)
+	}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiAddOwnerGroupHandler(w http.ResponseWriter, r *http.Request) {
+	owned := mux.Vars(r)["group"]
+	var req groupRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if err := isValidGroupName(req.Group); err != nil {
+		writeAPIError(w, errInvalid("%s", err))
+		return
+	}
+	if err := s.audit(apiClient(r), ActionAddOwnerGroup, req.Group, owned, func(st Store) error {
+		return st.AddOwnerGroup(req.Group, owned)
+	}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+type userGroups struct {
+	Owner      []Group `json:"owner"`
+	Member     []Group `json:"member"`
+	Transitive []Group `json:"transitive"`
+}
+
+func (s *Server) apiUserGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	user := strings.ToLower(mux.Vars(r)["user"])
+	var ret userGroups
+	var err error
+	if ret.Owner, err = s.store.GetGroupsOwnedBy(user); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if ret.Member, err = s.store.GetGroupsUserBelongsTo(user); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if ret.Transitive, err = s.store.GetAllTransitiveGroupsForUser(user); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	ret.Transitive = nonNil(ret.Transitive)
+	writeJSON(w, http.StatusOK, ret)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newTestAPI(t *testing.T) http.Handler {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewSQLiteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{store}
+	r := mux.NewRouter()
+	s.registerAPIHandlers(r)
+	return r
+}
+
+func apiRequest(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAPIGroups(t *testing.T) {
+	h := newTestAPI(t)
+	for _, g := range []string{"c", "a", "b"} {
+		if rr := apiRequest(t, h, http.MethodPost, "/api/groups", `{"name": "`+g+`", "owner": "Admin"}`); rr.Code != http.StatusCreated {
+			t.Fatalf("Expected group to be created, got %d %s", rr.Code, rr.Body)
+		}
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups", `{"name": "a", "owner": "admin"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("Expected conflict, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups", `{"name": "A!", "owner": "admin"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected bad request, got %d", rr.Code)
+	}
+	rr := apiRequest(t, h, http.MethodGet, "/api/groups?limit=2", "")
+	var page groupList
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 3 || len(page.Groups) != 2 || page.Groups[0].Name != "a" || page.Next != 2 {
+		t.Fatalf("Unexpected page: %+v", page)
+	}
+	rr = apiRequest(t, h, http.MethodGet, "/api/groups?limit=2&offset=2", "")
+	page = groupList{}
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Groups) != 1 || page.Groups[0].Name != "c" || page.Next != 0 {
+		t.Fatalf("Unexpected page: %+v", page)
+	}
+}
+
+func TestAPIMemberships(t *testing.T) {
+	h := newTestAPI(t)
+	for _, g := range []string{"parent", "child"} {
+		if rr := apiRequest(t, h, http.MethodPost, "/api/groups", `{"name": "`+g+`", "owner": "admin"}`); rr.Code != http.StatusCreated {
+			t.Fatalf("Expected group to be created, got %d %s", rr.Code, rr.Body)
+		}
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups/missing/members", `{"user": "foo"}`); rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected not found, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups/child/members", `{"user": "foo"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected member to be added, got %d %s", rr.Code, rr.Body)
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups/child/members", `{"user": "foo"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("Expected conflict, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups/parent/child-groups", `{"group": "child"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected child group to be added, got %d %s", rr.Code, rr.Body)
+	}
+	rr := apiRequest(t, h, http.MethodGet, "/api/users/foo/groups", "")
+	var groups userGroups
+	if err := json.NewDecoder(rr.Body).Decode(&groups); err != nil {
+		t.Fatal(err)
+	}
+	if len(groups.Member) != 1 || len(groups.Transitive) != 2 || len(groups.Owner) != 0 {
+		t.Fatalf("Unexpected groups: %+v", groups)
+	}
+	if rr := apiRequest(t, h, http.MethodDelete, "/api/groups/child/owners/admin", ""); rr.Code != http.StatusConflict {
+		t.Fatalf("Expected removing last owner to fail, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodDelete, "/api/groups/child/members/foo", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected member to be removed, got %d %s", rr.Code, rr.Body)
+	}
+	if rr := apiRequest(t, h, http.MethodDelete, "/api/groups/child/members/foo", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected not found, got %d", rr.Code)
+	}
+	rr = apiRequest(t, h, http.MethodGet, "/api/audit?group=child", "")
+	var log auditLog
+	if err := json.NewDecoder(rr.Body).Decode(&log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Entries) != 3 || log.Entries[0].Action != ActionRemoveMember || log.Entries[0].Actor != anonymousAPIClient {
+		t.Fatalf("Unexpected audit log: %+v", log)
+	}
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "installer"), []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	*apiTokensDir = dir
+	defer func() { *apiTokensDir = "" }()
+	h := newTestAPI(t)
+	req := httptest.NewRequest(http.MethodGet, "/api/groups", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected unauthorized, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups", `{"name": "a", "owner": "admin"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected group to be created, got %d %s", rr.Code, rr.Body)
+	}
+	rr = apiRequest(t, h, http.MethodGet, "/api/audit", "")
+	var log auditLog
+	if err := json.NewDecoder(rr.Body).Decode(&log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Entries) != 1 || log.Entries[0].Actor != "installer" {
+		t.Fatalf("Unexpected audit log: %+v", log)
+	}
+}
+
+func TestAPIDisabledWithoutTokens(t *testing.T) {
+	*apiTokensDir = filepath.Join(t.TempDir(), "api-tokens")
+	defer func() { *apiTokensDir = "" }()
+	h := newTestAPI(t)
+	if rr := apiRequest(t, h, http.MethodGet, "/api/groups", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected unauthorized, got %d", rr.Code)
+	}
+}
+
+func TestAPIJoinRequests(t *testing.T) {
+	h := newTestAPI(t)
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups", `{"name": "a", "owner": "admin"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected group to be created, got %d %s", rr.Code, rr.Body)
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups/a/members", `{"user": "foo", "expiresAt": "2000-01-01T00:00:00Z"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected bad request, got %d", rr.Code)
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups/a/join-requests", `{"user": "foo"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected join request to be created, got %d %s", rr.Code, rr.Body)
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups/a/join-requests/foo/approve", `{"expiresAt": "2100-01-01T00:00:00Z"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected join request to be approved, got %d %s", rr.Code, rr.Body)
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/groups/a/join-requests/foo/approve", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected not found, got %d", rr.Code)
+	}
+	rr := apiRequest(t, h, http.MethodGet, "/api/groups/a", "")
+	var details groupDetails
+	if err := json.NewDecoder(rr.Body).Decode(&details); err != nil {
+		t.Fatal(err)
+	}
+	if len(details.Memberships) != 1 || details.Memberships[0].ExpiresAt == nil || details.Memberships[0].ExpiresAt.Year() != 2100 {
+		t.Fatalf("Unexpected memberships: %+v", details.Memberships)
+	}
+	rr = apiRequest(t, h, http.MethodPost, "/api/groups/a/invitations", "")
+	var inv Invitation
+	if err := json.NewDecoder(rr.Body).Decode(&inv); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusCreated || inv.Token == "" {
+		t.Fatalf("Expected invitation to be created, got %d %+v", rr.Code, inv)
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/invitations/"+inv.Token+"/accept", `{"user": "bar"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected invitation to be accepted, got %d %s", rr.Code, rr.Body)
+	}
+	if rr := apiRequest(t, h, http.MethodDelete, "/api/groups/a/invitations/"+inv.Token, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected invitation to be revoked, got %d %s", rr.Code, rr.Body)
+	}
+	if rr := apiRequest(t, h, http.MethodPost, "/api/invitations/"+inv.Token+"/accept", `{"user": "baz"}`); rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected not found, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	ActionInit             AuditAction = "init"
+	ActionCreateGroup      AuditAction = "create-group"
+	ActionAddMember        AuditAction = "add-member"
+	ActionRemoveMember     AuditAction = "remove-member"
+	ActionAddOwner         AuditAction = "add-owner"
+	ActionRemoveOwner      AuditAction = "remove-owner"
+	ActionAddChildGroup    AuditAction = "add-child-group"
+	ActionRemoveChildGroup AuditAction = "remove-child-group"
+	ActionAddOwnerGroup    AuditAction = "add-owner-group"
+	ActionExpireMember     AuditAction = "expire-member"
+	ActionRequestJoin      AuditAction = "request-join"
+	ActionApproveJoin      AuditAction = "approve-join"
+	ActionDenyJoin         AuditAction = "deny-join"
+	ActionCreateInvitation AuditAction = "create-invitation"
+	ActionRevokeInvitation AuditAction = "revoke-invitation"
+	ActionAcceptInvitation AuditAction = "accept-invitation"
+)
+
+// AuditEntry records single mutation: actor performed action on subject,
+// user or group, within the group.
+type AuditEntry struct {
+	Id        int64       `json:"id"`
+	Timestamp time.Time   `json:"timestamp"`
+	Actor     string      `json:"actor"`
+	Action    AuditAction `json:"action"`
+	Subject   string      `json:"subject"`
+	Group     string      `json:"group"`
+}
+
+// AuditQuery filters audit log entries. Entries are returned newest first,
+// Before is the id to continue listing from.
+type AuditQuery struct {
+	Group  string
+	User   string
+	Before int64
+	Limit  int
+}
+
+const (
+	defaultAuditLimit   = 50
+	maxAuditLimit       = 500
+	groupPageAuditLimit = 20
+)
+
+func (s *SQLStore) AddAuditEntry(e AuditEntry) error {
+	query := `INSERT INTO audit_log (created_at, actor, action, subject, group_name) VALUES (?, ?, ?, ?, ?)`
+	_, err := s.exec(query, e.Timestamp.Unix(), e.Actor, string(e.Action), e.Subject, e.Group)
+	return err
+}
+
+func (s *SQLStore) GetAuditLog(q AuditQuery) ([]AuditEntry, error) {
+	conds := []string{}
+	args := []any{}
+	if q.Group != "" {
+		conds = append(conds, "group_name = ?")
+		args = append(args, q.Group)
+	}
+	if q.User != "" {
+		conds = append(conds, "(actor = ? OR subject = ?)")
+		args = append(args, q.User, q.User)
+	}
+	if q.Before > 0 {
+		conds = append(conds, "id < ?")
+		args = append(args, q.Before)
+	}
+	query := "SELECT id, created_at, actor, action, subject, group_name FROM audit_log"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, q.Limit)
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var ts int64
+		var action string
+		if err := rows.Scan(&e.Id, &ts, &e.Actor, &action, &e.Subject, &e.Group); err != nil {
+			return nil, err
+		}
+		e.Timestamp = time.Unix(ts, 0).UTC()
+		e.Action = AuditAction(action)
+		ret = append(ret, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// audit performs mutation and records it in the audit log within the same
+// transaction, so that neither persists without the other.
+func (s *Server) audit(actor string, action AuditAction, subject, group string, mutate func(Store) error) error {
+	return s.store.Transaction(func(st Store) error {
+		if err := mutate(st); err != nil {
+			return err
+		}
+		return addAuditEntry(st, actor, action, subject, group)
+	})
+}
+
+func addAuditEntry(st Store, actor string, action AuditAction, subject, group string) error {
+	return st.AddAuditEntry(AuditEntry{
+		Timestamp: time.Now(),
+		Actor:     actor,
+		Action:    action,
+		Subject:   subject,
+		Group:     group,
+	})
+}
+
+type auditLog struct {
+	Entries []AuditEntry `json:"entries"`
+	// Id to pass as before to fetch the next page, zero if there are no
+	// more entries.
+	Next int64 `json:"next,omitempty"`
+}
+
+func parseAuditQuery(r *http.Request) (AuditQuery, error) {
+	q := AuditQuery{
+		Group: r.FormValue("group"),
+		User:  strings.ToLower(r.FormValue("user")),
+		Limit: defaultAuditLimit,
+	}
+	if v := r.FormValue("before"); v != "" {
+		b, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return AuditQuery{}, err
+		}
+		q.Before = b
+	}
+	if v := r.FormValue("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil {
+			return AuditQuery{}, err
+		}
+		q.Limit = min(max(l, 1), maxAuditLimit)
+	}
+	return q, nil
+}
+
+func (s *Server) apiAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := s.store.GetAuditLog(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := auditLog{Entries: entries}
+	if len(entries) == q.Limit {
+		resp.Next = entries[len(entries)-1].Id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/ncruces/go-sqlite3"
+)
+
+const (
+	ErrorUniqueConstraintViolation     = 2067
+	ErrorConstraintPrimaryKeyViolation = 1555
+
+	pqUniqueViolation = "23505"
+)
+
+// dialect captures differences between databases store can run on.
+type dialect struct {
+	name string
+	// Column definition of the auto incremented primary key.
+	serial string
+	// Statement to serialize concurrent migrations with, if database is
+	// shared by multiple replicas.
+	lock                  string
+	positionalArgs        bool
+	isUniqueViolation     func(err error) bool
+	isPrimaryKeyViolation func(err error) bool
+}
+
+var sqliteDialect = dialect{
+	name:   "sqlite",
+	serial: "INTEGER PRIMARY KEY AUTOINCREMENT",
+	isUniqueViolation: func(err error) bool {
+		var e *sqlite3.Error
+		return errors.As(err, &e) && e.ExtendedCode() == ErrorUniqueConstraintViolation
+	},
+	isPrimaryKeyViolation: func(err error) bool {
+		var e *sqlite3.Error
+		return errors.As(err, &e) && e.ExtendedCode() == ErrorConstraintPrimaryKeyViolation
+	},
+}
+
+func isPQUniqueViolation(err error) bool {
+	var e *pq.Error
+	return errors.As(err, &e) && e.Code == pqUniqueViolation
+}
+
+var postgresDialect = dialect{
+	name:                  "postgres",
+	serial:                "BIGSERIAL PRIMARY KEY",
+	lock:                  "SELECT pg_advisory_xact_lock(7267031)",
+	positionalArgs:        true,
+	isUniqueViolation:     isPQUniqueViolation,
+	isPrimaryKeyViolation: isPQUniqueViolation,
+}
+
+// rebind replaces ? placeholders with $1, $2, ... if database expects
+// positional arguments. Quoted literals are left untouched.
+func (d dialect) rebind(query string) string {
+	if !d.positionalArgs {
+		return query
+	}
+	var ret strings.Builder
+	n := 0
+	quoted := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			ret.WriteString("$")
+			ret.WriteString(strconv.Itoa(n))
+			continue
+		}
+		ret.WriteRune(c)
+	}
+	return ret.String()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// requestError classifies failures caused by the request itself, so
+// that API can respond with the matching status code.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func errConflict(format string, args ...any) error {
+	return &requestError{http.StatusConflict, fmt.Sprintf(format, args...)}
+}
+
+func errNotFound(format string, args ...any) error {
+	return &requestError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+func errInvalid(format string, args ...any) error {
+	return &requestError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func errorStatus(err error) int {
+	var e *requestError
+	if errors.As(err, &e) {
+		return e.status
+	}
+	return http.StatusInternalServerError
+}
@@ -2,10 +2,13 @@ module memberships
 
 go 1.21.5
 
-require github.com/ncruces/go-sqlite3 v0.12.2
+require (
+	github.com/gorilla/mux v1.8.1
+	github.com/lib/pq v1.10.9
+	github.com/ncruces/go-sqlite3 v0.12.2
+)
 
 require (
-	github.com/gorilla/mux v1.8.1 // indirect
 	github.com/ncruces/julianday v1.0.0 // indirect
 	github.com/tetratelabs/wazero v1.6.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
@@ -0,0 +1,665 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultInvitationTTL = 7 * 24 * time.Hour
+	maxInvitationTTL     = 30 * 24 * time.Hour
+	// How often expired memberships are removed. Expired memberships are
+	// ignored by the queries even before they are removed.
+	expiredMembershipsCheckInterval = time.Minute
+	// Recorded as the actor of the mutations performed by the server itself.
+	systemActor = "system"
+)
+
+// JoinRequest is a pending request of the user to become a member of the
+// group, which owners of the group can approve or deny.
+type JoinRequest struct {
+	User      string    `json:"user"`
+	Group     string    `json:"group"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Invitation lets anyone who knows the token join the group as a member
+// until it expires or is revoked.
+type Invitation struct {
+	Token     string    `json:"token"`
+	Group     string    `json:"group"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func newInvitationToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// invitationId is the shortened token, safe to record in the audit log
+// which is visible to everyone.
+func invitationId(token string) string {
+	if len(token) > 8 {
+		return token[:8]
+	}
+	return token
+}
+
+// parseExpiresAt parses optional expiry date, either in RFC3339 or
+// YYYY-MM-DD format as submitted by date inputs. Returns zero time if value
+// is empty.
+func parseExpiresAt(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, value); err != nil {
+			return time.Time{}, errInvalid("invalid expiry date: %s", value)
+		}
+	}
+	if !t.After(now) {
+		return time.Time{}, errInvalid("expiry date must be in the future")
+	}
+	return t, nil
+}
+
+func (s *SQLStore) isActiveMember(tx storeTx, user, group string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM user_to_group WHERE username = ? AND group_name = ? AND ` + activeMembership + `)`
+	var exists bool
+	err := tx.QueryRow(s.dialect.rebind(query), user, group, time.Now().Unix()).Scan(&exists)
+	return exists, err
+}
+
+func (s *SQLStore) AddJoinRequest(user, group string) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if member, err := s.isActiveMember(tx, user, group); err != nil {
+		return err
+	} else if member {
+		return errConflict("%s is already a member of group %s", user, group)
+	}
+	query := `INSERT INTO join_requests (username, group_name, created_at) VALUES (?, ?, ?)`
+	if _, err := tx.Exec(s.dialect.rebind(query), user, group, time.Now().Unix()); err != nil {
+		if s.dialect.isUniqueViolation(err) {
+			return errConflict("%s has already requested to join group %s", user, group)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) GetJoinRequests(group string) ([]JoinRequest, error) {
+	query := `SELECT username, created_at FROM join_requests WHERE group_name = ? ORDER BY created_at, username`
+	rows, err := s.query(query, group)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []JoinRequest
+	for rows.Next() {
+		jr := JoinRequest{Group: group}
+		var createdAt int64
+		if err := rows.Scan(&jr.User, &createdAt); err != nil {
+			return nil, err
+		}
+		jr.CreatedAt = time.Unix(createdAt, 0).UTC()
+		ret = append(ret, jr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *SQLStore) removeJoinRequest(tx storeTx, user, group string) error {
+	query := `DELETE FROM join_requests WHERE username = ? AND group_name = ?`
+	res, err := tx.Exec(s.dialect.rebind(query), user, group)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNotFound("%s has not requested to join group %s", user, group)
+	}
+	return nil
+}
+
+func (s *SQLStore) RemoveJoinRequest(user, group string) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.removeJoinRequest(tx, user, group); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) ApproveJoinRequest(user, group string, expiresAt time.Time) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.removeJoinRequest(tx, user, group); err != nil {
+		return err
+	}
+	if err := s.addGroupMember(tx, user, group, expiresAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) CreateInvitation(inv Invitation) error {
+	query := `INSERT INTO group_invitations (token, group_name, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`
+	_, err := s.exec(query, inv.Token, inv.Group, inv.CreatedBy, inv.CreatedAt.Unix(), inv.ExpiresAt.Unix())
+	return err
+}
+
+func (s *SQLStore) GetInvitations(group string) ([]Invitation, error) {
+	query := `
+        SELECT token, created_by, created_at, expires_at
+        FROM group_invitations
+        WHERE group_name = ? AND expires_at > ?
+        ORDER BY created_at`
+	rows, err := s.query(query, group, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []Invitation
+	for rows.Next() {
+		inv := Invitation{Group: group}
+		var createdAt, expiresAt int64
+		if err := rows.Scan(&inv.Token, &inv.CreatedBy, &createdAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		inv.CreatedAt = time.Unix(createdAt, 0).UTC()
+		inv.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+		ret = append(ret, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *SQLStore) RemoveInvitation(group, token string) error {
+	res, err := s.exec(`DELETE FROM group_invitations WHERE group_name = ? AND token = ?`, group, token)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNotFound("invitation not found")
+	}
+	return nil
+}
+
+func (s *SQLStore) GetInvitation(token string, now time.Time) (Invitation, error) {
+	query := `SELECT group_name, created_by, created_at, expires_at FROM group_invitations WHERE token = ?`
+	inv := Invitation{Token: token}
+	var createdAt, expiresAt int64
+	if err := s.queryRow(query, token).Scan(&inv.Group, &inv.CreatedBy, &createdAt, &expiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Invitation{}, errNotFound("invitation not found or expired")
+		}
+		return Invitation{}, err
+	}
+	inv.CreatedAt = time.Unix(createdAt, 0).UTC()
+	inv.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+	if !inv.ExpiresAt.After(now) {
+		return Invitation{}, errNotFound("invitation not found or expired")
+	}
+	return inv, nil
+}
+
+func (s *SQLStore) AcceptInvitation(token, user string, now time.Time) (Invitation, error) {
+	inv, err := s.GetInvitation(token, now)
+	if err != nil {
+		return Invitation{}, err
+	}
+	tx, err := s.begin()
+	if err != nil {
+		return Invitation{}, err
+	}
+	defer tx.Rollback()
+	if err := s.addGroupMember(tx, user, inv.Group, time.Time{}); err != nil {
+		return Invitation{}, err
+	}
+	// Pending join request is fulfilled by the invitation.
+	query := `DELETE FROM join_requests WHERE username = ? AND group_name = ?`
+	if _, err := tx.Exec(s.dialect.rebind(query), user, inv.Group); err != nil {
+		return Invitation{}, err
+	}
+	return inv, tx.Commit()
+}
+
+// removeExpiredMemberships periodically removes expired memberships and
+// records their removal in the audit log.
+func (s *Server) removeExpiredMemberships(interval time.Duration) {
+	for {
+		if err := s.store.Transaction(func(st Store) error {
+			expired, err := st.RemoveExpiredMemberships(time.Now())
+			if err != nil {
+				return err
+			}
+			for _, m := range expired {
+				if err := addAuditEntry(st, systemActor, ActionExpireMember, m.User, m.Group); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			log.Printf("Failed to remove expired memberships: %s", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func groupErrorRedirect(w http.ResponseWriter, r *http.Request, group string, err error) {
+	redirectURL := fmt.Sprintf("/group/%s?errorMessage=%s", group, url.QueryEscape(err.Error()))
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+func (s *Server) requestJoinHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, err := getLoggedInUser(r)
+	if err != nil {
+		http.Error(w, "User Not Logged In", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	groupName := mux.Vars(r)["group-name"]
+	if err := isValidGroupName(groupName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user := strings.ToLower(loggedInUser)
+	if err := s.audit(loggedInUser, ActionRequestJoin, user, groupName, func(st Store) error {
+		return st.AddJoinRequest(user, groupName)
+	}); err != nil {
+		groupErrorRedirect(w, r, groupName, err)
+		return
+	}
+	http.Redirect(w, r, "/group/"+groupName, http.StatusSeeOther)
+}
+
+func (s *Server) joinRequestHandler(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		loggedInUser, err := getLoggedInUser(r)
+		if err != nil {
+			http.Error(w, "User Not Logged In", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		vars := mux.Vars(r)
+		groupName := vars["group-name"]
+		username := strings.ToLower(vars["username"])
+		if err := isValidGroupName(groupName); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.checkIsOwner(w, loggedInUser, groupName); err != nil {
+			groupErrorRedirect(w, r, groupName, err)
+			return
+		}
+		action := ActionDenyJoin
+		if approve {
+			action = ActionApproveJoin
+			expiresAt, err := parseExpiresAt(r.FormValue("expires-at"), time.Now())
+			if err != nil {
+				groupErrorRedirect(w, r, groupName, err)
+				return
+			}
+			err = s.audit(loggedInUser, action, username, groupName, func(st Store) error {
+				return st.ApproveJoinRequest(username, groupName, expiresAt)
+			})
+		} else {
+			err = s.audit(loggedInUser, action, username, groupName, func(st Store) error {
+				return st.RemoveJoinRequest(username, groupName)
+			})
+		}
+		if err != nil {
+			groupErrorRedirect(w, r, groupName, err)
+			return
+		}
+		http.Redirect(w, r, "/group/"+groupName, http.StatusSeeOther)
+	}
+}
+
+func (s *Server) newInvitation(group, createdBy string, expiresAt time.Time) (Invitation, error) {
+	now := time.Now()
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(defaultInvitationTTL)
+	}
+	if expiresAt.Sub(now) > maxInvitationTTL {
+		return Invitation{}, errInvalid("invitation can be valid for at most %d days", int(maxInvitationTTL.Hours()/24))
+	}
+	token, err := newInvitationToken()
+	if err != nil {
+		return Invitation{}, err
+	}
+	inv := Invitation{token, group, createdBy, now.UTC(), expiresAt.UTC()}
+	if err := s.audit(createdBy, ActionCreateInvitation, invitationId(token), group, func(st Store) error {
+		return st.CreateInvitation(inv)
+	}); err != nil {
+		return Invitation{}, err
+	}
+	return inv, nil
+}
+
+// acceptInvitation adds user to the group the invitation was created for.
+func (s *Server) acceptInvitation(actor, token, user string) (Invitation, error) {
+	var inv Invitation
+	err := s.store.Transaction(func(st Store) error {
+		var err error
+		if inv, err = st.AcceptInvitation(token, user, time.Now()); err != nil {
+			return err
+		}
+		return addAuditEntry(st, actor, ActionAcceptInvitation, user, inv.Group)
+	})
+	return inv, err
+}
+
+func (s *Server) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, err := getLoggedInUser(r)
+	if err != nil {
+		http.Error(w, "User Not Logged In", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	groupName := mux.Vars(r)["group-name"]
+	if err := isValidGroupName(groupName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkIsOwner(w, loggedInUser, groupName); err != nil {
+		groupErrorRedirect(w, r, groupName, err)
+		return
+	}
+	expiresAt, err := parseExpiresAt(r.FormValue("expires-at"), time.Now())
+	if err != nil {
+		groupErrorRedirect(w, r, groupName, err)
+		return
+	}
+	if _, err := s.newInvitation(groupName, loggedInUser, expiresAt); err != nil {
+		groupErrorRedirect(w, r, groupName, err)
+		return
+	}
+	http.Redirect(w, r, "/group/"+groupName, http.StatusSeeOther)
+}
+
+func (s *Server) revokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, err := getLoggedInUser(r)
+	if err != nil {
+		http.Error(w, "User Not Logged In", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	vars := mux.Vars(r)
+	groupName := vars["group-name"]
+	token := vars["token"]
+	if err := isValidGroupName(groupName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkIsOwner(w, loggedInUser, groupName); err != nil {
+		groupErrorRedirect(w, r, groupName, err)
+		return
+	}
+	if err := s.audit(loggedInUser, ActionRevokeInvitation, invitationId(token), groupName, func(st Store) error {
+		return st.RemoveInvitation(groupName, token)
+	}); err != nil {
+		groupErrorRedirect(w, r, groupName, err)
+		return
+	}
+	http.Redirect(w, r, "/group/"+groupName, http.StatusSeeOther)
+}
+
+func (s *Server) invitationHandler(w http.ResponseWriter, r *http.Request) {
+	loggedInUser, err := getLoggedInUser(r)
+	if err != nil {
+		http.Error(w, "User Not Logged In", http.StatusUnauthorized)
+		return
+	}
+	token := mux.Vars(r)["token"]
+	switch r.Method {
+	case http.MethodGet:
+		inv, err := s.store.GetInvitation(token, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		data := struct {
+			Invitation   Invitation
+			CurrentUser  string
+			ErrorMessage string
+		}{inv, loggedInUser, r.URL.Query().Get("errorMessage")}
+		templates, err := parseTemplates(tmpls)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := templates.invitation.Execute(w, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case http.MethodPost:
+		user := strings.ToLower(loggedInUser)
+		inv, err := s.acceptInvitation(loggedInUser, token, user)
+		if err != nil {
+			redirectURL := fmt.Sprintf("/join/%s?errorMessage=%s", token, url.QueryEscape(err.Error()))
+			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/group/"+inv.Group, http.StatusSeeOther)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) apiGetJoinRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	if err := s.requireGroup(group); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	requests, err := s.store.GetJoinRequests(group)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(requests))
+}
+
+func (s *Server) apiAddJoinRequestHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	if err := s.requireGroup(group); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	var req userRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	user := strings.ToLower(req.User)
+	if user == "" {
+		writeAPIError(w, errInvalid("user is required"))
+		return
+	}
+	if err := s.audit(apiClient(r), ActionRequestJoin, user, group, func(st Store) error {
+		return st.AddJoinRequest(user, group)
+	}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+type approveJoinRequest struct {
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (s *Server) apiApproveJoinRequestHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	group := vars["group"]
+	user := strings.ToLower(vars["user"])
+	if err := s.requireGroup(group); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	var req approveJoinRequest
+	if err := decodeOptionalRequest(r, &req); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	expiresAt, err := requestExpiresAt(req.ExpiresAt)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if err := s.audit(apiClient(r), ActionApproveJoin, user, group, func(st Store) error {
+		return st.ApproveJoinRequest(user, group, expiresAt)
+	}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) apiDenyJoinRequestHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	group := vars["group"]
+	user := strings.ToLower(vars["user"])
+	if err := s.requireGroup(group); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if err := s.audit(apiClient(r), ActionDenyJoin, user, group, func(st Store) error {
+		return st.RemoveJoinRequest(user, group)
+	}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestExpiresAt validates optional expiry time of the API request.
+func requestExpiresAt(t *time.Time) (time.Time, error) {
+	if t == nil {
+		return time.Time{}, nil
+	}
+	if !t.After(time.Now()) {
+		return time.Time{}, errInvalid("expiry date must be in the future")
+	}
+	return *t, nil
+}
+
+func (s *Server) apiGetInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	if err := s.requireGroup(group); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	invitations, err := s.store.GetInvitations(group)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(invitations))
+}
+
+type createInvitationRequest struct {
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (s *Server) apiCreateInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	group := mux.Vars(r)["group"]
+	if err := s.requireGroup(group); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	var req createInvitationRequest
+	if err := decodeOptionalRequest(r, &req); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	expiresAt, err := requestExpiresAt(req.ExpiresAt)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	inv, err := s.newInvitation(group, apiClient(r), expiresAt)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, inv)
+}
+
+func (s *Server) apiRevokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	group, token := vars["group"], vars["token"]
+	if err := s.audit(apiClient(r), ActionRevokeInvitation, invitationId(token), group, func(st Store) error {
+		return st.RemoveInvitation(group, token)
+	}); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) apiAcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	var req userRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	user := strings.ToLower(req.User)
+	if user == "" {
+		writeAPIError(w, errInvalid("user is required"))
+		return
+	}
+	inv, err := s.acceptInvitation(apiClient(r), token, user)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, Membership{User: user, Group: inv.Group})
+}
@@ -12,8 +12,9 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
-	"github.com/ncruces/go-sqlite3"
+	_ "github.com/lib/pq"
 	_ "github.com/ncruces/go-sqlite3/driver"
 	_ "github.com/ncruces/go-sqlite3/embed"
 
@@ -22,7 +23,9 @@ import (
 
 var port = flag.Int("port", 8080, "Port to listen on")
 var apiPort = flag.Int("api-port", 8081, "Port to listen on for API requests")
+var dbType = flag.String("db-type", "sqlite", "Database to store memberships in, either sqlite or postgres")
 var dbPath = flag.String("db-path", "memberships.db", "Path to SQLite file")
+var dbURL = flag.String("db-url", "", "PostgreSQL connection string")
 
 //go:embed memberships-tmpl/*
 var tmpls embed.FS
@@ -41,11 +44,16 @@ type Store interface {
 	GetGroupsUserBelongsTo(user string) ([]Group, error)
 	IsGroupOwner(user, group string) (bool, error)
 	IsMemberOfOwnerGroup(user, group string) (bool, error)
-	AddGroupMember(user, group string) error
+	// Membership never expires if expiresAt is zero.
+	AddGroupMember(user, group string, expiresAt time.Time) error
 	AddGroupOwner(user, group string) error
 	GetGroupOwners(group string) ([]string, error)
 	GetGroupOwnerGroups(group string) ([]Group, error)
+	// Returns active members of the group.
 	GetGroupMembers(group string) ([]string, error)
+	GetGroupMemberships(group string) ([]Membership, error)
+	// Removes memberships expired by now and returns them.
+	RemoveExpiredMemberships(now time.Time) ([]Membership, error)
 	GetGroupDescription(group string) (string, error)
 	GetAllTransitiveGroupsForUser(user string) ([]Group, error)
 	GetGroupsGroupBelongsTo(group string) ([]Group, error)
@@ -54,6 +62,25 @@ type Store interface {
 	RemoveFromGroupToGroup(parent, child string) error
 	RemoveUserFromTable(username, groupName, tableName string) error
 	GetAllGroups() ([]Group, error)
+	// Returns page of groups ordered by name together with the total number of groups.
+	ListGroups(offset, limit int) ([]Group, int, error)
+	AddAuditEntry(entry AuditEntry) error
+	// Runs f with a store whose operations all belong to a single
+	// transaction, committed only if f succeeds.
+	Transaction(f func(Store) error) error
+	GetAuditLog(query AuditQuery) ([]AuditEntry, error)
+	AddJoinRequest(user, group string) error
+	GetJoinRequests(group string) ([]JoinRequest, error)
+	RemoveJoinRequest(user, group string) error
+	// Adds user to the group and removes their join request.
+	ApproveJoinRequest(user, group string, expiresAt time.Time) error
+	CreateInvitation(invitation Invitation) error
+	GetInvitations(group string) ([]Invitation, error)
+	RemoveInvitation(group, token string) error
+	// Fails with not found error if invitation has expired by now.
+	GetInvitation(token string, now time.Time) (Invitation, error)
+	// Adds user to the group the invitation was created for and returns it.
+	AcceptInvitation(token, user string, now time.Time) (Invitation, error)
 }
 
 type Server struct {
@@ -61,59 +88,114 @@ type Server struct {
 }
 
 type Group struct {
-	Name        string
-	Description string
+	Name        string `json:"name"`
+	Description string `json:"description"`
 }
 
-type SQLiteStore struct {
-	db *sql.DB
+type Membership struct {
+	User  string `json:"user"`
+	Group string `json:"group"`
+	// Nil if membership never expires.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
 }
 
-const (
-	ErrorUniqueConstraintViolation     = 2067
-	ErrorConstraintPrimaryKeyViolation = 1555
-)
+// SQLStore implements Store on top of SQLite and PostgreSQL databases.
+// Queries are written with ? placeholders and rebound to the dialect of
+// the underlying database.
+type SQLStore struct {
+	db      *sql.DB
+	dialect dialect
+	// Set on stores passed to Transaction callbacks.
+	tx *sql.Tx
+}
 
-func NewSQLiteStore(db *sql.DB) (*SQLiteStore, error) {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS groups (
-			name TEXT PRIMARY KEY,
-			description TEXT
-		);
-		CREATE TABLE IF NOT EXISTS owners (
-			username TEXT,
-			group_name TEXT,
-			FOREIGN KEY(group_name) REFERENCES groups(name),
-			UNIQUE (username, group_name)
-		);
-		CREATE TABLE IF NOT EXISTS owner_groups (
-			owner_group TEXT,
-			owned_group TEXT,
-			FOREIGN KEY(owner_group) REFERENCES groups(name),
-			FOREIGN KEY(owned_group) REFERENCES groups(name),
-			UNIQUE (owner_group, owned_group)
-		);
-		CREATE TABLE IF NOT EXISTS group_to_group (
-			parent_group TEXT,
-			child_group TEXT,
-			FOREIGN KEY(parent_group) REFERENCES groups(name),
-			FOREIGN KEY(child_group) REFERENCES groups(name),
-			UNIQUE (parent_group, child_group)
-		);
-		CREATE TABLE IF NOT EXISTS user_to_group (
-			username TEXT,
-			group_name TEXT,
-			FOREIGN KEY(group_name) REFERENCES groups(name),
-			UNIQUE (username, group_name)
-		);`)
-	if err != nil {
+func NewSQLiteStore(db *sql.DB) (*SQLStore, error) {
+	return newSQLStore(db, sqliteDialect)
+}
+
+func NewPostgresStore(db *sql.DB) (*SQLStore, error) {
+	return newSQLStore(db, postgresDialect)
+}
+
+func newSQLStore(db *sql.DB, d dialect) (*SQLStore, error) {
+	if err := migrate(db, d); err != nil {
 		return nil, err
 	}
-	return &SQLiteStore{db: db}, nil
+	return &SQLStore{db: db, dialect: d}, nil
+}
+
+type conn interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (s *SQLStore) conn() conn {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+func (s *SQLStore) exec(query string, args ...any) (sql.Result, error) {
+	return s.conn().Exec(s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) query(query string, args ...any) (*sql.Rows, error) {
+	return s.conn().Query(s.dialect.rebind(query), args...)
+}
+
+func (s *SQLStore) queryRow(query string, args ...any) *sql.Row {
+	return s.conn().QueryRow(s.dialect.rebind(query), args...)
 }
 
-func (s *SQLiteStore) Init(owner string, groups []string) error {
+// storeTx is a transaction of a single store operation. Operations run
+// within Transaction join the enclosing transaction instead, which is
+// committed or rolled back as a whole once the callback returns.
+type storeTx struct {
+	*sql.Tx
+	nested bool
+}
+
+func (t storeTx) Commit() error {
+	if t.nested {
+		return nil
+	}
+	return t.Tx.Commit()
+}
+
+func (t storeTx) Rollback() error {
+	if t.nested {
+		return nil
+	}
+	return t.Tx.Rollback()
+}
+
+func (s *SQLStore) begin() (storeTx, error) {
+	if s.tx != nil {
+		return storeTx{s.tx, true}, nil
+	}
 	tx, err := s.db.Begin()
+	return storeTx{tx, false}, err
+}
+
+func (s *SQLStore) Transaction(f func(Store) error) error {
+	if s.tx != nil {
+		return f(s)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := f(&SQLStore{db: s.db, dialect: s.dialect, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Init(owner string, groups []string) error {
+	tx, err := s.begin()
 	if err != nil {
 		return err
 	}
@@ -124,28 +206,28 @@ func (s *SQLiteStore) Init(owner string, groups []string) error {
 		return err
 	}
 	if count != 0 {
-		return fmt.Errorf("store already initialised")
+		return errConflict("store already initialised")
 	}
 	for _, g := range groups {
 		query := `INSERT INTO groups (name, description) VALUES (?, '')`
-		if _, err := tx.Exec(query, g); err != nil {
+		if _, err := tx.Exec(s.dialect.rebind(query), g); err != nil {
 			return err
 		}
 		query = `INSERT INTO owners (username, group_name) VALUES (?, ?)`
-		if _, err := tx.Exec(query, owner, g); err != nil {
+		if _, err := tx.Exec(s.dialect.rebind(query), owner, g); err != nil {
 			return err
 		}
 		query = `INSERT INTO user_to_group (username, group_name) VALUES (?, ?)`
-		if _, err := tx.Exec(query, owner, g); err != nil {
+		if _, err := tx.Exec(s.dialect.rebind(query), owner, g); err != nil {
 			return err
 		}
 	}
 	return tx.Commit()
 }
 
-func (s *SQLiteStore) queryGroups(query string, args ...interface{}) ([]Group, error) {
+func (s *SQLStore) queryGroups(query string, args ...interface{}) ([]Group, error) {
 	groups := make([]Group, 0)
-	rows, err := s.db.Query(query, args...)
+	rows, err := s.query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +245,7 @@ func (s *SQLiteStore) queryGroups(query string, args ...interface{}) ([]Group, e
 	return groups, nil
 }
 
-func (s *SQLiteStore) GetGroupsOwnedBy(user string) ([]Group, error) {
+func (s *SQLStore) GetGroupsOwnedBy(user string) ([]Group, error) {
 	query := `
         SELECT groups.name, groups.description
         FROM groups
@@ -172,37 +254,36 @@ func (s *SQLiteStore) GetGroupsOwnedBy(user string) ([]Group, error) {
 	return s.queryGroups(query, user)
 }
 
-func (s *SQLiteStore) GetGroupsUserBelongsTo(user string) ([]Group, error) {
+func (s *SQLStore) GetGroupsUserBelongsTo(user string) ([]Group, error) {
 	query := `
         SELECT groups.name, groups.description
         FROM groups
         JOIN user_to_group ON groups.name = user_to_group.group_name
-        WHERE user_to_group.username = ?`
-	return s.queryGroups(query, user)
+        WHERE user_to_group.username = ? AND ` + activeMembership
+	return s.queryGroups(query, user, time.Now().Unix())
 }
 
-func (s *SQLiteStore) CreateGroup(owner string, group Group) error {
-	tx, err := s.db.Begin()
+func (s *SQLStore) CreateGroup(owner string, group Group) error {
+	tx, err := s.begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 	query := `INSERT INTO groups (name, description) VALUES (?, ?)`
-	if _, err := tx.Exec(query, group.Name, group.Description); err != nil {
-		sqliteErr, ok := err.(*sqlite3.Error)
-		if ok && sqliteErr.ExtendedCode() == ErrorConstraintPrimaryKeyViolation {
-			return fmt.Errorf("Group with the name %s already exists", group.Name)
+	if _, err := tx.Exec(s.dialect.rebind(query), group.Name, group.Description); err != nil {
+		if s.dialect.isPrimaryKeyViolation(err) {
+			return errConflict("Group with the name %s already exists", group.Name)
 		}
 		return err
 	}
 	query = `INSERT INTO owners (username, group_name) VALUES (?, ?)`
-	if _, err := tx.Exec(query, owner, group.Name); err != nil {
+	if _, err := tx.Exec(s.dialect.rebind(query), owner, group.Name); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
-func (s *SQLiteStore) IsGroupOwner(user, group string) (bool, error) {
+func (s *SQLStore) IsGroupOwner(user, group string) (bool, error) {
 	query := `
         SELECT EXISTS (
             SELECT 1
@@ -210,39 +291,134 @@ func (s *SQLiteStore) IsGroupOwner(user, group string) (bool, error) {
             WHERE username = ? AND group_name = ?
         )`
 	var exists bool
-	if err := s.db.QueryRow(query, user, group).Scan(&exists); err != nil {
+	if err := s.queryRow(query, user, group).Scan(&exists); err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-func (s *SQLiteStore) AddGroupMember(user, group string) error {
-	_, err := s.db.Exec(`INSERT INTO user_to_group (username, group_name) VALUES (?, ?)`, user, group)
+// Condition matching memberships which have not expired by the time
+// given as the query argument.
+const activeMembership = `(user_to_group.expires_at IS NULL OR user_to_group.expires_at > ?)`
+
+func toNullUnix(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
+
+func fromNullUnix(t sql.NullInt64) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	ret := time.Unix(t.Int64, 0).UTC()
+	return &ret
+}
+
+func (s *SQLStore) AddGroupMember(user, group string, expiresAt time.Time) error {
+	tx, err := s.begin()
 	if err != nil {
-		sqliteErr, ok := err.(*sqlite3.Error)
-		if ok && sqliteErr.ExtendedCode() == ErrorUniqueConstraintViolation {
-			return fmt.Errorf("%s is already a member of group %s", user, group)
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.addGroupMember(tx, user, group, expiresAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) addGroupMember(tx storeTx, user, group string, expiresAt time.Time) error {
+	// Expired membership might not have been removed yet.
+	query := `DELETE FROM user_to_group WHERE username = ? AND group_name = ? AND expires_at <= ?`
+	if _, err := tx.Exec(s.dialect.rebind(query), user, group, time.Now().Unix()); err != nil {
+		return err
+	}
+	query = `INSERT INTO user_to_group (username, group_name, expires_at) VALUES (?, ?, ?)`
+	if _, err := tx.Exec(s.dialect.rebind(query), user, group, toNullUnix(expiresAt)); err != nil {
+		if s.dialect.isUniqueViolation(err) {
+			return errConflict("%s is already a member of group %s", user, group)
 		}
 		return err
 	}
 	return nil
 }
 
-func (s *SQLiteStore) AddGroupOwner(user, group string) error {
-	_, err := s.db.Exec(`INSERT INTO owners (username, group_name) VALUES (?, ?)`, user, group)
+func (s *SQLStore) GetGroupMemberships(group string) ([]Membership, error) {
+	query := `
+        SELECT username, expires_at
+        FROM user_to_group
+        WHERE group_name = ? AND ` + activeMembership
+	rows, err := s.query(query, group, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret []Membership
+	for rows.Next() {
+		m := Membership{Group: group}
+		var expiresAt sql.NullInt64
+		if err := rows.Scan(&m.User, &expiresAt); err != nil {
+			return nil, err
+		}
+		m.ExpiresAt = fromNullUnix(expiresAt)
+		ret = append(ret, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *SQLStore) RemoveExpiredMemberships(now time.Time) ([]Membership, error) {
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	query := `SELECT username, group_name, expires_at FROM user_to_group WHERE expires_at <= ?`
+	rows, err := tx.Query(s.dialect.rebind(query), now.Unix())
 	if err != nil {
-		sqliteErr, ok := err.(*sqlite3.Error)
-		if ok && sqliteErr.ExtendedCode() == ErrorUniqueConstraintViolation {
-			return fmt.Errorf("%s is already an owner of group %s", user, group)
+		return nil, err
+	}
+	var ret []Membership
+	for rows.Next() {
+		var m Membership
+		var expiresAt sql.NullInt64
+		if err := rows.Scan(&m.User, &m.Group, &expiresAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.ExpiresAt = fromNullUnix(expiresAt)
+		ret = append(ret, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, m := range ret {
+		query := `DELETE FROM user_to_group WHERE username = ? AND group_name = ? AND expires_at <= ?`
+		if _, err := tx.Exec(s.dialect.rebind(query), m.User, m.Group, now.Unix()); err != nil {
+			return nil, err
+		}
+	}
+	return ret, tx.Commit()
+}
+
+func (s *SQLStore) AddGroupOwner(user, group string) error {
+	_, err := s.exec(`INSERT INTO owners (username, group_name) VALUES (?, ?)`, user, group)
+	if err != nil {
+		if s.dialect.isUniqueViolation(err) {
+			return errConflict("%s is already an owner of group %s", user, group)
 		}
 		return err
 	}
 	return nil
 }
 
-func (s *SQLiteStore) getUsersByGroup(table, group string) ([]string, error) {
+func (s *SQLStore) getUsersByGroup(table, group string) ([]string, error) {
 	query := fmt.Sprintf("SELECT username FROM %s WHERE group_name = ?", table)
-	rows, err := s.db.Query(query, group)
+	rows, err := s.query(query, group)
 	if err != nil {
 		return nil, err
 	}
@@ -261,49 +437,57 @@ func (s *SQLiteStore) getUsersByGroup(table, group string) ([]string, error) {
 	return users, nil
 }
 
-func (s *SQLiteStore) GetGroupOwners(group string) ([]string, error) {
+func (s *SQLStore) GetGroupOwners(group string) ([]string, error) {
 	return s.getUsersByGroup("owners", group)
 }
 
-func (s *SQLiteStore) GetGroupMembers(group string) ([]string, error) {
-	return s.getUsersByGroup("user_to_group", group)
+func (s *SQLStore) GetGroupMembers(group string) ([]string, error) {
+	memberships, err := s.GetGroupMemberships(group)
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, m := range memberships {
+		users = append(users, m.User)
+	}
+	return users, nil
 }
 
-func (s *SQLiteStore) GetGroupDescription(group string) (string, error) {
+func (s *SQLStore) GetGroupDescription(group string) (string, error) {
 	var description string
 	query := `SELECT description FROM groups WHERE name = ?`
-	if err := s.db.QueryRow(query, group).Scan(&description); err != nil {
+	if err := s.queryRow(query, group).Scan(&description); err != nil {
 		return "", err
 	}
 	return description, nil
 }
 
-func (s *SQLiteStore) DoesGroupExist(group string) (bool, error) {
+func (s *SQLStore) DoesGroupExist(group string) (bool, error) {
 	query := `SELECT EXISTS (SELECT 1 FROM groups WHERE name = ?)`
 	var exists bool
-	if err := s.db.QueryRow(query, group).Scan(&exists); err != nil {
+	if err := s.queryRow(query, group).Scan(&exists); err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-func (s *SQLiteStore) AddChildGroup(parent, child string) error {
+func (s *SQLStore) AddChildGroup(parent, child string) error {
 	if parent == child {
-		return fmt.Errorf("parent and child groups can not have same name")
+		return errInvalid("parent and child groups can not have same name")
 	}
 	exists, err := s.DoesGroupExist(parent)
 	if err != nil {
 		return fmt.Errorf("error checking parent group existence: %v", err)
 	}
 	if !exists {
-		return fmt.Errorf("parent group with name %s does not exist", parent)
+		return errNotFound("parent group with name %s does not exist", parent)
 	}
 	exists, err = s.DoesGroupExist(child)
 	if err != nil {
 		return fmt.Errorf("error checking child group existence: %v", err)
 	}
 	if !exists {
-		return fmt.Errorf("child group with name %s does not exist", child)
+		return errNotFound("child group with name %s does not exist", child)
 	}
 	parentGroups, err := s.GetAllTransitiveGroupsForGroup(parent)
 	if err != nil {
@@ -311,21 +495,20 @@ func (s *SQLiteStore) AddChildGroup(parent, child string) error {
 	}
 	for _, group := range parentGroups {
 		if group.Name == child {
-			return fmt.Errorf("circular reference detected: group %s is already a parent of group %s", child, parent)
+			return errInvalid("circular reference detected: group %s is already a parent of group %s", child, parent)
 		}
 	}
-	_, err = s.db.Exec(`INSERT INTO group_to_group (parent_group, child_group) VALUES (?, ?)`, parent, child)
+	_, err = s.exec(`INSERT INTO group_to_group (parent_group, child_group) VALUES (?, ?)`, parent, child)
 	if err != nil {
-		sqliteErr, ok := err.(*sqlite3.Error)
-		if ok && sqliteErr.ExtendedCode() == ErrorUniqueConstraintViolation {
-			return fmt.Errorf("child group name %s already exists in group %s", child, parent)
+		if s.dialect.isUniqueViolation(err) {
+			return errConflict("child group name %s already exists in group %s", child, parent)
 		}
 		return err
 	}
 	return nil
 }
 
-func (s *SQLiteStore) GetAllTransitiveGroupsForUser(user string) ([]Group, error) {
+func (s *SQLStore) GetAllTransitiveGroupsForUser(user string) ([]Group, error) {
 	if groups, err := s.GetGroupsUserBelongsTo(user); err != nil {
 		return nil, err
 	} else {
@@ -334,7 +517,7 @@ func (s *SQLiteStore) GetAllTransitiveGroupsForUser(user string) ([]Group, error
 	}
 }
 
-func (s *SQLiteStore) GetAllTransitiveGroupsForGroup(group string) ([]Group, error) {
+func (s *SQLStore) GetAllTransitiveGroupsForGroup(group string) ([]Group, error) {
 	if p, err := s.GetGroupsGroupBelongsTo(group); err != nil {
 		return nil, err
 	} else {
@@ -346,7 +529,7 @@ func (s *SQLiteStore) GetAllTransitiveGroupsForGroup(group string) ([]Group, err
 	}
 }
 
-func (s *SQLiteStore) getAllParentGroupsRecursive(groups []Group, visited map[string]struct{}) ([]Group, error) {
+func (s *SQLStore) getAllParentGroupsRecursive(groups []Group, visited map[string]struct{}) ([]Group, error) {
 	var ret []Group
 	for _, g := range groups {
 		if _, ok := visited[g.Name]; ok {
@@ -365,13 +548,13 @@ func (s *SQLiteStore) getAllParentGroupsRecursive(groups []Group, visited map[st
 	return ret, nil
 }
 
-func (s *SQLiteStore) GetGroupsGroupBelongsTo(group string) ([]Group, error) {
+func (s *SQLStore) GetGroupsGroupBelongsTo(group string) ([]Group, error) {
 	query := `
         SELECT groups.name, groups.description
         FROM groups
         JOIN group_to_group ON groups.name = group_to_group.parent_group
         WHERE group_to_group.child_group = ?`
-	rows, err := s.db.Query(query, group)
+	rows, err := s.query(query, group)
 	if err != nil {
 		return nil, err
 	}
@@ -390,13 +573,13 @@ func (s *SQLiteStore) GetGroupsGroupBelongsTo(group string) ([]Group, error) {
 	return parentGroups, nil
 }
 
-func (s *SQLiteStore) GetDirectChildrenGroups(group string) ([]Group, error) {
+func (s *SQLStore) GetDirectChildrenGroups(group string) ([]Group, error) {
 	query := `
         SELECT groups.name, groups.description
         FROM groups
         JOIN group_to_group ON groups.name = group_to_group.child_group
         WHERE group_to_group.parent_group = ?`
-	rows, err := s.db.Query(query, group)
+	rows, err := s.query(query, group)
 	if err != nil {
 		return nil, err
 	}
@@ -415,9 +598,9 @@ func (s *SQLiteStore) GetDirectChildrenGroups(group string) ([]Group, error) {
 	return childrenGroups, nil
 }
 
-func (s *SQLiteStore) RemoveFromGroupToGroup(parent, child string) error {
+func (s *SQLStore) RemoveFromGroupToGroup(parent, child string) error {
 	query := `DELETE FROM group_to_group WHERE parent_group = ? AND child_group = ?`
-	rowDeleted, err := s.db.Exec(query, parent, child)
+	rowDeleted, err := s.exec(query, parent, child)
 	if err != nil {
 		return err
 	}
@@ -426,23 +609,23 @@ func (s *SQLiteStore) RemoveFromGroupToGroup(parent, child string) error {
 		return err
 	}
 	if rowDeletedNumber == 0 {
-		return fmt.Errorf("pair of parent '%s' and child '%s' groups not found", parent, child)
+		return errNotFound("pair of parent '%s' and child '%s' groups not found", parent, child)
 	}
 	return nil
 }
 
-func (s *SQLiteStore) RemoveUserFromTable(username, groupName, tableName string) error {
+func (s *SQLStore) RemoveUserFromTable(username, groupName, tableName string) error {
 	if tableName == "owners" {
 		owners, err := s.GetGroupOwners(groupName)
 		if err != nil {
 			return err
 		}
 		if len(owners) == 1 {
-			return fmt.Errorf("cannot remove the last owner of the group")
+			return errConflict("cannot remove the last owner of the group")
 		}
 	}
 	query := fmt.Sprintf("DELETE FROM %s WHERE username = ? AND group_name = ?", tableName)
-	rowDeleted, err := s.db.Exec(query, username, groupName)
+	rowDeleted, err := s.exec(query, username, groupName)
 	if err != nil {
 		return err
 	}
@@ -451,41 +634,40 @@ func (s *SQLiteStore) RemoveUserFromTable(username, groupName, tableName string)
 		return err
 	}
 	if rowDeletedNumber == 0 {
-		return fmt.Errorf("pair of group '%s' and user '%s' not found", groupName, username)
+		return errNotFound("pair of group '%s' and user '%s' not found", groupName, username)
 	}
 	return nil
 }
 
-func (s *SQLiteStore) AddOwnerGroup(owner_group, owned_group string) error {
+func (s *SQLStore) AddOwnerGroup(owner_group, owned_group string) error {
 	if owned_group == owner_group {
-		return fmt.Errorf("group can not own itself")
+		return errInvalid("group can not own itself")
 	}
 	exists, err := s.DoesGroupExist(owned_group)
 	if err != nil {
 		return fmt.Errorf("error checking owned group existence: %v", err)
 	}
 	if !exists {
-		return fmt.Errorf("owned group with name %s does not exist", owned_group)
+		return errNotFound("owned group with name %s does not exist", owned_group)
 	}
 	exists, err = s.DoesGroupExist(owner_group)
 	if err != nil {
 		return fmt.Errorf("error checking owner group existence: %v", err)
 	}
 	if !exists {
-		return fmt.Errorf("owner group with name %s does not exist", owner_group)
+		return errNotFound("owner group with name %s does not exist", owner_group)
 	}
-	_, err = s.db.Exec(`INSERT INTO owner_groups (owner_group, owned_group) VALUES (?, ?)`, owner_group, owned_group)
+	_, err = s.exec(`INSERT INTO owner_groups (owner_group, owned_group) VALUES (?, ?)`, owner_group, owned_group)
 	if err != nil {
-		sqliteErr, ok := err.(*sqlite3.Error)
-		if ok && sqliteErr.ExtendedCode() == ErrorUniqueConstraintViolation {
-			return fmt.Errorf("group named %s is already owner of a group %s", owner_group, owned_group)
+		if s.dialect.isUniqueViolation(err) {
+			return errConflict("group named %s is already owner of a group %s", owner_group, owned_group)
 		}
 		return err
 	}
 	return nil
 }
 
-func (s *SQLiteStore) GetGroupOwnerGroups(group string) ([]Group, error) {
+func (s *SQLStore) GetGroupOwnerGroups(group string) ([]Group, error) {
 	query := `
         SELECT groups.name, groups.description
         FROM groups
@@ -494,25 +676,38 @@ func (s *SQLiteStore) GetGroupOwnerGroups(group string) ([]Group, error) {
 	return s.queryGroups(query, group)
 }
 
-func (s *SQLiteStore) IsMemberOfOwnerGroup(user, group string) (bool, error) {
+func (s *SQLStore) IsMemberOfOwnerGroup(user, group string) (bool, error) {
 	query := `
 		SELECT EXISTS (
 			SELECT 1 FROM owner_groups
 			INNER JOIN user_to_group ON owner_groups.owner_group = user_to_group.group_name
-			WHERE owner_groups.owned_group = ? AND user_to_group.username = ?)`
+			WHERE owner_groups.owned_group = ? AND user_to_group.username = ? AND ` + activeMembership + `)`
 	var exists bool
-	err := s.db.QueryRow(query, group, user).Scan(&exists)
+	err := s.queryRow(query, group, user, time.Now().Unix()).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-func (s *SQLiteStore) GetAllGroups() ([]Group, error) {
+func (s *SQLStore) GetAllGroups() ([]Group, error) {
 	query := `SELECT name, description FROM groups`
 	return s.queryGroups(query)
 }
 
+func (s *SQLStore) ListGroups(offset, limit int) ([]Group, int, error) {
+	var total int
+	if err := s.queryRow(`SELECT COUNT(*) FROM groups`).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	query := `SELECT name, description FROM groups ORDER BY name LIMIT ? OFFSET ?`
+	groups, err := s.queryGroups(query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return groups, total, nil
+}
+
 func getLoggedInUser(r *http.Request) (string, error) {
 	if user := r.Header.Get("X-User"); user != "" {
 		return user, nil
@@ -530,6 +725,7 @@ const (
 
 func (s *Server) Start() error {
 	e := make(chan error)
+	go s.removeExpiredMemberships(expiredMembershipsCheckInterval)
 	go func() {
 		r := mux.NewRouter()
 		r.PathPrefix("/static/").Handler(http.FileServer(http.FS(staticResources)))
@@ -539,6 +735,12 @@ func (s *Server) Start() error {
 		r.HandleFunc("/group/{parent-group}/remove-child-group/{child-group}", s.removeChildGroupHandler)
 		r.HandleFunc("/group/{group-name}/remove-owner/{username}", s.removeOwnerFromGroupHandler)
 		r.HandleFunc("/group/{group-name}/remove-member/{username}", s.removeMemberFromGroupHandler)
+		r.HandleFunc("/group/{group-name}/request-join", s.requestJoinHandler)
+		r.HandleFunc("/group/{group-name}/join-requests/{username}/approve", s.joinRequestHandler(true))
+		r.HandleFunc("/group/{group-name}/join-requests/{username}/deny", s.joinRequestHandler(false))
+		r.HandleFunc("/group/{group-name}/create-invitation", s.createInvitationHandler)
+		r.HandleFunc("/group/{group-name}/revoke-invitation/{token}", s.revokeInvitationHandler)
+		r.HandleFunc("/join/{token}", s.invitationHandler)
 		r.HandleFunc("/group/{group-name}", s.groupHandler)
 		r.HandleFunc("/user/{username}", s.userHandler)
 		r.HandleFunc("/create-group", s.createGroupHandler)
@@ -547,8 +749,10 @@ func (s *Server) Start() error {
 	}()
 	go func() {
 		r := mux.NewRouter()
+		// TODO(gio): authenticate installer and auth-proxy as API clients
 		r.HandleFunc("/api/init", s.apiInitHandler)
 		r.HandleFunc("/api/user/{username}", s.apiMemberOfHandler)
+		s.registerAPIHandlers(r)
 		e <- http.ListenAndServe(fmt.Sprintf(":%d", *apiPort), r)
 	}()
 	return <-e
@@ -579,8 +783,9 @@ func (s *Server) checkIsOwner(w http.ResponseWriter, user, group string) error {
 }
 
 type templates struct {
-	group *template.Template
-	user  *template.Template
+	group      *template.Template
+	user       *template.Template
+	invitation *template.Template
 }
 
 func parseTemplates(fs embed.FS) (templates, error) {
@@ -603,7 +808,11 @@ func parseTemplates(fs embed.FS) (templates, error) {
 	if err != nil {
 		return templates{}, err
 	}
-	return templates{group, user}, nil
+	invitation, err := parse("memberships-tmpl/invitation.html")
+	if err != nil {
+		return templates{}, err
+	}
+	return templates{group, user, invitation}, nil
 }
 
 func (s *Server) homePageHandler(w http.ResponseWriter, r *http.Request) {
@@ -690,7 +899,9 @@ func (s *Server) createGroupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	group.Description = r.PostFormValue("description")
-	if err := s.store.CreateGroup(loggedInUser, group); err != nil {
+	if err := s.audit(loggedInUser, ActionCreateGroup, group.Name, group.Name, func(st Store) error {
+		return st.CreateGroup(loggedInUser, group)
+	}); err != nil {
 		// http.Error(w, err.Error(), http.StatusInternalServerError)
 		redirectURL := fmt.Sprintf("/user/%s?errorMessage=%s", loggedInUser, url.QueryEscape(err.Error()))
 		http.Redirect(w, r, redirectURL, http.StatusFound)
@@ -700,7 +911,7 @@ func (s *Server) createGroupHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) groupHandler(w http.ResponseWriter, r *http.Request) {
-	_, err := getLoggedInUser(r)
+	loggedInUser, err := getLoggedInUser(r)
 	if err != nil {
 		http.Error(w, "User Not Logged In", http.StatusUnauthorized)
 		return
@@ -727,11 +938,31 @@ func (s *Server) groupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	members, err := s.store.GetGroupMembers(groupName)
+	members, err := s.store.GetGroupMemberships(groupName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	isMember := false
+	for _, m := range members {
+		if m.User == strings.ToLower(loggedInUser) {
+			isMember = true
+		}
+	}
+	// Only owners get to see and manage pending join requests and invitations.
+	isOwner := s.checkIsOwner(w, loggedInUser, groupName) == nil
+	var joinRequests []JoinRequest
+	var invitations []Invitation
+	if isOwner {
+		if joinRequests, err = s.store.GetJoinRequests(groupName); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if invitations, err = s.store.GetInvitations(groupName); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 	description, err := s.store.GetGroupDescription(groupName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -757,25 +988,40 @@ func (s *Server) groupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	auditLog, err := s.store.GetAuditLog(AuditQuery{Group: groupName, Limit: groupPageAuditLimit})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	data := struct {
 		GroupName        string
 		Description      string
 		Owners           []string
-		Members          []string
+		Members          []Membership
+		IsOwner          bool
+		IsMember         bool
+		JoinRequests     []JoinRequest
+		Invitations      []Invitation
 		AllGroups        []Group
 		TransitiveGroups []Group
 		ChildGroups      []Group
 		OwnerGroups      []Group
+		AuditLog         []AuditEntry
 		ErrorMessage     string
 	}{
 		GroupName:        groupName,
 		Description:      description,
 		Owners:           owners,
 		Members:          members,
+		IsOwner:          isOwner,
+		IsMember:         isMember,
+		JoinRequests:     joinRequests,
+		Invitations:      invitations,
 		AllGroups:        allGroups,
 		TransitiveGroups: transitiveGroups,
 		ChildGroups:      childGroups,
 		OwnerGroups:      ownerGroups,
+		AuditLog:         auditLog,
 		ErrorMessage:     errorMsg,
 	}
 	templates, err := parseTemplates(tmpls)
@@ -812,8 +1058,9 @@ func (s *Server) removeChildGroupHandler(w http.ResponseWriter, r *http.Request)
 			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 			return
 		}
-		err := s.store.RemoveFromGroupToGroup(parentGroup, childGroup)
-		if err != nil {
+		if err := s.audit(loggedInUser, ActionRemoveChildGroup, childGroup, parentGroup, func(st Store) error {
+			return st.RemoveFromGroupToGroup(parentGroup, childGroup)
+		}); err != nil {
 			redirectURL := fmt.Sprintf("/group/%s?errorMessage=%s", parentGroup, url.QueryEscape(err.Error()))
 			http.Redirect(w, r, redirectURL, http.StatusFound)
 			return
@@ -842,8 +1089,9 @@ func (s *Server) removeOwnerFromGroupHandler(w http.ResponseWriter, r *http.Requ
 			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 			return
 		}
-		err := s.store.RemoveUserFromTable(username, groupName, tableName)
-		if err != nil {
+		if err := s.audit(loggedInUser, ActionRemoveOwner, username, groupName, func(st Store) error {
+			return st.RemoveUserFromTable(username, groupName, tableName)
+		}); err != nil {
 			redirectURL := fmt.Sprintf("/group/%s?errorMessage=%s", groupName, url.QueryEscape(err.Error()))
 			http.Redirect(w, r, redirectURL, http.StatusFound)
 			return
@@ -872,8 +1120,9 @@ func (s *Server) removeMemberFromGroupHandler(w http.ResponseWriter, r *http.Req
 			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 			return
 		}
-		err := s.store.RemoveUserFromTable(username, groupName, tableName)
-		if err != nil {
+		if err := s.audit(loggedInUser, ActionRemoveMember, username, groupName, func(st Store) error {
+			return st.RemoveUserFromTable(username, groupName, tableName)
+		}); err != nil {
 			redirectURL := fmt.Sprintf("/group/%s?errorMessage=%s", groupName, url.QueryEscape(err.Error()))
 			http.Redirect(w, r, redirectURL, http.StatusFound)
 			return
@@ -913,16 +1162,29 @@ func (s *Server) addUserToGroupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 		return
 	}
+	expiresAt, err := parseExpiresAt(r.FormValue("expires-at"), time.Now())
+	if err == nil && status == Owner && !expiresAt.IsZero() {
+		err = errInvalid("only memberships can expire")
+	}
+	if err != nil {
+		redirectURL := fmt.Sprintf("/group/%s?errorMessage=%s", groupName, url.QueryEscape(err.Error()))
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+		return
+	}
+	var action AuditAction
+	var add func(st Store) error
 	switch status {
 	case Owner:
-		err = s.store.AddGroupOwner(username, groupName)
+		add = func(st Store) error { return st.AddGroupOwner(username, groupName) }
+		action = ActionAddOwner
 	case Member:
-		err = s.store.AddGroupMember(username, groupName)
+		add = func(st Store) error { return st.AddGroupMember(username, groupName, expiresAt) }
+		action = ActionAddMember
 	default:
 		http.Error(w, "Invalid status", http.StatusBadRequest)
 		return
 	}
-	if err != nil {
+	if err := s.audit(loggedInUser, action, username, groupName, add); err != nil {
 		redirectURL := fmt.Sprintf("/group/%s?errorMessage=%s", groupName, url.QueryEscape(err.Error()))
 		http.Redirect(w, r, redirectURL, http.StatusFound)
 		return
@@ -957,7 +1219,9 @@ func (s *Server) addChildGroupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 		return
 	}
-	if err := s.store.AddChildGroup(parentGroup, childGroup); err != nil {
+	if err := s.audit(loggedInUser, ActionAddChildGroup, childGroup, parentGroup, func(st Store) error {
+		return st.AddChildGroup(parentGroup, childGroup)
+	}); err != nil {
 		redirectURL := fmt.Sprintf("/group/%s?errorMessage=%s", parentGroup, url.QueryEscape(err.Error()))
 		http.Redirect(w, r, redirectURL, http.StatusFound)
 		return
@@ -991,7 +1255,9 @@ func (s *Server) addOwnerGroupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 		return
 	}
-	if err := s.store.AddOwnerGroup(ownerGroup, ownedGroup); err != nil {
+	if err := s.audit(loggedInUser, ActionAddOwnerGroup, ownerGroup, ownedGroup, func(st Store) error {
+		return st.AddOwnerGroup(ownerGroup, ownedGroup)
+	}); err != nil {
 		redirectURL := fmt.Sprintf("/group/%s?errorMessage=%s", ownedGroup, url.QueryEscape(err.Error()))
 		http.Redirect(w, r, redirectURL, http.StatusFound)
 		return
@@ -1010,7 +1276,17 @@ func (s *Server) apiInitHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := s.store.Init(req.Owner, req.Groups); err != nil {
+	if err := s.store.Transaction(func(st Store) error {
+		if err := st.Init(req.Owner, req.Groups); err != nil {
+			return err
+		}
+		for _, g := range req.Groups {
+			if err := addAuditEntry(st, req.Owner, ActionInit, req.Owner, g); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -1068,13 +1344,26 @@ func isValidGroupName(group string) error {
 
 func main() {
 	flag.Parse()
-	db, err := sql.Open("sqlite3", *dbPath)
-	if err != nil {
-		panic(err)
-	}
-	store, err := NewSQLiteStore(db)
-	if err != nil {
-		panic(err)
+	var store Store
+	switch *dbType {
+	case "sqlite":
+		db, err := sql.Open("sqlite3", *dbPath)
+		if err != nil {
+			panic(err)
+		}
+		if store, err = NewSQLiteStore(db); err != nil {
+			panic(err)
+		}
+	case "postgres":
+		db, err := sql.Open("postgres", *dbURL)
+		if err != nil {
+			panic(err)
+		}
+		if store, err = NewPostgresStore(db); err != nil {
+			panic(err)
+		}
+	default:
+		log.Fatalf("Unknown database type: %s", *dbType)
 	}
 	s := Server{store}
 	log.Fatal(s.Start())
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+type migration struct {
+	version     int
+	description string
+	// Schema definition, {serial} is replaced with auto incremented primary
+	// key column definition of the dialect.
+	schema string
+}
+
+// Migrations are applied in order and must never be modified once released,
+// schema changes are introduced by appending new ones.
+var migrations = []migration{
+	{1, "initial schema", `
+		CREATE TABLE IF NOT EXISTS groups (
+			name TEXT PRIMARY KEY,
+			description TEXT
+		);
+		CREATE TABLE IF NOT EXISTS owners (
+			username TEXT,
+			group_name TEXT,
+			FOREIGN KEY(group_name) REFERENCES groups(name),
+			UNIQUE (username, group_name)
+		);
+		CREATE TABLE IF NOT EXISTS owner_groups (
+			owner_group TEXT,
+			owned_group TEXT,
+			FOREIGN KEY(owner_group) REFERENCES groups(name),
+			FOREIGN KEY(owned_group) REFERENCES groups(name),
+			UNIQUE (owner_group, owned_group)
+		);
+		CREATE TABLE IF NOT EXISTS group_to_group (
+			parent_group TEXT,
+			child_group TEXT,
+			FOREIGN KEY(parent_group) REFERENCES groups(name),
+			FOREIGN KEY(child_group) REFERENCES groups(name),
+			UNIQUE (parent_group, child_group)
+		);
+		CREATE TABLE IF NOT EXISTS user_to_group (
+			username TEXT,
+			group_name TEXT,
+			FOREIGN KEY(group_name) REFERENCES groups(name),
+			UNIQUE (username, group_name)
+		);`},
+	{2, "audit log", `
+		CREATE TABLE audit_log (
+			id {serial},
+			created_at BIGINT NOT NULL,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			group_name TEXT NOT NULL
+		);
+		CREATE INDEX audit_log_group_name ON audit_log (group_name);`},
+	{3, "expiring memberships, join requests and invitations", `
+		ALTER TABLE user_to_group ADD COLUMN expires_at BIGINT;
+		CREATE TABLE join_requests (
+			username TEXT NOT NULL,
+			group_name TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			FOREIGN KEY(group_name) REFERENCES groups(name),
+			UNIQUE (username, group_name)
+		);
+		CREATE TABLE group_invitations (
+			token TEXT PRIMARY KEY,
+			group_name TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL,
+			FOREIGN KEY(group_name) REFERENCES groups(name)
+		);
+		CREATE INDEX group_invitations_group_name ON group_invitations (group_name);`},
+}
+
+// migrate brings database schema up to date. Databases created before
+// schema was versioned are at version 0, initial schema migration is a no-op
+// for them.
+func migrate(db *sql.DB, d dialect) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if d.lock != "" {
+		if _, err := tx.Exec(d.lock); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT
+	)`); err != nil {
+		return err
+	}
+	var current int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if _, err := tx.Exec(strings.ReplaceAll(m.schema, "{serial}", d.serial)); err != nil {
+			return fmt.Errorf("Failed to apply migration %d: %w", m.version, err)
+		}
+		if _, err := tx.Exec(
+			d.rebind("INSERT INTO schema_migrations (version, description) VALUES (?, ?)"),
+			m.version,
+			m.description,
+		); err != nil {
+			return err
+		}
+		log.Printf("Applied %s migration %d: %s", d.name, m.version, m.description)
+	}
+	return tx.Commit()
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	_ "github.com/ncruces/go-sqlite3/driver"
 	_ "github.com/ncruces/go-sqlite3/embed"
@@ -137,6 +138,140 @@ func TestGetAllTransitiveGroupsForUser(t *testing.T) {
 	}
 }
 
+func TestExpiredMembershipsAreIgnored(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewSQLiteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+        INSERT INTO groups (name, description)
+        VALUES
+            ('a', 'xxx'),
+            ('b', 'yyy'),
+            ('c', 'zzz');
+
+        INSERT INTO group_to_group (child_group, parent_group)
+        VALUES
+            ('b', 'c');
+        `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := store.AddGroupMember("u", "a", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddGroupMember("u", "b", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := store.GetAllTransitiveGroupsForUser("u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Name != "a" {
+		t.Fatalf("Expected only group a, got: %s", groups)
+	}
+	members, err := store.GetGroupMembers("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 0 {
+		t.Fatalf("Expected no members, got: %s", members)
+	}
+	expired, err := store.RemoveExpiredMemberships(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].Group != "b" {
+		t.Fatalf("Expected membership of b to expire, got: %+v", expired)
+	}
+	// Expired membership can be renewed.
+	if err := store.AddGroupMember("u", "b", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	memberships, err := store.GetGroupMemberships("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memberships) != 1 || memberships[0].ExpiresAt != nil {
+		t.Fatalf("Expected permanent membership, got: %+v", memberships)
+	}
+}
+
+func TestJoinRequestsAndInvitations(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewSQLiteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateGroup("owner", Group{"a", ""}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddJoinRequest("u", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddJoinRequest("u", "a"); errorStatus(err) != http.StatusConflict {
+		t.Fatalf("Expected conflict, got: %v", err)
+	}
+	if err := store.AddJoinRequest("v", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ApproveJoinRequest("u", "a", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveJoinRequest("v", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveJoinRequest("v", "a"); errorStatus(err) != http.StatusNotFound {
+		t.Fatalf("Expected not found, got: %v", err)
+	}
+	if err := store.AddJoinRequest("u", "a"); errorStatus(err) != http.StatusConflict {
+		t.Fatalf("Expected members not to be able to request to join, got: %v", err)
+	}
+	now := time.Now()
+	for _, inv := range []Invitation{
+		{"valid", "a", "owner", now, now.Add(time.Hour)},
+		{"expired", "a", "owner", now.Add(-2 * time.Hour), now.Add(-time.Hour)},
+	} {
+		if err := store.CreateInvitation(inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	invitations, err := store.GetInvitations("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invitations) != 1 || invitations[0].Token != "valid" {
+		t.Fatalf("Expected only valid invitation, got: %+v", invitations)
+	}
+	if _, err := store.AcceptInvitation("expired", "v", now); errorStatus(err) != http.StatusNotFound {
+		t.Fatalf("Expected not found, got: %v", err)
+	}
+	if inv, err := store.AcceptInvitation("valid", "v", now); err != nil || inv.Group != "a" {
+		t.Fatalf("Expected to join group a: %+v %v", inv, err)
+	}
+	members, err := store.GetGroupMembers("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("Expected two members, got: %s", members)
+	}
+	if err := store.RemoveInvitation("a", "valid"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AcceptInvitation("valid", "w", now); errorStatus(err) != http.StatusNotFound {
+		t.Fatalf("Expected revoked invitation to be rejected, got: %v", err)
+	}
+}
+
 func TestParentAndChildGroupCases(t *testing.T) {
 	db, err := sql.Open("sqlite3", ":memory:")
 	if err != nil {
@@ -168,3 +303,119 @@ func TestRemoveChildGroupHandler(t *testing.T) {
 			body, "expected body")
 	}
 }
+
+func TestMigrationsAreIdempotent(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSQLiteStore(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSQLiteStore(db); err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != migrations[len(migrations)-1].version {
+		t.Fatalf("Expected schema to be at version %d, got %d", migrations[len(migrations)-1].version, version)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewSQLiteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{store}
+	for _, e := range []struct {
+		action  AuditAction
+		subject string
+		group   string
+	}{
+		{ActionAddMember, "foo", "a"},
+		{ActionAddOwner, "bar", "a"},
+		{ActionAddMember, "bar", "b"},
+		{ActionRemoveMember, "foo", "a"},
+	} {
+		if err := s.audit("admin", e.action, e.subject, e.group, func(Store) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := store.GetAuditLog(AuditQuery{Group: "a", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Action != ActionRemoveMember || entries[1].Action != ActionAddOwner {
+		t.Fatalf("Unexpected entries: %+v", entries)
+	}
+	entries, err = store.GetAuditLog(AuditQuery{Group: "a", Before: entries[1].Id, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Subject != "foo" || entries[0].Actor != "admin" {
+		t.Fatalf("Unexpected entries: %+v", entries)
+	}
+	entries, err = store.GetAuditLog(AuditQuery{User: "bar", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected two entries, got %+v", entries)
+	}
+}
+
+func TestAuditRollsBackMutation(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewSQLiteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{store}
+	if err := s.audit("admin", ActionCreateGroup, "a", "a", func(st Store) error {
+		return st.CreateGroup("admin", Group{Name: "a"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Fails after creating the group, as it already owns it.
+	if err := s.audit("admin", ActionCreateGroup, "b", "b", func(st Store) error {
+		if err := st.CreateGroup("admin", Group{Name: "b"}); err != nil {
+			return err
+		}
+		return st.AddGroupOwner("admin", "b")
+	}); err == nil {
+		t.Fatal("Expected error")
+	}
+	if exists, err := store.DoesGroupExist("b"); err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Fatal("Expected group creation to be rolled back")
+	}
+	entries, err := store.GetAuditLog(AuditQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Subject != "a" {
+		t.Fatalf("Unexpected entries: %+v", entries)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	q := "SELECT * FROM t WHERE a = ? AND b = '?' AND c = ?"
+	if got := sqliteDialect.rebind(q); got != q {
+		t.Fatalf("Expected query to be left as is, got %s", got)
+	}
+	expected := "SELECT * FROM t WHERE a = $1 AND b = '?' AND c = $2"
+	if got := postgresDialect.rebind(q); got != expected {
+		t.Fatalf("Expected %s, got %s", expected, got)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// ttlCache keeps values for the fixed amount of time. Expired entries are
+// swept once per TTL period when new values are added.
+type ttlCache[V any] struct {
+	lock      sync.Mutex
+	ttl       time.Duration
+	entries   map[string]cacheEntry[V]
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newTTLCache[V any](ttl time.Duration) *ttlCache[V] {
+	return &ttlCache[V]{
+		ttl:     ttl,
+		entries: map[string]cacheEntry[V]{},
+		now:     time.Now,
+	}
+}
+
+func (c *ttlCache[V]) Get(key string) (V, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !c.now().Before(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (c *ttlCache[V]) Put(key string, value V) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	if now.Sub(c.lastSweep) > c.ttl {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[key] = cacheEntry[V]{value, now.Add(c.ttl)}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	now := time.Now()
+	c := newTTLCache[string](time.Minute)
+	c.now = func() time.Time { return now }
+	c.Put("foo", "bar")
+	if v, ok := c.Get("foo"); !ok || v != "bar" {
+		t.Fatalf("Expected cached value, got %s %t", v, ok)
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("foo"); ok {
+		t.Fatal("Expected value to expire")
+	}
+	now = now.Add(time.Second)
+	c.Put("bar", "baz")
+	if len(c.entries) != 1 {
+		t.Fatalf("Expected expired entries to be swept, got %d", len(c.entries))
+	}
+}
+
+func TestTTLCacheDisabled(t *testing.T) {
+	c := newTTLCache[string](0)
+	c.Put("foo", "bar")
+	if _, ok := c.Get("foo"); ok {
+		t.Fatal("Expected nothing to be cached")
+	}
+}
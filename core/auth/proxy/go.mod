@@ -2,4 +2,17 @@ module github.com/giolekva/pcloud/core/auth/proxy
 
 go 1.21.5
 
-require golang.org/x/exp v0.0.0-20240318143956-a85f2c67cd81
+require (
+	github.com/prometheus/client_golang v1.19.0
+	golang.org/x/exp v0.0.0-20240318143956-a85f2c67cd81
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	google.golang.org/protobuf v1.32.0 // indirect
+)
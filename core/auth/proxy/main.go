@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bytes"
-	"context"
 	"crypto/tls"
 	"encoding/json"
 	"flag"
@@ -10,19 +8,38 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"net/http/cookiejar"
+	"net/http/httputil"
 	"net/url"
 	"strings"
+	"time"
 
-	"golang.org/x/exp/slices"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var port = flag.Int("port", 3000, "Port to listen on")
+var metricsPort = flag.Int("metrics-port", 9090, "Port to serve Prometheus metrics on")
 var whoAmIAddr = flag.String("whoami-addr", "", "Kratos whoami endpoint address")
 var loginAddr = flag.String("login-addr", "", "Login page address")
 var membershipAddr = flag.String("membership-addr", "", "Group membership API endpoint")
 var groups = flag.String("groups", "", "Comma separated list of groups. User must be part of at least one of them. If empty group membership will not be checked.")
 var upstream = flag.String("upstream", "", "Upstream service address")
+var policyFile = flag.String("policy-file", "", "Path to the JSON file with per path and method authorization rules. Requests not matched by any rule are checked against groups. File is reloaded when modified.")
+var policyReloadInterval = flag.Duration("policy-reload-interval", 10*time.Second, "How often to check policy file for modifications")
+var sessionCookie = flag.String("session-cookie", "ory_kratos_session", "Name of the Kratos session cookie")
+var cacheTTL = flag.Duration("cache-ttl", time.Minute, "How long to cache sessions and group memberships for. Zero disables caching.")
+
+var policy *policyWatcher
+
+// TODO(gio): do not skip TLS verification
+var client = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	},
+}
+
+var sessions *ttlCache[*user]
+var memberships *ttlCache[[]string]
 
 type user struct {
 	Identity struct {
@@ -46,129 +63,134 @@ func getAddr(r *http.Request) (*url.URL, error) {
 		r.URL.RequestURI()))
 }
 
-func handle(w http.ResponseWriter, r *http.Request) {
-	user, err := queryWhoAmI(r.Cookies())
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// newUpstreamProxy streams requests to the upstream as is, including
+// protocol upgrades such as websockets. Host header is preserved.
+func newUpstreamProxy(addr string) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = addr
+		},
+		FlushInterval: -1,
 	}
-	if user == nil {
-		if r.Method != http.MethodGet {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		curr, err := getAddr(r)
+}
+
+func handle(proxy http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		user, err := getUser(r)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		addr := fmt.Sprintf("%s?return_to=%s", *loginAddr, curr.String())
-		http.Redirect(w, r, addr, http.StatusSeeOther)
-		return
-	}
-	if *groups != "" {
-		hasPermission := false
-		tg, err := getTransitiveGroups(user.Identity.Traits.Username)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if user == nil {
+			deniedRequests.WithLabelValues("unauthenticated").Inc()
+			if r.Method != http.MethodGet {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			curr, err := getAddr(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			addr := fmt.Sprintf("%s?return_to=%s", *loginAddr, curr.String())
+			http.Redirect(w, r, addr, http.StatusSeeOther)
 			return
 		}
-		for _, i := range strings.Split(*groups, ",") {
-			if slices.Contains(tg, strings.TrimSpace(i)) {
-				hasPermission = true
-				break
+		p := policy.Policy()
+		var tg []string
+		if *membershipAddr != "" {
+			tg, err = getTransitiveGroups(user.Identity.Traits.Username)
+			if err != nil {
+				if *groups != "" || !p.empty() {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				// Groups are only passed to the upstream, no need to fail the request.
+				log.Printf("Failed to fetch groups of %s: %s", user.Identity.Traits.Username, err)
 			}
 		}
-		if !hasPermission {
-			http.Error(w, "not authorized", http.StatusUnauthorized)
+		allowed := p.Allowed(r.URL.Path, r.Method, defaultGroups(), tg)
+		observeSince("total", start)
+		if !allowed {
+			deniedRequests.WithLabelValues("forbidden").Inc()
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
-
+		r.Header.Set("X-User", user.Identity.Traits.Username)
+		r.Header.Set("X-User-Groups", strings.Join(tg, ","))
+		proxy.ServeHTTP(w, r)
 	}
-	rc := r.Clone(context.Background())
-	rc.Header.Add("X-User", user.Identity.Traits.Username)
-	ru, err := url.Parse(fmt.Sprintf("http://%s%s", *upstream, r.URL.RequestURI()))
+}
+
+// getUser returns user the request session belongs to, or nil if request is
+// not authenticated.
+func getUser(r *http.Request) (*user, error) {
+	session, err := r.Cookie(*sessionCookie)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	rc.URL = ru
-	rc.RequestURI = ""
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
+		return nil, nil
 	}
-	resp, err := client.Do(rc)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if u, ok := sessions.Get(session.Value); ok {
+		cacheLookups.WithLabelValues("sessions", "hit").Inc()
+		return u, nil
 	}
-	for name, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(name, value)
-		}
+	cacheLookups.WithLabelValues("sessions", "miss").Inc()
+	u, err := queryWhoAmI(r.Cookies())
+	if err != nil {
+		return nil, err
 	}
-	w.WriteHeader(resp.StatusCode)
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// Unauthenticated sessions are not cached so that user is let in
+	// right after logging in.
+	if u != nil {
+		sessions.Put(session.Value, u)
 	}
+	return u, nil
 }
 
 func queryWhoAmI(cookies []*http.Cookie) (*user, error) {
-	jar, err := cookiejar.New(nil)
+	defer observeSince("whoami", time.Now())
+	req, err := http.NewRequest(http.MethodGet, *whoAmIAddr, nil)
 	if err != nil {
 		return nil, err
 	}
-	client := &http.Client{
-		Jar: jar,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
+	for _, c := range cookies {
+		req.AddCookie(c)
 	}
-	addr, err := url.Parse(*whoAmIAddr)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	client.Jar.SetCookies(addr, cookies)
-	resp, err := client.Get(*whoAmIAddr)
-	if err != nil {
-		return nil, err
-	}
-	data := make(map[string]any)
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, err
-	}
-	// TODO(gio): remove debugging
-	b, err := json.MarshalIndent(data, "", "  ")
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println(string(b))
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(data); err != nil {
-		return nil, err
-	}
-	tmp := buf.String()
 	if resp.StatusCode == http.StatusOK {
 		u := &user{}
-		if err := json.NewDecoder(strings.NewReader(tmp)).Decode(u); err != nil {
+		if err := json.Unmarshal(body, u); err != nil {
 			return nil, err
 		}
 		return u, nil
 	}
 	e := &authError{}
-	if err := json.NewDecoder(strings.NewReader(tmp)).Decode(e); err != nil {
+	if err := json.Unmarshal(body, e); err != nil {
 		return nil, err
 	}
 	if e.Error.Status == "Unauthorized" {
 		return nil, nil
 	}
-	return nil, fmt.Errorf("Unknown error: %s", tmp)
+	return nil, fmt.Errorf("Unknown error: %s", body)
+}
+
+func defaultGroups() []string {
+	ret := []string{}
+	for _, g := range strings.Split(*groups, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			ret = append(ret, g)
+		}
+	}
+	return ret
 }
 
 type MembershipInfo struct {
@@ -176,23 +198,48 @@ type MembershipInfo struct {
 }
 
 func getTransitiveGroups(user string) ([]string, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/%s", *membershipAddr, user))
+	if g, ok := memberships.Get(user); ok {
+		cacheLookups.WithLabelValues("memberships", "hit").Inc()
+		return g, nil
+	}
+	cacheLookups.WithLabelValues("memberships", "miss").Inc()
+	defer observeSince("memberships", time.Now())
+	resp, err := client.Get(fmt.Sprintf("%s/%s", *membershipAddr, url.PathEscape(user)))
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch memberships: %s", resp.Status)
+	}
 	var info MembershipInfo
 	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
 		return nil, err
 	}
+	memberships.Put(user, info.MemberOf)
 	return info.MemberOf, nil
 }
 
 func main() {
 	flag.Parse()
-	if *groups != "" && *membershipAddr == "" {
-		log.Fatal("membership-addr flag is required when groups are provided")
+	if (*groups != "" || *policyFile != "") && *membershipAddr == "" {
+		log.Fatal("membership-addr flag is required when groups or policy are provided")
 	}
-	http.HandleFunc("/", handle)
+	if *policyFile != "" {
+		var err error
+		if policy, err = newPolicyWatcher(*policyFile); err != nil {
+			log.Fatal(err)
+		}
+		go policy.Watch(*policyReloadInterval)
+	}
+	sessions = newTTLCache[*user](*cacheTTL)
+	memberships = newTTLCache[[]string](*cacheTTL)
+	go func() {
+		metrics := http.NewServeMux()
+		metrics.Handle("/metrics", promhttp.Handler())
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *metricsPort), metrics))
+	}()
+	http.HandleFunc("/", handle(newUpstreamProxy(*upstream)))
 	fmt.Printf("Starting HTTP server on port: %d\n", *port)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
 }
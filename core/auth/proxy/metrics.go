@@ -0,0 +1,28 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	authDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "auth_proxy_auth_duration_seconds",
+		Help:    "Time spent on authenticating and authorizing requests, total and per remote call.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"step"})
+	deniedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_proxy_denied_requests_total",
+		Help: "Number of requests denied, either because user is not authenticated or is forbidden by the policy.",
+	}, []string{"reason"})
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_proxy_cache_lookups_total",
+		Help: "Number of session and membership cache lookups.",
+	}, []string{"cache", "result"})
+)
+
+func observeSince(step string, start time.Time) {
+	authDuration.WithLabelValues(step).Observe(time.Since(start).Seconds())
+}
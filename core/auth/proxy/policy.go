@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Rule grants access to requests matching the path pattern and one of the
+// methods. Pattern ending with * matches every path with the given prefix,
+// as well as the prefix without its trailing slash, so that /admin/* covers
+// /admin too. Other patterns are matched using path.Match. Empty list of methods matches
+// any method and empty list of groups allows any authenticated user.
+type Rule struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+}
+
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+func (r Rule) matches(req string, method string) bool {
+	if len(r.Methods) > 0 && !slices.ContainsFunc(r.Methods, func(m string) bool {
+		return strings.EqualFold(m, method)
+	}) {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(r.Path, "*"); ok {
+		if dir, ok := strings.CutSuffix(prefix, "/"); ok && req == dir {
+			return true
+		}
+		return strings.HasPrefix(req, prefix)
+	}
+	ok, err := path.Match(r.Path, req)
+	return err == nil && ok
+}
+
+func (r Rule) allows(userGroups []string) bool {
+	if len(r.Groups) == 0 {
+		return true
+	}
+	for _, g := range r.Groups {
+		if slices.Contains(userGroups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed checks request against the first matching rule. Requests not
+// matched by any rule are checked against the given default groups.
+func (p *Policy) Allowed(path, method string, defaultGroups []string, userGroups []string) bool {
+	if p != nil {
+		for _, r := range p.Rules {
+			if r.matches(path, method) {
+				return r.allows(userGroups)
+			}
+		}
+	}
+	return Rule{Groups: defaultGroups}.allows(userGroups)
+}
+
+func (p *Policy) empty() bool {
+	return p == nil || len(p.Rules) == 0
+}
+
+func readPolicy(name string) (*Policy, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ret Policy
+	if err := json.NewDecoder(f).Decode(&ret); err != nil {
+		return nil, err
+	}
+	for _, r := range ret.Rules {
+		if _, err := path.Match(r.Path, ""); err != nil {
+			return nil, fmt.Errorf("Invalid path pattern %s: %s", r.Path, err)
+		}
+	}
+	return &ret, nil
+}
+
+// policyWatcher keeps policy read from the file up to date, so that rules
+// mounted from the ConfigMap can be changed without restarting the proxy.
+type policyWatcher struct {
+	name    string
+	policy  atomic.Pointer[Policy]
+	modTime time.Time
+}
+
+func newPolicyWatcher(name string) (*policyWatcher, error) {
+	w := &policyWatcher{name: name}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *policyWatcher) Policy() *Policy {
+	if w == nil {
+		return nil
+	}
+	return w.policy.Load()
+}
+
+func (w *policyWatcher) reload() error {
+	info, err := os.Stat(w.name)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(w.modTime) {
+		return nil
+	}
+	p, err := readPolicy(w.name)
+	if err != nil {
+		return err
+	}
+	w.policy.Store(p)
+	w.modTime = info.ModTime()
+	return nil
+}
+
+// Watch periodically rereads policy file if it has been modified. Previous
+// policy stays in effect if new one can not be read.
+func (w *policyWatcher) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := w.reload(); err != nil {
+			log.Printf("Failed to reload policy: %s", err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPolicyAllowed(t *testing.T) {
+	p := &Policy{Rules: []Rule{
+		{Path: "/admin/*", Groups: []string{"admin"}},
+		{Path: "/*", Methods: []string{"GET", "HEAD"}, Groups: []string{"readers", "writers"}},
+		{Path: "/public", Methods: []string{"POST"}},
+	}}
+	for _, c := range []struct {
+		path    string
+		method  string
+		groups  []string
+		allowed bool
+	}{
+		{"/admin/users", "GET", []string{"readers"}, false},
+		{"/admin/users", "DELETE", []string{"admin"}, true},
+		{"/admin", "GET", []string{"readers"}, false},
+		{"/admin", "GET", []string{"admin"}, true},
+		{"/administrators", "GET", []string{"readers"}, true},
+		{"/notes", "get", []string{"readers"}, true},
+		{"/notes", "GET", []string{}, false},
+		{"/public", "POST", []string{}, true},
+		// Falls back to default groups.
+		{"/notes", "POST", []string{"readers"}, false},
+		{"/notes", "POST", []string{"writers"}, true},
+	} {
+		if got := p.Allowed(c.path, c.method, []string{"writers"}, c.groups); got != c.allowed {
+			t.Errorf("%s %s %v: expected %t, got %t", c.method, c.path, c.groups, c.allowed, got)
+		}
+	}
+	var empty *Policy
+	if !empty.Allowed("/", "GET", []string{}, nil) {
+		t.Error("Expected request to be allowed without policy and default groups")
+	}
+}
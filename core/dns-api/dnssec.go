@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const dnsSecKeysFile = "dnssec-keys.json"
+
+var (
+	ErrRolloverInProgress = errors.New("key rollover already in progress")
+	ErrKeyNotFound        = errors.New("key not found")
+)
+
+type KeyRole string
+
+const (
+	// Key signing key, signs DNSKEY RRset only and is referenced by the DS
+	// record published in the parent zone.
+	KSK KeyRole = "ksk"
+	// Zone signing key, signs all the other records of the zone.
+	ZSK KeyRole = "zsk"
+)
+
+type KeyState string
+
+const (
+	KeyActive KeyState = "active"
+	// KSK whose DS record is published but not yet picked up by the parent
+	// zone. Key is already part of the DNSKEY RRset.
+	KeyPending KeyState = "pending"
+	// Key replaced by the newer one, stays published and signing until
+	// RetireAt so that cached signatures and DS records expire.
+	KeyRetiring KeyState = "retiring"
+)
+
+type KeyOptions struct {
+	// Lifetime of the ZSK after which it is automatically rolled over, zero
+	// disables automatic rollovers.
+	ZSKLifetime time.Duration
+	// How long retired keys stay published, must cover TTLs of the DNSKEY
+	// RRset, signatures and DS records.
+	PropagationDelay time.Duration
+}
+
+// KeyInfo describes the key without exposing its private part.
+type KeyInfo struct {
+	Role      KeyRole    `json:"role"`
+	State     KeyState   `json:"state"`
+	KeyTag    uint16     `json:"keyTag"`
+	Algorithm uint8      `json:"algorithm"`
+	Created   time.Time  `json:"created"`
+	RetireAt  *time.Time `json:"retireAt,omitempty"`
+	DS        string     `json:"ds,omitempty"`
+}
+
+// KeyManager keeps track of DNSSEC keys of the zone and rolls them over.
+// Keys are persisted next to the records file and every change is
+// reflected in CoreDNS configuration.
+//
+// ZSK is rolled over with the double-signature method: new key starts
+// signing right away while the old one keeps signing until its
+// signatures expire from caches. KSK rollover is driven externally, as
+// new DS record has to be published in the parent zone first.
+type KeyManager struct {
+	lock   sync.Mutex
+	fs     FS
+	dir    string
+	zone   string
+	opts   KeyOptions
+	config *coreDNSConfig
+	keys   []DNSSecKey
+	now    func() time.Time
+}
+
+func newKeyManager(fs FS, dir, zone string, opts KeyOptions, config *coreDNSConfig) (*KeyManager, error) {
+	m := &KeyManager{
+		fs:     fs,
+		dir:    dir,
+		zone:   zone,
+		opts:   opts,
+		config: config,
+		now:    time.Now,
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *KeyManager) path(name string) string {
+	return filepath.Join(m.dir, name)
+}
+
+// load reads persisted keys. Zones created before key rollover support
+// have single key, which becomes the KSK, and a new ZSK is generated for
+// them. CoreDNS only splits signing between keys when both KSK and ZSK
+// are configured.
+func (m *KeyManager) load() error {
+	ok, err := m.fs.Exists(m.path(dnsSecKeysFile))
+	if err != nil {
+		return err
+	}
+	if ok {
+		d, err := m.fs.Read(m.path(dnsSecKeysFile))
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(d), &m.keys); err != nil {
+			return err
+		}
+		return m.writeKeys()
+	}
+	ksk, err := getDNSSecKey(m.fs, m.zone)
+	if err != nil {
+		return err
+	}
+	ksk.Role = KSK
+	ksk.State = KeyActive
+	ksk.Created = m.now()
+	zsk, err := newDNSSecKey(m.zone, ZSK)
+	if err != nil {
+		return err
+	}
+	zsk.State = KeyActive
+	zsk.Created = m.now()
+	m.keys = []DNSSecKey{ksk, zsk}
+	return m.save()
+}
+
+// writeKeys writes key files of all the keys and configures CoreDNS to
+// sign the zone with them.
+func (m *KeyManager) writeKeys() error {
+	basenames := make([]string, 0, len(m.keys))
+	for _, k := range m.keys {
+		if err := m.fs.Write(m.path(k.Basename+".key"), string(k.Key)); err != nil {
+			return err
+		}
+		if err := m.fs.Write(m.path(k.Basename+".private"), string(k.Private)); err != nil {
+			return err
+		}
+		basenames = append(basenames, m.fs.AbsolutePath(m.path(k.Basename)))
+	}
+	return m.config.setKeys(basenames)
+}
+
+func (m *KeyManager) save() error {
+	d, err := json.MarshalIndent(m.keys, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := m.fs.Write(m.path(dnsSecKeysFile), string(d)); err != nil {
+		return err
+	}
+	return m.writeKeys()
+}
+
+func (m *KeyManager) Keys() ([]KeyInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ret := make([]KeyInfo, 0, len(m.keys))
+	for _, k := range m.keys {
+		i, err := k.info()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, i)
+	}
+	return ret, nil
+}
+
+// DSRecords returns DS records to be published in the parent zone. During
+// KSK rollover both current and new keys are referenced.
+func (m *KeyManager) DSRecords() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var ret []string
+	for _, k := range m.keys {
+		if k.Role == KSK && k.State != KeyRetiring {
+			ret = append(ret, string(k.DS))
+		}
+	}
+	return ret
+}
+
+func (m *KeyManager) find(role KeyRole, state KeyState) int {
+	for i, k := range m.keys {
+		if k.Role == role && k.State == state {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *KeyManager) rolloverZSK(now time.Time) (DNSSecKey, error) {
+	if m.find(ZSK, KeyRetiring) != -1 {
+		return DNSSecKey{}, fmt.Errorf("%w: previous ZSK is still retiring", ErrRolloverInProgress)
+	}
+	k, err := newDNSSecKey(m.zone, ZSK)
+	if err != nil {
+		return DNSSecKey{}, err
+	}
+	k.State = KeyActive
+	k.Created = now
+	if i := m.find(ZSK, KeyActive); i != -1 {
+		m.keys[i].State = KeyRetiring
+		m.keys[i].RetireAt = now.Add(m.opts.PropagationDelay)
+	}
+	m.keys = append(m.keys, k)
+	return k, m.save()
+}
+
+func (m *KeyManager) RolloverZSK() (KeyInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	k, err := m.rolloverZSK(m.now())
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	return k.info()
+}
+
+// StartKSKRollover generates new KSK and publishes it in the DNSKEY RRset.
+// Its DS record must be published in the parent zone before the rollover
+// is completed.
+func (m *KeyManager) StartKSKRollover() (KeyInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.find(KSK, KeyPending) != -1 {
+		return KeyInfo{}, fmt.Errorf("%w: KSK rollover has not been completed", ErrRolloverInProgress)
+	}
+	k, err := newDNSSecKey(m.zone, KSK)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	k.State = KeyPending
+	k.Created = m.now()
+	m.keys = append(m.keys, k)
+	if err := m.save(); err != nil {
+		return KeyInfo{}, err
+	}
+	return k.info()
+}
+
+// findPendingKSK returns index of the pending KSK with given key tag.
+func (m *KeyManager) findPendingKSK(keyTag uint16) (int, error) {
+	for i, k := range m.keys {
+		if k.Role != KSK || k.State != KeyPending {
+			continue
+		}
+		if tag, err := k.keyTag(); err != nil {
+			return -1, err
+		} else if tag == keyTag {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: no pending KSK with key tag %d", ErrKeyNotFound, keyTag)
+}
+
+// AbortKSKRollover drops pending KSK with given key tag, together with its
+// DS record. Current KSK stays active.
+func (m *KeyManager) AbortKSKRollover(keyTag uint16) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	pending, err := m.findPendingKSK(keyTag)
+	if err != nil {
+		return err
+	}
+	k := m.keys[pending]
+	m.keys = append(m.keys[:pending], m.keys[pending+1:]...)
+	if err := m.save(); err != nil {
+		return err
+	}
+	if err := m.fs.Remove(m.path(k.Basename + ".key")); err != nil {
+		return err
+	}
+	return m.fs.Remove(m.path(k.Basename + ".private"))
+}
+
+// CompleteKSKRollover activates pending KSK with given key tag, which must
+// be called only after parent zone serves its DS record. Previous KSK is
+// retired.
+func (m *KeyManager) CompleteKSKRollover(keyTag uint16) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.now()
+	pending, err := m.findPendingKSK(keyTag)
+	if err != nil {
+		return err
+	}
+	for i, k := range m.keys {
+		if k.Role == KSK && k.State == KeyActive {
+			m.keys[i].State = KeyRetiring
+			m.keys[i].RetireAt = now.Add(m.opts.PropagationDelay)
+		}
+	}
+	m.keys[pending].State = KeyActive
+	return m.save()
+}
+
+// Maintain removes retired keys and rolls over ZSK once it reaches its
+// lifetime.
+func (m *KeyManager) Maintain() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.now()
+	keys := make([]DNSSecKey, 0, len(m.keys))
+	for _, k := range m.keys {
+		if k.State == KeyRetiring && !now.Before(k.RetireAt) {
+			if err := m.fs.Remove(m.path(k.Basename + ".key")); err != nil {
+				return err
+			}
+			if err := m.fs.Remove(m.path(k.Basename + ".private")); err != nil {
+				return err
+			}
+			continue
+		}
+		keys = append(keys, k)
+	}
+	changed := len(keys) != len(m.keys)
+	m.keys = keys
+	if m.opts.ZSKLifetime > 0 && m.find(ZSK, KeyRetiring) == -1 {
+		if i := m.find(ZSK, KeyActive); i == -1 || !now.Before(m.keys[i].Created.Add(m.opts.ZSKLifetime)) {
+			_, err := m.rolloverZSK(now)
+			return err
+		}
+	}
+	if changed {
+		return m.save()
+	}
+	return nil
+}
+
+func (m *KeyManager) Run(interval time.Duration) {
+	for {
+		if err := m.Maintain(); err != nil {
+			fmt.Printf("Failed to maintain DNSSEC keys: %s\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (k DNSSecKey) dnskey() (*dns.DNSKEY, error) {
+	rr, err := dns.NewRR(string(k.Key))
+	if err != nil {
+		return nil, err
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("Not a DNSKEY record: %s", k.Key)
+	}
+	return key, nil
+}
+
+func (k DNSSecKey) keyTag() (uint16, error) {
+	key, err := k.dnskey()
+	if err != nil {
+		return 0, err
+	}
+	return key.KeyTag(), nil
+}
+
+func (k DNSSecKey) info() (KeyInfo, error) {
+	key, err := k.dnskey()
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	ret := KeyInfo{
+		Role:      k.Role,
+		State:     k.State,
+		KeyTag:    key.KeyTag(),
+		Algorithm: key.Algorithm,
+		Created:   k.Created,
+		DS:        string(k.DS),
+	}
+	if !k.RetireAt.IsZero() {
+		retireAt := k.RetireAt
+		ret.RetireAt = &retireAt
+	}
+	return ret, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func countKeys(keys []KeyInfo, role KeyRole, state KeyState) int {
+	ret := 0
+	for _, k := range keys {
+		if k.Role == role && k.State == state {
+			ret++
+		}
+	}
+	return ret
+}
+
+func TestKSKRollover(t *testing.T) {
+	_, keys, fs := newTestZone(t, KeyOptions{PropagationDelay: time.Hour})
+	now := time.Now()
+	keys.now = func() time.Time { return now }
+	if ds := keys.DSRecords(); len(ds) != 1 {
+		t.Fatalf("Expected single DS record, got %+v", ds)
+	}
+	pending, err := keys.StartKSKRollover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.StartKSKRollover(); !errors.Is(err, ErrRolloverInProgress) {
+		t.Fatalf("Expected rollover in progress, got %v", err)
+	}
+	if ds := keys.DSRecords(); len(ds) != 2 || ds[1] != pending.DS {
+		t.Fatalf("Expected both DS records to be published, got %+v", ds)
+	}
+	config, err := fs.Read("coredns.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(config, fs.AbsolutePath("K")); n != 3 {
+		t.Fatalf("Expected zone to be signed with three keys: %s", config)
+	}
+	if err := keys.CompleteKSKRollover(pending.KeyTag + 1); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected key not found, got %v", err)
+	}
+	if err := keys.CompleteKSKRollover(pending.KeyTag); err != nil {
+		t.Fatal(err)
+	}
+	if ds := keys.DSRecords(); len(ds) != 1 || ds[0] != pending.DS {
+		t.Fatalf("Expected only new DS record, got %+v", ds)
+	}
+	now = now.Add(time.Hour)
+	if err := keys.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+	all, err := keys.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || countKeys(all, KSK, KeyActive) != 1 || countKeys(all, ZSK, KeyActive) != 1 {
+		t.Fatalf("Expected retired KSK to be removed, got %+v", all)
+	}
+}
+
+func TestAbortKSKRollover(t *testing.T) {
+	_, keys, fs := newTestZone(t, KeyOptions{PropagationDelay: time.Hour})
+	current := keys.DSRecords()
+	pending, err := keys.StartKSKRollover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.AbortKSKRollover(pending.KeyTag + 1); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected key not found, got %v", err)
+	}
+	if err := keys.AbortKSKRollover(pending.KeyTag); err != nil {
+		t.Fatal(err)
+	}
+	if ds := keys.DSRecords(); len(ds) != 1 || ds[0] != current[0] {
+		t.Fatalf("Expected only current DS record, got %+v", ds)
+	}
+	config, err := fs.Read("coredns.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(config, fs.AbsolutePath("K")); n != 2 {
+		t.Fatalf("Expected zone to be signed with two keys: %s", config)
+	}
+	if _, err := keys.StartKSKRollover(); err != nil {
+		t.Fatalf("Expected new rollover to be allowed, got %v", err)
+	}
+}
+
+func TestZSKRollover(t *testing.T) {
+	_, keys, fs := newTestZone(t, KeyOptions{ZSKLifetime: 24 * time.Hour, PropagationDelay: time.Hour})
+	now := time.Now()
+	keys.now = func() time.Time { return now }
+	if err := keys.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+	all, err := keys.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("Expected no rollover before ZSK lifetime, got %+v", all)
+	}
+	now = now.Add(25 * time.Hour)
+	if err := keys.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+	if all, err = keys.Keys(); err != nil {
+		t.Fatal(err)
+	}
+	if countKeys(all, ZSK, KeyActive) != 1 || countKeys(all, ZSK, KeyRetiring) != 1 {
+		t.Fatalf("Expected ZSK to be rolled over, got %+v", all)
+	}
+	if _, err := keys.RolloverZSK(); !errors.Is(err, ErrRolloverInProgress) {
+		t.Fatalf("Expected rollover in progress, got %v", err)
+	}
+	now = now.Add(time.Hour)
+	if err := keys.Maintain(); err != nil {
+		t.Fatal(err)
+	}
+	if all, err = keys.Keys(); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || countKeys(all, ZSK, KeyActive) != 1 {
+		t.Fatalf("Expected retired ZSK to be removed, got %+v", all)
+	}
+	// Keys survive restarts.
+	reloaded, err := newKeyManager(fs, ".", "foo.bar.ge", KeyOptions{}, keys.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reloaded.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].KeyTag != all[0].KeyTag || got[1].KeyTag != all[1].KeyTag {
+		t.Fatalf("Expected %+v, got %+v", all, got)
+	}
+}
+
+func TestLegacyDNSSecKeyMigration(t *testing.T) {
+	fs := osFS{t.TempDir()}
+	legacy, err := getDNSSecKey(fs, "foo.bar.ge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, keys, err := NewStore(fs, "coredns.conf", "records.db", "foo.bar.ge", []string{"1.2.3.4"}, "10.0.1.0", []string{"4.3.2.1"}, KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds := keys.DSRecords(); len(ds) != 1 || ds[0] != string(legacy.DS) {
+		t.Fatalf("Expected legacy key to stay the KSK, got %+v", ds)
+	}
+	d, err := fs.Read(dnsSecKeysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var persisted []DNSSecKey
+	if err := json.Unmarshal([]byte(d), &persisted); err != nil {
+		t.Fatal(err)
+	}
+	if len(persisted) != 2 || persisted[1].Role != ZSK || len(persisted[1].DS) != 0 {
+		t.Fatalf("Expected KSK and ZSK, got %+v", persisted)
+	}
+}
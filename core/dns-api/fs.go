@@ -13,6 +13,7 @@ type FS interface {
 	Writer(path string) (io.WriteCloser, error)
 	Read(path string) (string, error)
 	Write(path string, data string) error
+	Remove(path string) error
 	AbsolutePath(path string) string
 }
 
@@ -65,3 +66,11 @@ func (f osFS) Write(path string, data string) error {
 	_, err = io.WriteString(w, data)
 	return err
 }
+
+func (f osFS) Remove(path string) error {
+	err := os.Remove(f.AbsolutePath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
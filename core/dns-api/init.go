@@ -3,7 +3,9 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -17,9 +19,9 @@ const coreDNSConfigTmpl = `
 		reload 1s
 	}
 	errors
-	{{- if .dnsSecBasename }}
+	{{- if .dnsSecKeys }}
 	dnssec {
-		key file {{ .dnsSecBasename}}
+		key file {{ join " " .dnsSecKeys }}
 	}
 	{{- end }}
 	log
@@ -48,27 +50,43 @@ ns{{ add1 $i }}.{{ $zone }}. 10800 IN A {{ $ns }}
 *.p.{{ $zone }}. 10800 IN A {{ .privateIP }}
 `
 
-func NewStore(fs FS, config string, db string, zone string, publicIP []string, privateIP string, nameserverIP []string) (RecordStore, string, error) {
-	dnsSec, err := getDNSSecKey(fs, zone)
+// coreDNSConfig generates CoreDNS configuration serving the zone, which
+// must be regenerated whenever DNSSEC keys of the zone change.
+type coreDNSConfig struct {
+	lock       sync.Mutex
+	fs         FS
+	path       string
+	zone       string
+	dbFile     string
+	dnsSecKeys []string
+}
+
+// setKeys configures zone to be signed with keys of given absolute
+// basenames.
+func (c *coreDNSConfig) setKeys(basenames []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dnsSecKeys = basenames
+	return c.write()
+}
+
+func (c *coreDNSConfig) write() error {
+	return executeTemplate(c.fs, c.path, coreDNSConfigTmpl, map[string]any{
+		"zone":       c.zone,
+		"dbFile":     c.fs.AbsolutePath(c.dbFile),
+		"dnsSecKeys": c.dnsSecKeys,
+	})
+}
+
+func NewStore(fs FS, config string, db string, zone string, publicIP []string, privateIP string, nameserverIP []string, keyOpts KeyOptions) (RecordStore, *KeyManager, error) {
+	cfg := &coreDNSConfig{fs: fs, path: config, zone: zone, dbFile: db}
+	keys, err := newKeyManager(fs, filepath.Dir(db), zone, keyOpts, cfg)
 	if err != nil {
-		return nil, "", err
-	}
-	if err := fs.Write(dnsSec.Basename+".key", string(dnsSec.Key)); err != nil {
-		return nil, "", err
-	}
-	if err := fs.Write(dnsSec.Basename+".private", string(dnsSec.Private)); err != nil {
-		return nil, "", err
-	}
-	if err := executeTemplate(fs, config, coreDNSConfigTmpl, map[string]any{
-		"zone":           zone,
-		"dbFile":         fs.AbsolutePath(db),
-		"dnsSecBasename": fs.AbsolutePath(dnsSec.Basename),
-	}); err != nil {
-		return nil, "", err
+		return nil, nil, err
 	}
 	ok, err := fs.Exists(db)
 	if err != nil {
-		return nil, "", err
+		return nil, nil, err
 	}
 	if !ok {
 		if err := executeTemplate(fs, db, recordsDBTmpl, map[string]any{
@@ -78,10 +96,17 @@ func NewStore(fs FS, config string, db string, zone string, publicIP []string, p
 			"nameserverIP": nameserverIP,
 			"nowUnix":      NowUnix(),
 		}); err != nil {
-			return nil, "", err
+			return nil, nil, err
 		}
 	}
-	return &fsRecordStore{zone, publicIP, fs, db}, string(dnsSec.DS), nil
+	store := &fsRecordStore{
+		zone:      zone,
+		publicIP:  publicIP,
+		fs:        fs,
+		db:        db,
+		protected: protection{zone, len(nameserverIP)},
+	}
+	return store, keys, nil
 }
 
 func getDNSSecKey(fs FS, zone string) (DNSSecKey, error) {
@@ -101,7 +126,7 @@ func getDNSSecKey(fs FS, zone string) (DNSSecKey, error) {
 		}
 		return k, nil
 	}
-	k, err := newDNSSecKey(zone)
+	k, err := newDNSSecKey(zone, KSK)
 	if err != nil {
 		return DNSSecKey{}, err
 	}
@@ -116,27 +141,39 @@ func getDNSSecKey(fs FS, zone string) (DNSSecKey, error) {
 }
 
 type DNSSecKey struct {
-	Basename string `json:"basename,omitempty"`
-	Key      []byte `json:"key,omitempty"`
-	Private  []byte `json:"private,omitempty"`
-	DS       []byte `json:"ds,omitempty"`
+	Role     KeyRole   `json:"role,omitempty"`
+	State    KeyState  `json:"state,omitempty"`
+	Basename string    `json:"basename,omitempty"`
+	Key      []byte    `json:"key,omitempty"`
+	Private  []byte    `json:"private,omitempty"`
+	DS       []byte    `json:"ds,omitempty"`
+	Created  time.Time `json:"created,omitempty"`
+	RetireAt time.Time `json:"retireAt,omitempty"`
 }
 
-func newDNSSecKey(zone string) (DNSSecKey, error) {
+func newDNSSecKey(zone string, role KeyRole) (DNSSecKey, error) {
+	flags := uint16(dns.ZONE)
+	if role == KSK {
+		flags |= dns.SEP
+	}
 	key := &dns.DNSKEY{
 		Hdr:       dns.RR_Header{Name: dns.Fqdn(zone), Class: dns.ClassINET, Ttl: 3600, Rrtype: dns.TypeDNSKEY},
-		Algorithm: dns.ECDSAP256SHA256, Flags: 257, Protocol: 3,
+		Algorithm: dns.ECDSAP256SHA256, Flags: flags, Protocol: 3,
 	}
 	priv, err := key.Generate(256)
 	if err != nil {
 		return DNSSecKey{}, err
 	}
-	return DNSSecKey{
+	ret := DNSSecKey{
+		Role:     role,
 		Basename: fmt.Sprintf("K%s+%03d+%05d", key.Header().Name, key.Algorithm, key.KeyTag()),
 		Key:      []byte(key.String()),
 		Private:  []byte(key.PrivateKeyString(priv)),
-		DS:       []byte(key.ToDS(dns.SHA256).String()),
-	}, nil
+	}
+	if role == KSK {
+		ret.DS = []byte(key.ToDS(dns.SHA256).String())
+	}
+	return ret, nil
 }
 
 // TODO(gio): not going to work in 15 years?
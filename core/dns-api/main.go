@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"strings"
+	"time"
 )
 
 var port = flag.Int("port", 8080, "Port to listen on")
@@ -13,16 +14,21 @@ var zone = flag.String("zone", "", "Zone domain")
 var publicIPs = flag.String("public-ip", "", "Comma separated list of public IPs of the pcloud environment")
 var privateIP = flag.String("private-ip", "", "Private IP of the pcloud environment")
 var nameserverIPs = flag.String("nameserver-ip", "", "Comma separated list of nameserver IPs")
+var zskLifetime = flag.Duration("zsk-lifetime", 30*24*time.Hour, "Lifetime of the zone signing key after which it is rolled over, zero disables automatic rollovers")
+var keyPropagationDelay = flag.Duration("key-propagation-delay", 24*time.Hour, "How long retired DNSSEC keys stay published")
+
+const keyMaintenanceInterval = time.Hour
 
 func main() {
 	flag.Parse()
 	publicIP := strings.Split(*publicIPs, ",")
 	nameserverIP := strings.Split(*nameserverIPs, ",")
 	fs := osFS{*rootDir}
-	store, ds, err := NewStore(fs, *config, *db, *zone, publicIP, *privateIP, nameserverIP)
+	store, keys, err := NewStore(fs, *config, *db, *zone, publicIP, *privateIP, nameserverIP, KeyOptions{*zskLifetime, *keyPropagationDelay})
 	if err != nil {
 		panic(err)
 	}
-	server := NewServer(*port, *zone, ds, store, nameserverIP)
+	go keys.Run(keyMaintenanceInterval)
+	server := NewServer(*port, *zone, store, keys, nameserverIP)
 	server.Start()
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultTTL = 300
+	maxTTL     = 7 * 24 * 60 * 60
+	// Maximum length of the single character string within TXT record.
+	maxTXTStringLen = 255
+)
+
+var (
+	ErrInvalidRecord   = errors.New("invalid record")
+	ErrRecordExists    = errors.New("record already exists")
+	ErrRecordNotFound  = errors.New("record not found")
+	ErrProtectedRecord = errors.New("record is managed by dns-api")
+)
+
+var supportedTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CNAME": dns.TypeCNAME,
+	"MX":    dns.TypeMX,
+	"SRV":   dns.TypeSRV,
+	"CAA":   dns.TypeCAA,
+	"TXT":   dns.TypeTXT,
+}
+
+// Record is the single resource record of the zone. Name is relative to the
+// zone, @ denoting the zone apex. Value is the record data in zone file
+// presentation format, for example "10 mx.example.com." for MX records,
+// except for TXT records where it is the unquoted text.
+type Record struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	TTL       uint32 `json:"ttl,omitempty"`
+	Value     string `json:"value"`
+	Protected bool   `json:"protected,omitempty"`
+}
+
+func parseType(t string) (uint16, error) {
+	if rrtype, ok := supportedTypes[strings.ToUpper(t)]; ok {
+		return rrtype, nil
+	}
+	return 0, fmt.Errorf("%w: unsupported type %s", ErrInvalidRecord, t)
+}
+
+// fqdn converts name relative to the zone into fully qualified one. Fully
+// qualified names must be within the zone.
+func fqdn(name, zone string) (string, error) {
+	zone = dns.Fqdn(zone)
+	var ret string
+	switch {
+	case name == "" || name == "@":
+		ret = zone
+	case dns.IsFqdn(name):
+		ret = name
+	default:
+		ret = name + "." + zone
+	}
+	ret = dns.CanonicalName(ret)
+	if _, ok := dns.IsDomainName(ret); !ok {
+		return "", fmt.Errorf("%w: invalid name %s", ErrInvalidRecord, name)
+	}
+	if !dns.IsSubDomain(zone, ret) {
+		return "", fmt.Errorf("%w: %s is outside of zone %s", ErrInvalidRecord, name, zone)
+	}
+	return ret, nil
+}
+
+func relativeName(name, zone string) string {
+	zone = dns.Fqdn(zone)
+	if strings.EqualFold(name, zone) {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+zone)
+}
+
+// toRR validates the record and converts it into the resource record.
+func (r Record) toRR(zone string) (dns.RR, error) {
+	rrtype, err := parseType(r.Type)
+	if err != nil {
+		return nil, err
+	}
+	name, err := fqdn(r.Name, zone)
+	if err != nil {
+		return nil, err
+	}
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	if ttl > maxTTL {
+		return nil, fmt.Errorf("%w: ttl must not exceed %d", ErrInvalidRecord, maxTTL)
+	}
+	if r.Value == "" {
+		return nil, fmt.Errorf("%w: value is required", ErrInvalidRecord)
+	}
+	// Make sure value can not inject additional records into the zone file.
+	if strings.ContainsAny(r.Value, "\r\n") {
+		return nil, fmt.Errorf("%w: value must be a single line", ErrInvalidRecord)
+	}
+	hdr := dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	if rrtype == dns.TypeTXT {
+		return &dns.TXT{Hdr: hdr, Txt: splitTXT(r.Value)}, nil
+	}
+	p := dns.NewZoneParser(strings.NewReader(fmt.Sprintf("%s %d IN %s %s", name, ttl, dns.TypeToString[rrtype], r.Value)), dns.Fqdn(zone), "")
+	p.SetIncludeAllowed(false)
+	rr, ok := p.Next()
+	if err := p.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+	}
+	if !ok || rr.Header().Rrtype != rrtype {
+		return nil, fmt.Errorf("%w: invalid %s value %s", ErrInvalidRecord, r.Type, r.Value)
+	}
+	if _, ok := p.Next(); ok {
+		return nil, fmt.Errorf("%w: value must define single record", ErrInvalidRecord)
+	}
+	return rr, nil
+}
+
+func fromRR(rr dns.RR, zone string) Record {
+	hdr := rr.Header()
+	ret := Record{
+		Name: relativeName(hdr.Name, zone),
+		Type: dns.TypeToString[hdr.Rrtype],
+		TTL:  hdr.Ttl,
+	}
+	if txt, ok := rr.(*dns.TXT); ok {
+		ret.Value = strings.Join(txt.Txt, "")
+	} else {
+		ret.Value = strings.TrimSpace(strings.TrimPrefix(rr.String(), hdr.String()))
+	}
+	return ret
+}
+
+// splitTXT splits long text, such as DKIM public keys, into multiple
+// character strings as each of them is limited to 255 bytes.
+func splitTXT(value string) []string {
+	var ret []string
+	for len(value) > maxTXTStringLen {
+		ret = append(ret, value[:maxTXTStringLen])
+		value = value[maxTXTStringLen:]
+	}
+	return append(ret, value)
+}
+
+// protection determines which records were generated by dns-api itself
+// and can not be modified through the API.
+type protection struct {
+	zone        string
+	nameservers int
+}
+
+func (p protection) isProtected(name string, rrtype uint16) bool {
+	zone := dns.Fqdn(p.zone)
+	switch {
+	case rrtype == dns.TypeSOA || rrtype == dns.TypeNS:
+		return true
+	case strings.HasPrefix(name, "*."):
+		return true
+	case strings.EqualFold(name, zone):
+		return rrtype == dns.TypeA
+	}
+	for i := 0; i < p.nameservers; i++ {
+		if strings.EqualFold(name, fmt.Sprintf("ns%d.%s", i+1, zone)) {
+			return true
+		}
+	}
+	return false
+}
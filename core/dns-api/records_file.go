@@ -97,6 +97,107 @@ func (z *RecordsFile) CreateARecord(name, value string) {
 	})
 }
 
+// Records returns records matching given name and type, empty name and
+// zero type matching any.
+func (z *RecordsFile) Records(name string, rrtype uint16) []dns.RR {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	var ret []dns.RR
+	for _, rr := range z.rrs {
+		if matches(rr, name, rrtype) {
+			ret = append(ret, rr)
+		}
+	}
+	return ret
+}
+
+func matches(rr dns.RR, name string, rrtype uint16) bool {
+	hdr := rr.Header()
+	return (name == "" || strings.EqualFold(hdr.Name, name)) && (rrtype == dns.TypeNone || hdr.Rrtype == rrtype)
+}
+
+// checkCNAME makes sure CNAME records do not coexist with other records of
+// the same name.
+func (z *RecordsFile) checkCNAME(name string, rrtype uint16) error {
+	for _, rr := range z.rrs {
+		if !matches(rr, name, dns.TypeNone) {
+			continue
+		}
+		if rrtype == dns.TypeCNAME || rr.Header().Rrtype == dns.TypeCNAME {
+			return fmt.Errorf("%w: CNAME record can not coexist with other records of %s", ErrRecordExists, name)
+		}
+	}
+	return nil
+}
+
+func (z *RecordsFile) AddRecord(rr dns.RR) error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	hdr := rr.Header()
+	for _, r := range z.rrs {
+		if dns.IsDuplicate(r, rr) {
+			return fmt.Errorf("%w: %s", ErrRecordExists, rr)
+		}
+	}
+	if err := z.checkCNAME(hdr.Name, hdr.Rrtype); err != nil {
+		return err
+	}
+	// All records of the same set must share TTL.
+	for _, r := range z.rrs {
+		if matches(r, hdr.Name, hdr.Rrtype) {
+			r.Header().Ttl = hdr.Ttl
+		}
+	}
+	z.rrs = append(z.rrs, rr)
+	return nil
+}
+
+// ReplaceRecords replaces all records of given name and type.
+func (z *RecordsFile) ReplaceRecords(name string, rrtype uint16, rrs []dns.RR) error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	prev := z.remove(func(rr dns.RR) bool {
+		return matches(rr, name, rrtype)
+	})
+	if len(rrs) > 0 {
+		if err := z.checkCNAME(name, rrtype); err != nil {
+			z.rrs = prev
+			return err
+		}
+	}
+	z.rrs = append(z.rrs, rrs...)
+	return nil
+}
+
+// remove removes records matching the predicate and returns previous list
+// of the records.
+func (z *RecordsFile) remove(pred func(rr dns.RR) bool) []dns.RR {
+	prev := z.rrs
+	rrs := make([]dns.RR, 0, len(z.rrs))
+	for _, rr := range z.rrs {
+		if !pred(rr) {
+			rrs = append(rrs, rr)
+		}
+	}
+	z.rrs = rrs
+	return prev
+}
+
+// DeleteRecords deletes records of given name and type, only the ones
+// having given data if value is not nil. Returns number of deleted records.
+func (z *RecordsFile) DeleteRecords(name string, rrtype uint16, value dns.RR) int {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	before := len(z.rrs)
+	z.remove(func(rr dns.RR) bool {
+		if value != nil {
+			return dns.IsDuplicate(rr, value)
+		}
+		return matches(rr, name, rrtype)
+	})
+	return before - len(z.rrs)
+}
+
 func (z *RecordsFile) Write(w io.Writer) error {
 	z.lock.Lock()
 	defer z.lock.Unlock()
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newTestStore(t *testing.T) (RecordStore, FS) {
+	store, _, fs := newTestZone(t, KeyOptions{})
+	return store, fs
+}
+
+func newTestZone(t *testing.T, keyOpts KeyOptions) (RecordStore, *KeyManager, FS) {
+	fs := osFS{t.TempDir()}
+	store, keys, err := NewStore(fs, "coredns.conf", "records.db", "foo.bar.ge", []string{"1.2.3.4"}, "10.0.1.0", []string{"4.3.2.1", "8.7.6.5"}, keyOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, keys, fs
+}
+
+func TestRecordValidation(t *testing.T) {
+	for _, test := range []struct {
+		record Record
+		err    error
+	}{
+		{Record{Name: "mail", Type: "A", Value: "1.2.3.4"}, nil},
+		{Record{Name: "mail", Type: "A", Value: "::1"}, ErrInvalidRecord},
+		{Record{Name: "mail", Type: "AAAA", Value: "::1"}, nil},
+		{Record{Name: "@", Type: "MX", Value: "10 mail"}, nil},
+		{Record{Name: "@", Type: "MX", Value: "mail"}, ErrInvalidRecord},
+		{Record{Name: "_imaps._tcp", Type: "SRV", Value: "0 1 993 mail.foo.bar.ge."}, nil},
+		{Record{Name: "@", Type: "CAA", Value: `0 issue "letsencrypt.org"`}, nil},
+		{Record{Name: "www", Type: "CNAME", Value: "foo.bar.ge."}, nil},
+		{Record{Name: "@", Type: "TXT", Value: "v=spf1 mx -all"}, nil},
+		{Record{Name: "www.example.com.", Type: "A", Value: "1.2.3.4"}, ErrInvalidRecord},
+		{Record{Name: "mail", Type: "NS", Value: "ns.example.com."}, ErrInvalidRecord},
+		{Record{Name: "mail", Type: "A", Value: "1.2.3.4\nevil 300 IN A 1.1.1.1"}, ErrInvalidRecord},
+		{Record{Name: "mail", Type: "A", TTL: maxTTL + 1, Value: "1.2.3.4"}, ErrInvalidRecord},
+	} {
+		_, err := test.record.toRR("foo.bar.ge")
+		if test.err == nil && err != nil {
+			t.Errorf("%+v: unexpected error %s", test.record, err)
+		} else if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%+v: expected %s, got %v", test.record, test.err, err)
+		}
+	}
+}
+
+func TestRecordsCRUD(t *testing.T) {
+	store, _ := newTestStore(t)
+	dkim := "v=DKIM1; k=rsa; p=" + strings.Repeat("A", 400)
+	for _, r := range []Record{
+		{Name: "@", Type: "MX", Value: "10 mail.foo.bar.ge."},
+		{Name: "mail", Type: "A", TTL: 60, Value: "1.2.3.4"},
+		{Name: "default._domainkey", Type: "TXT", Value: dkim},
+	} {
+		if err := store.Create(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Create(Record{Name: "mail", Type: "A", Value: "1.2.3.4"}); !errors.Is(err, ErrRecordExists) {
+		t.Fatalf("Expected duplicate record to be rejected, got %v", err)
+	}
+	if err := store.Create(Record{Name: "mail", Type: "CNAME", Value: "foo.bar.ge."}); !errors.Is(err, ErrRecordExists) {
+		t.Fatalf("Expected CNAME conflict, got %v", err)
+	}
+	for _, r := range []Record{
+		{Name: "@", Type: "A", Value: "5.6.7.8"},
+		{Name: "*", Type: "A", Value: "5.6.7.8"},
+		{Name: "ns1", Type: "A", Value: "5.6.7.8"},
+	} {
+		if err := store.Create(r); !errors.Is(err, ErrProtectedRecord) {
+			t.Fatalf("Expected %+v to be protected, got %v", r, err)
+		}
+	}
+	records, err := store.List("default._domainkey", "txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Value != dkim {
+		t.Fatalf("Unexpected records: %+v", records)
+	}
+	if err := store.Replace("mail", "A", 120, []string{"1.2.3.5", "1.2.3.6"}); err != nil {
+		t.Fatal(err)
+	}
+	records, err = store.List("mail", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].TTL != 120 || records[0].Value != "1.2.3.5" {
+		t.Fatalf("Unexpected records: %+v", records)
+	}
+	if err := store.DeleteRecords("mail", "A", "1.2.3.5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteRecords("mail", "A", "1.2.3.5"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("Expected not found, got %v", err)
+	}
+	if err := store.DeleteRecords("@", "A", ""); !errors.Is(err, ErrProtectedRecord) {
+		t.Fatalf("Expected apex A records to be protected, got %v", err)
+	}
+	records, err = store.List("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected := 0
+	for _, r := range records {
+		if r.Protected {
+			protected++
+		}
+	}
+	// SOA, two nameservers, apex and three wildcards.
+	if len(records) != 10 || protected != 7 {
+		t.Fatalf("Unexpected records: %+v", records)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -11,12 +12,12 @@ type Server struct {
 	s          *http.Server
 	m          *http.ServeMux
 	store      RecordStore
+	keys       *KeyManager
 	zone       string
-	ds         string
 	nameserver []string
 }
 
-func NewServer(port int, zone string, ds string, store RecordStore, nameserver []string) *Server {
+func NewServer(port int, zone string, store RecordStore, keys *KeyManager, nameserver []string) *Server {
 	m := http.NewServeMux()
 	s := &Server{
 		s: &http.Server{
@@ -25,13 +26,20 @@ func NewServer(port int, zone string, ds string, store RecordStore, nameserver [
 		},
 		m:          m,
 		store:      store,
+		keys:       keys,
 		zone:       zone,
-		ds:         ds,
 		nameserver: nameserver,
 	}
 	m.HandleFunc("/records-to-publish", s.recordsToPublish)
 	m.HandleFunc("/create-txt-record", s.createTxtRecord)
 	m.HandleFunc("/delete-txt-record", s.deleteTxtRecord)
+	m.HandleFunc("/records", s.records)
+	m.HandleFunc("/records/", s.recordSet)
+	m.HandleFunc("/dnssec/keys", s.dnsSecKeys)
+	m.HandleFunc("/dnssec/zsk/rollover", s.rolloverZSK)
+	m.HandleFunc("/dnssec/ksk/rollover", s.startKSKRollover)
+	m.HandleFunc("/dnssec/ksk/complete", s.completeKSKRollover)
+	m.HandleFunc("/dnssec/ksk/abort", s.abortKSKRollover)
 	return s
 }
 
@@ -47,9 +55,11 @@ type record struct {
 
 func (s *Server) recordsToPublish(w http.ResponseWriter, r *http.Request) {
 	subdomain := strings.Split(s.zone, ".")[0]
-	if _, err := fmt.Fprintln(w, s.ds); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	for _, ds := range s.keys.DSRecords() {
+		if _, err := fmt.Fprintln(w, ds); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 	for i, ip := range s.nameserver {
 		if _, err := fmt.Fprintf(w, "ns%d.%s. 10800 IN A %s\n", i+1, s.zone, ip); err != nil {
@@ -88,3 +98,169 @@ func (s *Server) deleteTxtRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidRecord):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrProtectedRecord):
+		return http.StatusForbidden
+	case errors.Is(err, ErrRecordNotFound), errors.Is(err, ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrRecordExists), errors.Is(err, ErrRolloverInProgress):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("Failed to write response: %s\n", err)
+	}
+}
+
+// records lists records of the zone, optionally filtered by name and type
+// query parameters, and creates new ones.
+func (s *Server) records(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		records, err := s.store.List(r.FormValue("name"), r.FormValue("type"))
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, records)
+	case http.MethodPost:
+		var req Record
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.store.Create(req); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+type recordSetRequest struct {
+	TTL    uint32   `json:"ttl,omitempty"`
+	Values []string `json:"values"`
+}
+
+// recordSet replaces or deletes records of /records/{name}/{type}. Single
+// record can be deleted by passing its value as a query parameter.
+func (s *Server) recordSet(w http.ResponseWriter, r *http.Request) {
+	name, rrtype, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/records/"), "/")
+	if !ok || name == "" || rrtype == "" || strings.Contains(rrtype, "/") {
+		http.Error(w, "Expected /records/{name}/{type}", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var req recordSetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.store.Replace(name, rrtype, req.TTL, req.Values); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	case http.MethodDelete:
+		if err := s.store.DeleteRecords(name, rrtype, r.FormValue("value")); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) dnsSecKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	keys, err := s.keys.Keys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (s *Server) rolloverZSK(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, err := s.keys.RolloverZSK()
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, key)
+}
+
+// startKSKRollover generates new KSK and responds with its DS record, which
+// is served by /records-to-publish from now on as well.
+func (s *Server) startKSKRollover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, err := s.keys.StartKSKRollover()
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, key)
+}
+
+type kskRolloverRequest struct {
+	KeyTag uint16 `json:"keyTag"`
+}
+
+func (s *Server) completeKSKRollover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req kskRolloverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.keys.CompleteKSKRollover(req.KeyTag); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// abortKSKRollover drops pending KSK, for example when its DS record was
+// never published by the parent zone.
+func (s *Server) abortKSKRollover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req kskRolloverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.keys.AbortKSKRollover(req.KeyTag); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,18 +2,34 @@ package main
 
 import (
 	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
 )
 
 type RecordStore interface {
 	Add(entry, txt string) error
 	Delete(entry, txt string) error
+	// Lists records of given name and type, empty ones matching any.
+	List(name, rrtype string) ([]Record, error)
+	Create(r Record) error
+	// Replaces all records of given name and type, deletes them if values
+	// is empty.
+	Replace(name, rrtype string, ttl uint32, values []string) error
+	// Deletes records of given name and type, only the one with given
+	// value if not empty.
+	DeleteRecords(name, rrtype, value string) error
 }
 
 type fsRecordStore struct {
-	zone     string
-	publicIP []string
-	fs       FS
-	db       string
+	// Serializes read-modify-write cycles of the records file.
+	lock      sync.Mutex
+	zone      string
+	publicIP  []string
+	fs        FS
+	db        string
+	protected protection
 }
 
 func (s *fsRecordStore) read() (*RecordsFile, error) {
@@ -33,26 +49,138 @@ func (s *fsRecordStore) write(z *RecordsFile) error {
 	return z.Write(w)
 }
 
-func (s *fsRecordStore) Add(entry, txt string) error {
+func (s *fsRecordStore) update(f func(z *RecordsFile) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	z, err := s.read()
 	if err != nil {
 		return err
 	}
-	fqdn := fmt.Sprintf("%s.%s.", entry, s.zone)
-	z.CreateOrReplaceTxtRecord(fqdn, txt)
-	// for _, ip := range s.publicIP {
-	// 	z.CreateARecord(fqdn, ip)
-	// }
+	if err := f(z); err != nil {
+		return err
+	}
 	return s.write(z)
 }
 
+func (s *fsRecordStore) Add(entry, txt string) error {
+	return s.update(func(z *RecordsFile) error {
+		fqdn := fmt.Sprintf("%s.%s.", entry, s.zone)
+		z.CreateOrReplaceTxtRecord(fqdn, txt)
+		// for _, ip := range s.publicIP {
+		// 	z.CreateARecord(fqdn, ip)
+		// }
+		return nil
+	})
+}
+
 func (s *fsRecordStore) Delete(entry, txt string) error {
+	return s.update(func(z *RecordsFile) error {
+		fqdn := fmt.Sprintf("%s.%s.", entry, s.zone)
+		z.DeleteTxtRecord(fqdn, txt)
+		// z.DeleteRecordsFor(fqdn)
+		return nil
+	})
+}
+
+func (s *fsRecordStore) List(name, rrtype string) ([]Record, error) {
+	var t uint16
+	if rrtype != "" {
+		var ok bool
+		if t, ok = dns.StringToType[strings.ToUpper(rrtype)]; !ok {
+			return nil, fmt.Errorf("%w: unknown type %s", ErrInvalidRecord, rrtype)
+		}
+	}
+	if name != "" {
+		var err error
+		if name, err = fqdn(name, s.zone); err != nil {
+			return nil, err
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	z, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	ret := []Record{}
+	for _, rr := range z.Records(name, t) {
+		r := fromRR(rr, s.zone)
+		r.Protected = s.protected.isProtected(rr.Header().Name, rr.Header().Rrtype)
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// toRR validates the record and makes sure it is not managed by dns-api.
+func (s *fsRecordStore) toRR(r Record) (dns.RR, error) {
+	rr, err := r.toRR(s.zone)
+	if err != nil {
+		return nil, err
+	}
+	if s.protected.isProtected(rr.Header().Name, rr.Header().Rrtype) {
+		return nil, fmt.Errorf("%w: %s %s", ErrProtectedRecord, r.Name, r.Type)
+	}
+	return rr, nil
+}
+
+func (s *fsRecordStore) Create(r Record) error {
+	rr, err := s.toRR(r)
 	if err != nil {
 		return err
 	}
-	fqdn := fmt.Sprintf("%s.%s.", entry, s.zone)
-	z.DeleteTxtRecord(fqdn, txt)
-	// z.DeleteRecordsFor(fqdn)
-	return s.write(z)
+	return s.update(func(z *RecordsFile) error {
+		return z.AddRecord(rr)
+	})
+}
+
+func (s *fsRecordStore) Replace(name, rrtype string, ttl uint32, values []string) error {
+	rrs := make([]dns.RR, 0, len(values))
+	for _, v := range values {
+		rr, err := s.toRR(Record{Name: name, Type: rrtype, TTL: ttl, Value: v})
+		if err != nil {
+			return err
+		}
+		rrs = append(rrs, rr)
+	}
+	n, err := fqdn(name, s.zone)
+	if err != nil {
+		return err
+	}
+	t, err := parseType(rrtype)
+	if err != nil {
+		return err
+	}
+	if s.protected.isProtected(n, t) {
+		return fmt.Errorf("%w: %s %s", ErrProtectedRecord, name, rrtype)
+	}
+	return s.update(func(z *RecordsFile) error {
+		return z.ReplaceRecords(n, t, rrs)
+	})
+}
+
+func (s *fsRecordStore) DeleteRecords(name, rrtype, value string) error {
+	var rr dns.RR
+	if value != "" {
+		var err error
+		if rr, err = s.toRR(Record{Name: name, Type: rrtype, Value: value}); err != nil {
+			return err
+		}
+	}
+	n, err := fqdn(name, s.zone)
+	if err != nil {
+		return err
+	}
+	t, err := parseType(rrtype)
+	if err != nil {
+		return err
+	}
+	if s.protected.isProtected(n, t) {
+		return fmt.Errorf("%w: %s %s", ErrProtectedRecord, name, rrtype)
+	}
+	return s.update(func(z *RecordsFile) error {
+		if z.DeleteRecords(n, t, rr) == 0 {
+			return fmt.Errorf("%w: %s %s", ErrRecordNotFound, name, rrtype)
+		}
+		return nil
+	})
 }
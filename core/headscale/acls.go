@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (s *server) getACLs(c echo.Context) error {
+	d, err := os.ReadFile(s.aclsPath)
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/json", d)
+}
+
+// updateACLs replaces ACL policy and makes headscale reload it. Policy is
+// left untouched if it has not changed, so it can be pushed periodically.
+func (s *server) updateACLs(c echo.Context) error {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	var policy map[string]any
+	if err := json.Unmarshal(body, &policy); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid policy: %s", err))
+	}
+	if current, err := os.ReadFile(s.aclsPath); err == nil && bytes.Equal(current, body) {
+		return c.NoContent(http.StatusOK)
+	}
+	tmp := s.aclsPath + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.aclsPath); err != nil {
+		return err
+	}
+	if err := reloadHeadscale(); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// reloadHeadscale sends SIGHUP to the headscale server process, upon which it
+// reloads ACL policy. Requires pod to share process namespace.
+func reloadHeadscale() error {
+	procs, err := filepath.Glob("/proc/[0-9]*/cmdline")
+	if err != nil {
+		return err
+	}
+	for _, p := range procs {
+		d, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		args := strings.Split(strings.TrimRight(string(d), "\x00"), "\x00")
+		if filepath.Base(args[0]) != "headscale" || args[len(args)-1] != "serve" {
+			continue
+		}
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(p)))
+		if err != nil {
+			return err
+		}
+		return syscall.Kill(pid, syscall.SIGHUP)
+	}
+	return errors.New("headscale process not found")
+}
@@ -1,45 +1,350 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 )
 
+// apiError is the error returned by headscale gRPC gateway.
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("headscale: %d %s", e.Status, e.Message)
+}
+
+type User struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type PreAuthKey struct {
+	Id         string    `json:"id"`
+	User       string    `json:"user"`
+	Key        string    `json:"key"`
+	Reusable   bool      `json:"reusable"`
+	Ephemeral  bool      `json:"ephemeral"`
+	Used       bool      `json:"used"`
+	Expiration time.Time `json:"expiration"`
+	CreatedAt  time.Time `json:"createdAt"`
+	AclTags    []string  `json:"aclTags,omitempty"`
+}
+
+func (k PreAuthKey) expired(now time.Time) bool {
+	return !k.Expiration.IsZero() && !now.Before(k.Expiration)
+}
+
+// Node is called machine by headscale 0.22 API.
+type Node struct {
+	Id          string    `json:"id"`
+	Name        string    `json:"name"`
+	GivenName   string    `json:"givenName"`
+	User        User      `json:"user"`
+	IpAddresses []string  `json:"ipAddresses"`
+	Online      bool      `json:"online"`
+	LastSeen    time.Time `json:"lastSeen"`
+	Expiry      time.Time `json:"expiry"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ForcedTags  []string  `json:"forcedTags,omitempty"`
+	ValidTags   []string  `json:"validTags,omitempty"`
+}
+
+type Route struct {
+	Id         string    `json:"id"`
+	Node       Node      `json:"machine"`
+	Prefix     string    `json:"prefix"`
+	Advertised bool      `json:"advertised"`
+	Enabled    bool      `json:"enabled"`
+	IsPrimary  bool      `json:"isPrimary"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type apiKey struct {
+	Id         string    `json:"id"`
+	Prefix     string    `json:"prefix"`
+	Expiration time.Time `json:"expiration"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// client talks to headscale REST API authenticating with an API key.
 type client struct {
-	config string
+	addr       string
+	httpClient *http.Client
+	// Guards apiKey which is replaced when refreshed.
+	lock   sync.Mutex
+	apiKey string
 }
 
-func newClient(config string) *client {
+func newClient(addr, apiKey string) *client {
 	return &client{
-		config: fmt.Sprintf("--config=%s", config),
+		addr:       strings.TrimSuffix(addr, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
 	}
 }
 
-func (c *client) createUser(name string) error {
-	cmd := exec.Command("headscale", c.config, "users", "create", name)
-	out, err := cmd.Output()
-	fmt.Println(string(out))
-	return err
+func (c *client) do(method, path string, req, resp any) error {
+	var body io.Reader
+	if req != nil {
+		var d bytes.Buffer
+		if err := json.NewEncoder(&d).Encode(req); err != nil {
+			return err
+		}
+		body = &d
+	}
+	r, err := http.NewRequest(method, c.addr+path, body)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	r.Header.Set("Authorization", "Bearer "+c.apiKey)
+	c.lock.Unlock()
+	if req != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		e := &apiError{Status: res.StatusCode}
+		d, _ := io.ReadAll(res.Body)
+		if err := json.Unmarshal(d, e); err != nil || e.Message == "" {
+			e.Message = strings.TrimSpace(string(d))
+		}
+		// headscale does not map store errors to gRPC codes.
+		if strings.Contains(e.Message, "already exists") {
+			e.Status = http.StatusConflict
+		} else if strings.Contains(strings.ToLower(e.Message), "not found") {
+			e.Status = http.StatusNotFound
+		}
+		return e
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
 }
 
-func (c *client) createPreAuthKey(user string) (string, error) {
-	// TODO(giolekva): make expiration configurable, and auto-refresh
-	cmd := exec.Command("headscale", c.config, "--user", user, "preauthkeys", "create", "--reusable", "--expiration", "365d")
-	out, err := cmd.Output()
+func (c *client) listUsers() ([]User, error) {
+	var resp struct {
+		Users []User `json:"users"`
+	}
+	if err := c.do(http.MethodGet, "/api/v1/user", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Users, nil
+}
+
+func (c *client) createUser(name string) (User, error) {
+	var resp struct {
+		User User `json:"user"`
+	}
+	if err := c.do(http.MethodPost, "/api/v1/user", map[string]string{"name": name}, &resp); err != nil {
+		return User{}, err
+	}
+	return resp.User, nil
+}
+
+// deleteUser deletes the user along with all of its nodes, as headscale
+// refuses to delete users having any.
+func (c *client) deleteUser(name string) error {
+	nodes, err := c.listNodes(name)
 	if err != nil {
-		return "", err
+		return err
+	}
+	for _, n := range nodes {
+		if err := c.deleteNode(n.Id); err != nil {
+			return err
+		}
 	}
-	fmt.Println(string(out))
-	return extractLastLine(string(out))
+	return c.do(http.MethodDelete, fmt.Sprintf("/api/v1/user/%s", url.PathEscape(name)), nil, nil)
+}
+
+// listNodes returns nodes of the given user, all of them if user is empty.
+func (c *client) listNodes(user string) ([]Node, error) {
+	var resp struct {
+		Nodes []Node `json:"machines"`
+	}
+	path := "/api/v1/machine"
+	if user != "" {
+		path = fmt.Sprintf("%s?user=%s", path, url.QueryEscape(user))
+	}
+	if err := c.do(http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Nodes, nil
+}
+
+func (c *client) deleteNode(id string) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("/api/v1/machine/%s", url.PathEscape(id)), nil, nil)
+}
+
+func (c *client) listRoutes() ([]Route, error) {
+	var resp struct {
+		Routes []Route `json:"routes"`
+	}
+	if err := c.do(http.MethodGet, "/api/v1/routes", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Routes, nil
 }
 
 func (c *client) enableRoute(id string) error {
-	// TODO(giolekva): make expiration configurable, and auto-refresh
-	cmd := exec.Command("headscale", c.config, "routes", "enable", "-r", id)
+	return c.do(http.MethodPost, fmt.Sprintf("/api/v1/routes/%s/enable", url.PathEscape(id)), nil, nil)
+}
+
+func (c *client) disableRoute(id string) error {
+	return c.do(http.MethodPost, fmt.Sprintf("/api/v1/routes/%s/disable", url.PathEscape(id)), nil, nil)
+}
+
+func (c *client) listPreAuthKeys(user string) ([]PreAuthKey, error) {
+	var resp struct {
+		PreAuthKeys []PreAuthKey `json:"preAuthKeys"`
+	}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/api/v1/preauthkey?user=%s", url.QueryEscape(user)), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.PreAuthKeys, nil
+}
+
+func (c *client) createPreAuthKey(user string, reusable bool, expiration time.Duration) (PreAuthKey, error) {
+	var resp struct {
+		PreAuthKey PreAuthKey `json:"preAuthKey"`
+	}
+	if err := c.do(http.MethodPost, "/api/v1/preauthkey", map[string]any{
+		"user":       user,
+		"reusable":   reusable,
+		"expiration": time.Now().Add(expiration).UTC().Format(time.RFC3339),
+	}, &resp); err != nil {
+		return PreAuthKey{}, err
+	}
+	return resp.PreAuthKey, nil
+}
+
+func (c *client) expirePreAuthKey(user, key string) error {
+	return c.do(http.MethodPost, "/api/v1/preauthkey/expire", map[string]string{
+		"user": user,
+		"key":  key,
+	}, nil)
+}
+
+// rotatePreAuthKey creates new reusable key and expires all the other
+// still valid keys of the user. Nodes already registered with the old keys
+// stay connected.
+func (c *client) rotatePreAuthKey(user string, expiration time.Duration) (PreAuthKey, error) {
+	keys, err := c.listPreAuthKeys(user)
+	if err != nil {
+		return PreAuthKey{}, err
+	}
+	key, err := c.createPreAuthKey(user, true, expiration)
+	if err != nil {
+		return PreAuthKey{}, err
+	}
+	now := time.Now()
+	for _, k := range keys {
+		if k.expired(now) || (k.Used && !k.Reusable) {
+			continue
+		}
+		if err := c.expirePreAuthKey(user, k.Key); err != nil {
+			return PreAuthKey{}, err
+		}
+	}
+	return key, nil
+}
+
+func (c *client) listAPIKeys() ([]apiKey, error) {
+	var resp struct {
+		ApiKeys []apiKey `json:"apiKeys"`
+	}
+	if err := c.do(http.MethodGet, "/api/v1/apikey", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.ApiKeys, nil
+}
+
+func (c *client) createAPIKey(expiration time.Duration) (string, error) {
+	var resp struct {
+		ApiKey string `json:"apiKey"`
+	}
+	if err := c.do(http.MethodPost, "/api/v1/apikey", map[string]string{
+		"expiration": time.Now().Add(expiration).UTC().Format(time.RFC3339),
+	}, &resp); err != nil {
+		return "", err
+	}
+	return resp.ApiKey, nil
+}
+
+func (c *client) expireAPIKey(prefix string) error {
+	return c.do(http.MethodPost, "/api/v1/apikey/expire", map[string]string{"prefix": prefix}, nil)
+}
+
+// refreshAPIKey replaces API key of the client once it gets close to its
+// expiration, persisting the new one at the given path.
+func (c *client) refreshAPIKey(path string, lifetime time.Duration) error {
+	keys, err := c.listAPIKeys()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	prefix, _, _ := strings.Cut(c.apiKey, ".")
+	c.lock.Unlock()
+	for _, k := range keys {
+		if k.Prefix != prefix || time.Until(k.Expiration) > lifetime/4 {
+			continue
+		}
+		key, err := c.createAPIKey(lifetime)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(key), 0600); err != nil {
+			return err
+		}
+		c.lock.Lock()
+		c.apiKey = key
+		c.lock.Unlock()
+		return c.expireAPIKey(prefix)
+	}
+	return nil
+}
+
+// loadAPIKey reads API key from the given path. On the very first start it
+// is created with headscale CLI, which talks to headscale over the unix
+// socket without authentication.
+func loadAPIKey(config, path string, lifetime time.Duration) (string, error) {
+	if d, err := os.ReadFile(path); err == nil {
+		return strings.TrimSpace(string(d)), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	cmd := exec.Command("headscale", fmt.Sprintf("--config=%s", config), "apikeys", "create", "--expiration", fmt.Sprintf("%dh", int(lifetime.Hours())))
 	out, err := cmd.Output()
-	fmt.Println(string(out))
-	return err
+	if err != nil {
+		return "", err
+	}
+	key, err := extractLastLine(string(out))
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(key), 0600); err != nil {
+		return "", err
+	}
+	return key, nil
 }
 
 func extractLastLine(s string) (string, error) {
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeHeadscale struct {
+	t       *testing.T
+	keys    []PreAuthKey
+	expired []string
+	deleted []string
+}
+
+func (f *fakeHeadscale) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer test-key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Unauthorized"))
+		return
+	}
+	switch r.Method + " " + r.URL.Path {
+	case "POST /api/v1/user":
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"code": 2, "message": "user already exists", "details": []}`))
+	case "GET /api/v1/preauthkey":
+		json.NewEncoder(w).Encode(map[string]any{"preAuthKeys": f.keys})
+	case "POST /api/v1/preauthkey":
+		key := PreAuthKey{Id: "3", User: "foo", Key: "new", Reusable: true, Expiration: time.Now().Add(time.Hour)}
+		json.NewEncoder(w).Encode(map[string]any{"preAuthKey": key})
+	case "POST /api/v1/preauthkey/expire":
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			f.t.Fatal(err)
+		}
+		f.expired = append(f.expired, req["key"])
+		w.Write([]byte("{}"))
+	case "GET /api/v1/machine":
+		json.NewEncoder(w).Encode(map[string]any{"machines": []Node{{Id: "1"}, {Id: "2"}}})
+	case "DELETE /api/v1/machine/1", "DELETE /api/v1/machine/2", "DELETE /api/v1/user/foo":
+		f.deleted = append(f.deleted, r.URL.Path)
+		w.Write([]byte("{}"))
+	case "DELETE /api/v1/user/bar":
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"code": 2, "message": "User not found", "details": []}`))
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte(`{"code": 12, "message": "Not Implemented"}`))
+	}
+}
+
+func TestRotatePreAuthKey(t *testing.T) {
+	now := time.Now()
+	f := &fakeHeadscale{t: t, keys: []PreAuthKey{
+		{Id: "1", Key: "valid", Reusable: true, Expiration: now.Add(time.Hour)},
+		{Id: "2", Key: "expired", Reusable: true, Expiration: now.Add(-time.Hour)},
+		{Id: "3", Key: "used", Used: true, Expiration: now.Add(time.Hour)},
+	}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	c := newClient(srv.URL, "test-key")
+	key, err := c.rotatePreAuthKey("foo", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Key != "new" {
+		t.Fatalf("Expected new key, got %+v", key)
+	}
+	if len(f.expired) != 1 || f.expired[0] != "valid" {
+		t.Fatalf("Expected only valid key to be expired, got %v", f.expired)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	srv := httptest.NewServer(&fakeHeadscale{t: t})
+	defer srv.Close()
+	var ae *apiError
+	if _, err := newClient(srv.URL, "test-key").createUser("foo"); !errors.As(err, &ae) || ae.Status != http.StatusConflict {
+		t.Fatalf("Expected conflict, got %v", err)
+	}
+	if _, err := newClient(srv.URL, "wrong").listUsers(); !errors.As(err, &ae) || ae.Status != http.StatusUnauthorized || ae.Message != "Unauthorized" {
+		t.Fatalf("Expected unauthorized, got %v", err)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	f := &fakeHeadscale{t: t}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	c := newClient(srv.URL, "test-key")
+	if err := c.deleteUser("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.deleted) != 3 || f.deleted[2] != "/api/v1/user/foo" {
+		t.Fatalf("Expected nodes to be deleted before the user, got %v", f.deleted)
+	}
+	var ae *apiError
+	if err := c.deleteUser("bar"); !errors.As(err, &ae) || ae.Status != http.StatusNotFound {
+		t.Fatalf("Expected not found, got %v", err)
+	}
+}
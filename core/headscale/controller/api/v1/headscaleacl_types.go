@@ -0,0 +1,81 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ACLRule grants members of the memberships group access to destinations.
+type ACLRule struct {
+	// Memberships group, members of its child groups included. "*" matches
+	// everyone.
+	Group string `json:"group"`
+	// Destinations in headscale ACL format, for example "10.1.0.0/24:*".
+	Destinations []string `json:"destinations"`
+}
+
+type SecretKeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// HeadscaleACLSpec defines the desired state of HeadscaleACL
+type HeadscaleACLSpec struct {
+	HeadscaleAddress   string `json:"headscaleAddress,omitempty"`
+	MembershipsAddress string `json:"membershipsAddress,omitempty"`
+	// Secret holding token of the memberships management API, if it
+	// requires authentication.
+	MembershipsToken *SecretKeyRef `json:"membershipsToken,omitempty"`
+	// Subnet routes advertised by any node which are approved automatically.
+	AutoApproveRoutes []string  `json:"autoApproveRoutes,omitempty"`
+	Rules             []ACLRule `json:"rules,omitempty"`
+}
+
+// HeadscaleACLStatus defines the observed state of HeadscaleACL
+type HeadscaleACLStatus struct {
+	Ready bool   `json:"ready,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Resolved members of each group referenced by the rules.
+	Groups     map[string][]string `json:"groups,omitempty"`
+	LastSynced *metav1.Time        `json:"lastSynced,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// HeadscaleACL is the Schema for the headscaleacls API
+type HeadscaleACL struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HeadscaleACLSpec   `json:"spec,omitempty"`
+	Status HeadscaleACLStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HeadscaleACLList contains a list of HeadscaleACL
+type HeadscaleACLList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HeadscaleACL `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HeadscaleACL{}, &HeadscaleACLList{})
+}
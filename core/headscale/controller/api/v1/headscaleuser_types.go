@@ -26,6 +26,9 @@ import (
 type PreAuthKey struct {
 	Enabled    bool   `json:"enabled,omitempty"`
 	SecretName string `json:"secretName,omitempty"`
+	// Lifetime of the key, which is rotated once a quarter of it remains.
+	// Defaults to a year.
+	Expiration *metav1.Duration `json:"expiration,omitempty"`
 }
 
 // HeadscaleUserSpec defines the desired state of HeadscaleUser
@@ -43,7 +46,10 @@ type HeadscaleUserStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	Ready bool `json:"ready,omitempty"`
+	Ready      bool               `json:"ready,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Expiration of the key stored in the pre-authenticated key secret.
+	PreAuthKeyExpiration *metav1.Time `json:"preAuthKeyExpiration,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,252 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	headscalev1 "github.com/giolekva/pcloud/api/v1"
+)
+
+const everyone = "*"
+
+type MembershipsClient struct {
+	addr       string
+	token      string
+	httpClient *http.Client
+}
+
+func NewMembershipsClient(addr, token string) *MembershipsClient {
+	return &MembershipsClient{
+		addr,
+		token,
+		&http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type membershipsGroup struct {
+	Name string `json:"name"`
+}
+
+type membershipsGroupDetails struct {
+	Members     []string           `json:"members"`
+	ChildGroups []membershipsGroup `json:"childGroups"`
+}
+
+func (c *MembershipsClient) getGroup(name string) (membershipsGroupDetails, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/groups/%s", c.addr, url.PathEscape(name)), nil)
+	if err != nil {
+		return membershipsGroupDetails{}, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return membershipsGroupDetails{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return membershipsGroupDetails{}, fmt.Errorf("Could not get group %s: %s", name, resp.Status)
+	}
+	var ret membershipsGroupDetails
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return membershipsGroupDetails{}, err
+	}
+	return ret, nil
+}
+
+// GroupMembers returns members of the group and all of its descendant
+// groups.
+func (c *MembershipsClient) GroupMembers(group string) ([]string, error) {
+	members := map[string]struct{}{}
+	visited := map[string]struct{}{}
+	queue := []string{group}
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[g]; ok {
+			continue
+		}
+		visited[g] = struct{}{}
+		details, err := c.getGroup(g)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range details.Members {
+			members[m] = struct{}{}
+		}
+		for _, child := range details.ChildGroups {
+			queue = append(queue, child.Name)
+		}
+	}
+	ret := make([]string, 0, len(members))
+	for m := range members {
+		ret = append(ret, m)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+type aclPolicy struct {
+	Groups        map[string][]string `json:"groups,omitempty"`
+	AutoApprovers aclAutoApprovers    `json:"autoApprovers"`
+	ACLs          []aclPolicyRule     `json:"acls"`
+}
+
+type aclAutoApprovers struct {
+	Routes map[string][]string `json:"routes,omitempty"`
+}
+
+type aclPolicyRule struct {
+	Action string   `json:"action"`
+	Src    []string `json:"src"`
+	Dst    []string `json:"dst"`
+}
+
+// generatePolicy converts rules into headscale ACL policy, mapping each
+// memberships group onto headscale group of the same name. Rules of groups
+// without members are dropped, as headscale rejects empty sources.
+func generatePolicy(spec headscalev1.HeadscaleACLSpec, members func(group string) ([]string, error)) (aclPolicy, map[string][]string, error) {
+	ret := aclPolicy{
+		Groups: map[string][]string{},
+		ACLs:   []aclPolicyRule{},
+	}
+	resolved := map[string][]string{}
+	if len(spec.AutoApproveRoutes) > 0 {
+		ret.AutoApprovers.Routes = map[string][]string{}
+		for _, r := range spec.AutoApproveRoutes {
+			ret.AutoApprovers.Routes[r] = []string{everyone}
+		}
+	}
+	for _, r := range spec.Rules {
+		src := everyone
+		if r.Group != everyone {
+			m, ok := resolved[r.Group]
+			if !ok {
+				var err error
+				if m, err = members(r.Group); err != nil {
+					return aclPolicy{}, nil, err
+				}
+				resolved[r.Group] = m
+			}
+			if len(m) == 0 {
+				continue
+			}
+			src = fmt.Sprintf("group:%s", r.Group)
+			ret.Groups[src] = m
+		}
+		ret.ACLs = append(ret.ACLs, aclPolicyRule{
+			Action: "accept",
+			Src:    []string{src},
+			Dst:    r.Destinations,
+		})
+	}
+	return ret, resolved, nil
+}
+
+// HeadscaleACLReconciler reconciles a HeadscaleACL object. As memberships
+// does not notify about changes, policy is regenerated periodically.
+type HeadscaleACLReconciler struct {
+	client.Client
+	Scheme       *runtime.Scheme
+	ResyncPeriod time.Duration
+}
+
+//+kubebuilder:rbac:groups=headscale.dodo.cloud,resources=headscaleacls,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=headscale.dodo.cloud,resources=headscaleacls/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+func (r *HeadscaleACLReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	logger.Info(req.String())
+
+	resource := &headscalev1.HeadscaleACL{}
+	if err := r.Get(ctx, req.NamespacedName, resource); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{RequeueAfter: time.Minute}, err
+	}
+	groups, err := r.sync(ctx, resource)
+	now := metav1.Now()
+	resource.Status.LastSynced = &now
+	resource.Status.Ready = err == nil
+	resource.Status.Groups = groups
+	resource.Status.Error = ""
+	if err != nil {
+		resource.Status.Error = err.Error()
+	}
+	if err := r.Status().Update(ctx, resource); err != nil {
+		return ctrl.Result{RequeueAfter: time.Minute}, err
+	}
+	if err != nil {
+		return ctrl.Result{RequeueAfter: time.Minute}, err
+	}
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+func (r *HeadscaleACLReconciler) sync(ctx context.Context, resource *headscalev1.HeadscaleACL) (map[string][]string, error) {
+	token := ""
+	if ref := resource.Spec.MembershipsToken; ref != nil {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: resource.Namespace, Name: ref.Name}, secret); err != nil {
+			return nil, err
+		}
+		t, ok := secret.Data[ref.Key]
+		if !ok {
+			return nil, fmt.Errorf("Secret %s does not have key %s", ref.Name, ref.Key)
+		}
+		token = string(t)
+	}
+	memberships := NewMembershipsClient(resource.Spec.MembershipsAddress, token)
+	policy, groups, err := generatePolicy(resource.Spec, memberships.GroupMembers)
+	if err != nil {
+		return nil, err
+	}
+	baseAddr, err := url.Parse(resource.Spec.HeadscaleAddress)
+	if err != nil {
+		return nil, err
+	}
+	if err := NewHeadscaleClient(*baseAddr).UpdateACLs(policy); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HeadscaleACLReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&headscalev1.HeadscaleACL{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
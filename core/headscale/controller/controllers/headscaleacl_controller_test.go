@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"encoding/json"
+	"testing"
+
+	headscalev1 "github.com/giolekva/pcloud/api/v1"
+)
+
+func TestGeneratePolicy(t *testing.T) {
+	members := map[string][]string{
+		"admins": []string{"alice", "bob"},
+		"guests": []string{},
+	}
+	spec := headscalev1.HeadscaleACLSpec{
+		AutoApproveRoutes: []string{"10.1.0.0/24"},
+		Rules: []headscalev1.ACLRule{
+			{Group: "admins", Destinations: []string{"10.1.0.0/24:*"}},
+			{Group: "guests", Destinations: []string{"10.1.0.0/24:*"}},
+			{Group: "*", Destinations: []string{"10.1.0.1:443"}},
+		},
+	}
+	policy, groups, err := generatePolicy(spec, func(group string) ([]string, error) {
+		return members[group], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"groups":{"group:admins":["alice","bob"]},"autoApprovers":{"routes":{"10.1.0.0/24":["*"]}},"acls":[{"action":"accept","src":["group:admins"],"dst":["10.1.0.0/24:*"]},{"action":"accept","src":["*"],"dst":["10.1.0.1:443"]}]}`
+	if string(actual) != expected {
+		t.Fatalf("Expected %s, got %s", expected, actual)
+	}
+	if len(groups) != 2 || len(groups["admins"]) != 2 {
+		t.Fatalf("Unexpected resolved groups: %+v", groups)
+	}
+}
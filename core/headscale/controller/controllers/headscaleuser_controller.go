@@ -19,6 +19,7 @@ package controllers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -27,10 +28,13 @@ import (
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	headscalev1 "github.com/giolekva/pcloud/api/v1"
@@ -66,33 +70,100 @@ func (c *HeadscaleClient) CreateUser(name string) error {
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode != http.StatusOK {
+	// User might have been created by the previous reconciliation.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
 		return fmt.Errorf("Could not create user")
 	}
 	return nil
 }
 
-func (c *HeadscaleClient) CreateReusablePreAuthKey(user string) (string, error) {
+// DeleteUser deletes the user together with its nodes. Missing user is not
+// an error, as it might have been deleted by the previous reconciliation.
+func (c *HeadscaleClient) DeleteUser(name string) error {
 	reqAddr := c.baseUrl
-	reqAddr.Path = fmt.Sprintf("/user/%s/preauthkey", user)
+	reqAddr.Path = fmt.Sprintf("/user/%s", name)
+	req := &http.Request{
+		Method: http.MethodDelete,
+		URL:    &reqAddr,
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Could not delete user: %s %s", resp.Status, msg)
+	}
+	return nil
+}
+
+type preAuthKey struct {
+	Key        string    `json:"key"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// RotatePreAuthKey creates new reusable pre-authenticated key valid for the
+// given duration and expires all the previous ones.
+func (c *HeadscaleClient) RotatePreAuthKey(user string, expiration time.Duration) (preAuthKey, error) {
+	reqAddr := c.baseUrl
+	reqAddr.Path = fmt.Sprintf("/user/%s/preauthkey/rotate", user)
+	reqAddr.RawQuery = url.Values{"expiration": []string{expiration.String()}}.Encode()
 	req := &http.Request{
 		Method: http.MethodPost,
 		URL:    &reqAddr,
 	}
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", err
+		return preAuthKey{}, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Could not create pre-authenticated key")
+		msg, _ := io.ReadAll(resp.Body)
+		return preAuthKey{}, fmt.Errorf("Could not rotate pre-authenticated key: %s %s", resp.Status, msg)
 	}
-	var contents bytes.Buffer
-	if _, err := io.Copy(&contents, resp.Body); err != nil {
-		return "", err
+	var key preAuthKey
+	if err := json.NewDecoder(resp.Body).Decode(&key); err != nil {
+		return preAuthKey{}, err
 	}
-	return contents.String(), nil
+	return key, nil
 }
 
+// UpdateACLs replaces ACL policy of headscale.
+func (c *HeadscaleClient) UpdateACLs(policy any) error {
+	body, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	reqAddr := c.baseUrl
+	reqAddr.Path = "/acls"
+	req := &http.Request{
+		Method: http.MethodPut,
+		URL:    &reqAddr,
+		Header: map[string][]string{
+			"Content-Type": []string{"application/json"},
+		},
+		Body: io.NopCloser(bytes.NewReader(body)),
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Could not update ACLs: %s %s", resp.Status, msg)
+	}
+	return nil
+}
+
+const (
+	headscaleUserFinalizer = "headscale.dodo.cloud/finalizer"
+	conditionReady         = "Ready"
+	// Used when pre-authenticated key lifetime is not specified.
+	defaultPreAuthKeyExpiration = 365 * 24 * time.Hour
+)
+
 // HeadscaleUserReconciler reconciles a HeadscaleUser object
 type HeadscaleUserReconciler struct {
 	client.Client
@@ -105,12 +176,9 @@ type HeadscaleUserReconciler struct {
 //+kubebuilder:rbac:groups=headscale.dodo.cloud,resources=headscaleusers/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the HeadscaleUser object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Reconcile makes sure headscale user exists for as long as the resource
+// does and keeps its pre-authenticated key secret valid, rotating the key
+// once a quarter of its lifetime remains.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.12.2/pkg/reconcile
@@ -119,55 +187,132 @@ func (r *HeadscaleUserReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	logger.Info(req.String())
 
 	resource := &headscalev1.HeadscaleUser{}
-	if err := r.Get(context.Background(), client.ObjectKey{
-		Namespace: req.Namespace,
-		Name:      req.Name,
-	}, resource); err != nil {
+	if err := r.Get(ctx, req.NamespacedName, resource); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
 		return ctrl.Result{RequeueAfter: time.Minute}, err
 	}
-	if resource.Status.Ready {
-		return ctrl.Result{}, nil
-	}
 	baseAddr, err := url.Parse(resource.Spec.HeadscaleAddress)
 	if err != nil {
-		return ctrl.Result{RequeueAfter: time.Minute}, err
+		return r.setFailed(ctx, resource, "InvalidAddress", err)
 	}
 	headscale := NewHeadscaleClient(*baseAddr)
-	if err := headscale.CreateUser(resource.Spec.Name); err != nil {
-		return ctrl.Result{RequeueAfter: time.Minute}, err
-	}
-	if resource.Spec.PreAuthKey.Enabled {
-		key, err := headscale.CreateReusablePreAuthKey(resource.Spec.Name)
-		if err != nil {
-			return ctrl.Result{RequeueAfter: time.Minute}, err
+	if !resource.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(resource, headscaleUserFinalizer) {
+			return ctrl.Result{}, nil
 		}
-		secret := &corev1.Secret{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "secret",
-				APIVersion: "v1",
-			},
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      resource.Spec.PreAuthKey.SecretName,
-				Namespace: req.Namespace,
-			},
-			StringData: map[string]string{
-				"authkey": strings.TrimSpace(key),
-			},
+		if err := headscale.DeleteUser(resource.Spec.Name); err != nil {
+			return r.setFailed(ctx, resource, "UserDeletionFailed", err)
 		}
-		if err := r.Create(context.Background(), secret); err != nil {
+		// Secret is garbage collected through its owner reference.
+		controllerutil.RemoveFinalizer(resource, headscaleUserFinalizer)
+		if err := r.Update(ctx, resource); err != nil {
 			return ctrl.Result{RequeueAfter: time.Minute}, err
 		}
+		return ctrl.Result{}, nil
+	}
+	if !controllerutil.ContainsFinalizer(resource, headscaleUserFinalizer) {
+		controllerutil.AddFinalizer(resource, headscaleUserFinalizer)
+		if err := r.Update(ctx, resource); err != nil {
+			return ctrl.Result{RequeueAfter: time.Minute}, err
+		}
+	}
+	if err := headscale.CreateUser(resource.Spec.Name); err != nil {
+		return r.setFailed(ctx, resource, "UserCreationFailed", err)
+	}
+	var requeueAfter time.Duration
+	if resource.Spec.PreAuthKey.Enabled {
+		if requeueAfter, err = r.reconcilePreAuthKey(ctx, resource, headscale); err != nil {
+			return r.setFailed(ctx, resource, "PreAuthKeyFailed", err)
+		}
 	}
 	resource.Status.Ready = true
-	if err := r.Status().Update(context.Background(), resource); err != nil {
+	meta.SetStatusCondition(&resource.Status.Conditions, metav1.Condition{
+		Type:               conditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Reconciled",
+		ObservedGeneration: resource.Generation,
+	})
+	if err := r.Status().Update(ctx, resource); err != nil {
 		return ctrl.Result{RequeueAfter: time.Minute}, err
 	}
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// setFailed records the error in Ready condition and schedules retry.
+func (r *HeadscaleUserReconciler) setFailed(ctx context.Context, resource *headscalev1.HeadscaleUser, reason string, err error) (ctrl.Result, error) {
+	resource.Status.Ready = false
+	meta.SetStatusCondition(&resource.Status.Conditions, metav1.Condition{
+		Type:               conditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            err.Error(),
+		ObservedGeneration: resource.Generation,
+	})
+	if err := r.Status().Update(ctx, resource); err != nil {
+		log.FromContext(ctx).Error(err, "Could not update status")
+	}
+	return ctrl.Result{RequeueAfter: time.Minute}, err
+}
+
+func preAuthKeyLifetime(spec headscalev1.PreAuthKey) time.Duration {
+	if spec.Expiration == nil || spec.Expiration.Duration <= 0 {
+		return defaultPreAuthKeyExpiration
+	}
+	return spec.Expiration.Duration
+}
+
+// rotateAt returns when the key expiring at given time must be rotated.
+func rotateAt(expiration time.Time, lifetime time.Duration) time.Time {
+	return expiration.Add(-lifetime / 4)
+}
+
+// reconcilePreAuthKey rotates pre-authenticated key if the secret is missing
+// or the key is close to its expiration, updating the secret in place so that
+// its consumers pick up the new key. Returns duration until the next rotation.
+func (r *HeadscaleUserReconciler) reconcilePreAuthKey(ctx context.Context, resource *headscalev1.HeadscaleUser, headscale *HeadscaleClient) (time.Duration, error) {
+	lifetime := preAuthKeyLifetime(resource.Spec.PreAuthKey)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      resource.Spec.PreAuthKey.SecretName,
+			Namespace: resource.Namespace,
+		},
+	}
+	exists := true
+	if err := r.Get(ctx, client.ObjectKeyFromObject(secret), secret); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return 0, err
+		}
+		exists = false
+	}
+	now := time.Now()
+	if exists && len(secret.Data["authkey"]) > 0 && resource.Status.PreAuthKeyExpiration != nil {
+		if next := rotateAt(resource.Status.PreAuthKeyExpiration.Time, lifetime); now.Before(next) {
+			return next.Sub(now), nil
+		}
+	}
+	key, err := headscale.RotatePreAuthKey(resource.Spec.Name, lifetime)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Data = map[string][]byte{
+			"authkey": []byte(strings.TrimSpace(key.Key)),
+		}
+		return controllerutil.SetControllerReference(resource, secret, r.Scheme)
+	}); err != nil {
+		return 0, err
+	}
+	expiration := metav1.NewTime(key.Expiration)
+	resource.Status.PreAuthKeyExpiration = &expiration
+	return rotateAt(key.Expiration, lifetime).Sub(now), nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *HeadscaleUserReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&headscalev1.HeadscaleUser{}).
+		Owns(&corev1.Secret{}).
 		Complete(r)
 }
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRotatePreAuthKey(t *testing.T) {
+	expiration := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/user/foo/preauthkey/rotate" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if e := r.URL.Query().Get("expiration"); e != "2h0m0s" {
+			t.Errorf("Unexpected expiration: %s", e)
+		}
+		w.Write([]byte(`{"id": "1", "key": "secret", "reusable": true, "expiration": "2024-01-01T00:00:00Z"}`))
+	}))
+	defer srv.Close()
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewHeadscaleClient(*addr).RotatePreAuthKey("foo", 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Key != "secret" || !key.Expiration.Equal(expiration) {
+		t.Fatalf("Unexpected key: %+v", key)
+	}
+	if at := rotateAt(expiration, 4*time.Hour); !at.Equal(expiration.Add(-time.Hour)) {
+		t.Fatalf("Expected rotation an hour before expiration, got %s", at)
+	}
+}
+
+func TestDeleteMissingUser(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "user not found"}`))
+	}))
+	defer srv.Close()
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewHeadscaleClient(*addr).DeleteUser("foo"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -18,6 +20,10 @@ var port = flag.Int("port", 3000, "Port to listen on")
 var config = flag.String("config", "", "Path to headscale config")
 var acls = flag.String("acls", "", "Path to the headscale acls file")
 var ipSubnet = flag.String("ip-subnet", "10.1.0.0/24", "IP subnet of the private network")
+var headscaleAddr = flag.String("headscale-addr", "http://localhost:8080", "Address of the headscale API")
+var apiKeyFile = flag.String("api-key-file", "", "Path to the headscale API key, generated on first start if missing")
+var apiKeyLifetime = flag.Duration("api-key-lifetime", 90*24*time.Hour, "Lifetime of the headscale API key, it is refreshed once a quarter of it remains")
+var preAuthKeyExpiration = flag.Duration("preauthkey-expiration", 365*24*time.Hour, "Default expiration of the pre-authenticated keys")
 
 // TODO(gio): make internal network cidr and proxy user configurable
 const defaultACLs = `
@@ -42,25 +48,88 @@ const defaultACLs = `
 `
 
 type server struct {
-	port   int
-	client *client
+	port     int
+	client   *client
+	aclsPath string
 }
 
-func newServer(port int, client *client) *server {
+func newServer(port int, client *client, aclsPath string) *server {
 	return &server{
 		port,
 		client,
+		aclsPath,
 	}
 }
 
 func (s *server) start() {
 	e := echo.New()
-	e.POST("/user/:user/preauthkey", s.createReusablePreAuthKey)
+	e.HTTPErrorHandler = handleError
+	e.GET("/users", s.listUsers)
 	e.POST("/user", s.createUser)
+	e.DELETE("/user/:user", s.deleteUser)
+	e.GET("/user/:user/nodes", s.listUserNodes)
+	e.GET("/user/:user/preauthkeys", s.listPreAuthKeys)
+	e.POST("/user/:user/preauthkey", s.createReusablePreAuthKey)
+	e.POST("/user/:user/preauthkey/rotate", s.rotatePreAuthKey)
+	e.POST("/user/:user/preauthkey/expire", s.expirePreAuthKey)
+	e.GET("/nodes", s.listNodes)
+	e.DELETE("/nodes/:id", s.deleteNode)
+	e.GET("/routes", s.listRoutes)
 	e.POST("/routes/:id/enable", s.enableRoute)
+	e.POST("/routes/:id/disable", s.disableRoute)
+	e.GET("/acls", s.getACLs)
+	e.PUT("/acls", s.updateACLs)
 	log.Fatal(e.Start(fmt.Sprintf(":%d", s.port)))
 }
 
+type errorResp struct {
+	Error string `json:"error"`
+}
+
+// handleError responds with JSON error, preserving status code reported by
+// headscale.
+func handleError(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	status := http.StatusInternalServerError
+	msg := err.Error()
+	var ae *apiError
+	var he *echo.HTTPError
+	if errors.As(err, &ae) {
+		status = ae.Status
+		msg = ae.Message
+	} else if errors.As(err, &he) {
+		status = he.Code
+		msg = fmt.Sprint(he.Message)
+	}
+	if err := c.JSON(status, errorResp{msg}); err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+// expiration parses optional expiration query parameter, falling back to the
+// default one.
+func expiration(c echo.Context) (time.Duration, error) {
+	v := c.QueryParam("expiration")
+	if v == "" {
+		return *preAuthKeyExpiration, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid expiration: %s", v))
+	}
+	return d, nil
+}
+
+func (s *server) listUsers(c echo.Context) error {
+	if users, err := s.client.listUsers(); err != nil {
+		return err
+	} else {
+		return c.JSON(http.StatusOK, users)
+	}
+}
+
 type createUserReq struct {
 	Name string `json:"name"`
 }
@@ -68,20 +137,104 @@ type createUserReq struct {
 func (s *server) createUser(c echo.Context) error {
 	var req createUserReq
 	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
-		return err
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
-	if err := s.client.createUser(req.Name); err != nil {
+	if req.Name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "name is required")
+	}
+	if _, err := s.client.createUser(req.Name); err != nil {
 		return err
 	} else {
 		return c.String(http.StatusOK, "")
 	}
 }
 
+func (s *server) deleteUser(c echo.Context) error {
+	if err := s.client.deleteUser(c.Param("user")); err != nil {
+		return err
+	} else {
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func (s *server) listUserNodes(c echo.Context) error {
+	if nodes, err := s.client.listNodes(c.Param("user")); err != nil {
+		return err
+	} else {
+		return c.JSON(http.StatusOK, nodes)
+	}
+}
+
+func (s *server) listNodes(c echo.Context) error {
+	if nodes, err := s.client.listNodes(c.QueryParam("user")); err != nil {
+		return err
+	} else {
+		return c.JSON(http.StatusOK, nodes)
+	}
+}
+
+func (s *server) deleteNode(c echo.Context) error {
+	if err := s.client.deleteNode(c.Param("id")); err != nil {
+		return err
+	} else {
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func (s *server) listPreAuthKeys(c echo.Context) error {
+	if keys, err := s.client.listPreAuthKeys(c.Param("user")); err != nil {
+		return err
+	} else {
+		return c.JSON(http.StatusOK, keys)
+	}
+}
+
+// createReusablePreAuthKey responds with the plain key.
 func (s *server) createReusablePreAuthKey(c echo.Context) error {
-	if key, err := s.client.createPreAuthKey(c.Param("user")); err != nil {
+	exp, err := expiration(c)
+	if err != nil {
+		return err
+	}
+	if key, err := s.client.createPreAuthKey(c.Param("user"), true, exp); err != nil {
+		return err
+	} else {
+		return c.String(http.StatusOK, key.Key)
+	}
+}
+
+func (s *server) rotatePreAuthKey(c echo.Context) error {
+	exp, err := expiration(c)
+	if err != nil {
+		return err
+	}
+	if key, err := s.client.rotatePreAuthKey(c.Param("user"), exp); err != nil {
+		return err
+	} else {
+		return c.JSON(http.StatusOK, key)
+	}
+}
+
+type expirePreAuthKeyReq struct {
+	Key string `json:"key"`
+}
+
+func (s *server) expirePreAuthKey(c echo.Context) error {
+	var req expirePreAuthKeyReq
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := s.client.expirePreAuthKey(c.Param("user"), req.Key); err != nil {
+		return err
+	} else {
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func (s *server) listRoutes(c echo.Context) error {
+	if routes, err := s.client.listRoutes(); err != nil {
 		return err
 	} else {
-		return c.String(http.StatusOK, key)
+		return c.JSON(http.StatusOK, routes)
 	}
 }
 
@@ -93,7 +246,20 @@ func (s *server) enableRoute(c echo.Context) error {
 	}
 }
 
-func updateACLs(cidrs []string, aclsPath string) error {
+func (s *server) disableRoute(c echo.Context) error {
+	if err := s.client.disableRoute(c.Param("id")); err != nil {
+		return err
+	} else {
+		return c.String(http.StatusOK, "")
+	}
+}
+
+// writeDefaultACLs initializes ACL policy, which is managed by HeadscaleACL
+// resources afterwards.
+func writeDefaultACLs(cidrs []string, aclsPath string) error {
+	if _, err := os.Stat(aclsPath); err == nil {
+		return nil
+	}
 	tmpl, err := template.New("acls").Parse(defaultACLs)
 	if err != nil {
 		return err
@@ -121,8 +287,28 @@ func main() {
 		}
 		cidrs = append(cidrs, cidr.String())
 	}
-	updateACLs(cidrs, *acls)
-	c := newClient(*config)
-	s := newServer(*port, c)
+	if err := writeDefaultACLs(cidrs, *acls); err != nil {
+		log.Printf("Failed to write default ACLs: %s\n", err)
+	}
+	var key string
+	for {
+		// headscale might not be up yet.
+		var err error
+		if key, err = loadAPIKey(*config, *apiKeyFile, *apiKeyLifetime); err == nil {
+			break
+		}
+		log.Printf("Failed to load API key: %s\n", err)
+		time.Sleep(5 * time.Second)
+	}
+	c := newClient(*headscaleAddr, key)
+	go func() {
+		for {
+			if err := c.refreshAPIKey(*apiKeyFile, *apiKeyLifetime); err != nil {
+				log.Printf("Failed to refresh API key: %s\n", err)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+	s := newServer(*port, c, *acls)
 	s.start()
 }
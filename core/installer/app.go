@@ -2,11 +2,13 @@ package installer
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	template "html/template"
 	"net"
 	"net/netip"
+	"sort"
 	"strings"
 
 	"cuelang.org/go/cue"
@@ -38,12 +40,14 @@ networks: {
 		certificateIssuer: "\(global.id)-public"
 		domain: global.domain
 		allocatePortAddr: "http://port-allocator.\(global.pcloudEnvName)-ingress-public.svc.cluster.local/api/allocate"
+		deallocatePortAddr: "http://port-allocator.\(global.pcloudEnvName)-ingress-public.svc.cluster.local/api/remove"
 	}
 	private: #Network & {
 		name: "Private"
 		ingressClass: "\(global.id)-ingress-private"
 		domain: global.privateDomain
 		allocatePortAddr: "http://port-allocator.\(global.id)-ingress-private.svc.cluster.local/api/allocate"
+		deallocatePortAddr: "http://port-allocator.\(global.id)-ingress-private.svc.cluster.local/api/remove"
 	}
 }
 
@@ -64,6 +68,11 @@ issuerPublic: "\(global.id)-public"
 
 	_domain: "\(subdomain).\(network.domain)"
     _authProxyHTTPPortName: "http"
+	// Set when application declares multiple ingresses so that their
+	// helm releases and kubernetes resources do not collide.
+	_prefix: string | *""
+	_ingressName: "\(_prefix)ingress"
+	_authProxyName: "\(_prefix)auth-proxy"
 
 	out: {
 		images: {
@@ -94,9 +103,10 @@ issuerPublic: "\(global.id)-public"
 		}
 		helm: {
 			if auth.enabled {
-				"auth-proxy": {
+				"\(_authProxyName)": {
 					chart: charts.authProxy
 					values: {
+						name: _authProxyName
 						image: {
 							repository: images.authProxy.fullName
 							tag: images.authProxy.tag
@@ -107,20 +117,25 @@ issuerPublic: "\(global.id)-public"
 						loginAddr: "https://accounts-ui.\(global.domain)/login"
 						membershipAddr: "http://memberships-api.\(global.id)-core-auth-memberships.svc.cluster.local/api/user"
 						groups: auth.groups
+						policies: auth.policies
 						portName: _authProxyHTTPPortName
 					}
 				}
 			}
-			ingress: {
+			"\(_ingressName)": {
 				chart: charts.ingress
 				_service: service
 				values: {
+					name: _ingressName
+					if _prefix != "" {
+						tlsSecretName: "cert-\(_ingressName)"
+					}
 					domain: _domain
 					ingressClassName: network.ingressClass
 					certificateIssuer: network.certificateIssuer
 					service: {
 						if auth.enabled {
-							name: "auth-proxy"
+							name: _authProxyName
                             port: name: _authProxyHTTPPortName
 						}
 						if !auth.enabled {
@@ -143,7 +158,11 @@ ingress: {}
 
 _ingressValidate: {
 	for key, value in ingress {
-		"\(key)": #Ingress & value
+		"\(key)": #Ingress & value & {
+			if len(ingress) > 1 {
+				_prefix: "\(key)-"
+			}
+		}
 	}
 }
 `
@@ -169,6 +188,7 @@ import (
 )
 
 name: string | *""
+version: string | *""
 description: string | *""
 readme: string | *""
 icon: string | *""
@@ -184,12 +204,38 @@ help: [...#HelpDocument] | *[]
 
 url: string | *""
 
+#Rename: {
+	from: string
+	to: string
+}
+
+#Migration: {
+	version: string
+	rename: [...#Rename] | *[]
+	remove: [...string] | *[]
+	set: {[string]: _} | *{}
+}
+
+migrations: [...#Migration] | *[]
+
 #AppType: "infra" | "env"
 appType: #AppType | *"env"
 
 #Auth: {
   enabled: bool | *false // TODO(gio): enabled by default?
   groups: string | *"" // TODO(gio): []string
+  // Evaluated in order, first rule matching request path and method
+  // decides. Requests not matched by any rule are checked against groups.
+  policies: [...#AuthPolicy] | *[]
+}
+
+#AuthPolicy: {
+  // Shell pattern as accepted by path.Match, or prefix if ends with *.
+  path: string
+  // If empty, any method matches.
+  methods: [...string] | *[]
+  // If empty, any authenticated user is allowed.
+  groups: [...string] | *[]
 }
 
 #Network: {
@@ -198,8 +244,13 @@ appType: #AppType | *"env"
 	certificateIssuer: string | *""
 	domain: string
 	allocatePortAddr: string
+	deallocatePortAddr: string
 }
 
+#Port: int & >=1 & <=65535
+
+#Duration: string & =~"^([0-9]+(\\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$"
+
 #Image: {
 	registry: string | *"docker.io"
 	repository: string
@@ -231,15 +282,60 @@ appType: #AppType | *"env"
 	servicesTo: net.IPv4
 }
 
+#Binding: {
+	instanceId: string
+	appId: string
+	namespace: string
+	service: string | *""
+	port: int | *0
+	secret: string | *""
+}
+
 #Release: {
 	appInstanceId: string
 	namespace: string
 	repoAddr: string
 	appDir: string
+	bindings: {[string]: #Binding}
+}
+
+// Other applications this one needs, bound to their existing instances at
+// install time and available as release.bindings.<name>.
+#Dependency: {
+	app: string
+	optional: bool | *false
+}
+
+dependencies: {[string]: #Dependency}
+
+release: bindings: {
+	for name, d in dependencies if !d.optional {
+		"\(name)": _
+	}
 }
 
+// Connection details exposed to the applications depending on this one.
+#Provides: {
+	service: string | *""
+	port: int | *0
+	secret: string | *""
+}
+
+provides: #Provides
+
+// Persistent volumes holding application data, snapshotted when backing up
+// the application instance.
+#Volume: {
+	name: string
+	// Helm release creating the volume, restored volume is handed over to it.
+	release: string | *""
+}
+
+backup: volumes: [...#Volume] | *[]
+
 #PortForward: {
 	allocator: string
+	deallocator: string | *""
 	protocol: "TCP" | "UDP" | *"TCP"
 	sourcePort: int
 	targetService: string
@@ -281,21 +377,24 @@ charts: {
 #Helm: {
 	name: string
 	dependsOn: [...#ResourceReference] | *[]
+	// Maps helm values path to the secret input field, actual value is
+	// provided by flux from the generated secret.
+	secretValues: {[string]: string} | *{}
 	...
 }
 
+// Name of the Secret holding generated secret inputs.
+secretsName: "\(release.appInstanceId)-secrets"
+
 _helmValidate: {
 	for key, value in helm {
 		"\(key)": #Helm & value & {
 			name: key
 		}
 	}
-	for key, value in _ingressValidate {
+	for _, value in _ingressValidate {
 		for ing, ingValue in value.out.helm {
-            // TODO(gio): support multiple ingresses
-			// "\(key)-\(ing)": #Helm & ingValue & {
 			"\(ing)": #Helm & ingValue & {
-				// name: "\(key)-\(ing)"
 				name: ing
 			}
 		}
@@ -307,6 +406,12 @@ _helmValidate: {
 	_chart: #Chart
 	_values: _
 	_dependencies: [...#ResourceReference] | *[]
+	_valuesFrom: [...{
+		kind: "Secret" | "ConfigMap"
+		name: string
+		valuesKey: string
+		targetPath: string
+	}] | *[]
 
 	apiVersion: "helm.toolkit.fluxcd.io/v2beta1"
 	kind: "HelmRelease"
@@ -321,6 +426,9 @@ _helmValidate: {
 			spec: _chart
 		}
 		values: _values
+		if len(_valuesFrom) > 0 {
+			valuesFrom: _valuesFrom
+		}
 	}
 }
 
@@ -331,6 +439,12 @@ output: {
 			_chart: r.chart
 			_values: r.values
 			_dependencies: r.dependsOn
+			_valuesFrom: [for path, key in r.secretValues {
+				kind: "Secret"
+				name: secretsName
+				valuesKey: key
+				targetPath: path
+			}]
 		}
 	}
 }
@@ -354,6 +468,9 @@ url: string | *""
 type rendered struct {
 	Name      string
 	Readme    string
+	Secrets   map[string]string
+	Provides  Provides
+	Volumes   []Volume
 	Resources CueAppData
 	Ports     []PortForward
 	Data      CueAppData
@@ -380,6 +497,7 @@ type InfraAppRendered struct {
 
 type PortForward struct {
 	Allocator     string `json:"allocator"`
+	Deallocator   string `json:"deallocator"`
 	Protocol      string `json:"protocol"`
 	SourcePort    int    `json:"sourcePort"`
 	TargetService string `json:"targetService"`
@@ -397,6 +515,10 @@ type App interface {
 	Name() string
 	Type() AppType
 	Slug() string
+	Version() string
+	Digest() string
+	Migrate(fromVersion string, values map[string]any) (map[string]any, error)
+	Dependencies() []Dependency
 	Description() string
 	Icon() template.HTML
 	Schema() Schema
@@ -432,6 +554,9 @@ func NewEnvNetwork(subnet net.IP) (EnvNetwork, error) {
 	if !addr.Is4() {
 		return EnvNetwork{}, fmt.Errorf("Expected IPv4, got %s instead", addr)
 	}
+	if !envSubnetsRange.Contains(addr) {
+		return EnvNetwork{}, fmt.Errorf("Expected subnet within %s, got %s instead", envSubnetsRange, addr)
+	}
 	dns := addr.Next()
 	ingress := dns.Next()
 	headscale := ingress.Next()
@@ -488,10 +613,14 @@ type EnvApp interface {
 
 type cueApp struct {
 	name        string
+	version     string
+	digest      string
 	description string
 	icon        template.HTML
 	namespace   string
 	schema      Schema
+	migrations  []Migration
+	deps        []Dependency
 	cfg         cue.Value
 	data        CueAppData
 }
@@ -532,29 +661,60 @@ func ParseCueAppConfig(data CueAppData) (cue.Value, error) {
 
 func newCueApp(config cue.Value, data CueAppData) (cueApp, error) {
 	cfg := struct {
-		Name        string `json:"name"`
-		Namespace   string `json:"namespace"`
-		Description string `json:"description"`
-		Icon        string `json:"icon"`
+		Name        string                `json:"name"`
+		Version     string                `json:"version"`
+		Namespace   string                `json:"namespace"`
+		Description string                `json:"description"`
+		Icon        string                `json:"icon"`
+		Migrations  []Migration           `json:"migrations"`
+		Deps        map[string]Dependency `json:"dependencies"`
 	}{}
 	if err := config.Decode(&cfg); err != nil {
 		return cueApp{}, err
 	}
+	deps := make([]Dependency, 0, len(cfg.Deps))
+	for name, d := range cfg.Deps {
+		d.Name = name
+		deps = append(deps, d)
+	}
+	sort.Slice(deps, func(i, j int) bool {
+		return deps[i].Name < deps[j].Name
+	})
 	schema, err := NewCueSchema("input", config.LookupPath(cue.ParsePath("input")))
 	if err != nil {
 		return cueApp{}, err
 	}
 	return cueApp{
 		name:        cfg.Name,
+		version:     cfg.Version,
+		digest:      digest(data),
 		description: cfg.Description,
 		icon:        template.HTML(cfg.Icon),
 		namespace:   cfg.Namespace,
 		schema:      schema,
+		migrations:  cfg.Migrations,
+		deps:        deps,
 		cfg:         config,
 		data:        data,
 	}, nil
 }
 
+// digest returns content hash of the application definition, used to detect
+// changes to the definition when version stays the same.
+func digest(data CueAppData) string {
+	names := make([]string, 0, len(data))
+	for n := range data {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, n := range names {
+		fmt.Fprintf(h, "%s\n%d\n", n, len(data[n]))
+		h.Write(data[n])
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
 func ParseAndCreateNewCueApp(data CueAppData) (cueApp, error) {
 	config, err := ParseCueAppConfig(data)
 	if err != nil {
@@ -571,6 +731,24 @@ func (a cueApp) Slug() string {
 	return strings.ReplaceAll(strings.ToLower(a.name), " ", "-")
 }
 
+func (a cueApp) Version() string {
+	return a.version
+}
+
+func (a cueApp) Digest() string {
+	return a.digest
+}
+
+// Migrate applies migrations declared by the application definition to the
+// values of an instance rendered by the given older version of it.
+func (a cueApp) Migrate(fromVersion string, values map[string]any) (map[string]any, error) {
+	return applyMigrations(a.migrations, fromVersion, a.version, values)
+}
+
+func (a cueApp) Dependencies() []Dependency {
+	return a.deps
+}
+
 func (a cueApp) Description() string {
 	return a.description
 }
@@ -620,6 +798,14 @@ func (a cueApp) render(values map[string]any) (rendered, error) {
 	if err := res.LookupPath(cue.ParsePath("portForward")).Decode(&ret.Ports); err != nil {
 		return rendered{}, err
 	}
+	if err := res.LookupPath(cue.ParsePath("backup.volumes")).Decode(&ret.Volumes); err != nil {
+		return rendered{}, err
+	}
+	if provides := res.LookupPath(cue.ParsePath("provides")); provides.Exists() {
+		if err := provides.Decode(&ret.Provides); err != nil {
+			return rendered{}, err
+		}
+	}
 	output := res.LookupPath(cue.ParsePath("output"))
 	i, err := output.Fields()
 	if err != nil {
@@ -670,29 +856,43 @@ func (a cueEnvApp) Type() AppType {
 
 func (a cueEnvApp) Render(release Release, env EnvConfig, values map[string]any) (EnvAppRendered, error) {
 	networks := CreateNetworks(env)
+	if err := ValidateValues(values, a.Schema(), networks); err != nil {
+		return EnvAppRendered{}, err
+	}
 	derived, err := deriveValues(values, a.Schema(), networks)
 	if err != nil {
-		return EnvAppRendered{}, nil
+		return EnvAppRendered{}, err
 	}
+	secrets := map[string]string{}
+	input := extractSecrets(derived, a.Schema(), "", secrets)
 	ret, err := a.cueApp.render(map[string]any{
 		"global":  env,
 		"release": release,
-		"input":   derived,
+		"input":   input,
 	})
 	if err != nil {
 		return EnvAppRendered{}, err
 	}
+	ret.Secrets = secrets
+	var provides *Provides
+	if ret.Provides != (Provides{}) {
+		provides = &ret.Provides
+	}
 	return EnvAppRendered{
 		rendered: ret,
 		Config: AppInstanceConfig{
-			AppId:   a.Slug(),
-			Env:     env,
-			Release: release,
-			Values:  values,
-			Input:   derived,
-			URL:     ret.URL,
-			Help:    ret.Help,
-			Icon:    ret.Icon,
+			AppId:      a.Slug(),
+			AppVersion: a.Version(),
+			AppDigest:  a.Digest(),
+			Env:        env,
+			Release:    release,
+			Values:     withoutSecrets(values, a.Schema()),
+			Input:      withoutSecrets(derived, a.Schema()),
+			URL:        ret.URL,
+			Help:       ret.Help,
+			Icon:       ret.Icon,
+			Provides:   provides,
+			Volumes:    ret.Volumes,
 		},
 	}, nil
 }
@@ -725,13 +925,15 @@ func (a cueInfraApp) Render(release Release, infra InfraConfig, values map[strin
 	return InfraAppRendered{
 		rendered: ret,
 		Config: InfraAppInstanceConfig{
-			AppId:   a.Slug(),
-			Infra:   infra,
-			Release: release,
-			Values:  values,
-			Input:   values,
-			URL:     ret.URL,
-			Help:    ret.Help,
+			AppId:      a.Slug(),
+			AppVersion: a.Version(),
+			AppDigest:  a.Digest(),
+			Infra:      infra,
+			Release:    release,
+			Values:     values,
+			Input:      values,
+			URL:        ret.URL,
+			Help:       ret.Help,
 		},
 	}, nil
 }
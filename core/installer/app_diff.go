@@ -0,0 +1,81 @@
+package installer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+type FileDiffAction string
+
+const (
+	FileAdded    FileDiffAction = "added"
+	FileModified FileDiffAction = "modified"
+	FileDeleted  FileDiffAction = "deleted"
+)
+
+type FileDiff struct {
+	Path   string         `json:"path"`
+	Action FileDiffAction `json:"action"`
+	Diff   string         `json:"diff"`
+}
+
+// dryRun runs given operation on top of the in-memory overlay of the
+// repository and returns line oriented diff of every modified file.
+func dryRun(repo soft.RepoFS, op func(r soft.RepoFS) error) ([]FileDiff, error) {
+	o := soft.NewOverlayFS(repo)
+	if err := op(o); err != nil {
+		return nil, err
+	}
+	changes, err := o.Changes()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]FileDiff, 0, len(changes))
+	for _, c := range changes {
+		action := FileModified
+		if c.Old == nil {
+			action = FileAdded
+		} else if c.New == nil {
+			action = FileDeleted
+		}
+		ret = append(ret, FileDiff{
+			Path:   c.Path,
+			Action: action,
+			Diff:   lineDiff(string(c.Old), string(c.New)),
+		})
+	}
+	return ret, nil
+}
+
+func lineDiff(src, dst string) string {
+	var ret strings.Builder
+	for _, d := range diff.Do(src, dst) {
+		prefix := " "
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			prefix = "+"
+		case diffmatchpatch.DiffDelete:
+			prefix = "-"
+		}
+		for _, l := range strings.SplitAfter(d.Text, "\n") {
+			if l == "" {
+				continue
+			}
+			ret.WriteString(prefix)
+			ret.WriteString(l)
+			if !strings.HasSuffix(l, "\n") {
+				ret.WriteString("\n")
+			}
+		}
+	}
+	return ret.String()
+}
+
+func (d FileDiff) String() string {
+	return fmt.Sprintf("%s %s\n%s", d.Action, d.Path, d.Diff)
+}
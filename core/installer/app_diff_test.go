@@ -0,0 +1,25 @@
+package installer
+
+import (
+	"testing"
+)
+
+func TestLineDiff(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		src      string
+		dst      string
+		expected string
+	}{
+		{"add", "", "a\nb\n", "+a\n+b\n"},
+		{"modify", "a\nb\nc\n", "a\nx\nc\n", " a\n-b\n+x\n c\n"},
+		{"delete", "a\nb\n", "", "-a\n-b\n"},
+		{"no trailing newline", "a", "a\nb", "-a\n+a\n+b\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := lineDiff(test.src, test.dst); got != test.expected {
+				t.Fatalf("Expected:\n%s\ngot:\n%s", test.expected, got)
+			}
+		})
+	}
+}
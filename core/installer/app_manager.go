@@ -1,14 +1,14 @@
 package installer
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
-	"net/http"
 	"path"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 
 	"github.com/giolekva/pcloud/core/installer/io"
 	"github.com/giolekva/pcloud/core/installer/soft"
@@ -24,13 +24,15 @@ var ErrorNotFound = errors.New("not found")
 type AppManager struct {
 	repoIO     soft.RepoIO
 	nsCreator  NamespaceCreator
+	secrets    SecretStore
 	appDirRoot string
 }
 
-func NewAppManager(repoIO soft.RepoIO, nsCreator NamespaceCreator, appDirRoot string) (*AppManager, error) {
+func NewAppManager(repoIO soft.RepoIO, nsCreator NamespaceCreator, secrets SecretStore, appDirRoot string) (*AppManager, error) {
 	return &AppManager{
 		repoIO,
 		nsCreator,
+		secrets,
 		appDirRoot,
 	}, nil
 }
@@ -116,36 +118,6 @@ func (m *AppManager) AppConfig(name string) (AppInstanceConfig, error) {
 	return cfg, nil
 }
 
-type allocatePortReq struct {
-	Protocol      string `json:"protocol"`
-	SourcePort    int    `json:"sourcePort"`
-	TargetService string `json:"targetService"`
-	TargetPort    int    `json:"targetPort"`
-}
-
-func openPorts(ports []PortForward) error {
-	for _, p := range ports {
-		var buf bytes.Buffer
-		req := allocatePortReq{
-			Protocol:      p.Protocol,
-			SourcePort:    p.SourcePort,
-			TargetService: p.TargetService,
-			TargetPort:    p.TargetPort,
-		}
-		if err := json.NewEncoder(&buf).Encode(req); err != nil {
-			return err
-		}
-		resp, err := http.Post(p.Allocator, "application/json", &buf)
-		if err != nil {
-			return err
-		}
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("Could not allocate port %d, status code: %d", p.SourcePort, resp.StatusCode)
-		}
-	}
-	return nil
-}
-
 func createKustomizationChain(r soft.RepoFS, path string) error {
 	for p := filepath.Clean(path); p != "/"; {
 		parent, child := filepath.Split(p)
@@ -189,94 +161,168 @@ func InstallApp(
 	opts ...soft.DoOption,
 ) (ReleaseResources, error) {
 	return ReleaseResources{}, repo.Do(func(r soft.RepoFS) (string, error) {
-		if err := r.RemoveDir(appDir); err != nil {
+		if err := writeApp(r, appDir, config, resources, data); err != nil {
 			return "", err
 		}
-		resourcesDir := path.Join(appDir, "resources")
-		if err := r.CreateDir(resourcesDir); err != nil {
-			return "", err
+		return fmt.Sprintf("install: %s", name), nil
+	}, opts...)
+}
+
+func writeApp(
+	r soft.RepoFS,
+	appDir string,
+	config any,
+	resources CueAppData,
+	data CueAppData,
+) error {
+	if err := r.RemoveDir(appDir); err != nil {
+		return err
+	}
+	resourcesDir := path.Join(appDir, "resources")
+	if err := r.CreateDir(resourcesDir); err != nil {
+		return err
+	}
+	{
+		if err := soft.WriteYaml(r, path.Join(appDir, configFileName), config); err != nil {
+			return err
 		}
-		{
-			if err := soft.WriteYaml(r, path.Join(appDir, configFileName), config); err != nil {
-				return "", err
+		if err := soft.WriteJson(r, path.Join(appDir, "config.json"), config); err != nil {
+			return err
+		}
+		for name, contents := range data {
+			if name == "config.json" || name == "kustomization.yaml" || name == "resources" {
+				return fmt.Errorf("%s is forbidden", name)
 			}
-			if err := soft.WriteJson(r, path.Join(appDir, "config.json"), config); err != nil {
-				return "", err
+			w, err := r.Writer(path.Join(appDir, name))
+			if err != nil {
+				return err
 			}
-			for name, contents := range data {
-				if name == "config.json" || name == "kustomization.yaml" || name == "resources" {
-					return "", fmt.Errorf("%s is forbidden", name)
-				}
-				w, err := r.Writer(path.Join(appDir, name))
-				if err != nil {
-					return "", err
-				}
-				defer w.Close()
-				if _, err := w.Write(contents); err != nil {
-					return "", err
-				}
+			defer w.Close()
+			if _, err := w.Write(contents); err != nil {
+				return err
 			}
 		}
-		{
-			if err := createKustomizationChain(r, resourcesDir); err != nil {
-				return "", err
-			}
-			appKust := io.NewKustomization()
-			for name, contents := range resources {
-				appKust.AddResources(name)
-				w, err := r.Writer(path.Join(resourcesDir, name))
-				if err != nil {
-					return "", err
-				}
-				defer w.Close()
-				if _, err := w.Write(contents); err != nil {
-					return "", err
-				}
+	}
+	{
+		if err := createKustomizationChain(r, resourcesDir); err != nil {
+			return err
+		}
+		appKust := io.NewKustomization()
+		for name, contents := range resources {
+			appKust.AddResources(name)
+			w, err := r.Writer(path.Join(resourcesDir, name))
+			if err != nil {
+				return err
 			}
-			if err := soft.WriteYaml(r, path.Join(resourcesDir, "kustomization.yaml"), appKust); err != nil {
-				return "", err
+			defer w.Close()
+			if _, err := w.Write(contents); err != nil {
+				return err
 			}
 		}
-		return fmt.Sprintf("install: %s", name), nil
-	}, opts...)
+		if err := soft.WriteYaml(r, path.Join(resourcesDir, "kustomization.yaml"), appKust); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type InstallRequest struct {
+	App        EnvApp
+	InstanceId string
+	AppDir     string
+	Namespace  string
+	Values     map[string]any
+	// Maps dependency name to the instance it must be bound to, dependencies
+	// missing here are bound automatically when possible.
+	Bindings map[string]string
 }
 
 // TODO(gio): commit instanceId -> appDir mapping as well
 func (m *AppManager) Install(app EnvApp, instanceId string, appDir string, namespace string, values map[string]any) (ReleaseResources, error) {
-	appDir = filepath.Clean(appDir)
+	return m.InstallAll([]InstallRequest{{
+		App:        app,
+		InstanceId: instanceId,
+		AppDir:     appDir,
+		Namespace:  namespace,
+		Values:     values,
+	}})
+}
+
+// InstallAll installs given applications within single commit.
+// If any of the steps fails, namespaces created, ports allocated and
+// the commit pushed so far are rolled back.
+func (m *AppManager) InstallAll(reqs []InstallRequest) (ReleaseResources, error) {
 	if err := m.repoIO.Pull(); err != nil {
 		return ReleaseResources{}, err
 	}
-	if err := m.nsCreator.Create(namespace); err != nil {
-		return ReleaseResources{}, err
-	}
 	env, err := m.Config()
 	if err != nil {
 		return ReleaseResources{}, err
 	}
-	release := Release{
-		AppInstanceId: instanceId,
-		Namespace:     namespace,
-		RepoAddr:      m.repoIO.FullAddress(),
-		AppDir:        appDir,
+	rendered := make([]EnvAppRendered, 0, len(reqs))
+	for i, req := range reqs {
+		reqs[i].AppDir = filepath.Clean(req.AppDir)
+		release := Release{
+			AppInstanceId: req.InstanceId,
+			Namespace:     req.Namespace,
+			RepoAddr:      m.repoIO.FullAddress(),
+			AppDir:        reqs[i].AppDir,
+		}
+		release.Bindings, err = m.resolveBindings(req.App, req.Bindings)
+		if err != nil {
+			return ReleaseResources{}, err
+		}
+		r, err := req.App.Render(release, env, req.Values)
+		if err != nil {
+			return ReleaseResources{}, err
+		}
+		rendered = append(rendered, r)
 	}
-	rendered, err := app.Render(release, env, values)
-	if err != nil {
-		return ReleaseResources{}, err
+	tx := newAppTransaction(m.repoIO, m.nsCreator, m.secrets)
+	for _, req := range reqs {
+		if err := tx.CreateNamespace(req.Namespace); err != nil {
+			return ReleaseResources{}, tx.Rollback(err)
+		}
 	}
-	if _, err := InstallApp(m.repoIO, appDir, rendered.Name, rendered.Config, rendered.Ports, rendered.Resources, rendered.Data); err != nil {
-		return ReleaseResources{}, err
+	for i, app := range rendered {
+		if len(app.Secrets) == 0 {
+			continue
+		}
+		if err := tx.StoreSecrets(reqs[i].Namespace, secretsName(reqs[i].InstanceId), app.Secrets); err != nil {
+			return ReleaseResources{}, tx.Rollback(err)
+		}
+	}
+	if err := tx.Commit(func(r soft.RepoFS) (string, error) {
+		names := make([]string, 0, len(rendered))
+		for i, app := range rendered {
+			if err := writeApp(r, reqs[i].AppDir, app.Config, app.Resources, app.Data); err != nil {
+				return "", err
+			}
+			names = append(names, app.Name)
+		}
+		return fmt.Sprintf("install: %s", strings.Join(names, ", ")), nil
+	}); err != nil {
+		return ReleaseResources{}, tx.Rollback(err)
 	}
 	// TODO(gio): add ingress-nginx to release resources
-	if err := openPorts(rendered.Ports); err != nil {
-		return ReleaseResources{}, err
+	ret := ReleaseResources{
+		Helm: make([]Resource, 0),
 	}
-	return ReleaseResources{
-		Helm: extractHelm(rendered.Resources),
-	}, nil
+	for _, app := range rendered {
+		if err := tx.OpenPorts(app.Ports); err != nil {
+			return ReleaseResources{}, tx.Rollback(err)
+		}
+		helm, err := extractHelm(app.Resources)
+		if err != nil {
+			return ReleaseResources{}, tx.Rollback(err)
+		}
+		ret.Helm = append(ret.Helm, helm...)
+	}
+	return ret, nil
 }
 
 type helmRelease struct {
+	Kind     string   `json:"kind"`
 	Metadata Resource `json:"metadata"`
 	Status   struct {
 		Conditions []struct {
@@ -286,31 +332,76 @@ type helmRelease struct {
 	} `json:"status,omitempty"`
 }
 
-func extractHelm(resources CueAppData) []Resource {
+func extractHelm(resources CueAppData) ([]Resource, error) {
 	ret := make([]Resource, 0, len(resources))
 	for _, contents := range resources {
 		var h helmRelease
 		if err := yaml.Unmarshal(contents, &h); err != nil {
-			panic(err) // TODO(gio): handle
+			return nil, err
+		}
+		if h.Kind != "HelmRelease" {
+			continue
 		}
 		ret = append(ret, h.Metadata)
 	}
-	return ret
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Namespace != ret[j].Namespace {
+			return ret[i].Namespace < ret[j].Namespace
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
 }
 
-func (m *AppManager) Update(app EnvApp, instanceId string, values map[string]any, opts ...soft.DoOption) (ReleaseResources, error) {
+func (m *AppManager) renderInstall(app EnvApp, instanceId string, appDir string, namespace string, values map[string]any, bindings map[string]string) (EnvAppRendered, error) {
 	if err := m.repoIO.Pull(); err != nil {
-		return ReleaseResources{}, err
+		return EnvAppRendered{}, err
 	}
 	env, err := m.Config()
 	if err != nil {
-		return ReleaseResources{}, err
+		return EnvAppRendered{}, err
+	}
+	release := Release{
+		AppInstanceId: instanceId,
+		Namespace:     namespace,
+		RepoAddr:      m.repoIO.FullAddress(),
+		AppDir:        appDir,
+	}
+	release.Bindings, err = m.resolveBindings(app, bindings)
+	if err != nil {
+		return EnvAppRendered{}, err
+	}
+	return app.Render(release, env, values)
+}
+
+// InstallDryRun renders given application and returns changes its
+// installation would make to the config repository, without committing them.
+func (m *AppManager) InstallDryRun(app EnvApp, instanceId string, appDir string, namespace string, values map[string]any, bindings map[string]string) ([]FileDiff, error) {
+	appDir = filepath.Clean(appDir)
+	rendered, err := m.renderInstall(app, instanceId, appDir, namespace, values, bindings)
+	if err != nil {
+		return nil, err
+	}
+	return dryRun(m.repoIO, func(r soft.RepoFS) error {
+		return writeApp(r, appDir, rendered.Config, rendered.Resources, rendered.Data)
+	})
+}
+
+// renderUpdate renders the instance with the new values, together with the
+// ports forwarded for it before the update.
+func (m *AppManager) renderUpdate(app EnvApp, instanceId string, values map[string]any) (EnvAppRendered, string, []PortForward, error) {
+	if err := m.repoIO.Pull(); err != nil {
+		return EnvAppRendered{}, "", nil, err
+	}
+	env, err := m.Config()
+	if err != nil {
+		return EnvAppRendered{}, "", nil, err
 	}
 	instanceDir := filepath.Join(m.appDirRoot, instanceId)
 	instanceConfigPath := filepath.Join(instanceDir, "config.json")
 	config, err := m.appConfig(instanceConfigPath)
 	if err != nil {
-		return ReleaseResources{}, err
+		return EnvAppRendered{}, "", nil, err
 	}
 	release := Release{
 		AppInstanceId: instanceId,
@@ -318,18 +409,162 @@ func (m *AppManager) Update(app EnvApp, instanceId string, values map[string]any
 		RepoAddr:      m.repoIO.FullAddress(),
 		AppDir:        instanceDir,
 	}
+	// Keeps dependencies bound to the same instances, while refreshing their
+	// connection details and binding ones introduced by the new version.
+	release.Bindings, err = m.resolveBindings(app, boundInstances(app, config.Release.Bindings))
+	if err != nil {
+		return EnvAppRendered{}, "", nil, err
+	}
+	prevValues := config.Values
+	if hasSecrets(app.Schema()) && m.secrets != nil {
+		prev, err := m.secrets.Get(config.Release.Namespace, secretsName(instanceId))
+		if err != nil {
+			return EnvAppRendered{}, "", nil, err
+		}
+		if len(prev) == 0 {
+			prev = committedSecrets(config, app.Schema())
+		}
+		legacySecrets(prev, app.Schema(), "")
+		values = keepSecrets(values, prev, app.Schema(), "")
+		prevValues = keepSecrets(prevValues, prev, app.Schema(), "")
+	}
 	rendered, err := app.Render(release, env, values)
+	if err != nil {
+		return EnvAppRendered{}, "", nil, err
+	}
+	// Previous values might not match the schema anymore, in which case all
+	// the ports are treated as new ones.
+	var prevPorts []PortForward
+	if prevRendered, err := app.Render(release, env, prevValues); err == nil {
+		prevPorts = prevRendered.Ports
+	}
+	return rendered, instanceDir, prevPorts, nil
+}
+
+func (m *AppManager) Update(app EnvApp, instanceId string, values map[string]any, opts ...soft.DoOption) (ReleaseResources, error) {
+	rendered, instanceDir, prevPorts, err := m.renderUpdate(app, instanceId, values)
 	if err != nil {
 		return ReleaseResources{}, err
 	}
-	return InstallApp(m.repoIO, instanceDir, rendered.Name, rendered.Config, rendered.Ports, rendered.Resources, rendered.Data, opts...)
+	return m.applyUpdate(instanceId, instanceDir, rendered, prevPorts, fmt.Sprintf("update: %s", rendered.Name), opts...)
+}
+
+// applyUpdate stores secrets, commits the re-rendered instance and opens
+// ports it did not forward before. If any of the steps fails, changes made
+// so far are rolled back.
+func (m *AppManager) applyUpdate(instanceId, instanceDir string, rendered EnvAppRendered, prevPorts []PortForward, message string, opts ...soft.DoOption) (ReleaseResources, error) {
+	tx := newAppTransaction(m.repoIO, m.nsCreator, m.secrets)
+	if len(rendered.Secrets) > 0 {
+		if err := tx.StoreSecrets(rendered.Config.Release.Namespace, secretsName(instanceId), rendered.Secrets); err != nil {
+			return ReleaseResources{}, tx.Rollback(err)
+		}
+	}
+	if err := tx.Commit(func(r soft.RepoFS) (string, error) {
+		if err := writeApp(r, instanceDir, rendered.Config, rendered.Resources, rendered.Data); err != nil {
+			return "", err
+		}
+		return message, nil
+	}, opts...); err != nil {
+		return ReleaseResources{}, tx.Rollback(err)
+	}
+	if err := tx.OpenPorts(newPorts(prevPorts, rendered.Ports)); err != nil {
+		return ReleaseResources{}, tx.Rollback(err)
+	}
+	helm, err := extractHelm(rendered.Resources)
+	if err != nil {
+		return ReleaseResources{}, tx.Rollback(err)
+	}
+	return ReleaseResources{
+		Helm: helm,
+	}, nil
+}
+
+// newPorts returns ports which are not forwarded yet.
+func newPorts(prev, ports []PortForward) []PortForward {
+	ret := make([]PortForward, 0, len(ports))
+	for _, p := range ports {
+		if !slices.Contains(prev, p) {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// UpdateDryRun returns changes updating given application instance
+// would make to the config repository, without committing them.
+func (m *AppManager) UpdateDryRun(app EnvApp, instanceId string, values map[string]any) ([]FileDiff, error) {
+	rendered, instanceDir, _, err := m.renderUpdate(app, instanceId, values)
+	if err != nil {
+		return nil, err
+	}
+	return dryRun(m.repoIO, func(r soft.RepoFS) error {
+		return writeApp(r, instanceDir, rendered.Config, rendered.Resources, rendered.Data)
+	})
+}
+
+func (m *AppManager) renderUpgrade(r AppRepository, instanceId string, toVersion string) (EnvAppRendered, string, []PortForward, AppInstanceConfig, error) {
+	if err := m.repoIO.Pull(); err != nil {
+		return EnvAppRendered{}, "", nil, AppInstanceConfig{}, err
+	}
+	config, err := m.FindInstance(instanceId)
+	if err != nil {
+		return EnvAppRendered{}, "", nil, AppInstanceConfig{}, err
+	}
+	app, err := FindEnvAppVersion(r, config.AppId, toVersion)
+	if err != nil {
+		return EnvAppRendered{}, "", nil, AppInstanceConfig{}, err
+	}
+	migrated, err := MigrateInstance(app, *config)
+	if err != nil {
+		return EnvAppRendered{}, "", nil, AppInstanceConfig{}, err
+	}
+	rendered, instanceDir, prevPorts, err := m.renderUpdate(app, instanceId, migrated.Values)
+	if err != nil {
+		return EnvAppRendered{}, "", nil, AppInstanceConfig{}, err
+	}
+	return rendered, instanceDir, prevPorts, *config, nil
+}
+
+// Upgrade re-renders given instance using the requested version of its
+// application definition, preserving previously provided values.
+// Latest available version is used if toVersion is empty.
+func (m *AppManager) Upgrade(r AppRepository, instanceId string, toVersion string, opts ...soft.DoOption) (ReleaseResources, error) {
+	rendered, instanceDir, prevPorts, config, err := m.renderUpgrade(r, instanceId, toVersion)
+	if err != nil {
+		return ReleaseResources{}, err
+	}
+	message := fmt.Sprintf("upgrade: %s %s -> %s", instanceId, config.AppVersion, rendered.Config.AppVersion)
+	return m.applyUpdate(instanceId, instanceDir, rendered, prevPorts, message, opts...)
+}
+
+// UpgradeDryRun returns changes upgrading given instance would make to
+// the config repository, without committing them.
+func (m *AppManager) UpgradeDryRun(r AppRepository, instanceId string, toVersion string) ([]FileDiff, error) {
+	rendered, instanceDir, _, _, err := m.renderUpgrade(r, instanceId, toVersion)
+	if err != nil {
+		return nil, err
+	}
+	return dryRun(m.repoIO, func(r soft.RepoFS) error {
+		return writeApp(r, instanceDir, rendered.Config, rendered.Resources, rendered.Data)
+	})
 }
 
 func (m *AppManager) Remove(instanceId string) error {
 	if err := m.repoIO.Pull(); err != nil {
 		return err
 	}
-	return m.repoIO.Do(func(r soft.RepoFS) (string, error) {
+	dependents, err := m.findDependents(instanceId)
+	if err != nil {
+		return err
+	}
+	if len(dependents) > 0 {
+		return fmt.Errorf("Instance %s is used by: %s", instanceId, strings.Join(dependents, ", "))
+	}
+	config, err := m.appConfig(filepath.Join(m.appDirRoot, instanceId, "config.json"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := m.repoIO.Do(func(r soft.RepoFS) (string, error) {
 		r.RemoveDir(filepath.Join(m.appDirRoot, instanceId))
 		kustPath := filepath.Join(m.appDirRoot, "kustomization.yaml")
 		kust, err := soft.ReadKustomization(r, kustPath)
@@ -339,24 +574,32 @@ func (m *AppManager) Remove(instanceId string) error {
 		kust.RemoveResources(instanceId)
 		soft.WriteYaml(r, kustPath, kust)
 		return fmt.Sprintf("uninstall: %s", instanceId), nil
-	})
+	}); err != nil {
+		return err
+	}
+	if m.secrets == nil || config.Release.Namespace == "" {
+		return nil
+	}
+	return m.secrets.Delete(config.Release.Namespace, secretsName(instanceId))
 }
 
 // TODO(gio): deduplicate with cue definition in app.go, this one should be removed.
 func CreateNetworks(env EnvConfig) []Network {
 	return []Network{
 		{
-			Name:              "Public",
-			IngressClass:      fmt.Sprintf("%s-ingress-public", env.InfraName),
-			CertificateIssuer: fmt.Sprintf("%s-public", env.Id),
-			Domain:            env.Domain,
-			AllocatePortAddr:  fmt.Sprintf("http://port-allocator.%s-ingress-public.svc.cluster.local/api/allocate", env.InfraName),
+			Name:               "Public",
+			IngressClass:       fmt.Sprintf("%s-ingress-public", env.InfraName),
+			CertificateIssuer:  fmt.Sprintf("%s-public", env.Id),
+			Domain:             env.Domain,
+			AllocatePortAddr:   fmt.Sprintf("http://port-allocator.%s-ingress-public.svc.cluster.local/api/allocate", env.InfraName),
+			DeallocatePortAddr: fmt.Sprintf("http://port-allocator.%s-ingress-public.svc.cluster.local/api/remove", env.InfraName),
 		},
 		{
-			Name:             "Private",
-			IngressClass:     fmt.Sprintf("%s-ingress-private", env.Id),
-			Domain:           env.PrivateDomain,
-			AllocatePortAddr: fmt.Sprintf("http://port-allocator.%s-ingress-private.svc.cluster.local/api/allocate", env.Id),
+			Name:               "Private",
+			IngressClass:       fmt.Sprintf("%s-ingress-private", env.Id),
+			Domain:             env.PrivateDomain,
+			AllocatePortAddr:   fmt.Sprintf("http://port-allocator.%s-ingress-private.svc.cluster.local/api/allocate", env.Id),
+			DeallocatePortAddr: fmt.Sprintf("http://port-allocator.%s-ingress-private.svc.cluster.local/api/remove", env.Id),
 		},
 	}
 }
@@ -411,43 +654,69 @@ func (m *InfraAppManager) FindInstance(id string) (InfraAppInstanceConfig, error
 	return InfraAppInstanceConfig{}, nil
 }
 
-func (m *InfraAppManager) Install(app InfraApp, appDir string, namespace string, values map[string]any) (ReleaseResources, error) {
-	appDir = filepath.Clean(appDir)
+func (m *InfraAppManager) renderInstall(app InfraApp, appDir string, namespace string, values map[string]any) (InfraAppRendered, error) {
 	if err := m.repoIO.Pull(); err != nil {
-		return ReleaseResources{}, err
-	}
-	if err := m.nsCreator.Create(namespace); err != nil {
-		return ReleaseResources{}, err
+		return InfraAppRendered{}, err
 	}
 	infra, err := m.Config()
 	if err != nil {
-		return ReleaseResources{}, err
+		return InfraAppRendered{}, err
 	}
 	release := Release{
 		Namespace: namespace,
 		RepoAddr:  m.repoIO.FullAddress(),
 		AppDir:    appDir,
 	}
-	rendered, err := app.Render(release, infra, values)
+	return app.Render(release, infra, values)
+}
+
+func (m *InfraAppManager) Install(app InfraApp, appDir string, namespace string, values map[string]any) (ReleaseResources, error) {
+	appDir = filepath.Clean(appDir)
+	rendered, err := m.renderInstall(app, appDir, namespace, values)
 	if err != nil {
 		return ReleaseResources{}, err
 	}
-	return InstallApp(m.repoIO, appDir, rendered.Name, rendered.Config, rendered.Ports, rendered.Resources, rendered.Data)
+	tx := newAppTransaction(m.repoIO, m.nsCreator, nil)
+	if err := tx.CreateNamespace(namespace); err != nil {
+		return ReleaseResources{}, tx.Rollback(err)
+	}
+	if err := tx.Commit(func(r soft.RepoFS) (string, error) {
+		if err := writeApp(r, appDir, rendered.Config, rendered.Resources, rendered.Data); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("install: %s", rendered.Name), nil
+	}); err != nil {
+		return ReleaseResources{}, tx.Rollback(err)
+	}
+	return ReleaseResources{}, nil
 }
 
-func (m *InfraAppManager) Update(app InfraApp, instanceId string, values map[string]any, opts ...soft.DoOption) (ReleaseResources, error) {
+// InstallDryRun renders given infrastructure application and returns
+// changes its installation would make to the repository, without committing them.
+func (m *InfraAppManager) InstallDryRun(app InfraApp, appDir string, namespace string, values map[string]any) ([]FileDiff, error) {
+	appDir = filepath.Clean(appDir)
+	rendered, err := m.renderInstall(app, appDir, namespace, values)
+	if err != nil {
+		return nil, err
+	}
+	return dryRun(m.repoIO, func(r soft.RepoFS) error {
+		return writeApp(r, appDir, rendered.Config, rendered.Resources, rendered.Data)
+	})
+}
+
+func (m *InfraAppManager) renderUpdate(app InfraApp, instanceId string, values map[string]any) (InfraAppRendered, string, error) {
 	if err := m.repoIO.Pull(); err != nil {
-		return ReleaseResources{}, err
+		return InfraAppRendered{}, "", err
 	}
 	env, err := m.Config()
 	if err != nil {
-		return ReleaseResources{}, err
+		return InfraAppRendered{}, "", err
 	}
 	instanceDir := filepath.Join("/infrastructure", instanceId)
 	instanceConfigPath := filepath.Join(instanceDir, "config.json")
 	config, err := m.appConfig(instanceConfigPath)
 	if err != nil {
-		return ReleaseResources{}, err
+		return InfraAppRendered{}, "", err
 	}
 	release := Release{
 		AppInstanceId: instanceId,
@@ -456,8 +725,37 @@ func (m *InfraAppManager) Update(app InfraApp, instanceId string, values map[str
 		AppDir:        instanceDir,
 	}
 	rendered, err := app.Render(release, env, values)
+	if err != nil {
+		return InfraAppRendered{}, "", err
+	}
+	return rendered, instanceDir, nil
+}
+
+func (m *InfraAppManager) Update(app InfraApp, instanceId string, values map[string]any, opts ...soft.DoOption) (ReleaseResources, error) {
+	rendered, instanceDir, err := m.renderUpdate(app, instanceId, values)
 	if err != nil {
 		return ReleaseResources{}, err
 	}
-	return InstallApp(m.repoIO, instanceDir, rendered.Name, rendered.Config, rendered.Ports, rendered.Resources, rendered.Data, opts...)
+	tx := newAppTransaction(m.repoIO, m.nsCreator, nil)
+	if err := tx.Commit(func(r soft.RepoFS) (string, error) {
+		if err := writeApp(r, instanceDir, rendered.Config, rendered.Resources, rendered.Data); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("update: %s", rendered.Name), nil
+	}, opts...); err != nil {
+		return ReleaseResources{}, tx.Rollback(err)
+	}
+	return ReleaseResources{}, nil
+}
+
+// UpdateDryRun returns changes updating given infrastructure application
+// instance would make to the repository, without committing them.
+func (m *InfraAppManager) UpdateDryRun(app InfraApp, instanceId string, values map[string]any) ([]FileDiff, error) {
+	rendered, instanceDir, err := m.renderUpdate(app, instanceId, values)
+	if err != nil {
+		return nil, err
+	}
+	return dryRun(m.repoIO, func(r soft.RepoFS) error {
+		return writeApp(r, instanceDir, rendered.Config, rendered.Resources, rendered.Data)
+	})
 }
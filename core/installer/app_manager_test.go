@@ -0,0 +1,533 @@
+package installer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+type fakeNSCreator struct{}
+
+func (f fakeNSCreator) Create(name string) error {
+	return nil
+}
+
+func (f fakeNSCreator) Exists(name string) (bool, error) {
+	return false, nil
+}
+
+func (f fakeNSCreator) Delete(name string) error {
+	return nil
+}
+
+func (f fakeNSCreator) List() ([]string, error) {
+	return []string{}, nil
+}
+
+type mockRepoIO struct {
+	soft.RepoFS
+}
+
+func (r mockRepoIO) FullAddress() string {
+	return "ssh://repo"
+}
+
+func (r mockRepoIO) Pull() error {
+	return nil
+}
+
+func (r mockRepoIO) CommitAndPush(message string) error {
+	return nil
+}
+
+func (r mockRepoIO) Do(op soft.DoFn, _ ...soft.DoOption) error {
+	_, err := op(r)
+	return err
+}
+
+func (r mockRepoIO) Revert(commit string, message string) error {
+	return nil
+}
+
+func newTestAppManager(t *testing.T) *AppManager {
+	repo := mockRepoIO{soft.NewBillyRepoFS(memfs.New())}
+	if err := soft.WriteYaml(repo, configFileName, env); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewAppManager(repo, fakeNSCreator{}, nil, "/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func newTestEnvApp(t *testing.T, contents string) EnvApp {
+	app, err := NewCueEnvApp(CueAppData{
+		"base.cue":   []byte(cueBaseConfig),
+		"global.cue": []byte(cueEnvAppGlobal),
+		"app.cue":    []byte(contents),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+const providerApp = `
+name: "Database"
+namespace: "db"
+input: {}
+helm: {}
+provides: {
+	service: "postgres"
+	port: 5432
+	secret: secretsName
+}
+`
+
+const consumerApp = `
+name: "Consumer"
+namespace: "consumer"
+input: {}
+dependencies: {
+	db: app: "database"
+}
+helm: {
+	consumer: {
+		chart: {
+			chart: "charts/consumer"
+			sourceRef: {
+				kind: "GitRepository"
+				name: "pcloud"
+				namespace: global.id
+			}
+		}
+		values: {
+			dbAddr: "\(release.bindings.db.service).\(release.bindings.db.namespace).svc.cluster.local:\(release.bindings.db.port)"
+			dbSecret: release.bindings.db.secret
+		}
+	}
+}
+`
+
+func TestDependencies(t *testing.T) {
+	m := newTestAppManager(t)
+	provider := newTestEnvApp(t, providerApp)
+	consumer := newTestEnvApp(t, consumerApp)
+	var derr UnresolvedDependencyError
+	if _, err := m.Install(consumer, "consumer", "/apps/consumer", "id-consumer", map[string]any{}); !errors.As(err, &derr) {
+		t.Fatalf("Expected unresolved dependency, got %v", err)
+	}
+	if _, err := m.Install(provider, "db1", "/apps/db1", "id-db1", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Install(consumer, "consumer", "/apps/consumer", "id-consumer", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	inst, err := m.FindInstance("consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, ok := inst.Release.Bindings["db"]
+	if !ok || b.InstanceId != "db1" || b.Namespace != "id-db1" || b.Secret != "db1-secrets" {
+		t.Fatalf("Unexpected bindings: %+v", inst.Release.Bindings)
+	}
+	r, err := m.repoIO.Reader("/apps/consumer/resources/consumer.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), "postgres.id-db1.svc.cluster.local:5432") {
+		t.Fatalf("Binding is not rendered: %s", contents)
+	}
+	if err := m.Remove("db1"); err == nil {
+		t.Fatal("Expected removal of the instance in use to fail")
+	}
+	if _, err := m.Install(provider, "db2", "/apps/db2", "id-db2", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Install(consumer, "consumer2", "/apps/consumer2", "id-consumer2", map[string]any{}); !errors.As(err, &derr) {
+		t.Fatalf("Expected unresolved dependency, got %v", err)
+	} else if len(derr.Candidates) != 2 {
+		t.Fatalf("Expected two candidates, got %v", derr.Candidates)
+	}
+	if _, err := m.InstallAll([]InstallRequest{{
+		App:        consumer,
+		InstanceId: "consumer2",
+		AppDir:     "/apps/consumer2",
+		Namespace:  "id-consumer2",
+		Values:     map[string]any{},
+		Bindings:   map[string]string{"db": "db2"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Update(consumer, "consumer2", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	if inst, err := m.FindInstance("consumer2"); err != nil {
+		t.Fatal(err)
+	} else if inst.Release.Bindings["db"].InstanceId != "db2" {
+		t.Fatalf("Expected binding to be preserved on update, got %+v", inst.Release.Bindings)
+	}
+	if err := m.Remove("consumer"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove("db1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type fakeVolumeSnapshotter struct {
+	snapshots map[string]VolumeSnapshot
+	restored  []string
+}
+
+func (f *fakeVolumeSnapshotter) Snapshot(namespace, volume, name string) (VolumeSnapshot, error) {
+	s := VolumeSnapshot{Name: name, Volume: volume, Size: "1Gi"}
+	f.snapshots[name] = s
+	return s, nil
+}
+
+func (f *fakeVolumeSnapshotter) Restore(namespace string, snapshot VolumeSnapshot, owner Resource) error {
+	if _, ok := f.snapshots[snapshot.Name]; !ok {
+		return fmt.Errorf("not found: %s", snapshot.Name)
+	}
+	f.restored = append(f.restored, fmt.Sprintf("%s/%s/%s", namespace, snapshot.Volume, owner.Name))
+	return nil
+}
+
+func (f *fakeVolumeSnapshotter) Delete(namespace, name string) error {
+	delete(f.snapshots, name)
+	return nil
+}
+
+type fakeSecretStore struct {
+	secrets map[string]map[string][]byte
+}
+
+func (f fakeSecretStore) Get(namespace, name string) (map[string][]byte, error) {
+	if s, ok := f.secrets[namespace+"/"+name]; ok {
+		return s, nil
+	}
+	return map[string][]byte{}, nil
+}
+
+func (f fakeSecretStore) Put(namespace, name string, data map[string][]byte) error {
+	f.secrets[namespace+"/"+name] = data
+	return nil
+}
+
+func (f fakeSecretStore) Delete(namespace, name string) error {
+	delete(f.secrets, namespace+"/"+name)
+	return nil
+}
+
+func TestBackupRestore(t *testing.T) {
+	m := newTestAppManager(t)
+	secrets := fakeSecretStore{map[string]map[string][]byte{}}
+	m.secrets = secrets
+	app := newTestEnvApp(t, `
+name: "Notes"
+namespace: "notes"
+input: {
+	password: string @role(secret)
+}
+backup: volumes: [{
+	name: "data"
+	release: "notes"
+}]
+helm: {}
+`)
+	r := NewInMemoryAppRepository([]App{app})
+	if _, err := m.Install(app, "notes", "/apps/notes", "id-notes", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	password := string(secrets.secrets["id-notes/notes-secrets"]["password"])
+	if password == "" {
+		t.Fatal("Expected password to be generated")
+	}
+	snapshots := &fakeVolumeSnapshotter{snapshots: map[string]VolumeSnapshot{}}
+	b := NewBackupManager(m, m.repoIO, snapshots, secrets)
+	backup, err := b.Backup("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backup.Snapshots) != 1 || backup.Snapshots[0].Volume != "data" {
+		t.Fatalf("Unexpected snapshots: %+v", backup.Snapshots)
+	}
+	if _, err := b.Restore(r, "notes", backup.Id); err == nil {
+		t.Fatal("Expected restoring installed instance to fail")
+	}
+	if err := m.Remove("notes"); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := b.List("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Id != backup.Id {
+		t.Fatalf("Unexpected backups: %+v", backups)
+	}
+	if _, err := b.Restore(r, "notes", backup.Id); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snapshots.restored, []string{"id-notes/data/notes"}) {
+		t.Fatalf("Unexpected restored volumes: %v", snapshots.restored)
+	}
+	if _, err := m.FindInstance("notes"); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secrets.secrets["id-notes/notes-secrets"]["password"]); got != password {
+		t.Fatalf("Expected password to be restored, got %s", got)
+	}
+}
+
+func TestInstanceResources(t *testing.T) {
+	m := newTestAppManager(t)
+	consumer := newTestEnvApp(t, consumerApp)
+	provider := newTestEnvApp(t, providerApp)
+	if _, err := m.Install(provider, "db", "/apps/db", "id-db", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	rr, err := m.Install(consumer, "consumer", "/apps/consumer", "id-consumer", map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.InstanceResources("consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, rr) {
+		t.Fatalf("Expected %+v, got %+v", rr, got)
+	}
+	if len(got.Helm) != 1 || got.Helm[0] != (Resource{"consumer", "id-consumer"}) {
+		t.Fatalf("Unexpected resources: %+v", got.Helm)
+	}
+}
+
+func TestUpdateRollsBack(t *testing.T) {
+	var allocated []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req allocatePortReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.SourcePort == 2222 {
+			http.Error(w, "port is already taken", http.StatusConflict)
+			return
+		}
+		allocated = append(allocated, req.SourcePort)
+	}))
+	defer srv.Close()
+	m := newTestAppManager(t)
+	secrets := fakeSecretStore{map[string]map[string][]byte{}}
+	m.secrets = secrets
+	app := newTestEnvApp(t, fmt.Sprintf(`
+name: "Notes"
+namespace: "notes"
+input: {
+	password: string @role(secret)
+	port: int
+}
+portForward: [{
+	allocator: "%s"
+	sourcePort: input.port
+	targetService: "notes"
+	targetPort: 80
+}]
+helm: {}
+`, srv.URL))
+	if _, err := m.Install(app, "notes", "/apps/notes", "id-notes", map[string]any{"port": 1111}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Update(app, "notes", map[string]any{"port": 1111, "password": "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(allocated, []int{1111}) {
+		t.Fatalf("Expected already forwarded port not to be allocated again: %v", allocated)
+	}
+	if _, err := m.Update(app, "notes", map[string]any{"port": 2222, "password": "bar"}); err == nil {
+		t.Fatal("Expected update to fail")
+	}
+	if got := string(secrets.secrets["id-notes/notes-secrets"]["password"]); got != "foo" {
+		t.Fatalf("Expected password to be restored, got %s", got)
+	}
+}
+
+func TestUpdateSeedsCommittedSecrets(t *testing.T) {
+	m := newTestAppManager(t)
+	secrets := fakeSecretStore{map[string]map[string][]byte{}}
+	m.secrets = secrets
+	app := newTestEnvApp(t, `
+name: "Notes"
+namespace: "notes"
+input: {
+	password: string @role(secret)
+}
+helm: {}
+`)
+	if _, err := m.Install(app, "notes", "/apps/notes", "id-notes", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	// Instances installed before secrets were stored separately have them
+	// committed along with the rest of the values.
+	delete(secrets.secrets, "id-notes/notes-secrets")
+	var config AppInstanceConfig
+	if err := soft.ReadJson(m.repoIO, "/apps/notes/config.json", &config); err != nil {
+		t.Fatal(err)
+	}
+	config.Input["password"] = "legacy"
+	if err := soft.WriteJson(m.repoIO, "/apps/notes/config.json", config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Update(app, "notes", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secrets.secrets["id-notes/notes-secrets"]["password"]); got != "legacy" {
+		t.Fatalf("Expected committed password to be kept, got %s", got)
+	}
+}
+
+func TestUpdateUsesLegacySecret(t *testing.T) {
+	m := newTestAppManager(t)
+	secrets := fakeSecretStore{map[string]map[string][]byte{}}
+	m.secrets = secrets
+	app := newTestEnvApp(t, `
+name: "Notes"
+namespace: "notes"
+input: {
+	password: string | *"legacy" @role(secret)
+}
+helm: {}
+`)
+	if _, err := m.Install(app, "notes", "/apps/notes", "id-notes", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	password := string(secrets.secrets["id-notes/notes-secrets"]["password"])
+	if password == "" || password == "legacy" {
+		t.Fatalf("Expected password to be generated, got %s", password)
+	}
+	if _, err := m.Update(app, "notes", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secrets.secrets["id-notes/notes-secrets"]["password"]); got != password {
+		t.Fatalf("Expected generated password to be kept, got %s", got)
+	}
+	// Instance installed before the password was introduced.
+	delete(secrets.secrets, "id-notes/notes-secrets")
+	if _, err := m.Update(app, "notes", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secrets.secrets["id-notes/notes-secrets"]["password"]); got != "legacy" {
+		t.Fatalf("Expected legacy password, got %s", got)
+	}
+}
+
+func commitAll(t *testing.T, wt *git.Worktree, message string) {
+	if err := wt.AddGlob("*"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{Name: "test"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hasFileDiff(diffs []FileDiff, path string, action FileDiffAction) bool {
+	for _, d := range diffs {
+		if d.Path == path && d.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDryRunLeavesRepoUntouched(t *testing.T) {
+	r, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := soft.NewRepoIO(&soft.Repository{Repository: r}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := soft.WriteYaml(repo, configFileName, env); err != nil {
+		t.Fatal(err)
+	}
+	commitAll(t, wt, "init")
+	// Installs the instance without committing, as there is no remote to push to.
+	installer, err := NewAppManager(mockRepoIO{soft.NewBillyRepoFS(wt.Filesystem)}, fakeNSCreator{}, nil, "/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newTestEnvApp(t, `
+name: "Notes"
+namespace: "notes"
+input: {
+	title: string | *"notes"
+}
+helm: {}
+`)
+	if _, err := installer.Install(app, "notes", "/apps/notes", "id-notes", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	commitAll(t, wt, "install")
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewAppManager(repo, fakeNSCreator{}, nil, "/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	install, err := m.InstallDryRun(app, "other", "/apps/other", "id-other", map[string]any{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasFileDiff(install, "/apps/other/config.json", FileAdded) {
+		t.Fatalf("Expected instance config to be added: %+v", install)
+	}
+	update, err := m.UpdateDryRun(app, "notes", map[string]any{"title": "journal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasFileDiff(update, "/apps/notes/config.json", FileModified) {
+		t.Fatalf("Expected instance config to be modified: %+v", update)
+	}
+	after, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Hash() != head.Hash() {
+		t.Fatalf("Expected HEAD to stay at %s, got %s", head.Hash(), after.Hash())
+	}
+	status, err := wt.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.IsClean() {
+		t.Fatalf("Expected worktree to be clean:\n%s", status)
+	}
+}
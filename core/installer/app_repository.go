@@ -9,7 +9,11 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"path"
+	"sort"
+	"strings"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/go-git/go-billy/v5"
 	"sigs.k8s.io/yaml"
 )
@@ -66,8 +70,12 @@ var infraAppConfigs = []string{
 type AppRepository interface {
 	GetAll() ([]App, error)
 	Find(name string) (App, error)
+	FindVersion(name, version string) (App, error)
+	Versions(name string) ([]string, error)
 }
 
+// InMemoryAppRepository may hold multiple versions of the same
+// application, in which case GetAll and Find return the latest one.
 type InMemoryAppRepository struct {
 	apps []App
 }
@@ -77,16 +85,75 @@ func NewInMemoryAppRepository(apps []App) InMemoryAppRepository {
 }
 
 func (r InMemoryAppRepository) Find(name string) (App, error) {
+	var ret App
 	for _, a := range r.apps {
-		if a.Slug() == name {
+		if a.Slug() == name && (ret == nil || compareVersions(a.Version(), ret.Version()) > 0) {
+			ret = a
+		}
+	}
+	if ret == nil {
+		return nil, fmt.Errorf("Application not found: %s", name)
+	}
+	return ret, nil
+}
+
+// FindVersion returns given version of the application, or the latest one
+// if version is empty.
+func (r InMemoryAppRepository) FindVersion(name, version string) (App, error) {
+	if version == "" {
+		return r.Find(name)
+	}
+	for _, a := range r.apps {
+		if a.Slug() == name && a.Version() == version {
 			return a, nil
 		}
 	}
-	return nil, fmt.Errorf("Application not found: %s", name)
+	return nil, fmt.Errorf("Application not found: %s@%s", name, version)
+}
+
+// Versions returns all the available versions of the application, latest first.
+func (r InMemoryAppRepository) Versions(name string) ([]string, error) {
+	ret := make([]string, 0)
+	for _, a := range r.apps {
+		if a.Slug() == name {
+			ret = append(ret, a.Version())
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("Application not found: %s", name)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return compareVersions(ret[i], ret[j]) > 0
+	})
+	return ret, nil
 }
 
 func (r InMemoryAppRepository) GetAll() ([]App, error) {
-	return r.apps, nil
+	ret := make([]App, 0, len(r.apps))
+	seen := make(map[string]struct{})
+	for _, a := range r.apps {
+		if _, ok := seen[a.Slug()]; ok {
+			continue
+		}
+		seen[a.Slug()] = struct{}{}
+		latest, err := r.Find(a.Slug())
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, latest)
+	}
+	return ret, nil
+}
+
+// compareVersions compares versions semantically, falling back to
+// lexicographical comparison if either of them is not a valid semver.
+func compareVersions(a, b string) int {
+	va, errA := semver.NewVersion(a)
+	vb, errB := semver.NewVersion(b)
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+	return va.Compare(vb)
 }
 
 func CreateAllApps() []App {
@@ -174,19 +241,7 @@ func FetchAppsFromHTTPRepository(addr string, fs billy.Filesystem) error {
 	}
 	for name, conf := range apps.Entries {
 		for _, version := range conf {
-			resp, err := http.Get(version.Urls[0])
-			if err != nil {
-				return err
-			}
-			nameVersion := fmt.Sprintf("%s-%s", name, version.Version)
-			if err := fs.MkdirAll(nameVersion, 0700); err != nil {
-				return err
-			}
-			sub, err := fs.Chroot(nameVersion)
-			if err != nil {
-				return err
-			}
-			if err := extractApp(resp.Body, sub); err != nil {
+			if err := fetchAppVersion(name, version, fs); err != nil {
 				return err
 			}
 		}
@@ -194,6 +249,27 @@ func FetchAppsFromHTTPRepository(addr string, fs billy.Filesystem) error {
 	return nil
 }
 
+// fetchAppVersion extracts application archive into <name>/<version> directory.
+func fetchAppVersion(name string, version appVersion, fs billy.Filesystem) error {
+	if len(version.Urls) == 0 {
+		return fmt.Errorf("No archive provided for %s@%s", name, version.Version)
+	}
+	resp, err := http.Get(version.Urls[0])
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dir := path.Join(name, version.Version)
+	if err := fs.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	sub, err := fs.Chroot(dir)
+	if err != nil {
+		return err
+	}
+	return extractApp(resp.Body, sub)
+}
+
 func extractApp(archive io.Reader, fs billy.Filesystem) error {
 	uncompressed, err := gzip.NewReader(archive)
 	if err != nil {
@@ -244,16 +320,26 @@ func NewFSAppRepository(fs billy.Filesystem) (AppRepository, error) {
 		if !e.IsDir() {
 			continue
 		}
-		appFS, err := fs.Chroot(e.Name())
+		versions, err := fs.ReadDir(e.Name())
 		if err != nil {
 			return nil, err
 		}
-		app, err := loadApp(appFS)
-		if err != nil {
-			log.Printf("Ignoring directory %s: %s", e.Name(), err)
-			continue
+		for _, v := range versions {
+			if !v.IsDir() {
+				continue
+			}
+			dir := path.Join(e.Name(), v.Name())
+			appFS, err := fs.Chroot(dir)
+			if err != nil {
+				return nil, err
+			}
+			app, err := loadApp(appFS, v.Name())
+			if err != nil {
+				log.Printf("Ignoring directory %s: %s", dir, err)
+				continue
+			}
+			apps = append(apps, app)
 		}
-		apps = append(apps, app)
 	}
 	return &fsAppRepository{
 		NewInMemoryAppRepository(apps),
@@ -261,7 +347,7 @@ func NewFSAppRepository(fs billy.Filesystem) (AppRepository, error) {
 	}, nil
 }
 
-func loadApp(fs billy.Filesystem) (App, error) {
+func loadApp(fs billy.Filesystem, version string) (App, error) {
 	items, err := fs.ReadDir(".")
 	if err != nil {
 		return nil, err
@@ -281,8 +367,9 @@ func loadApp(fs billy.Filesystem) (App, error) {
 		}
 	}
 	return NewCueEnvApp(CueAppData{
-		"base.cue": []byte(cueBaseConfig),
-		"app.cue":  contents.Bytes(),
+		"base.cue":    []byte(cueBaseConfig),
+		"app.cue":     contents.Bytes(),
+		"version.cue": []byte(fmt.Sprintf("version: %q", version)),
 	})
 }
 
@@ -339,6 +426,18 @@ func FindEnvApp(r AppRepository, name string) (EnvApp, error) {
 	}
 }
 
+func FindEnvAppVersion(r AppRepository, name, version string) (EnvApp, error) {
+	app, err := r.FindVersion(name, version)
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := app.(EnvApp); ok {
+		return a, nil
+	} else {
+		return nil, fmt.Errorf("not found")
+	}
+}
+
 func FindInfraApp(r AppRepository, name string) (InfraApp, error) {
 	app, err := r.Find(name)
 	if err != nil {
@@ -350,3 +449,35 @@ func FindInfraApp(r AppRepository, name string) (InfraApp, error) {
 		return nil, fmt.Errorf("not found")
 	}
 }
+
+type AppUpgrade struct {
+	InstanceId     string   `json:"instanceId"`
+	AppId          string   `json:"appId"`
+	CurrentVersion string   `json:"currentVersion"`
+	LatestVersion  string   `json:"latestVersion"`
+	Versions       []string `json:"versions"`
+	Available      bool     `json:"available"`
+}
+
+// CheckUpgrade reports whether newer version of the application is available
+// for given instance. Definitions sharing the same version are told apart by
+// their digests.
+func CheckUpgrade(r AppRepository, inst AppInstanceConfig) (AppUpgrade, error) {
+	latest, err := r.Find(inst.AppId)
+	if err != nil {
+		return AppUpgrade{}, err
+	}
+	versions, err := r.Versions(inst.AppId)
+	if err != nil {
+		return AppUpgrade{}, err
+	}
+	cmp := compareVersions(latest.Version(), inst.AppVersion)
+	return AppUpgrade{
+		InstanceId:     inst.Id,
+		AppId:          inst.AppId,
+		CurrentVersion: inst.AppVersion,
+		LatestVersion:  latest.Version(),
+		Versions:       versions,
+		Available:      cmp > 0 || (cmp == 0 && latest.Digest() != inst.AppDigest),
+	}, nil
+}
@@ -1,8 +1,15 @@
 package installer
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
+
+	"sigs.k8s.io/yaml"
 )
 
 var env = EnvConfig{
@@ -85,6 +92,51 @@ func TestAuthProxyDisabled(t *testing.T) {
 	}
 }
 
+func TestAuthProxyPolicies(t *testing.T) {
+	r := NewInMemoryAppRepository(CreateAllApps())
+	a, err := FindEnvApp(r, "rpuppy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range a.Schema().Fields() {
+		if f.Name == "auth" && f.Schema.Kind() != KindAuth {
+			t.Fatalf("Expected auth kind, got %d", f.Schema.Kind())
+		}
+	}
+	values := map[string]any{
+		"network":   "Public",
+		"subdomain": "woof",
+		"auth": map[string]any{
+			"enabled": true,
+			"groups":  "a",
+			"policies": []any{
+				map[string]any{
+					"path":   "/admin/*",
+					"groups": []any{"admins"},
+				},
+				map[string]any{
+					"path":    "/*",
+					"methods": []any{"GET"},
+					"groups":  []any{"readers"},
+				},
+			},
+		},
+	}
+	rendered, err := a.Render(Release{Namespace: "foo"}, env, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, r := range rendered.Resources {
+		if strings.Contains(string(r), "/admin/*") && strings.Contains(string(r), "readers") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Policies are not passed to the auth proxy")
+	}
+}
+
 func TestGroupMemberships(t *testing.T) {
 	r := NewInMemoryAppRepository(CreateAllApps())
 	a, err := FindEnvApp(r, "memberships")
@@ -298,3 +350,450 @@ func TestDNSGateway(t *testing.T) {
 		t.Log(string(r))
 	}
 }
+
+func TestAppVersions(t *testing.T) {
+	contents, err := valuesTmpls.ReadFile("values-tmpl/rpuppy.cue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := make([]App, 0)
+	for _, v := range []string{"0.1.0", "0.10.0", "0.2.0"} {
+		app, err := NewCueEnvApp(CueAppData{
+			"base.cue":    []byte(cueBaseConfig),
+			"app.cue":     []byte(contents),
+			"global.cue":  []byte(cueEnvAppGlobal),
+			"version.cue": []byte(fmt.Sprintf("version: %q", v)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		apps = append(apps, app)
+	}
+	r := NewInMemoryAppRepository(apps)
+	latest, err := r.Find("rpuppy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Version() != "0.10.0" {
+		t.Fatalf("Expected 0.10.0, got %s", latest.Version())
+	}
+	old, err := FindEnvAppVersion(r, "rpuppy", "0.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := old.Render(Release{Namespace: "foo"}, env, map[string]any{
+		"network":   "Public",
+		"subdomain": "woof",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered.Config.AppVersion != "0.2.0" || rendered.Config.AppDigest != old.Digest() {
+		t.Fatalf("Version is not recorded: %+v", rendered.Config)
+	}
+	upgrade, err := CheckUpgrade(r, rendered.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !upgrade.Available || upgrade.LatestVersion != "0.10.0" {
+		t.Fatalf("Expected upgrade to be available: %+v", upgrade)
+	}
+	rendered, err = latest.(EnvApp).Render(Release{Namespace: "foo"}, env, map[string]any{
+		"network":   "Public",
+		"subdomain": "woof",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgrade, err := CheckUpgrade(r, rendered.Config); err != nil {
+		t.Fatal(err)
+	} else if upgrade.Available {
+		t.Fatalf("Expected no upgrade: %+v", upgrade)
+	}
+}
+
+func TestValidateValues(t *testing.T) {
+	r := NewInMemoryAppRepository(CreateAllApps())
+	a, err := FindEnvApp(r, "gerrit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.Render(Release{Namespace: "foo"}, env, map[string]any{
+		"network": "Foo",
+		"sshPort": "22",
+	})
+	var verr ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected validation error, got: %v", err)
+	}
+	expected := map[string]bool{
+		"network":   false,
+		"subdomain": false,
+		"sshPort":   false,
+	}
+	for _, e := range verr.Errors {
+		if _, ok := expected[e.Path]; !ok {
+			t.Fatalf("Unexpected error: %s", e)
+		}
+		expected[e.Path] = true
+	}
+	for p, found := range expected {
+		if !found {
+			t.Fatalf("Expected error for %s: %s", p, verr)
+		}
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	migrations := []Migration{
+		{Version: "0.2.0", Rename: []Rename{{From: "domain", To: "subdomain"}}},
+		{Version: "0.3.0", Remove: []string{"legacy"}, Set: map[string]any{"auth.enabled": true}},
+		{Version: "0.4.0", Rename: []Rename{{From: "subdomain", To: "host"}}},
+	}
+	values := map[string]any{
+		"domain": "woof",
+		"legacy": 1,
+	}
+	migrated, err := applyMigrations(migrations, "0.1.0", "0.3.0", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated["subdomain"] != "woof" {
+		t.Fatalf("Expected field to be renamed: %+v", migrated)
+	}
+	if _, ok := migrated["legacy"]; ok {
+		t.Fatalf("Expected field to be removed: %+v", migrated)
+	}
+	if auth, ok := migrated["auth"].(map[string]any); !ok || auth["enabled"] != true {
+		t.Fatalf("Expected nested field to be set: %+v", migrated)
+	}
+	if _, ok := values["subdomain"]; ok {
+		t.Fatal("Original values must not be modified")
+	}
+	migrated, err = applyMigrations(migrations, "0.3.0", "0.3.0", migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := migrated["host"]; ok {
+		t.Fatalf("Migrations newer than target version must not be applied: %+v", migrated)
+	}
+	migrated, err = applyMigrations(migrations, "", "0.4.0", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated["domain"] != "woof" || migrated["legacy"] != 1 {
+		t.Fatalf("Migrations must not be applied to unversioned instances: %+v", migrated)
+	}
+}
+
+func TestMigrationRenamesInOrder(t *testing.T) {
+	m := Migration{
+		Version: "0.2.0",
+		Rename: []Rename{
+			{From: "a", To: "tmp"},
+			{From: "b", To: "a"},
+			{From: "tmp", To: "b"},
+		},
+	}
+	for i := 0; i < 10; i++ {
+		values := map[string]any{"a": 1, "b": 2}
+		if err := m.apply(values); err != nil {
+			t.Fatal(err)
+		}
+		if values["a"] != 2 || values["b"] != 1 || len(values) != 2 {
+			t.Fatalf("Expected fields to be swapped: %+v", values)
+		}
+	}
+}
+
+func TestSchemaKinds(t *testing.T) {
+	app, err := NewCueEnvApp(CueAppData{
+		"base.cue":   []byte(cueBaseConfig),
+		"global.cue": []byte(cueEnvAppGlobal),
+		"app.cue": []byte(`
+helm: {}
+
+input: {
+	mode: "a" | "b" | *"c"
+	password: string @role(secret)
+	port: #Port
+	sshPort: #Port | *22
+	timeout: #Duration
+	users: [...{
+		name: string
+		admin: bool | *false
+	}]
+	tags: [...string]
+}
+`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Kind{
+		"mode":     KindEnum,
+		"password": KindSecret,
+		"port":     KindPort,
+		"sshPort":  KindPort,
+		"timeout":  KindDuration,
+		"users":    KindArrayStruct,
+		"tags":     KindArrayString,
+	}
+	for _, f := range app.Schema().Fields() {
+		if f.Schema.Kind() != expected[f.Name] {
+			t.Fatalf("%s: expected kind %d, got %d", f.Name, expected[f.Name], f.Schema.Kind())
+		}
+	}
+	values := map[string]any{
+		"mode":    "d",
+		"port":    float64(70000),
+		"timeout": "1x",
+		"users":   []any{map[string]any{"admin": true}},
+	}
+	err = ValidateValues(values, app.Schema(), CreateNetworks(env))
+	var verr ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected validation error, got: %v", err)
+	}
+	if len(verr.Errors) != 4 {
+		t.Fatalf("Expected 4 errors, got: %s", verr)
+	}
+	values = map[string]any{
+		"mode":    "a",
+		"port":    float64(8080),
+		"timeout": "1h30m",
+		"users":   []any{map[string]any{"name": "foo"}},
+	}
+	rendered, err := app.Render(Release{Namespace: "foo"}, env, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, ok := rendered.Secrets["password"]
+	if !ok || secret == "" {
+		t.Fatalf("Expected secret to be generated: %+v", rendered.Secrets)
+	}
+	if _, ok := rendered.Config.Input["password"]; ok {
+		t.Fatal("Secret must not be stored in config")
+	}
+	kept := keepSecrets(values, map[string][]byte{"password": []byte(secret)}, app.Schema(), "")
+	if kept["password"] != secret {
+		t.Fatalf("Expected secret to be kept: %+v", kept)
+	}
+}
+
+func TestSecretsInArrayItems(t *testing.T) {
+	app, err := NewCueEnvApp(CueAppData{
+		"base.cue":   []byte(cueBaseConfig),
+		"global.cue": []byte(cueEnvAppGlobal),
+		"app.cue": []byte(`
+helm: {}
+
+input: {
+	users: [...{
+		name: string
+		password: string @role(secret)
+	}]
+}
+`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]any{
+		"users": []any{
+			map[string]any{"name": "foo", "password": "foo-password"},
+			map[string]any{"name": "bar"},
+		},
+	}
+	rendered, err := app.Render(Release{Namespace: "foo"}, env, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered.Secrets["users.0.password"] != "foo-password" || rendered.Secrets["users.1.password"] == "" {
+		t.Fatalf("Expected item secrets to be extracted: %+v", rendered.Secrets)
+	}
+	config, err := json.Marshal(rendered.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(config), "password") {
+		t.Fatalf("Secrets must not be stored in config: %s", config)
+	}
+	prev := map[string][]byte{}
+	for k, v := range rendered.Secrets {
+		prev[k] = []byte(v)
+	}
+	kept := keepSecrets(rendered.Config.Values, prev, app.Schema(), "")
+	items, ok := arrayItems(kept["users"])
+	if !ok || len(items) != 2 || items[0]["password"] != "foo-password" || items[1]["password"] != rendered.Secrets["users.1.password"] {
+		t.Fatalf("Expected item secrets to be kept: %+v", kept)
+	}
+}
+
+func TestSSHKeyPrivatePartIsSecret(t *testing.T) {
+	app, err := NewCueEnvApp(CueAppData{
+		"base.cue":   []byte(cueBaseConfig),
+		"global.cue": []byte(cueEnvAppGlobal),
+		"app.cue": []byte(`
+helm: {}
+
+input: {
+	key: #SSHKey
+}
+`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := app.Render(Release{Namespace: "foo"}, env, map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	private := rendered.Secrets["key.private"]
+	if private == "" {
+		t.Fatalf("Expected private key to be extracted: %+v", rendered.Secrets)
+	}
+	config, err := json.Marshal(rendered.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(config), `"private"`) || !strings.Contains(string(config), `"public"`) {
+		t.Fatalf("Private key must not be stored in config: %s", config)
+	}
+	kept := keepSecrets(rendered.Config.Input, map[string][]byte{"key.private": []byte(private)}, app.Schema(), "")
+	key, ok := sshKeyValues(kept["key"])
+	if !ok || key["private"] != private {
+		t.Fatalf("Expected private key to be kept: %+v", kept)
+	}
+}
+
+func TestSecretValuesFrom(t *testing.T) {
+	app, err := NewCueEnvApp(CueAppData{
+		"base.cue":   []byte(cueBaseConfig),
+		"global.cue": []byte(cueEnvAppGlobal),
+		"app.cue": []byte(`
+input: {
+	password: string @role(secret)
+}
+
+helm: {
+	foo: {
+		chart: {
+			chart: "charts/foo"
+			sourceRef: {
+				kind: "GitRepository"
+				name: "pcloud"
+				namespace: "foo"
+			}
+		}
+		values: {
+			user: "admin"
+		}
+		secretValues: {
+			"admin.password": "password"
+		}
+	}
+}
+`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := app.Render(Release{AppInstanceId: "foo-bar", Namespace: "foo"}, env, map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := rendered.Secrets["password"]
+	if secret == "" {
+		t.Fatal("Expected secret to be generated")
+	}
+	for name, r := range rendered.Resources {
+		if strings.Contains(string(r), secret) {
+			t.Fatalf("Secret leaked into %s", name)
+		}
+	}
+	for name, d := range rendered.Data {
+		if strings.Contains(string(d), secret) {
+			t.Fatalf("Secret leaked into %s", name)
+		}
+	}
+	var hr struct {
+		Spec struct {
+			ValuesFrom []map[string]string `json:"valuesFrom"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal(rendered.Resources["foo.yaml"], &hr); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"kind":       "Secret",
+		"name":       "foo-bar-secrets",
+		"valuesKey":  "password",
+		"targetPath": "admin.password",
+	}
+	if len(hr.Spec.ValuesFrom) != 1 || !reflect.DeepEqual(hr.Spec.ValuesFrom[0], expected) {
+		t.Fatalf("Unexpected valuesFrom: %+v", hr.Spec.ValuesFrom)
+	}
+}
+
+func TestMultipleIngresses(t *testing.T) {
+	app, err := NewCueEnvApp(CueAppData{
+		"base.cue":   []byte(cueBaseConfig),
+		"global.cue": []byte(cueEnvAppGlobal),
+		"app.cue": []byte(`
+input: {
+	network: #Network
+	subdomain: string
+	auth: #Auth
+}
+
+ingress: {
+	web: {
+		auth: input.auth
+		network: input.network
+		subdomain: input.subdomain
+		service: {
+			name: "web"
+			port: name: "http"
+		}
+	}
+	admin: {
+		auth: enabled: true
+		network: networks.private
+		subdomain: "admin-\(input.subdomain)"
+		service: {
+			name: "admin"
+			port: number: 8080
+		}
+	}
+}
+
+helm: {}
+`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := app.Render(Release{Namespace: "foo"}, env, map[string]any{
+		"network":   "Public",
+		"subdomain": "woof",
+		"auth": map[string]any{
+			"enabled": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := extractHelm(rendered.Resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, r := range resources {
+		names = append(names, r.Name)
+	}
+	expected := []string{"admin-auth-proxy", "admin-ingress", "web-auth-proxy", "web-ingress"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,171 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+// appTransaction keeps track of all the side effects of installing or
+// updating applications, so that they can be undone if any of the
+// steps fails.
+type appTransaction struct {
+	repoIO     soft.RepoIO
+	nsCreator  NamespaceCreator
+	secrets    SecretStore
+	namespaces []string
+	secretRefs []storedSecret
+	commit     string
+	ports      []PortForward
+}
+
+func newAppTransaction(repoIO soft.RepoIO, nsCreator NamespaceCreator, secrets SecretStore) *appTransaction {
+	return &appTransaction{
+		repoIO:     repoIO,
+		nsCreator:  nsCreator,
+		secrets:    secrets,
+		namespaces: make([]string, 0),
+		secretRefs: make([]storedSecret, 0),
+		ports:      make([]PortForward, 0),
+	}
+}
+
+// CreateNamespace creates given namespace. Only namespaces which did not
+// exist before are deleted on rollback.
+func (t *appTransaction) CreateNamespace(name string) error {
+	exists, err := t.nsCreator.Exists(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if err := t.nsCreator.Create(name); err != nil {
+		return err
+	}
+	t.namespaces = append(t.namespaces, name)
+	return nil
+}
+
+type storedSecret struct {
+	ref  Resource
+	prev map[string][]byte
+}
+
+// StoreSecrets creates or updates Secret object holding generated
+// application secrets. Previous contents are restored on rollback.
+func (t *appTransaction) StoreSecrets(namespace, name string, secrets map[string]string) error {
+	var prev map[string][]byte
+	if t.secrets != nil {
+		var err error
+		if prev, err = t.secrets.Get(namespace, name); err != nil {
+			return err
+		}
+	}
+	if err := storeSecrets(t.secrets, namespace, name, secrets); err != nil {
+		return err
+	}
+	t.secretRefs = append(t.secretRefs, storedSecret{Resource{name, namespace}, prev})
+	return nil
+}
+
+func (t *appTransaction) Commit(op soft.DoFn, opts ...soft.DoOption) error {
+	return t.repoIO.Do(op, append(opts, soft.WithCommitHash(&t.commit))...)
+}
+
+func (t *appTransaction) OpenPorts(ports []PortForward) error {
+	for _, p := range ports {
+		if err := sendPortReq(p.Allocator, p); err != nil {
+			return err
+		}
+		t.ports = append(t.ports, p)
+	}
+	return nil
+}
+
+// Rollback undoes all the recorded side effects in reverse order and
+// returns the cause combined with errors encountered along the way.
+func (t *appTransaction) Rollback(cause error) error {
+	errs := []error{cause}
+	for i := len(t.ports) - 1; i >= 0; i-- {
+		p := t.ports[i]
+		if p.Deallocator == "" {
+			errs = append(errs, fmt.Errorf("Could not release port %d, deallocator address is missing", p.SourcePort))
+			continue
+		}
+		if err := sendPortReq(p.Deallocator, p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	t.ports = nil
+	if t.commit != "" {
+		if err := t.repoIO.Revert(t.commit, fmt.Sprintf("revert: %s", t.commit)); err != nil {
+			errs = append(errs, err)
+		}
+		t.commit = ""
+	}
+	for i := len(t.secretRefs) - 1; i >= 0; i-- {
+		ref := t.secretRefs[i].ref
+		var err error
+		if prev := t.secretRefs[i].prev; len(prev) > 0 {
+			err = t.secrets.Put(ref.Namespace, ref.Name, prev)
+		} else {
+			err = t.secrets.Delete(ref.Namespace, ref.Name)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	t.secretRefs = nil
+	for i := len(t.namespaces) - 1; i >= 0; i-- {
+		if err := t.nsCreator.Delete(t.namespaces[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	t.namespaces = nil
+	return errors.Join(errs...)
+}
+
+func storeSecrets(store SecretStore, namespace, name string, secrets map[string]string) error {
+	if store == nil {
+		return fmt.Errorf("Can not store secrets %s/%s, secret store is not configured", namespace, name)
+	}
+	data := make(map[string][]byte, len(secrets))
+	for k, v := range secrets {
+		data[k] = []byte(v)
+	}
+	return store.Put(namespace, name, data)
+}
+
+type allocatePortReq struct {
+	Protocol      string `json:"protocol"`
+	SourcePort    int    `json:"sourcePort"`
+	TargetService string `json:"targetService"`
+	TargetPort    int    `json:"targetPort"`
+}
+
+func sendPortReq(addr string, p PortForward) error {
+	var buf bytes.Buffer
+	req := allocatePortReq{
+		Protocol:      p.Protocol,
+		SourcePort:    p.SourcePort,
+		TargetService: p.TargetService,
+		TargetPort:    p.TargetPort,
+	}
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		return err
+	}
+	resp, err := http.Post(addr, "application/json", &buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Could not process port %d request at %s, status code: %d", p.SourcePort, addr, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+const backupsDir = "/backups"
+
+// Volume is a persistent volume claim holding application data.
+type Volume struct {
+	Name    string `json:"name"`
+	Release string `json:"release,omitempty"`
+}
+
+type VolumeSnapshot struct {
+	Name         string   `json:"name"`
+	Volume       string   `json:"volume"`
+	Release      string   `json:"release,omitempty"`
+	StorageClass string   `json:"storageClass,omitempty"`
+	AccessModes  []string `json:"accessModes,omitempty"`
+	Size         string   `json:"size"`
+}
+
+// Backup records snapshots of the instance volumes together with the
+// instance configuration, so that it can be installed again on restore.
+type Backup struct {
+	Id        string            `json:"id"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Config    AppInstanceConfig `json:"config"`
+	Snapshots []VolumeSnapshot  `json:"snapshots"`
+}
+
+type BackupManager struct {
+	m         *AppManager
+	repoIO    soft.RepoIO
+	snapshots VolumeSnapshotter
+	secrets   SecretStore
+}
+
+func NewBackupManager(m *AppManager, repoIO soft.RepoIO, snapshots VolumeSnapshotter, secrets SecretStore) *BackupManager {
+	return &BackupManager{m, repoIO, snapshots, secrets}
+}
+
+func backupSecretsName(instanceId, backupId string) string {
+	return fmt.Sprintf("%s-backup-%s", instanceId, backupId)
+}
+
+func backupPath(instanceId, backupId string) string {
+	return filepath.Join(backupsDir, instanceId, fmt.Sprintf("%s.json", backupId))
+}
+
+// Backup snapshots all the volumes given instance declares. If any of the
+// snapshots fails, ones taken so far are deleted.
+func (b *BackupManager) Backup(instanceId string) (Backup, error) {
+	if err := b.repoIO.Pull(); err != nil {
+		return Backup{}, err
+	}
+	config, err := b.m.FindInstance(instanceId)
+	if err != nil {
+		return Backup{}, err
+	}
+	now := time.Now().UTC()
+	ret := Backup{
+		Id:        now.Format("20060102150405"),
+		CreatedAt: now,
+		Config:    *config,
+		Snapshots: make([]VolumeSnapshot, 0, len(config.Volumes)),
+	}
+	namespace := config.Release.Namespace
+	rollback := func(err error) (Backup, error) {
+		for _, s := range ret.Snapshots {
+			if e := b.snapshots.Delete(namespace, s.Name); e != nil {
+				err = errors.Join(err, e)
+			}
+		}
+		if b.secrets != nil {
+			if e := b.secrets.Delete(namespace, backupSecretsName(instanceId, ret.Id)); e != nil {
+				err = errors.Join(err, e)
+			}
+		}
+		return Backup{}, err
+	}
+	for _, v := range config.Volumes {
+		s, err := b.snapshots.Snapshot(namespace, v.Name, fmt.Sprintf("%s-%s-%s", instanceId, v.Name, ret.Id))
+		if err != nil {
+			return rollback(err)
+		}
+		s.Release = v.Release
+		ret.Snapshots = append(ret.Snapshots, s)
+	}
+	if b.secrets != nil {
+		secrets, err := b.secrets.Get(namespace, secretsName(instanceId))
+		if err != nil {
+			return rollback(err)
+		}
+		if len(secrets) > 0 {
+			if err := b.secrets.Put(namespace, backupSecretsName(instanceId, ret.Id), secrets); err != nil {
+				return rollback(err)
+			}
+		}
+	}
+	if err := b.repoIO.Do(func(r soft.RepoFS) (string, error) {
+		if err := soft.WriteJson(r, backupPath(instanceId, ret.Id), ret); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("backup: %s %s", instanceId, ret.Id), nil
+	}); err != nil {
+		return rollback(err)
+	}
+	return ret, nil
+}
+
+// List returns backups of given instance, latest first.
+func (b *BackupManager) List(instanceId string) ([]Backup, error) {
+	if err := b.repoIO.Pull(); err != nil {
+		return nil, err
+	}
+	files, err := b.repoIO.ListDir(filepath.Join(backupsDir, instanceId))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []Backup{}, nil
+		}
+		return nil, err
+	}
+	ret := make([]Backup, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		var backup Backup
+		if err := soft.ReadJson(b.repoIO, filepath.Join(backupsDir, instanceId, f.Name()), &backup); err != nil {
+			return nil, err
+		}
+		ret = append(ret, backup)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].CreatedAt.After(ret[j].CreatedAt)
+	})
+	return ret, nil
+}
+
+// Restore populates volumes of the instance from the given backup and
+// installs it again using the recorded configuration. Instance must be
+// removed beforehand so that its volumes are not in use.
+func (b *BackupManager) Restore(r AppRepository, instanceId, backupId string) (ReleaseResources, error) {
+	if err := b.repoIO.Pull(); err != nil {
+		return ReleaseResources{}, err
+	}
+	if _, err := b.m.FindInstance(instanceId); err == nil {
+		return ReleaseResources{}, fmt.Errorf("Instance %s must be removed before restoring it", instanceId)
+	} else if !errors.Is(err, ErrorNotFound) && !errors.Is(err, fs.ErrNotExist) {
+		return ReleaseResources{}, err
+	}
+	var backup Backup
+	if err := soft.ReadJson(b.repoIO, backupPath(instanceId, backupId), &backup); err != nil {
+		return ReleaseResources{}, err
+	}
+	config := backup.Config
+	app, err := FindEnvAppVersion(r, config.AppId, config.AppVersion)
+	if err != nil {
+		return ReleaseResources{}, err
+	}
+	namespace := config.Release.Namespace
+	for _, s := range backup.Snapshots {
+		owner := Resource{}
+		if s.Release != "" {
+			owner = Resource{s.Release, namespace}
+		}
+		if err := b.snapshots.Restore(namespace, s, owner); err != nil {
+			return ReleaseResources{}, err
+		}
+	}
+	values := config.Values
+	if b.secrets != nil {
+		secrets, err := b.secrets.Get(namespace, backupSecretsName(instanceId, backupId))
+		if err != nil {
+			return ReleaseResources{}, err
+		}
+		values = keepSecrets(values, secrets, app.Schema(), "")
+	}
+	bindings := map[string]string{}
+	for name, binding := range config.Release.Bindings {
+		bindings[name] = binding.InstanceId
+	}
+	return b.m.InstallAll([]InstallRequest{{
+		App:        app,
+		InstanceId: instanceId,
+		AppDir:     config.Release.AppDir,
+		Namespace:  namespace,
+		Values:     values,
+		Bindings:   bindings,
+	}})
+}
@@ -437,7 +437,7 @@ func (b Bootstrapper) installInfrastructureServices(mgr *InfraAppManager, env Bo
 
 func configureMainRepo(repo soft.RepoIO, bootstrap BootstrapConfig) error {
 	return repo.Do(func(r soft.RepoFS) (string, error) {
-		if err := soft.WriteYaml(r, "bootstrap-config.yaml", bootstrap); err != nil {
+		if err := soft.WriteYaml(r, bootstrapConfigPath, bootstrap); err != nil {
 			return "", err
 		}
 		infra := InfraConfig{
@@ -449,7 +449,10 @@ func configureMainRepo(repo soft.RepoIO, bootstrap BootstrapConfig) error {
 		if err := soft.WriteYaml(r, "config.yaml", infra); err != nil {
 			return "", err
 		}
-		if err := soft.WriteYaml(r, "env-cidrs.yaml", EnvCIDRs{}); err != nil {
+		if err := soft.WriteYaml(r, ipamLeasesPath, EnvCIDRs{}); err != nil {
+			return "", err
+		}
+		if err := soft.WriteYaml(r, ipamConfigPath, defaultIPAMConfig); err != nil {
 			return "", err
 		}
 		kust := io.NewKustomization()
@@ -513,13 +516,16 @@ func (b Bootstrapper) installEnvManager(mgr *InfraAppManager, ss soft.Client, en
 	}
 	namespace := fmt.Sprintf("%s-%s", env.InfraName, app.Namespace())
 	appDir := filepath.Join("/infrastructure", app.Slug())
-	_, err = mgr.Install(app, appDir, namespace, map[string]any{
+	if _, err := mgr.Install(app, appDir, namespace, map[string]any{
 		"repoIP":        env.ServiceIPs.ConfigRepo,
 		"repoPort":      22,
 		"repoName":      "config",
 		"sshPrivateKey": string(keys.RawPrivateKey()),
-	})
-	return err
+	}); err != nil {
+		return err
+	}
+	fmt.Printf("Env manager admin password is stored in %s/admin-password secret\n", namespace)
+	return nil
 }
 
 func (b Bootstrapper) installIngressPublic(mgr *InfraAppManager, ss soft.Client, env BootstrapConfig) error {
@@ -20,6 +20,8 @@ var appManagerFlags struct {
 	repoAddr    string
 	port        int
 	appRepoAddr string
+	// Class of the snapshots taken when backing up application volumes.
+	snapshotClass string
 }
 
 func appManagerCmd() *cobra.Command {
@@ -51,6 +53,12 @@ func appManagerCmd() *cobra.Command {
 		"",
 		"",
 	)
+	cmd.Flags().StringVar(
+		&appManagerFlags.snapshotClass,
+		"snapshot-class",
+		"longhorn",
+		"",
+	)
 	return cmd
 }
 
@@ -80,7 +88,11 @@ func appManagerCmdRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	m, err := installer.NewAppManager(repoIO, kube, "/apps")
+	secrets, err := newSecretStore()
+	if err != nil {
+		return err
+	}
+	m, err := installer.NewAppManager(repoIO, kube, secrets, "/apps")
 	if err != nil {
 		return err
 	}
@@ -108,15 +120,25 @@ func appManagerCmdRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	snapshots, err := newVolumeSnapshotter(appManagerFlags.snapshotClass)
+	if err != nil {
+		return err
+	}
+	health, err := newHealthChecker()
+	if err != nil {
+		return err
+	}
 	s := welcome.NewAppManagerServer(
 		appManagerFlags.port,
 		m,
+		installer.NewBackupManager(m, repoIO, snapshots, secrets),
 		r,
 		tasks.NewFluxcdReconciler( // TODO(gio): make reconciler address a flag
 			"http://fluxcd-reconciler.dodo-fluxcd-reconciler.svc.cluster.local",
 			env.Id,
 		),
 		helmMon,
+		health,
 	)
 	return s.Start()
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/spf13/cobra"
+
+	"github.com/giolekva/pcloud/core/installer"
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+var dryRunFlags struct {
+	repoAddr string
+	sshKey   string
+	app      string
+	instance string
+	values   string
+	bindings map[string]string
+	infra    bool
+}
+
+func dryRunCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dry-run",
+		Short: "Prints changes installing or updating an application would make to the config repository",
+		RunE:  dryRunCmdRun,
+	}
+	cmd.Flags().StringVar(
+		&dryRunFlags.repoAddr,
+		"repo-addr",
+		"",
+		"",
+	)
+	cmd.Flags().StringVar(
+		&dryRunFlags.sshKey,
+		"ssh-key",
+		"",
+		"",
+	)
+	cmd.Flags().StringVar(
+		&dryRunFlags.app,
+		"app",
+		"",
+		"Application to install, update is performed if omitted",
+	)
+	cmd.Flags().StringVar(
+		&dryRunFlags.instance,
+		"instance",
+		"",
+		"Application instance id, defaults to application slug on install",
+	)
+	cmd.Flags().StringVar(
+		&dryRunFlags.values,
+		"values",
+		"",
+		"Path to the JSON file with application input values",
+	)
+	cmd.Flags().StringToStringVar(
+		&dryRunFlags.bindings,
+		"bind",
+		nil,
+		"Instances to bind application dependencies to, as dependency=instance pairs",
+	)
+	cmd.Flags().BoolVar(
+		&dryRunFlags.infra,
+		"infra",
+		false,
+		"Whether to install or update infrastructure application",
+	)
+	return cmd
+}
+
+func dryRunCmdRun(cmd *cobra.Command, args []string) error {
+	if dryRunFlags.app == "" && dryRunFlags.instance == "" {
+		return fmt.Errorf("either --app or --instance must be provided")
+	}
+	values := map[string]any{}
+	if dryRunFlags.values != "" {
+		contents, err := os.ReadFile(dryRunFlags.values)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(contents, &values); err != nil {
+			return err
+		}
+	}
+	sshKey, err := os.ReadFile(dryRunFlags.sshKey)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.ParsePrivateKey(sshKey)
+	if err != nil {
+		return err
+	}
+	addr, err := soft.ParseRepositoryAddress(dryRunFlags.repoAddr)
+	if err != nil {
+		return err
+	}
+	repo, err := soft.CloneRepository(addr, signer)
+	if err != nil {
+		return err
+	}
+	repoIO, err := soft.NewRepoIO(repo, signer)
+	if err != nil {
+		return err
+	}
+	r := installer.NewInMemoryAppRepository(installer.CreateAllApps())
+	var diff []installer.FileDiff
+	if dryRunFlags.infra {
+		diff, err = dryRunInfra(repoIO, r, values)
+	} else {
+		diff, err = dryRunEnv(repoIO, r, values)
+	}
+	if err != nil {
+		return err
+	}
+	if len(diff) == 0 {
+		fmt.Println("No changes")
+	}
+	for _, d := range diff {
+		fmt.Println(d)
+	}
+	return nil
+}
+
+func dryRunEnv(repoIO soft.RepoIO, r installer.AppRepository, values map[string]any) ([]installer.FileDiff, error) {
+	// Namespaces are never created during dry run.
+	mgr, err := installer.NewAppManager(repoIO, nil, nil, "/apps")
+	if err != nil {
+		return nil, err
+	}
+	if dryRunFlags.app == "" {
+		inst, err := mgr.FindInstance(dryRunFlags.instance)
+		if err != nil {
+			return nil, err
+		}
+		app, err := installer.FindEnvApp(r, inst.AppId)
+		if err != nil {
+			return nil, err
+		}
+		return mgr.UpdateDryRun(app, inst.Id, values)
+	}
+	app, err := installer.FindEnvApp(r, dryRunFlags.app)
+	if err != nil {
+		return nil, err
+	}
+	env, err := mgr.Config()
+	if err != nil {
+		return nil, err
+	}
+	instanceId := dryRunFlags.instance
+	if instanceId == "" {
+		instanceId = app.Slug()
+	}
+	appDir := filepath.Join("/apps", instanceId)
+	namespace := fmt.Sprintf("%s%s", env.NamespacePrefix, app.Namespace())
+	return mgr.InstallDryRun(app, instanceId, appDir, namespace, values, dryRunFlags.bindings)
+}
+
+func dryRunInfra(repoIO soft.RepoIO, r installer.AppRepository, values map[string]any) ([]installer.FileDiff, error) {
+	mgr, err := installer.NewInfraAppManager(repoIO, nil)
+	if err != nil {
+		return nil, err
+	}
+	if dryRunFlags.app == "" {
+		inst, err := mgr.FindInstance(dryRunFlags.instance)
+		if err != nil {
+			return nil, err
+		}
+		app, err := installer.FindInfraApp(r, inst.AppId)
+		if err != nil {
+			return nil, err
+		}
+		return mgr.UpdateDryRun(app, dryRunFlags.instance, values)
+	}
+	app, err := installer.FindInfraApp(r, dryRunFlags.app)
+	if err != nil {
+		return nil, err
+	}
+	infra, err := mgr.Config()
+	if err != nil {
+		return nil, err
+	}
+	instanceId := dryRunFlags.instance
+	if instanceId == "" {
+		instanceId = app.Slug()
+	}
+	appDir := filepath.Join("/infrastructure", instanceId)
+	namespace := fmt.Sprintf("%s-%s", infra.Name, app.Namespace())
+	return mgr.InstallDryRun(app, appDir, namespace, values)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/giolekva/pcloud/core/installer"
+	"github.com/giolekva/pcloud/core/installer/soft"
+	"github.com/giolekva/pcloud/core/installer/tasks"
+)
+
+var envDeleteFlags struct {
+	repoAddr string
+	repoName string
+	sshKey   string
+	envId    string
+}
+
+func envDeleteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "envdelete",
+		RunE: envDeleteCmdRun,
+	}
+	cmd.Flags().StringVar(
+		&envDeleteFlags.repoAddr,
+		"repo-addr",
+		"",
+		"",
+	)
+	cmd.Flags().StringVar(
+		&envDeleteFlags.repoName,
+		"repo-name",
+		"",
+		"",
+	)
+	cmd.Flags().StringVar(
+		&envDeleteFlags.sshKey,
+		"ssh-key",
+		"",
+		"",
+	)
+	cmd.Flags().StringVar(
+		&envDeleteFlags.envId,
+		"env-id",
+		"",
+		"",
+	)
+	return cmd
+}
+
+func envDeleteCmdRun(cmd *cobra.Command, args []string) error {
+	if envDeleteFlags.envId == "" {
+		return fmt.Errorf("Environment id not provided")
+	}
+	sshKey, err := installer.NewSSHKeyPair(envDeleteFlags.sshKey)
+	if err != nil {
+		return err
+	}
+	ss, err := soft.RealClientGetter{}.Get(envDeleteFlags.repoAddr, sshKey.RawPrivateKey(), log.Default())
+	if err != nil {
+		return err
+	}
+	repoIO, err := ss.GetRepo(envDeleteFlags.repoName)
+	if err != nil {
+		return err
+	}
+	nsCreator, err := newNSCreator()
+	if err != nil {
+		return err
+	}
+	issuers, err := newClusterIssuerDeleter()
+	if err != nil {
+		return err
+	}
+	mgr, err := installer.NewInfraAppManager(repoIO, nsCreator)
+	if err != nil {
+		return err
+	}
+	infra, err := mgr.Config()
+	if err != nil {
+		return err
+	}
+	progress := tasks.NewRepoProgressStore(repoIO, "/env-tasks", nil, "")
+	if err := tasks.ValidateEnvDeletion(envDeleteFlags.envId, infra, installer.NewIPAM(repoIO, nil), progress); err != nil {
+		return err
+	}
+	t := tasks.NewDeleteEnvTask(envDeleteFlags.envId, infra, repoIO, mgr, nsCreator, issuers)
+	done := make(chan error)
+	t.OnDone(func(err error) {
+		done <- err
+	})
+	go t.Start()
+	if err := <-done; err != nil {
+		return err
+	}
+	fmt.Printf("Deleted environment %s\n", envDeleteFlags.envId)
+	return nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"log"
+	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -13,10 +15,12 @@ import (
 )
 
 var envManagerFlags struct {
-	repoAddr string
-	repoName string
-	sshKey   string
-	port     int
+	repoAddr          string
+	repoName          string
+	sshKey            string
+	adminPasswordFile string
+	namespace         string
+	port              int
 }
 
 func envManagerCmd() *cobra.Command {
@@ -42,6 +46,18 @@ func envManagerCmd() *cobra.Command {
 		"",
 		"",
 	)
+	cmd.Flags().StringVar(
+		&envManagerFlags.adminPasswordFile,
+		"admin-password-file",
+		"",
+		"",
+	)
+	cmd.Flags().StringVar(
+		&envManagerFlags.namespace,
+		"namespace",
+		"",
+		"",
+	)
 	cmd.Flags().IntVar(
 		&envManagerFlags.port,
 		"port",
@@ -75,6 +91,26 @@ func envManagerCmdRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	issuers, err := newClusterIssuerDeleter()
+	if err != nil {
+		return err
+	}
+	pools, err := newAddressPoolLister()
+	if err != nil {
+		return err
+	}
+	secrets, err := newSecretStore()
+	if err != nil {
+		return err
+	}
+	adminPassword := ""
+	if envManagerFlags.adminPasswordFile != "" {
+		p, err := os.ReadFile(envManagerFlags.adminPasswordFile)
+		if err != nil {
+			return err
+		}
+		adminPassword = strings.TrimSpace(string(p))
+	}
 	httpClient := http.NewClient()
 	s := welcome.NewEnvServer(
 		envManagerFlags.port,
@@ -86,6 +122,11 @@ func envManagerCmdRun(cmd *cobra.Command, args []string) error {
 		installer.NewFixedLengthRandomNameGenerator(4),
 		httpClient,
 		dns.NewClient(),
+		issuers,
+		adminPassword,
+		installer.NewIPAM(repoIO, pools),
+		secrets,
+		envManagerFlags.namespace,
 	)
 	log.Printf("Starting server\n")
 	s.Start()
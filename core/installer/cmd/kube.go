@@ -8,6 +8,18 @@ func newNSCreator() (installer.NamespaceCreator, error) {
 	return installer.NewNamespaceCreator(rootFlags.kubeConfig)
 }
 
+func newClusterIssuerDeleter() (installer.ClusterIssuerDeleter, error) {
+	return installer.NewClusterIssuerDeleter(rootFlags.kubeConfig)
+}
+
+func newAddressPoolLister() (installer.AddressPoolLister, error) {
+	return installer.NewAddressPoolLister(rootFlags.kubeConfig)
+}
+
+func newSecretStore() (installer.SecretStore, error) {
+	return installer.NewSecretStore(rootFlags.kubeConfig)
+}
+
 func newZoneFetcher() (installer.ZoneStatusFetcher, error) {
 	return installer.NewZoneStatusFetcher(rootFlags.kubeConfig)
 }
@@ -15,3 +27,11 @@ func newZoneFetcher() (installer.ZoneStatusFetcher, error) {
 func newHelmReleaseMonitor() (installer.HelmReleaseMonitor, error) {
 	return installer.NewHelmReleaseMonitor(rootFlags.kubeConfig)
 }
+
+func newVolumeSnapshotter(snapshotClass string) (installer.VolumeSnapshotter, error) {
+	return installer.NewVolumeSnapshotter(rootFlags.kubeConfig, snapshotClass)
+}
+
+func newHealthChecker() (installer.HealthChecker, error) {
+	return installer.NewHealthChecker(rootFlags.kubeConfig)
+}
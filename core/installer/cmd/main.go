@@ -25,9 +25,11 @@ func init() {
 	rootCmd.AddCommand(bootstrapCmd())
 	rootCmd.AddCommand(appManagerCmd())
 	rootCmd.AddCommand(envManagerCmd())
+	rootCmd.AddCommand(envDeleteCmd())
 	rootCmd.AddCommand(welcomeCmd())
 	rootCmd.AddCommand(rewriteCmd())
 	rootCmd.AddCommand(launcherCmd())
+	rootCmd.AddCommand(dryRunCmd())
 }
 
 func main() {
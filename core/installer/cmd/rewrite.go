@@ -61,7 +61,7 @@ func rewriteCmdRun(cmd *cobra.Command, args []string) error {
 	}
 	log.Println("Creating repository")
 	r := installer.NewInMemoryAppRepository(installer.CreateAllApps())
-	mgr, err := installer.NewAppManager(repoIO, nil, "/apps")
+	mgr, err := installer.NewAppManager(repoIO, nil, nil, "/apps")
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,153 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// Dependency is another application the one being installed needs,
+// resolved to one of its existing instances at install time.
+type Dependency struct {
+	Name     string `json:"name"`
+	App      string `json:"app"`
+	Optional bool   `json:"optional"`
+}
+
+// Provides describes how dependent applications can connect to the
+// application instance.
+type Provides struct {
+	Service string `json:"service,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+}
+
+// Binding holds connection details of the instance a dependency was
+// resolved to. Bindings are recorded in the release of the dependent
+// instance and exposed to its definition as release.bindings.
+type Binding struct {
+	InstanceId string `json:"instanceId"`
+	AppId      string `json:"appId"`
+	Namespace  string `json:"namespace"`
+	Service    string `json:"service,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	Secret     string `json:"secret,omitempty"`
+}
+
+// UnresolvedDependencyError is returned when dependency can not be bound
+// automatically, either because there are no instances of the required
+// application or there are multiple ones to choose from.
+type UnresolvedDependencyError struct {
+	Dependency Dependency `json:"dependency"`
+	Candidates []string   `json:"candidates"`
+}
+
+func (e UnresolvedDependencyError) Error() string {
+	if len(e.Candidates) == 0 {
+		return fmt.Sprintf("Dependency %s requires %s to be installed first", e.Dependency.Name, e.Dependency.App)
+	}
+	return fmt.Sprintf("Dependency %s must be bound to one of: %s", e.Dependency.Name, strings.Join(e.Candidates, ", "))
+}
+
+func newBinding(inst AppInstanceConfig) Binding {
+	ret := Binding{
+		InstanceId: inst.Id,
+		AppId:      inst.AppId,
+		Namespace:  inst.Release.Namespace,
+	}
+	if inst.Provides != nil {
+		ret.Service = inst.Provides.Service
+		ret.Port = inst.Provides.Port
+		ret.Secret = inst.Provides.Secret
+	}
+	return ret
+}
+
+// resolveBindings binds each of the application dependencies to an
+// existing instance. Explicitly chosen instances take precedence, otherwise
+// dependency is bound to the only instance of the required application.
+func (m *AppManager) resolveBindings(app EnvApp, chosen map[string]string) (map[string]Binding, error) {
+	deps := app.Dependencies()
+	if len(deps) == 0 {
+		return nil, nil
+	}
+	for name := range chosen {
+		if !hasDependency(deps, name) {
+			return nil, fmt.Errorf("Unknown dependency: %s", name)
+		}
+	}
+	ret := map[string]Binding{}
+	for _, d := range deps {
+		if id, ok := chosen[d.Name]; ok {
+			inst, err := m.FindInstance(id)
+			if err != nil {
+				return nil, err
+			}
+			if inst.AppId != d.App {
+				return nil, fmt.Errorf("Dependency %s requires %s, got %s", d.Name, d.App, inst.AppId)
+			}
+			ret[d.Name] = newBinding(*inst)
+			continue
+		}
+		all, err := m.FindAllAppInstances(d.App)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		switch {
+		case len(all) == 1:
+			ret[d.Name] = newBinding(all[0])
+		case len(all) == 0 && d.Optional:
+			continue
+		default:
+			candidates := make([]string, 0, len(all))
+			for _, inst := range all {
+				candidates = append(candidates, inst.Id)
+			}
+			sort.Strings(candidates)
+			return nil, UnresolvedDependencyError{d, candidates}
+		}
+	}
+	return ret, nil
+}
+
+// boundInstances returns instances given bindings refer to, omitting
+// dependencies application no longer declares.
+func boundInstances(app EnvApp, bindings map[string]Binding) map[string]string {
+	ret := map[string]string{}
+	for name, b := range bindings {
+		if hasDependency(app.Dependencies(), name) {
+			ret[name] = b.InstanceId
+		}
+	}
+	return ret
+}
+
+func hasDependency(deps []Dependency, name string) bool {
+	for _, d := range deps {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// findDependents returns ids of the instances bound to the given one.
+func (m *AppManager) findDependents(instanceId string) ([]string, error) {
+	all, err := m.FindAllInstances()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0)
+	for _, inst := range all {
+		for _, b := range inst.Release.Bindings {
+			if b.InstanceId == instanceId {
+				ret = append(ret, inst.Id)
+				break
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
@@ -6,42 +6,50 @@ import (
 )
 
 type Release struct {
-	AppInstanceId string `json:"appInstanceId"`
-	Namespace     string `json:"namespace"`
-	RepoAddr      string `json:"repoAddr"`
-	AppDir        string `json:"appDir"`
+	AppInstanceId string             `json:"appInstanceId"`
+	Namespace     string             `json:"namespace"`
+	RepoAddr      string             `json:"repoAddr"`
+	AppDir        string             `json:"appDir"`
+	Bindings      map[string]Binding `json:"bindings,omitempty"`
 }
 
 type Network struct {
-	Name              string `json:"name,omitempty"`
-	IngressClass      string `json:"ingressClass,omitempty"`
-	CertificateIssuer string `json:"certificateIssuer,omitempty"`
-	Domain            string `json:"domain,omitempty"`
-	AllocatePortAddr  string `json:"allocatePortAddr,omitempty"`
+	Name               string `json:"name,omitempty"`
+	IngressClass       string `json:"ingressClass,omitempty"`
+	CertificateIssuer  string `json:"certificateIssuer,omitempty"`
+	Domain             string `json:"domain,omitempty"`
+	AllocatePortAddr   string `json:"allocatePortAddr,omitempty"`
+	DeallocatePortAddr string `json:"deallocatePortAddr,omitempty"`
 }
 
 type InfraAppInstanceConfig struct {
-	Id      string         `json:"id"`
-	AppId   string         `json:"appId"`
-	Infra   InfraConfig    `json:"infra"`
-	Release Release        `json:"release"`
-	Values  map[string]any `json:"values"`
-	Input   map[string]any `json:"input"`
-	URL     string         `json:"url"`
-	Help    []HelpDocument `json:"help"`
-	Icon    template.HTML  `json:"icon"`
+	Id         string         `json:"id"`
+	AppId      string         `json:"appId"`
+	AppVersion string         `json:"appVersion,omitempty"`
+	AppDigest  string         `json:"appDigest,omitempty"`
+	Infra      InfraConfig    `json:"infra"`
+	Release    Release        `json:"release"`
+	Values     map[string]any `json:"values"`
+	Input      map[string]any `json:"input"`
+	URL        string         `json:"url"`
+	Help       []HelpDocument `json:"help"`
+	Icon       template.HTML  `json:"icon"`
 }
 
 type AppInstanceConfig struct {
-	Id      string         `json:"id"`
-	AppId   string         `json:"appId"`
-	Env     EnvConfig      `json:"env"`
-	Release Release        `json:"release"`
-	Values  map[string]any `json:"values"`
-	Input   map[string]any `json:"input"`
-	URL     string         `json:"url"`
-	Help    []HelpDocument `json:"help"`
-	Icon    string         `json:"icon"`
+	Id         string         `json:"id"`
+	AppId      string         `json:"appId"`
+	AppVersion string         `json:"appVersion,omitempty"`
+	AppDigest  string         `json:"appDigest,omitempty"`
+	Env        EnvConfig      `json:"env"`
+	Release    Release        `json:"release"`
+	Values     map[string]any `json:"values"`
+	Input      map[string]any `json:"input"`
+	URL        string         `json:"url"`
+	Help       []HelpDocument `json:"help"`
+	Icon       string         `json:"icon"`
+	Provides   *Provides      `json:"provides,omitempty"`
+	Volumes    []Volume       `json:"volumes,omitempty"`
 }
 
 func (a AppInstanceConfig) InputToValues(schema Schema) map[string]any {
@@ -59,6 +67,9 @@ func deriveValues(values any, schema Schema, networks []Network) (map[string]any
 		def := f.Schema
 		// TODO(gio): validate that it is map
 		v, ok := values.(map[string]any)[k]
+		if def.Kind() == KindSecret && v == "" {
+			ok = false
+		}
 		// TODO(gio): if missing use default value
 		if !ok {
 			if def.Kind() == KindSSHKey {
@@ -70,6 +81,12 @@ func deriveValues(values any, schema Schema, networks []Network) (map[string]any
 					"public":  string(key.RawAuthorizedKey()),
 					"private": string(key.RawPrivateKey()),
 				}
+			} else if def.Kind() == KindSecret {
+				secret, err := generateSecret()
+				if err != nil {
+					return nil, err
+				}
+				ret[k] = secret
 			}
 			continue
 		}
@@ -80,10 +97,14 @@ func deriveValues(values any, schema Schema, networks []Network) (map[string]any
 			ret[k] = v
 		case KindInt:
 			ret[k] = v
+		case KindNumber:
+			ret[k] = v
+		case KindEnum, KindSecret, KindPort, KindDuration:
+			ret[k] = v
 		case KindArrayString:
-			a, ok := v.([]string)
-			if !ok {
-				return nil, fmt.Errorf("expected string array")
+			a, err := toStringArray(v)
+			if err != nil {
+				return nil, err
 			}
 			ret[k] = a
 		case KindNetwork:
@@ -110,6 +131,20 @@ func deriveValues(values any, schema Schema, networks []Network) (map[string]any
 				return nil, err
 			}
 			ret[k] = r
+		case KindArrayStruct:
+			items, ok := v.([]any)
+			if !ok {
+				return nil, fmt.Errorf("expected array")
+			}
+			r := make([]any, 0, len(items))
+			for _, i := range items {
+				d, err := deriveValues(i, def, networks)
+				if err != nil {
+					return nil, err
+				}
+				r = append(r, d)
+			}
+			ret[k] = r
 		default:
 			return nil, fmt.Errorf("Should not reach!")
 		}
@@ -134,10 +169,12 @@ func derivedToConfig(derived map[string]any, schema Schema) (map[string]any, err
 			ret[k] = v
 		case KindInt:
 			ret[k] = v
+		case KindNumber, KindEnum, KindSecret, KindPort, KindDuration:
+			ret[k] = v
 		case KindArrayString:
-			a, ok := v.([]string)
-			if !ok {
-				return nil, fmt.Errorf("expected string array")
+			a, err := toStringArray(v)
+			if err != nil {
+				return nil, err
 			}
 			ret[k] = a
 		case KindNetwork:
@@ -180,6 +217,24 @@ func derivedToConfig(derived map[string]any, schema Schema) (map[string]any, err
 				return nil, err
 			}
 			ret[k] = r
+		case KindArrayStruct:
+			items, ok := v.([]any)
+			if !ok {
+				return nil, fmt.Errorf("expected array")
+			}
+			r := make([]any, 0, len(items))
+			for _, i := range items {
+				im, ok := i.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("expected map")
+				}
+				c, err := derivedToConfig(im, def)
+				if err != nil {
+					return nil, err
+				}
+				r = append(r, c)
+			}
+			ret[k] = r
 		default:
 			return nil, fmt.Errorf("Should not reach!")
 		}
@@ -187,6 +242,25 @@ func derivedToConfig(derived map[string]any, schema Schema) (map[string]any, err
 	return ret, nil
 }
 
+func toStringArray(v any) ([]string, error) {
+	switch a := v.(type) {
+	case []string:
+		return a, nil
+	case []any:
+		ret := make([]string, 0, len(a))
+		for _, e := range a {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string array")
+			}
+			ret = append(ret, s)
+		}
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("expected string array")
+	}
+}
+
 func findNetwork(networks []Network, name string) (Network, error) {
 	for _, n := range networks {
 		if n.Name == name {
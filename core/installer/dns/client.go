@@ -1,11 +1,17 @@
 package dns
 
 import (
+	"fmt"
 	"net"
+
+	"github.com/miekg/dns"
 )
 
 type Client interface {
 	Lookup(host string) ([]net.IP, error)
+	// Returns DS records of the zone, as served by its parent zone, in
+	// presentation format.
+	LookupDS(zone string) ([]string, error)
 }
 
 type realClient struct{}
@@ -17,3 +23,36 @@ func NewClient() Client {
 func (c realClient) Lookup(host string) ([]net.IP, error) {
 	return net.LookupIP(host)
 }
+
+func (c realClient) LookupDS(zone string) ([]string, error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, err
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(zone), dns.TypeDS)
+	m.RecursionDesired = true
+	var lastErr error
+	for _, server := range conf.Servers {
+		resp, _, err := new(dns.Client).Exchange(m, net.JoinHostPort(server, conf.Port))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			lastErr = fmt.Errorf("DS query failed: %s", dns.RcodeToString[resp.Rcode])
+			continue
+		}
+		ret := []string{}
+		for _, rr := range resp.Answer {
+			if ds, ok := rr.(*dns.DS); ok {
+				ret = append(ret, ds.String())
+			}
+		}
+		return ret, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("No nameservers configured")
+	}
+	return nil, lastErr
+}
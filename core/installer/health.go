@@ -0,0 +1,65 @@
+package installer
+
+import (
+	"io"
+	"path/filepath"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+type ResourceHealth struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Ready     bool   `json:"ready"`
+	Message   string `json:"message,omitempty"`
+}
+
+// InstanceHealth aggregates readiness of the helm releases, pods and
+// certificates of the application instance.
+type InstanceHealth struct {
+	Ready     bool             `json:"ready"`
+	Resources []ResourceHealth `json:"resources"`
+}
+
+func newInstanceHealth(resources []ResourceHealth) InstanceHealth {
+	ret := InstanceHealth{true, resources}
+	for _, r := range resources {
+		if !r.Ready {
+			ret.Ready = false
+			break
+		}
+	}
+	return ret
+}
+
+type HealthChecker interface {
+	Check(namespace string, rr ReleaseResources) (InstanceHealth, error)
+}
+
+// InstanceResources returns resources rendered for the given instance.
+func (m *AppManager) InstanceResources(instanceId string) (ReleaseResources, error) {
+	resourcesDir := filepath.Join(m.appDirRoot, instanceId, "resources")
+	kust, err := soft.ReadKustomization(m.repoIO, filepath.Join(resourcesDir, "kustomization.yaml"))
+	if err != nil {
+		return ReleaseResources{}, err
+	}
+	resources := CueAppData{}
+	for _, name := range kust.Resources {
+		r, err := m.repoIO.Reader(filepath.Join(resourcesDir, name))
+		if err != nil {
+			return ReleaseResources{}, err
+		}
+		contents, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return ReleaseResources{}, err
+		}
+		resources[name] = contents
+	}
+	helm, err := extractHelm(resources)
+	if err != nil {
+		return ReleaseResources{}, err
+	}
+	return ReleaseResources{Helm: helm}, nil
+}
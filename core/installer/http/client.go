@@ -1,11 +1,13 @@
 package http
 
 import (
+	"io"
 	"net/http"
 )
 
 type Client interface {
 	Get(addr string) (*http.Response, error)
+	Post(addr, contentType string, body io.Reader) (*http.Response, error)
 }
 
 type realClient struct{}
@@ -14,6 +16,10 @@ func (c realClient) Get(addr string) (*http.Response, error) {
 	return http.Get(addr)
 }
 
+func (c realClient) Post(addr, contentType string, body io.Reader) (*http.Response, error) {
+	return http.Post(addr, contentType, body)
+}
+
 func NewClient() Client {
 	return realClient{}
 }
@@ -0,0 +1,309 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+const (
+	ipamConfigPath = "ipam.yaml"
+	// Leases are kept in the file environment CIDRs have always been
+	// recorded in, so that existing allocations are honoured.
+	ipamLeasesPath      = "env-cidrs.yaml"
+	bootstrapConfigPath = "bootstrap-config.yaml"
+	envSubnetBits       = 24
+)
+
+// Environment subnets must be within this range, as NewEnvNetwork derives
+// in-cluster DNS address of the environment from its second and third octets.
+var envSubnetsRange = netip.MustParsePrefix("10.0.0.0/8")
+
+// IPPool is a range environment subnets are allocated from. Every
+// environment gets /24 subnet, which NewEnvNetwork lays its addresses out in.
+type IPPool struct {
+	Name   string       `json:"name"`
+	Prefix netip.Prefix `json:"prefix"`
+}
+
+type IPAMConfig struct {
+	Pools []IPPool `json:"pools"`
+	// Ranges which must never be allocated.
+	Reserved []netip.Prefix `json:"reserved,omitempty"`
+}
+
+var defaultIPAMConfig = IPAMConfig{
+	Pools: []IPPool{{"default", envSubnetsRange}},
+	Reserved: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/24"),
+		// Pod and service networks of the cluster.
+		netip.MustParsePrefix("10.42.0.0/15"),
+		// In-cluster DNS addresses of the environments.
+		netip.MustParsePrefix("10.44.0.0/16"),
+	},
+}
+
+// AddressRange is an inclusive range of IP addresses.
+type AddressRange struct {
+	From netip.Addr `json:"from"`
+	To   netip.Addr `json:"to"`
+}
+
+func (r AddressRange) overlaps(p netip.Prefix) bool {
+	first, last := prefixBounds(p)
+	return r.From.Compare(last) <= 0 && first.Compare(r.To) <= 0
+}
+
+// ParseAddressRange parses either CIDR or dash separated range of
+// addresses, the formats metallb address pools are defined in.
+func ParseAddressRange(s string) (AddressRange, error) {
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		f, err := netip.ParseAddr(strings.TrimSpace(from))
+		if err != nil {
+			return AddressRange{}, err
+		}
+		t, err := netip.ParseAddr(strings.TrimSpace(to))
+		if err != nil {
+			return AddressRange{}, err
+		}
+		return AddressRange{f, t}, nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return AddressRange{}, err
+	}
+	first, last := prefixBounds(p)
+	return AddressRange{first, last}, nil
+}
+
+func prefixBounds(p netip.Prefix) (netip.Addr, netip.Addr) {
+	p = p.Masked()
+	b := p.Addr().As4()
+	ip := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	ip |= uint32(1)<<(32-p.Bits()) - 1
+	return p.Addr(), netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)})
+}
+
+// AddressPoolLister returns ranges of the metallb address pools configured
+// in the cluster.
+type AddressPoolLister interface {
+	List() ([]AddressRange, error)
+}
+
+// IPAM allocates environment subnets out of the configured pools and
+// records them as leases in the repository. Released subnets are reused.
+type IPAM struct {
+	repo  soft.RepoIO
+	pools AddressPoolLister
+}
+
+// NewIPAM returns IPAM backed by the given repository. Collisions with
+// metallb address pools are checked only if pools is not nil.
+func NewIPAM(repo soft.RepoIO, pools AddressPoolLister) *IPAM {
+	return &IPAM{repo, pools}
+}
+
+func readIPAMConfig(r soft.RepoFS) (IPAMConfig, error) {
+	var ret IPAMConfig
+	if err := soft.ReadYaml(r, ipamConfigPath, &ret); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return defaultIPAMConfig, nil
+		}
+		return IPAMConfig{}, err
+	}
+	for _, p := range ret.Pools {
+		if !p.Prefix.Addr().Is4() || p.Prefix.Bits() > envSubnetBits {
+			return IPAMConfig{}, fmt.Errorf("Pool %s must be IPv4 range of at least /%d", p.Name, envSubnetBits)
+		}
+		if !envSubnetsRange.Contains(p.Prefix.Addr()) || p.Prefix.Bits() < envSubnetsRange.Bits() {
+			return IPAMConfig{}, fmt.Errorf("Pool %s must be within %s", p.Name, envSubnetsRange)
+		}
+	}
+	return ret, nil
+}
+
+func readLeases(r soft.RepoFS) (EnvCIDRs, error) {
+	var ret EnvCIDRs
+	if err := soft.ReadYaml(r, ipamLeasesPath, &ret); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return EnvCIDRs{}, nil
+		}
+		return nil, err
+	}
+	return ret, nil
+}
+
+// serviceRanges returns addresses infrastructure services were bootstrapped with.
+func serviceRanges(r soft.RepoFS) ([]AddressRange, error) {
+	var bootstrap BootstrapConfig
+	if err := soft.ReadYaml(r, bootstrapConfigPath, &bootstrap); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ret := []AddressRange{}
+	ips := bootstrap.ServiceIPs
+	for _, ip := range []netip.Addr{ips.ConfigRepo, ips.IngressPublic} {
+		if ip.IsValid() {
+			ret = append(ret, AddressRange{ip, ip})
+		}
+	}
+	if ips.From.IsValid() && ips.To.IsValid() {
+		ret = append(ret, AddressRange{ips.From, ips.To})
+	}
+	return ret, nil
+}
+
+func toPrefix(ip net.IP) (netip.Prefix, error) {
+	addr, err := netip.ParseAddr(ip.String())
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, envSubnetBits), nil
+}
+
+// Leases returns subnets allocated so far.
+func (m *IPAM) Leases() (EnvCIDRs, error) {
+	if err := m.repo.Pull(); err != nil {
+		return nil, err
+	}
+	return readLeases(m.repo)
+}
+
+// Lease returns subnet allocated to the given owner.
+func (m *IPAM) Lease(owner string) (netip.Prefix, bool, error) {
+	leases, err := m.Leases()
+	if err != nil {
+		return netip.Prefix{}, false, err
+	}
+	for _, l := range leases {
+		if l.Name == owner {
+			p, err := toPrefix(l.IP)
+			return p, err == nil, err
+		}
+	}
+	return netip.Prefix{}, false, nil
+}
+
+// Allocate returns the first free subnet which collides neither with
+// existing leases, nor reserved ranges, infrastructure service addresses
+// and metallb address pools. Allocating for the same owner again returns
+// its existing lease.
+func (m *IPAM) Allocate(owner string) (netip.Prefix, error) {
+	var metallb []AddressRange
+	if m.pools != nil {
+		var err error
+		if metallb, err = m.pools.List(); err != nil {
+			return netip.Prefix{}, err
+		}
+	}
+	var ret netip.Prefix
+	if err := m.repo.Do(func(r soft.RepoFS) (string, error) {
+		config, err := readIPAMConfig(r)
+		if err != nil {
+			return "", err
+		}
+		leases, err := readLeases(r)
+		if err != nil {
+			return "", err
+		}
+		taken := map[netip.Prefix]struct{}{}
+		for _, l := range leases {
+			p, err := toPrefix(l.IP)
+			if err != nil {
+				return "", err
+			}
+			if l.Name == owner {
+				return "", errLeaseExists{p}
+			}
+			taken[p] = struct{}{}
+		}
+		services, err := serviceRanges(r)
+		if err != nil {
+			return "", err
+		}
+		occupied := append(services, metallb...)
+		for _, p := range config.Reserved {
+			first, last := prefixBounds(p)
+			occupied = append(occupied, AddressRange{first, last})
+		}
+		for _, pool := range config.Pools {
+			if subnet, ok := findFreeSubnet(pool.Prefix, taken, occupied); ok {
+				ret = subnet
+				leases = append(leases, EnvCIDR{owner, net.ParseIP(subnet.Addr().String())})
+				if err := soft.WriteYaml(r, ipamLeasesPath, leases); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("Allocate CIDR for %s", owner), nil
+			}
+		}
+		return "", fmt.Errorf("Can not allocate, all the pools are exhausted")
+	}); err != nil {
+		var existing errLeaseExists
+		if errors.As(err, &existing) {
+			return existing.subnet, nil
+		}
+		return netip.Prefix{}, err
+	}
+	return ret, nil
+}
+
+// Release frees subnet allocated to the given owner, if any.
+func (m *IPAM) Release(owner string) error {
+	if _, ok, err := m.Lease(owner); err != nil || !ok {
+		return err
+	}
+	return m.repo.Do(func(r soft.RepoFS) (string, error) {
+		leases, err := readLeases(r)
+		if err != nil {
+			return "", err
+		}
+		keep := make(EnvCIDRs, 0, len(leases))
+		for _, l := range leases {
+			if l.Name != owner {
+				keep = append(keep, l)
+			}
+		}
+		if err := soft.WriteYaml(r, ipamLeasesPath, keep); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Release CIDR of %s", owner), nil
+	})
+}
+
+// errLeaseExists aborts allocation without committing anything.
+type errLeaseExists struct {
+	subnet netip.Prefix
+}
+
+func (e errLeaseExists) Error() string {
+	return fmt.Sprintf("Lease already exists: %s", e.subnet)
+}
+
+func findFreeSubnet(pool netip.Prefix, taken map[netip.Prefix]struct{}, occupied []AddressRange) (netip.Prefix, bool) {
+	first, last := prefixBounds(pool)
+	for addr := first; addr.IsValid() && addr.Compare(last) <= 0; {
+		subnet := netip.PrefixFrom(addr, envSubnetBits)
+		free := true
+		if _, ok := taken[subnet]; ok {
+			free = false
+		}
+		for _, r := range occupied {
+			if free && r.overlaps(subnet) {
+				free = false
+			}
+		}
+		if free {
+			return subnet, true
+		}
+		_, subnetLast := prefixBounds(subnet)
+		addr = subnetLast.Next()
+	}
+	return netip.Prefix{}, false
+}
@@ -0,0 +1,85 @@
+package installer
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+type fakeAddressPoolLister []AddressRange
+
+func (f fakeAddressPoolLister) List() ([]AddressRange, error) {
+	return f, nil
+}
+
+func TestIPAM(t *testing.T) {
+	repo := mockRepoIO{soft.NewBillyRepoFS(memfs.New())}
+	if err := soft.WriteYaml(repo, ipamConfigPath, IPAMConfig{
+		Pools:    []IPPool{{"small", netip.MustParsePrefix("10.1.0.0/22")}},
+		Reserved: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/24")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := soft.WriteYaml(repo, bootstrapConfigPath, BootstrapConfig{
+		ServiceIPs: EnvServiceIPs{
+			ConfigRepo: netip.MustParseAddr("10.1.1.5"),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	metallb, err := ParseAddressRange("10.1.2.100-10.1.2.110")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam := NewIPAM(repo, fakeAddressPoolLister{metallb})
+	first, err := ipam.Allocate("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != "10.1.3.0/24" {
+		t.Fatalf("Expected collisions to be skipped, got %s", first)
+	}
+	if again, err := ipam.Allocate("first"); err != nil {
+		t.Fatal(err)
+	} else if again != first {
+		t.Fatalf("Expected existing lease, got %s", again)
+	}
+	if _, err := ipam.Allocate("second"); err == nil {
+		t.Fatal("Expected pool to be exhausted")
+	}
+	if err := ipam.Release("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipam.Release("first"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := ipam.Allocate("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Fatalf("Expected released subnet to be reused, got %s", second)
+	}
+	network, err := NewEnvNetwork(second.Addr().AsSlice())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if network.DNSInClusterIP.String() != "10.44.1.3" {
+		t.Fatalf("Unexpected in-cluster DNS address: %s", network.DNSInClusterIP)
+	}
+}
+
+func TestIPAMRejectsPoolOutsideOfEnvRange(t *testing.T) {
+	repo := mockRepoIO{soft.NewBillyRepoFS(memfs.New())}
+	if err := soft.WriteYaml(repo, ipamConfigPath, IPAMConfig{
+		Pools: []IPPool{{"public", netip.MustParsePrefix("192.168.0.0/16")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewIPAM(repo, nil).Allocate("env"); err == nil {
+		t.Fatal("Expected pool to be rejected")
+	}
+}
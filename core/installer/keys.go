@@ -1,7 +1,11 @@
 package installer
 
 import (
+	"os"
+	"path/filepath"
+
 	"github.com/charmbracelet/keygen"
+	"golang.org/x/crypto/ssh"
 )
 
 func NewSSHKeyPair(path string) (*keygen.KeyPair, error) {
@@ -11,3 +15,26 @@ func NewSSHKeyPair(path string) (*keygen.KeyPair, error) {
 func NewECDSASSHKeyPair(path string) (*keygen.KeyPair, error) {
 	return keygen.New(path, keygen.WithKeyType(keygen.ECDSA))
 }
+
+// ParseSSHKeyPair recreates key pair from the PEM encoded private key
+// previously returned by RawPrivateKey.
+func ParseSSHKeyPair(privateKey []byte) (*keygen.KeyPair, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	// keygen can only read existing keys from the filesystem.
+	dir, err := os.MkdirTemp("", "keys")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key")
+	if err := os.WriteFile(path, privateKey, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(signer.PublicKey()), 0600); err != nil {
+		return nil, err
+	}
+	return keygen.New(path)
+}
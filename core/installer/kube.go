@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -20,6 +24,30 @@ import (
 
 type NamespaceCreator interface {
 	Create(name string) error
+	Exists(name string) (bool, error)
+	Delete(name string) error
+	List() ([]string, error)
+}
+
+// ClusterIssuerDeleter removes cert-manager cluster issuers, which being
+// cluster scoped outlive namespaces of the environment they belong to.
+type ClusterIssuerDeleter interface {
+	Delete(name string) error
+}
+
+// SecretStore keeps generated application secrets out of the config repository.
+type SecretStore interface {
+	Get(namespace, name string) (map[string][]byte, error)
+	Put(namespace, name string, data map[string][]byte) error
+	Delete(namespace, name string) error
+}
+
+// VolumeSnapshotter takes and restores point in time copies of the
+// persistent volume claims.
+type VolumeSnapshotter interface {
+	Snapshot(namespace, volume, name string) (VolumeSnapshot, error)
+	Restore(namespace string, snapshot VolumeSnapshot, owner Resource) error
+	Delete(namespace, name string) error
 }
 
 type ZoneInfo struct {
@@ -51,6 +79,191 @@ func (n *realNamespaceCreator) Create(name string) error {
 	return err
 }
 
+func (n *realNamespaceCreator) Exists(name string) (bool, error) {
+	_, err := n.clientset.CoreV1().Namespaces().Get(context.Background(), name, metav1.GetOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (n *realNamespaceCreator) Delete(name string) error {
+	err := n.clientset.CoreV1().Namespaces().Delete(context.Background(), name, metav1.DeleteOptions{})
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (n *realNamespaceCreator) List() ([]string, error) {
+	namespaces, err := n.clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		ret = append(ret, ns.Name)
+	}
+	return ret, nil
+}
+
+type realSecretStore struct {
+	clientset *kubernetes.Clientset
+}
+
+func (s *realSecretStore) Get(namespace, name string) (map[string][]byte, error) {
+	secret, err := s.clientset.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return map[string][]byte{}, nil
+		}
+		return nil, err
+	}
+	return secret.Data, nil
+}
+
+func (s *realSecretStore) Put(namespace, name string, data map[string][]byte) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+	_, err := s.clientset.CoreV1().Secrets(namespace).Update(context.Background(), secret, metav1.UpdateOptions{})
+	if err != nil && errors.IsNotFound(err) {
+		_, err = s.clientset.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+	}
+	return err
+}
+
+func (s *realSecretStore) Delete(namespace, name string) error {
+	err := s.clientset.CoreV1().Secrets(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+var volumeSnapshotsResource = schema.GroupVersionResource{Group: "snapshot.storage.k8s.io", Version: "v1", Resource: "volumesnapshots"}
+
+type realVolumeSnapshotter struct {
+	clientset     *kubernetes.Clientset
+	d             dynamic.Interface
+	snapshotClass string
+}
+
+func (s *realVolumeSnapshotter) Snapshot(namespace, volume, name string) (VolumeSnapshot, error) {
+	ctx := context.Background()
+	pvc, err := s.clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, volume, metav1.GetOptions{})
+	if err != nil {
+		return VolumeSnapshot{}, err
+	}
+	snapshot := &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "snapshot.storage.k8s.io/v1",
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]any{
+				"name":      name,
+				"namespace": namespace,
+			},
+			"spec": map[string]any{
+				"volumeSnapshotClassName": s.snapshotClass,
+				"source": map[string]any{
+					"persistentVolumeClaimName": volume,
+				},
+			},
+		},
+	}
+	if _, err := s.d.Resource(volumeSnapshotsResource).Namespace(namespace).Create(ctx, snapshot, metav1.CreateOptions{}); err != nil {
+		return VolumeSnapshot{}, err
+	}
+	ret := VolumeSnapshot{
+		Name:   name,
+		Volume: volume,
+		Size:   pvc.Spec.Resources.Requests.Storage().String(),
+	}
+	if pvc.Spec.StorageClassName != nil {
+		ret.StorageClass = *pvc.Spec.StorageClassName
+	}
+	for _, m := range pvc.Spec.AccessModes {
+		ret.AccessModes = append(ret.AccessModes, string(m))
+	}
+	return ret, nil
+}
+
+// Restore replaces the volume claim with the new one populated from the
+// snapshot. If owner is given, restored claim is annotated so that helm
+// adopts it when the owning release is installed again.
+func (s *realVolumeSnapshotter) Restore(namespace string, snapshot VolumeSnapshot, owner Resource) error {
+	ctx := context.Background()
+	pvcs := s.clientset.CoreV1().PersistentVolumeClaims(namespace)
+	if err := pvcs.Delete(ctx, snapshot.Volume, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err := wait.PollUntilContextTimeout(ctx, time.Second, 2*time.Minute, true, func(ctx context.Context) (bool, error) {
+		_, err := pvcs.Get(ctx, snapshot.Volume, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}); err != nil {
+		return err
+	}
+	size, err := resource.ParseQuantity(snapshot.Size)
+	if err != nil {
+		return err
+	}
+	apiGroup := "snapshot.storage.k8s.io"
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      snapshot.Volume,
+			Namespace: namespace,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     "VolumeSnapshot",
+				Name:     snapshot.Name,
+			},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+	if snapshot.StorageClass != "" {
+		pvc.Spec.StorageClassName = &snapshot.StorageClass
+	}
+	for _, m := range snapshot.AccessModes {
+		pvc.Spec.AccessModes = append(pvc.Spec.AccessModes, corev1.PersistentVolumeAccessMode(m))
+	}
+	if owner.Name != "" {
+		pvc.Labels = map[string]string{
+			"app.kubernetes.io/managed-by": "Helm",
+		}
+		pvc.Annotations = map[string]string{
+			"meta.helm.sh/release-name":      owner.Name,
+			"meta.helm.sh/release-namespace": owner.Namespace,
+		}
+	}
+	_, err = pvcs.Create(ctx, pvc, metav1.CreateOptions{})
+	return err
+}
+
+func (s *realVolumeSnapshotter) Delete(namespace, name string) error {
+	err := s.d.Resource(volumeSnapshotsResource).Namespace(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
 // TODO(gio): take http client
 type realZoneStatusFetcher struct{}
 
@@ -75,6 +288,96 @@ func NewNamespaceCreator(kubeconfig string) (NamespaceCreator, error) {
 	return &realNamespaceCreator{clientset}, nil
 }
 
+var clusterIssuersResource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "clusterissuers"}
+
+type realClusterIssuerDeleter struct {
+	d dynamic.Interface
+}
+
+func (c *realClusterIssuerDeleter) Delete(name string) error {
+	err := c.d.Resource(clusterIssuersResource).Delete(context.Background(), name, metav1.DeleteOptions{})
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func NewClusterIssuerDeleter(kubeconfig string) (ClusterIssuerDeleter, error) {
+	config, err := newRestConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	d, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &realClusterIssuerDeleter{d}, nil
+}
+
+var ipAddressPoolsResource = schema.GroupVersionResource{Group: "metallb.io", Version: "v1beta1", Resource: "ipaddresspools"}
+
+type realAddressPoolLister struct {
+	d dynamic.Interface
+}
+
+func (l *realAddressPoolLister) List() ([]AddressRange, error) {
+	pools, err := l.d.Resource(ipAddressPoolsResource).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ret := []AddressRange{}
+	for _, p := range pools.Items {
+		addrs, _, err := unstructured.NestedStringSlice(p.Object, "spec", "addresses")
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			r, err := ParseAddressRange(a)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid addresses of pool %s/%s: %s", p.GetNamespace(), p.GetName(), err)
+			}
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+func NewAddressPoolLister(kubeconfig string) (AddressPoolLister, error) {
+	config, err := newRestConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	d, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &realAddressPoolLister{d}, nil
+}
+
+func NewSecretStore(kubeconfig string) (SecretStore, error) {
+	clientset, err := NewKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return &realSecretStore{clientset}, nil
+}
+
+func NewVolumeSnapshotter(kubeconfig string, snapshotClass string) (VolumeSnapshotter, error) {
+	config, err := newRestConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	d, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &realVolumeSnapshotter{clientset, d, snapshotClass}, nil
+}
+
 func NewZoneStatusFetcher(kubeconfig string) (ZoneStatusFetcher, error) {
 	return &realZoneStatusFetcher{}, nil
 }
@@ -89,7 +392,7 @@ type realHelmReleaseMonitor struct {
 
 func (m *realHelmReleaseMonitor) IsReleased(namespace, name string) (bool, error) {
 	ctx := context.Background()
-	res, err := m.d.Resource(schema.GroupVersionResource{"helm.toolkit.fluxcd.io", "v2beta1", "helmreleases"}).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	res, err := m.d.Resource(helmReleasesResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		return false, err
 	}
@@ -118,19 +421,116 @@ func NewHelmReleaseMonitor(kubeconfig string) (HelmReleaseMonitor, error) {
 	return &realHelmReleaseMonitor{d}, nil
 }
 
-func NewKubeConfig(kubeconfig string) (*kubernetes.Clientset, error) {
-	if kubeconfig == "" {
-		config, err := rest.InClusterConfig()
+var (
+	helmReleasesResource = schema.GroupVersionResource{Group: "helm.toolkit.fluxcd.io", Version: "v2beta1", Resource: "helmreleases"}
+	certificatesResource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+)
+
+type realHealthChecker struct {
+	clientset *kubernetes.Clientset
+	d         dynamic.Interface
+}
+
+func (h *realHealthChecker) Check(namespace string, rr ReleaseResources) (InstanceHealth, error) {
+	ctx := context.Background()
+	ret := make([]ResourceHealth, 0)
+	for _, r := range rr.Helm {
+		res, err := h.d.Resource(helmReleasesResource).Namespace(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
 		if err != nil {
-			return nil, err
+			if errors.IsNotFound(err) {
+				ret = append(ret, ResourceHealth{"HelmRelease", r.Namespace, r.Name, false, "Not created yet"})
+				continue
+			}
+			return InstanceHealth{}, err
 		}
-		return kubernetes.NewForConfig(config)
+		ret = append(ret, readyCondition("HelmRelease", res))
+	}
+	pods, err := h.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return InstanceHealth{}, err
+	}
+	for _, p := range pods.Items {
+		ret = append(ret, podHealth(p))
+	}
+	certs, err := h.d.Resource(certificatesResource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return InstanceHealth{}, err
+	}
+	if certs != nil {
+		for _, c := range certs.Items {
+			ret = append(ret, readyCondition("Certificate", &c))
+		}
+	}
+	return newInstanceHealth(ret), nil
+}
 
-	} else {
-		config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			return nil, err
+func readyCondition(kind string, res *unstructured.Unstructured) ResourceHealth {
+	ret := ResourceHealth{kind, res.GetNamespace(), res.GetName(), false, "Waiting to become ready"}
+	conditions, _, _ := unstructured.NestedSlice(res.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+		ret.Ready = cond["status"] == "True"
+		if msg, ok := cond["message"].(string); ok {
+			ret.Message = msg
+		}
+	}
+	return ret
+}
+
+func podHealth(p corev1.Pod) ResourceHealth {
+	ret := ResourceHealth{"Pod", p.Namespace, p.Name, false, string(p.Status.Phase)}
+	switch p.Status.Phase {
+	case corev1.PodSucceeded:
+		ret.Ready = true
+	case corev1.PodRunning:
+		ret.Ready = true
+		for _, c := range p.Status.ContainerStatuses {
+			if c.Ready {
+				continue
+			}
+			ret.Ready = false
+			if c.State.Waiting != nil {
+				ret.Message = fmt.Sprintf("%s: %s", c.Name, c.State.Waiting.Reason)
+			} else {
+				ret.Message = fmt.Sprintf("%s: not ready", c.Name)
+			}
+			break
 		}
-		return kubernetes.NewForConfig(config)
+	}
+	return ret
+}
+
+func NewHealthChecker(kubeconfig string) (HealthChecker, error) {
+	config, err := newRestConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	d, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &realHealthChecker{clientset, d}, nil
+}
+
+func NewKubeConfig(kubeconfig string) (*kubernetes.Clientset, error) {
+	config, err := newRestConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+func newRestConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		return rest.InClusterConfig()
+	} else {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
 	}
 }
@@ -0,0 +1,144 @@
+package installer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Migration describes how values of the older application instances must be
+// transformed to match the schema introduced by given version of the
+// application definition. Paths are dot separated field names. Renames are
+// applied in the order they are declared, so that one may move a field out
+// of the way of another.
+type Migration struct {
+	Version string         `json:"version"`
+	Rename  []Rename       `json:"rename"`
+	Remove  []string       `json:"remove"`
+	Set     map[string]any `json:"set"`
+}
+
+type Rename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (m Migration) apply(values map[string]any) error {
+	for _, r := range m.Rename {
+		v, ok := getPath(values, r.From)
+		if !ok {
+			continue
+		}
+		deletePath(values, r.From)
+		if err := setPath(values, r.To, v); err != nil {
+			return err
+		}
+	}
+	for _, p := range m.Remove {
+		deletePath(values, p)
+	}
+	for p, v := range m.Set {
+		if err := setPath(values, p, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyMigrations applies migrations introduced after the fromVersion and
+// up to the toVersion, in the order they are declared. Given values are
+// left untouched. Instances installed before versions were recorded have
+// no known baseline, so none of the migrations are applied to them.
+func applyMigrations(migrations []Migration, fromVersion, toVersion string, values map[string]any) (map[string]any, error) {
+	ret := copyValues(values)
+	if fromVersion == "" {
+		return ret, nil
+	}
+	for _, m := range migrations {
+		if compareVersions(m.Version, fromVersion) <= 0 || compareVersions(m.Version, toVersion) > 0 {
+			continue
+		}
+		if err := m.apply(ret); err != nil {
+			return nil, fmt.Errorf("Migration to %s failed: %s", m.Version, err)
+		}
+	}
+	return ret, nil
+}
+
+// MigrateInstance brings values of the instance rendered by an older
+// version of the application definition in line with the current one.
+func MigrateInstance(app App, inst AppInstanceConfig) (AppInstanceConfig, error) {
+	values, err := app.Migrate(inst.AppVersion, inst.Values)
+	if err != nil {
+		return AppInstanceConfig{}, err
+	}
+	input, err := app.Migrate(inst.AppVersion, inst.Input)
+	if err != nil {
+		return AppInstanceConfig{}, err
+	}
+	inst.Values = values
+	inst.Input = input
+	return inst, nil
+}
+
+func copyValues(values map[string]any) map[string]any {
+	if values == nil {
+		return nil
+	}
+	ret := make(map[string]any, len(values))
+	for k, v := range values {
+		if m, ok := v.(map[string]any); ok {
+			ret[k] = copyValues(m)
+		} else {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+func getPath(values map[string]any, p string) (any, bool) {
+	parts := strings.Split(p, ".")
+	cur := values
+	for _, k := range parts[:len(parts)-1] {
+		next, ok := cur[k].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		cur = next
+	}
+	v, ok := cur[parts[len(parts)-1]]
+	return v, ok
+}
+
+func deletePath(values map[string]any, p string) {
+	parts := strings.Split(p, ".")
+	cur := values
+	for _, k := range parts[:len(parts)-1] {
+		next, ok := cur[k].(map[string]any)
+		if !ok {
+			return
+		}
+		cur = next
+	}
+	delete(cur, parts[len(parts)-1])
+}
+
+func setPath(values map[string]any, p string, v any) error {
+	parts := strings.Split(p, ".")
+	cur := values
+	for _, k := range parts[:len(parts)-1] {
+		next, ok := cur[k]
+		if !ok {
+			m := map[string]any{}
+			cur[k] = m
+			cur = m
+			continue
+		}
+		m, ok := next.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s is not an object", k)
+		}
+		cur = m
+	}
+	cur[parts[len(parts)-1]] = v
+	return nil
+}
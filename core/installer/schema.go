@@ -19,11 +19,17 @@ const (
 	KindSSHKey           = 6
 	KindNumber           = 4
 	KindArrayString      = 8
+	KindEnum             = 9
+	KindSecret           = 10
+	KindPort             = 11
+	KindDuration         = 12
+	KindArrayStruct      = 13
 )
 
 type Field struct {
-	Name   string
-	Schema Schema
+	Name     string
+	Schema   Schema
+	Required bool
 }
 
 type Schema interface {
@@ -36,17 +42,28 @@ type Schema interface {
 var AuthSchema Schema = structSchema{
 	name: "Auth",
 	fields: []Field{
-		Field{"enabled", basicSchema{"Enabled", KindBoolean, false}},
-		Field{"groups", basicSchema{"Groups", KindString, false}},
+		Field{"enabled", basicSchema{"Enabled", KindBoolean, false}, false},
+		Field{"groups", basicSchema{"Groups", KindString, false}, false},
+		Field{"policies", arraySchema{"Policies", AuthPolicySchema, true}, false},
 	},
 	advanced: false,
 }
 
+var AuthPolicySchema Schema = structSchema{
+	name: "Policy",
+	fields: []Field{
+		Field{"path", basicSchema{"Path", KindString, false}, true},
+		Field{"methods", basicSchema{"Methods", KindArrayString, false}, false},
+		Field{"groups", basicSchema{"Groups", KindArrayString, false}, false},
+	},
+	advanced: true,
+}
+
 var SSHKeySchema Schema = structSchema{
 	name: "SSH Key",
 	fields: []Field{
-		Field{"public", basicSchema{"Public Key", KindString, false}},
-		Field{"private", basicSchema{"Private Key", KindString, false}},
+		Field{"public", basicSchema{"Public Key", KindString, false}, false},
+		Field{"private", basicSchema{"Private Key", KindString, false}, false},
 	},
 	advanced: true,
 }
@@ -58,6 +75,7 @@ const networkSchema = `
 	certificateIssuer: string | *""
 	domain: string
 	allocatePortAddr: string
+	deallocatePortAddr: string
 }
 
 value: { %s }
@@ -82,6 +100,13 @@ const authSchema = `
 #Auth: {
     enabled: bool | false
     groups: string | *""
+    policies: [...#AuthPolicy] | *[]
+}
+
+#AuthPolicy: {
+    path: string
+    methods: [...string] | *[]
+    groups: [...string] | *[]
 }
 
 value: { %s }
@@ -126,6 +151,88 @@ func isSSHKey(v cue.Value) bool {
 	return false
 }
 
+const portSchema = `
+#Port: int & >=1 & <=65535
+
+value: %#v
+`
+
+func isPort(v cue.Value) bool {
+	if v.IncompleteKind() != cue.IntKind {
+		return false
+	}
+	return matchesScalarSchema(portSchema, "#Port", v)
+}
+
+const durationSchema = `
+#Duration: string & =~"^([0-9]+(\\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$"
+
+value: %#v
+`
+
+func isDuration(v cue.Value) bool {
+	if v.IncompleteKind() != cue.StringKind {
+		return false
+	}
+	return matchesScalarSchema(durationSchema, "#Duration", v)
+}
+
+// matchesScalarSchema reports whether v is constrained exactly as the given
+// definition. Default value, if any, is ignored.
+func matchesScalarSchema(schema string, def string, v cue.Value) bool {
+	s := fmt.Sprintf(schema, v)
+	c := cuecontext.New()
+	u := c.CompileString(s)
+	if u.Err() != nil {
+		return false
+	}
+	d := u.LookupPath(cue.ParsePath(def))
+	vv := u.LookupPath(cue.ParsePath("value"))
+	if err := d.Subsume(vv); err != nil {
+		return false
+	}
+	_, hasDefault := vv.Default()
+	return hasDefault || vv.Subsume(d) == nil
+}
+
+// enumValues returns options of the disjunction of concrete strings.
+func enumValues(v cue.Value) ([]string, bool) {
+	op, args := v.Expr()
+	if op != cue.OrOp || len(args) < 2 {
+		return nil, false
+	}
+	ret := make([]string, 0, len(args))
+	seen := map[string]struct{}{}
+	for _, a := range args {
+		if !a.IsConcrete() {
+			return nil, false
+		}
+		s, err := a.String()
+		if err != nil {
+			return nil, false
+		}
+		if _, ok := seen[s]; ok {
+			continue
+		}
+		seen[s] = struct{}{}
+		ret = append(ret, s)
+	}
+	return ret, true
+}
+
+func isSecret(v cue.Value) bool {
+	attr := v.Attribute("role")
+	return attr.Err() == nil && attr.Contents() == "secret"
+}
+
+// secretSchema is the secret with a declared default. New instances still get
+// a generated one, the default is what instances installed before the secret
+// was introduced have been using all along.
+type secretSchema struct {
+	basicSchema
+	legacy string
+}
+
 type basicSchema struct {
 	name     string
 	kind     Kind
@@ -170,6 +277,85 @@ func (s structSchema) Advanced() bool {
 	return s.advanced
 }
 
+// isRequired reports whether value must be provided by the user. Fields with
+// defaults, lists, authentication settings and auto-generated SSH keys and
+// secrets are optional, structs are required only if any of their fields is.
+func isRequired(v cue.Value, schema Schema, optional bool) bool {
+	if optional {
+		return false
+	}
+	if _, ok := v.Default(); ok {
+		return false
+	}
+	switch schema.Kind() {
+	case KindAuth, KindSSHKey, KindSecret, KindArrayStruct:
+		return false
+	case KindStruct:
+		for _, f := range schema.Fields() {
+			if f.Required {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+type enumSchema struct {
+	name     string
+	values   []string
+	advanced bool
+}
+
+func (s enumSchema) Name() string {
+	return s.name
+}
+
+func (s enumSchema) Kind() Kind {
+	return KindEnum
+}
+
+func (s enumSchema) Fields() []Field {
+	return nil
+}
+
+func (s enumSchema) Advanced() bool {
+	return s.advanced
+}
+
+func (s enumSchema) Values() []string {
+	return s.values
+}
+
+// arraySchema describes list of structs, Fields returns fields of the
+// individual items.
+type arraySchema struct {
+	name     string
+	item     Schema
+	advanced bool
+}
+
+func (s arraySchema) Name() string {
+	return s.name
+}
+
+func (s arraySchema) Kind() Kind {
+	return KindArrayStruct
+}
+
+func (s arraySchema) Fields() []Field {
+	return s.item.Fields()
+}
+
+func (s arraySchema) Advanced() bool {
+	return s.advanced
+}
+
+func (s arraySchema) Item() Schema {
+	return s.item
+}
+
 func NewCueSchema(name string, v cue.Value) (Schema, error) {
 	nameAttr := v.Attribute("name")
 	if nameAttr.Err() == nil {
@@ -177,14 +363,37 @@ func NewCueSchema(name string, v cue.Value) (Schema, error) {
 	}
 	switch v.IncompleteKind() {
 	case cue.StringKind:
+		if isSecret(v) {
+			if d, ok := v.Default(); ok {
+				if legacy, err := d.String(); err == nil {
+					return secretSchema{basicSchema{name, KindSecret, false}, legacy}, nil
+				}
+			}
+			return basicSchema{name, KindSecret, false}, nil
+		} else if values, ok := enumValues(v); ok {
+			return enumSchema{name, values, false}, nil
+		} else if isDuration(v) {
+			return basicSchema{name, KindDuration, false}, nil
+		}
 		return basicSchema{name, KindString, false}, nil
 	case cue.BoolKind:
 		return basicSchema{name, KindBoolean, false}, nil
 	case cue.NumberKind:
 		return basicSchema{name, KindNumber, false}, nil
 	case cue.IntKind:
+		if isPort(v) {
+			return basicSchema{name, KindPort, false}, nil
+		}
 		return basicSchema{name, KindInt, false}, nil
 	case cue.ListKind:
+		item := v.LookupPath(cue.MakePath(cue.AnyIndex))
+		if item.Exists() && item.IncompleteKind() == cue.StructKind {
+			scm, err := NewCueSchema(name, item)
+			if err != nil {
+				return nil, err
+			}
+			return arraySchema{name, scm, false}, nil
+		}
 		return basicSchema{name, KindArrayString, false}, nil
 	case cue.StructKind:
 		if isNetwork(v) {
@@ -204,7 +413,7 @@ func NewCueSchema(name string, v cue.Value) (Schema, error) {
 			if err != nil {
 				return nil, err
 			}
-			s.fields = append(s.fields, Field{f.Selector().String(), scm})
+			s.fields = append(s.fields, Field{f.Selector().String(), scm, isRequired(f.Value(), scm, f.IsOptional())})
 		}
 		return s, nil
 	default:
@@ -0,0 +1,269 @@
+package installer
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+const secretLength = 32
+
+// Value secret inputs are replaced with before being passed to the
+// application definition. Actual values never leave the Secret object.
+const secretPlaceholder = "<redacted>"
+
+func generateSecret() (string, error) {
+	b := make([]byte, secretLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Must match secretsName in cueBaseConfig.
+func secretsName(instanceId string) string {
+	return fmt.Sprintf("%s-secrets", instanceId)
+}
+
+func secretKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return fmt.Sprintf("%s.%s", prefix, name)
+}
+
+// arrayItems returns items of the array of structs, values read from JSON
+// and the ones built in code have different types.
+func arrayItems(v any) ([]map[string]any, bool) {
+	switch items := v.(type) {
+	case []map[string]any:
+		return items, true
+	case []any:
+		ret := make([]map[string]any, 0, len(items))
+		for _, i := range items {
+			m, ok := i.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			ret = append(ret, m)
+		}
+		return ret, true
+	default:
+		return nil, false
+	}
+}
+
+// sshKeyValues returns copy of the SSH key pair value, freshly generated keys
+// and the ones read from JSON have different types.
+func sshKeyValues(v any) (map[string]any, bool) {
+	switch key := v.(type) {
+	case map[string]string:
+		ret := make(map[string]any, len(key))
+		for k, v := range key {
+			ret[k] = v
+		}
+		return ret, true
+	case map[string]any:
+		ret := make(map[string]any, len(key))
+		for k, v := range key {
+			ret[k] = v
+		}
+		return ret, true
+	default:
+		return nil, false
+	}
+}
+
+// itemKey returns prefix of the secrets of the i-th array item.
+func itemKey(prefix, name string, i int) string {
+	return secretKey(secretKey(prefix, name), fmt.Sprint(i))
+}
+
+// extractSecrets moves secret fields out of the values into the secrets map,
+// keyed by their dot separated paths, and leaves placeholders in their place.
+func extractSecrets(values map[string]any, schema Schema, prefix string, secrets map[string]string) map[string]any {
+	if values == nil {
+		return nil
+	}
+	ret := make(map[string]any, len(values))
+	for k, v := range values {
+		ret[k] = v
+	}
+	for _, f := range schema.Fields() {
+		switch f.Schema.Kind() {
+		case KindSecret:
+			if v, ok := ret[f.Name].(string); ok {
+				secrets[secretKey(prefix, f.Name)] = v
+				ret[f.Name] = secretPlaceholder
+			}
+		case KindSSHKey:
+			// Private part of the key pair is treated as a secret.
+			if m, ok := sshKeyValues(ret[f.Name]); ok {
+				if v, ok := m["private"].(string); ok {
+					secrets[secretKey(secretKey(prefix, f.Name), "private")] = v
+					m["private"] = secretPlaceholder
+				}
+				ret[f.Name] = m
+			}
+		case KindStruct:
+			if m, ok := ret[f.Name].(map[string]any); ok {
+				ret[f.Name] = extractSecrets(m, f.Schema, secretKey(prefix, f.Name), secrets)
+			}
+		case KindArrayStruct:
+			if items, ok := arrayItems(ret[f.Name]); ok {
+				r := make([]any, 0, len(items))
+				for i, m := range items {
+					r = append(r, extractSecrets(m, f.Schema, itemKey(prefix, f.Name, i), secrets))
+				}
+				ret[f.Name] = r
+			}
+		}
+	}
+	return ret
+}
+
+// withoutSecrets returns copy of the values with all the secret fields removed.
+func withoutSecrets(values map[string]any, schema Schema) map[string]any {
+	if values == nil {
+		return nil
+	}
+	ret := make(map[string]any, len(values))
+	for k, v := range values {
+		ret[k] = v
+	}
+	for _, f := range schema.Fields() {
+		switch f.Schema.Kind() {
+		case KindSecret:
+			delete(ret, f.Name)
+		case KindSSHKey:
+			if m, ok := sshKeyValues(ret[f.Name]); ok {
+				delete(m, "private")
+				ret[f.Name] = m
+			}
+		case KindStruct:
+			if m, ok := ret[f.Name].(map[string]any); ok {
+				ret[f.Name] = withoutSecrets(m, f.Schema)
+			}
+		case KindArrayStruct:
+			if items, ok := arrayItems(ret[f.Name]); ok {
+				r := make([]any, 0, len(items))
+				for _, m := range items {
+					r = append(r, withoutSecrets(m, f.Schema))
+				}
+				ret[f.Name] = r
+			}
+		}
+	}
+	return ret
+}
+
+// keepSecrets fills secret fields missing from values with the ones
+// previously generated, so that updating an instance does not rotate them.
+func keepSecrets(values map[string]any, prev map[string][]byte, schema Schema, prefix string) map[string]any {
+	if len(prev) == 0 {
+		return values
+	}
+	ret := make(map[string]any, len(values))
+	for k, v := range values {
+		ret[k] = v
+	}
+	for _, f := range schema.Fields() {
+		switch f.Schema.Kind() {
+		case KindSecret:
+			if v, ok := ret[f.Name]; ok && v != "" {
+				continue
+			}
+			if p, ok := prev[secretKey(prefix, f.Name)]; ok {
+				ret[f.Name] = string(p)
+			}
+		case KindSSHKey:
+			// Missing key pair is generated from scratch, only the one with
+			// its private part stripped is restored.
+			m, ok := sshKeyValues(ret[f.Name])
+			if !ok {
+				continue
+			}
+			if v, ok := m["private"]; ok && v != "" {
+				continue
+			}
+			if p, ok := prev[secretKey(secretKey(prefix, f.Name), "private")]; ok {
+				m["private"] = string(p)
+				ret[f.Name] = m
+			}
+		case KindStruct:
+			m, ok := ret[f.Name].(map[string]any)
+			if !ok {
+				m = map[string]any{}
+			}
+			m = keepSecrets(m, prev, f.Schema, secretKey(prefix, f.Name))
+			if len(m) > 0 {
+				ret[f.Name] = m
+			}
+		case KindArrayStruct:
+			// Only secrets of the items still present are kept, removed
+			// items are not resurrected.
+			if items, ok := arrayItems(ret[f.Name]); ok {
+				r := make([]any, 0, len(items))
+				for i, m := range items {
+					r = append(r, keepSecrets(m, prev, f.Schema, itemKey(prefix, f.Name, i)))
+				}
+				ret[f.Name] = r
+			}
+		}
+	}
+	return ret
+}
+
+// committedSecrets returns secrets of the instance installed before they were
+// moved out of its values, committed to the config repository as is.
+func committedSecrets(config AppInstanceConfig, schema Schema) map[string][]byte {
+	secrets := map[string]string{}
+	extractSecrets(config.Input, schema, "", secrets)
+	extractSecrets(config.Values, schema, "", secrets)
+	ret := make(map[string][]byte, len(secrets))
+	for k, v := range secrets {
+		if v != "" && v != secretPlaceholder {
+			ret[k] = []byte(v)
+		}
+	}
+	return ret
+}
+
+// legacySecrets fills secrets missing from the existing instance with the
+// defaults they declare.
+func legacySecrets(secrets map[string][]byte, schema Schema, prefix string) {
+	for _, f := range schema.Fields() {
+		switch s := f.Schema.(type) {
+		case secretSchema:
+			if _, ok := secrets[secretKey(prefix, f.Name)]; !ok {
+				secrets[secretKey(prefix, f.Name)] = []byte(s.legacy)
+			}
+		default:
+			if s.Kind() == KindStruct {
+				legacySecrets(secrets, s, secretKey(prefix, f.Name))
+			}
+		}
+	}
+}
+
+func hasSecrets(schema Schema) bool {
+	for _, f := range schema.Fields() {
+		switch f.Schema.Kind() {
+		case KindSecret, KindSSHKey:
+			return true
+		case KindStruct, KindArrayStruct:
+			if hasSecrets(f.Schema) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithoutSecrets returns copy of the instance config which is safe to be
+// shown to the user.
+func (a AppInstanceConfig) WithoutSecrets(schema Schema) AppInstanceConfig {
+	a.Values = withoutSecrets(a.Values, schema)
+	a.Input = withoutSecrets(a.Input, schema)
+	return a
+}
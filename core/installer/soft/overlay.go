@@ -0,0 +1,241 @@
+package soft
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileChange describes how single file would be modified.
+// Nil Old means file is created, nil New means file is deleted.
+type FileChange struct {
+	Path string
+	Old  []byte
+	New  []byte
+}
+
+// OverlayFS records all the modifications in memory on top of the
+// underlying read-only file system. Used to preview changes without
+// touching the repository.
+type OverlayFS struct {
+	base    RepoFS
+	files   map[string][]byte
+	removed map[string]struct{}
+}
+
+func NewOverlayFS(base RepoFS) *OverlayFS {
+	return &OverlayFS{
+		base,
+		make(map[string][]byte),
+		make(map[string]struct{}),
+	}
+}
+
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+func isUnder(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+func (o *OverlayFS) isRemoved(p string) bool {
+	for dir := range o.removed {
+		if isUnder(p, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *OverlayFS) Reader(p string) (io.ReadCloser, error) {
+	p = cleanPath(p)
+	if contents, ok := o.files[p]; ok {
+		return io.NopCloser(bytes.NewReader(contents)), nil
+	}
+	if o.isRemoved(p) {
+		return nil, fs.ErrNotExist
+	}
+	return o.base.Reader(p)
+}
+
+type overlayWriter struct {
+	o    *OverlayFS
+	path string
+}
+
+func (w overlayWriter) Write(b []byte) (int, error) {
+	w.o.files[w.path] = append(w.o.files[w.path], b...)
+	return len(b), nil
+}
+
+func (w overlayWriter) Close() error {
+	return nil
+}
+
+func (o *OverlayFS) Writer(p string) (io.WriteCloser, error) {
+	p = cleanPath(p)
+	o.files[p] = []byte{}
+	return overlayWriter{o, p}, nil
+}
+
+func (o *OverlayFS) CreateDir(p string) error {
+	return nil
+}
+
+func (o *OverlayFS) RemoveDir(p string) error {
+	p = cleanPath(p)
+	for f := range o.files {
+		if isUnder(f, p) {
+			delete(o.files, f)
+		}
+	}
+	o.removed[p] = struct{}{}
+	return nil
+}
+
+type overlayFileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (i overlayFileInfo) Name() string {
+	return i.name
+}
+
+func (i overlayFileInfo) Size() int64 {
+	return i.size
+}
+
+func (i overlayFileInfo) Mode() fs.FileMode {
+	if i.isDir {
+		return fs.ModeDir | fs.ModePerm
+	}
+	return fs.ModePerm
+}
+
+func (i overlayFileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (i overlayFileInfo) IsDir() bool {
+	return i.isDir
+}
+
+func (i overlayFileInfo) Sys() any {
+	return nil
+}
+
+func (o *OverlayFS) ListDir(p string) ([]fs.FileInfo, error) {
+	p = cleanPath(p)
+	entries := make(map[string]fs.FileInfo)
+	if !o.isRemoved(p) {
+		items, err := o.base.ListDir(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, i := range items {
+			if !o.isRemoved(path.Join(p, i.Name())) {
+				entries[i.Name()] = i
+			}
+		}
+	}
+	for f, contents := range o.files {
+		if f == p || !isUnder(f, p) {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(f, p), "/")
+		name, _, isDir := strings.Cut(rel, "/")
+		entries[name] = overlayFileInfo{name, int64(len(contents)), isDir}
+	}
+	if len(entries) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	ret := make([]fs.FileInfo, 0, len(entries))
+	for _, i := range entries {
+		ret = append(ret, i)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name() < ret[j].Name()
+	})
+	return ret, nil
+}
+
+// Changes returns modifications recorded so far, sorted by file path.
+func (o *OverlayFS) Changes() ([]FileChange, error) {
+	ret := make([]FileChange, 0)
+	deleted := make(map[string]struct{})
+	for dir := range o.removed {
+		files, err := listFiles(o.base, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if _, ok := o.files[f]; ok {
+				continue
+			}
+			if _, ok := deleted[f]; ok {
+				continue
+			}
+			deleted[f] = struct{}{}
+			old, err := readFile(o.base, f)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, FileChange{f, old, nil})
+		}
+	}
+	for f, contents := range o.files {
+		old, err := readFile(o.base, f)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if bytes.Equal(old, contents) && old != nil {
+			continue
+		}
+		ret = append(ret, FileChange{f, old, contents})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Path < ret[j].Path
+	})
+	return ret, nil
+}
+
+func readFile(repo RepoFS, p string) ([]byte, error) {
+	r, err := repo.Reader(p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func listFiles(repo RepoFS, dir string) ([]string, error) {
+	items, err := repo.ListDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ret := make([]string, 0)
+	for _, i := range items {
+		p := path.Join(dir, i.Name())
+		if i.IsDir() {
+			sub, err := listFiles(repo, p)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, sub...)
+		} else {
+			ret = append(ret, p)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,83 @@
+package soft
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+func writeFile(t *testing.T, repo RepoFS, p, contents string) {
+	w, err := repo.Writer(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOverlayFS(t *testing.T) {
+	base := NewBillyRepoFS(memfs.New())
+	writeFile(t, base, "/apps/a/config.json", "a")
+	writeFile(t, base, "/apps/b/config.json", "b")
+	o := NewOverlayFS(base)
+	writeFile(t, o, "/apps/a/config.json", "a2")
+	writeFile(t, o, "apps/c/config.json", "c")
+	if err := o.RemoveDir("/apps/b"); err != nil {
+		t.Fatal(err)
+	}
+	for p, expected := range map[string]string{
+		"/apps/a/config.json": "a2",
+		"/apps/c/config.json": "c",
+	} {
+		if got, err := readFile(o, p); err != nil {
+			t.Fatal(err)
+		} else if string(got) != expected {
+			t.Fatalf("Expected %s to contain %s, got %s", p, expected, got)
+		}
+	}
+	if _, err := o.Reader("/apps/b/config.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected removed file to not exist, got %v", err)
+	}
+	items, err := o.ListDir("/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Name() != "a" || items[1].Name() != "c" || !items[1].IsDir() {
+		t.Fatalf("Unexpected directory listing: %+v", items)
+	}
+	changes, err := o.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []FileChange{
+		{"/apps/a/config.json", []byte("a"), []byte("a2")},
+		{"/apps/b/config.json", []byte("b"), nil},
+		{"/apps/c/config.json", nil, []byte("c")},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Unexpected changes: %+v", changes)
+	}
+	for i, c := range changes {
+		e := expected[i]
+		if c.Path != e.Path || string(c.Old) != string(e.Old) || (c.Old == nil) != (e.Old == nil) || string(c.New) != string(e.New) || (c.New == nil) != (e.New == nil) {
+			t.Fatalf("Expected %+v, got %+v", e, c)
+		}
+	}
+	for p, expected := range map[string]string{
+		"/apps/a/config.json": "a",
+		"/apps/b/config.json": "b",
+	} {
+		if got, err := readFile(base, p); err != nil {
+			t.Fatal(err)
+		} else if string(got) != expected {
+			t.Fatalf("Expected underlying %s to be left untouched, got %s", p, got)
+		}
+	}
+	if _, err := base.Reader("/apps/c/config.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected file to not be created in the underlying repository, got %v", err)
+	}
+}
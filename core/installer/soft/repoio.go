@@ -3,6 +3,7 @@ package soft
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
@@ -16,8 +17,10 @@ import (
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
 	"golang.org/x/crypto/ssh"
 	"sigs.k8s.io/yaml"
 )
@@ -27,12 +30,14 @@ type RepoFS interface {
 	Writer(path string) (io.WriteCloser, error)
 	CreateDir(path string) error
 	RemoveDir(path string) error
+	ListDir(path string) ([]fs.FileInfo, error)
 }
 
 type DoFn func(r RepoFS) (string, error)
 
 type doOptions struct {
-	NoCommit bool
+	NoCommit   bool
+	CommitHash *string
 }
 
 type DoOption func(*doOptions)
@@ -43,12 +48,20 @@ func WithNoCommit() DoOption {
 	}
 }
 
+// WithCommitHash stores hash of the commit created by Do into the given string.
+func WithCommitHash(hash *string) DoOption {
+	return func(o *doOptions) {
+		o.CommitHash = hash
+	}
+}
+
 type RepoIO interface {
 	RepoFS
 	FullAddress() string
 	Pull() error
 	CommitAndPush(message string) error
 	Do(op DoFn, opts ...DoOption) error
+	Revert(commit string, message string) error
 }
 
 type repoFS struct {
@@ -84,6 +97,10 @@ func (r *repoFS) RemoveDir(path string) error {
 	return nil
 }
 
+func (r *repoFS) ListDir(path string) ([]fs.FileInfo, error) {
+	return r.fs.ReadDir(path)
+}
+
 type repoIO struct {
 	*repoFS
 	repo   *Repository
@@ -134,24 +151,61 @@ func (r *repoIO) pullWithoutLock() error {
 }
 
 func (r *repoIO) CommitAndPush(message string) error {
+	_, err := r.commitAndPush(message)
+	return err
+}
+
+func (r *repoIO) commitAndPush(message string) (string, error) {
 	wt, err := r.repo.Worktree()
 	if err != nil {
-		return err
+		return "", err
 	}
 	if err := wt.AddGlob("*"); err != nil {
-		return err
+		return "", err
 	}
-	if _, err := wt.Commit(message, &git.CommitOptions{
+	hash, err := wt.Commit(message, &git.CommitOptions{
 		Author: &object.Signature{
 			Name: "pcloud-installer",
 			When: time.Now(),
 		},
-	}); err != nil {
-		return err
+	})
+	if err != nil {
+		return "", err
 	}
-	return r.repo.Push(&git.PushOptions{
+	if err := r.repo.Push(&git.PushOptions{
 		RemoteName: "origin",
 		Auth:       auth(r.signer),
+	}); err != nil {
+		// Drops the local commit, otherwise it would be pushed together
+		// with the next unrelated change.
+		if rerr := r.resetToRemote(wt, hash); rerr != nil {
+			return "", errors.Join(err, rerr)
+		}
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+// resetToRemote moves the worktree back to the remote head, or to the parent
+// of the given commit if remote branch is not known yet.
+func (r *repoIO) resetToRemote(wt *git.Worktree, commit plumbing.Hash) error {
+	head, err := r.repo.Head()
+	if err != nil {
+		return err
+	}
+	to := plumbing.ZeroHash
+	if remote, err := r.repo.Reference(plumbing.NewRemoteReferenceName("origin", head.Name().Short()), true); err == nil {
+		to = remote.Hash()
+	} else if c, err := r.repo.CommitObject(commit); err != nil {
+		return err
+	} else if len(c.ParentHashes) > 0 {
+		to = c.ParentHashes[0]
+	} else {
+		return fmt.Errorf("Can not reset initial commit %s", commit)
+	}
+	return wt.Reset(&git.ResetOptions{
+		Commit: to,
+		Mode:   git.HardReset,
 	})
 }
 
@@ -169,12 +223,80 @@ func (r *repoIO) Do(op DoFn, opts ...DoOption) error {
 		return err
 	} else {
 		if !o.NoCommit {
-			return r.CommitAndPush(msg)
+			hash, err := r.commitAndPush(msg)
+			if err != nil {
+				return err
+			}
+			if o.CommitHash != nil {
+				*o.CommitHash = hash
+			}
 		}
 	}
 	return nil
 }
 
+// Revert creates new commit undoing all the changes introduced by the given one.
+// Changes made by commits created on top of it are kept as is.
+func (r *repoIO) Revert(commit string, message string) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if err := r.pullWithoutLock(); err != nil {
+		return err
+	}
+	c, err := r.repo.CommitObject(plumbing.NewHash(commit))
+	if err != nil {
+		return err
+	}
+	parent, err := c.Parent(0)
+	if err != nil {
+		return err
+	}
+	from, err := parent.Tree()
+	if err != nil {
+		return err
+	}
+	to, err := c.Tree()
+	if err != nil {
+		return err
+	}
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			return err
+		}
+		switch action {
+		case merkletrie.Insert:
+			if err := r.fs.Remove(change.To.Name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		case merkletrie.Delete, merkletrie.Modify:
+			f, err := from.File(change.From.Name)
+			if err != nil {
+				return err
+			}
+			contents, err := f.Contents()
+			if err != nil {
+				return err
+			}
+			w, err := r.Writer(change.From.Name)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, contents)
+			w.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = r.commitAndPush(message)
+	return err
+}
+
 func auth(signer ssh.Signer) *gitssh.PublicKeys {
 	return &gitssh.PublicKeys{
 		Signer: signer,
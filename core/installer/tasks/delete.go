@@ -0,0 +1,235 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/giolekva/pcloud/core/installer"
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+const namespaceDeletionTimeout = 10 * time.Minute
+
+var ErrUnknownEnv = errors.New("unknown environment")
+
+// ValidateEnvDeletion makes sure that envId refers to the environment
+// created by env manager: it either holds CIDR lease or its creation
+// progress has been recorded. As steps of the deletion tolerate missing
+// artifacts, deleting non existent environment would otherwise succeed
+// while wiping everything matching its name.
+func ValidateEnvDeletion(envId string, infra installer.InfraConfig, ipam *installer.IPAM, progress ProgressStore) error {
+	if envId == "" || envId == infra.Name ||
+		(infra.InfraNamespacePrefix != "" && strings.HasPrefix(envId+"-", infra.InfraNamespacePrefix)) {
+		return fmt.Errorf("%w: %s is the infrastructure", ErrUnknownEnv, envId)
+	}
+	if _, ok, err := ipam.Lease(envId); err != nil {
+		return err
+	} else if ok {
+		return nil
+	}
+	states, err := progress.List()
+	if err != nil {
+		return err
+	}
+	for _, st := range states {
+		if st.Env.Id == envId {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownEnv, envId)
+}
+
+// NewDeleteEnvTask tears down environment created by NewCreateEnvTask.
+// Every step tolerates its artifacts being already gone, so task can be
+// run again to finish partially completed deletion. Callers must check
+// environment with ValidateEnvDeletion first.
+func NewDeleteEnvTask(
+	envId string,
+	infra installer.InfraConfig,
+	repo soft.RepoIO,
+	mgr *installer.InfraAppManager,
+	nsCreator installer.NamespaceCreator,
+	issuers installer.ClusterIssuerDeleter,
+) Task {
+	return newSequentialParentTask(
+		fmt.Sprintf("Delete env %s", envId),
+		true,
+		RemoveEnvDNSServer(envId, repo, mgr),
+		RemoveEnvConfiguration(envId, repo),
+		DeleteCertificateIssuers(envId, issuers),
+		DeleteEnvNamespaces(envId, infra, nsCreator),
+		ReleaseEnvCIDR(envId, repo),
+	)
+}
+
+func RemoveEnvDNSServer(envId string, repo soft.RepoIO, mgr *installer.InfraAppManager) Task {
+	t := newLeafTask("Remove DNS server from gateway", func() error {
+		subnet, ok, err := installer.NewIPAM(repo, nil).Lease(envId)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// CIDR is released last, so gateway has already been updated.
+			return nil
+		}
+		network, err := installer.NewEnvNetwork(net.ParseIP(subnet.Addr().String()))
+		if err != nil {
+			return err
+		}
+		cfg, err := mgr.FindInstance("dns-gateway")
+		if err != nil {
+			return err
+		}
+		serversJSON, ok := cfg.Values["servers"]
+		if !ok {
+			return nil
+		}
+		serversTmp, err := json.Marshal(serversJSON)
+		if err != nil {
+			return err
+		}
+		servers := []installer.EnvDNS{}
+		if err := json.Unmarshal(serversTmp, &servers); err != nil {
+			return err
+		}
+		keep := make([]installer.EnvDNS, 0, len(servers))
+		for _, s := range servers {
+			if s.Address != network.DNSInClusterIP.String() {
+				keep = append(keep, s)
+			}
+		}
+		if len(keep) == len(servers) {
+			return nil
+		}
+		app, err := installer.FindInfraApp(installer.NewInMemoryAppRepository(installer.CreateAllApps()), "dns-gateway")
+		if err != nil {
+			return err
+		}
+		_, err = mgr.Update(app, "dns-gateway", map[string]any{
+			"servers": keep,
+		})
+		return err
+	})
+	return &t
+}
+
+// RemoveEnvConfiguration removes environment from the infrastructure
+// repository, after which Flux prunes its Git server together with all the
+// resources it manages.
+func RemoveEnvConfiguration(envId string, repo soft.RepoIO) Task {
+	t := newLeafTask("Remove GitOps configuration", func() error {
+		if err := repo.Pull(); err != nil {
+			return err
+		}
+		kust, err := soft.ReadKustomization(repo, "environments/kustomization.yaml")
+		if err != nil {
+			return err
+		}
+		_, err = repo.ListDir(filepath.Join("environments", envId))
+		if !contains(kust.Resources, envId) && err != nil {
+			return nil
+		}
+		return repo.Do(func(r soft.RepoFS) (string, error) {
+			kust, err := soft.ReadKustomization(r, "environments/kustomization.yaml")
+			if err != nil {
+				return "", err
+			}
+			kust.RemoveResources(envId)
+			if err := soft.WriteYaml(r, "environments/kustomization.yaml", kust); err != nil {
+				return "", err
+			}
+			if err := r.RemoveDir(filepath.Join("environments", envId)); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s: remove environment", envId), nil
+		})
+	})
+	return &t
+}
+
+func DeleteCertificateIssuers(envId string, issuers installer.ClusterIssuerDeleter) Task {
+	t := newLeafTask("Delete TLS certificate issuers", func() error {
+		for _, name := range []string{fmt.Sprintf("%s-public", envId), fmt.Sprintf("%s-private", envId)} {
+			if err := issuers.Delete(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return &t
+}
+
+// envNamespaces returns namespaces of the environment, never including ones
+// of the infrastructure even if they share the prefix.
+func envNamespaces(envId string, infra installer.InfraConfig, nsCreator installer.NamespaceCreator) ([]string, error) {
+	all, err := nsCreator.List()
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, ns := range all {
+		if ns == infra.Name || (infra.InfraNamespacePrefix != "" && strings.HasPrefix(ns, infra.InfraNamespacePrefix)) {
+			continue
+		}
+		if ns == envId || strings.HasPrefix(ns, fmt.Sprintf("%s-", envId)) {
+			ret = append(ret, ns)
+		}
+	}
+	return ret, nil
+}
+
+func DeleteEnvNamespaces(envId string, infra installer.InfraConfig, nsCreator installer.NamespaceCreator) Task {
+	t := newLeafTask("Delete namespaces", func() error {
+		namespaces, err := envNamespaces(envId, infra, nsCreator)
+		if err != nil {
+			return err
+		}
+		for _, ns := range namespaces {
+			if err := nsCreator.Delete(ns); err != nil {
+				return err
+			}
+		}
+		// Wait for namespaces to terminate so that addresses they hold are
+		// not handed out to the new environment too early.
+		deadline := time.Now().Add(namespaceDeletionTimeout)
+		for {
+			remaining := []string{}
+			for _, ns := range namespaces {
+				if exists, err := nsCreator.Exists(ns); err != nil {
+					return err
+				} else if exists {
+					remaining = append(remaining, ns)
+				}
+			}
+			if len(remaining) == 0 {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("Timed out waiting for namespaces to be deleted: %s", strings.Join(remaining, ", "))
+			}
+			time.Sleep(5 * time.Second)
+		}
+	})
+	return &t
+}
+
+func ReleaseEnvCIDR(envId string, repo soft.RepoIO) Task {
+	t := newLeafTask("Release CIDR", func() error {
+		return installer.NewIPAM(repo, nil).Release(envId)
+	})
+	return &t
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
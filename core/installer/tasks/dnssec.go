@@ -0,0 +1,206 @@
+package tasks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/giolekva/pcloud/core/installer"
+	pdns "github.com/giolekva/pcloud/core/installer/dns"
+	phttp "github.com/giolekva/pcloud/core/installer/http"
+)
+
+// Parent zone might take days to publish DS record of the new key, as it
+// is often done manually through the registrar.
+const dsPublishTimeout = 7 * 24 * time.Hour
+
+const kskOutput = "ksk"
+
+var ErrKSKRolloverAborted = errors.New("KSK rollover was aborted")
+
+type dnsSecKey struct {
+	KeyTag uint16 `json:"keyTag"`
+	DS     string `json:"ds"`
+	// Deadline for the parent zone to publish DS record of the key.
+	Deadline time.Time `json:"deadline"`
+}
+
+type dnsSecKeyInfo struct {
+	Role   string `json:"role"`
+	State  string `json:"state"`
+	KeyTag uint16 `json:"keyTag"`
+}
+
+// NewKSKRolloverTask replaces key signing key of the zone served by
+// dns-api. New key is introduced alongside the current one and its DS
+// record is published through /records-to-publish. Old key is retired only
+// once parent zone serves DS record of the new one. New key is recorded in
+// the progress, so that the task can be resumed after restart.
+func NewKSKRolloverTask(zone, dnsAPIAddr string, httpClient phttp.Client, dnsClient pdns.Client, progress *Progress) Task {
+	if progress == nil {
+		progress = NewKSKRolloverProgress(nil, zone, installer.EnvConfig{Domain: zone})
+	}
+	var key dnsSecKey
+	t := newSequentialParentTask(
+		fmt.Sprintf("Roll over KSK of %s", zone),
+		true,
+		GenerateKSK(dnsAPIAddr, httpClient, progress, &key),
+		WaitForDS(zone, dnsAPIAddr, httpClient, dnsClient, &key),
+		RetireKSK(dnsAPIAddr, httpClient, &key),
+	)
+	track(t, rootTaskId, progress)
+	return t
+}
+
+func postJSON(client phttp.Client, addr string, req, resp any) error {
+	var body bytes.Buffer
+	if req != nil {
+		if err := json.NewEncoder(&body).Encode(req); err != nil {
+			return err
+		}
+	}
+	r, err := client.Post(addr, "application/json", &body)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode >= 300 {
+		msg, _ := io.ReadAll(r.Body)
+		return fmt.Errorf("%s: %s %s", addr, r.Status, strings.TrimSpace(string(msg)))
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+func getJSON(client phttp.Client, addr string, resp any) error {
+	r, err := client.Get(addr)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode >= 300 {
+		msg, _ := io.ReadAll(r.Body)
+		return fmt.Errorf("%s: %s %s", addr, r.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+func GenerateKSK(dnsAPIAddr string, client phttp.Client, progress *Progress, key *dnsSecKey) Task {
+	t := newLeafTask("Generate new key signing key", func() error {
+		if err := postJSON(client, fmt.Sprintf("%s/dnssec/ksk/rollover", dnsAPIAddr), nil, key); err != nil {
+			return err
+		}
+		key.Deadline = time.Now().Add(dsPublishTimeout)
+		k, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		progress.SetOutput(kskOutput, k)
+		return nil
+	})
+	t.restore = func() error {
+		k, err := progress.Output(kskOutput)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(k, key)
+	}
+	return &t
+}
+
+func pendingKSKs(dnsAPIAddr string, client phttp.Client) ([]uint16, error) {
+	var keys []dnsSecKeyInfo
+	if err := getJSON(client, fmt.Sprintf("%s/dnssec/keys", dnsAPIAddr), &keys); err != nil {
+		return nil, err
+	}
+	ret := []uint16{}
+	for _, k := range keys {
+		if k.Role == "ksk" && k.State == "pending" {
+			ret = append(ret, k.KeyTag)
+		}
+	}
+	return ret, nil
+}
+
+// AbortKSKRollover drops pending key signing keys of the zone served by
+// dns-api. Rollover task waiting for their DS records fails afterwards.
+func AbortKSKRollover(dnsAPIAddr string, client phttp.Client) error {
+	pending, err := pendingKSKs(dnsAPIAddr, client)
+	if err != nil {
+		return err
+	}
+	for _, keyTag := range pending {
+		if err := postJSON(client, fmt.Sprintf("%s/dnssec/ksk/abort", dnsAPIAddr), map[string]any{
+			"keyTag": keyTag,
+		}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameDS compares DS records ignoring their owner names and TTLs.
+func sameDS(a, b string) bool {
+	x, err := dns.NewRR(a)
+	if err != nil {
+		return false
+	}
+	y, err := dns.NewRR(b)
+	if err != nil {
+		return false
+	}
+	xds, ok := x.(*dns.DS)
+	if !ok {
+		return false
+	}
+	yds, ok := y.(*dns.DS)
+	if !ok {
+		return false
+	}
+	return xds.KeyTag == yds.KeyTag &&
+		xds.Algorithm == yds.Algorithm &&
+		xds.DigestType == yds.DigestType &&
+		strings.EqualFold(xds.Digest, yds.Digest)
+}
+
+func WaitForDS(zone, dnsAPIAddr string, httpClient phttp.Client, client pdns.Client, key *dnsSecKey) Task {
+	t := newLeafTask("Wait for parent zone to publish DS record", func() error {
+		for {
+			records, err := client.LookupDS(zone)
+			if err == nil {
+				for _, r := range records {
+					if sameDS(r, key.DS) {
+						return nil
+					}
+				}
+			}
+			if pending, err := pendingKSKs(dnsAPIAddr, httpClient); err == nil && !slices.Contains(pending, key.KeyTag) {
+				return fmt.Errorf("%w: key %d is not pending anymore", ErrKSKRolloverAborted, key.KeyTag)
+			}
+			remaining := time.Until(key.Deadline)
+			if remaining <= 0 {
+				return fmt.Errorf("DS record of key %d was not published by %s", key.KeyTag, key.Deadline.Format(time.RFC3339))
+			}
+			time.Sleep(min(time.Minute, remaining))
+		}
+	})
+	return &t
+}
+
+func RetireKSK(dnsAPIAddr string, client phttp.Client, key *dnsSecKey) Task {
+	t := newLeafTask("Retire previous key signing key", func() error {
+		return postJSON(client, fmt.Sprintf("%s/dnssec/ksk/complete", dnsAPIAddr), map[string]any{
+			"keyTag": key.KeyTag,
+		}, nil)
+	})
+	return &t
+}
@@ -0,0 +1,163 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testDS = "foo.bar.ge.\t3600\tIN\tDS\t12345 13 2 ABCDEF0123"
+
+type fakeDNSAPI struct {
+	lock     sync.Mutex
+	requests []string
+	keyTag   uint16
+	aborted  bool
+}
+
+func (f *fakeDNSAPI) Get(addr string) (*http.Response, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !strings.HasSuffix(addr, "/dnssec/keys") {
+		return nil, io.EOF
+	}
+	resp := `[{"role": "ksk", "state": "active", "keyTag": 1}]`
+	if !f.aborted {
+		resp = `[{"role": "ksk", "state": "active", "keyTag": 1}, {"role": "ksk", "state": "pending", "keyTag": 12345}]`
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(resp)),
+	}, nil
+}
+
+func (f *fakeDNSAPI) Post(addr, contentType string, body io.Reader) (*http.Response, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.requests = append(f.requests, addr)
+	resp := "{}"
+	switch {
+	case strings.HasSuffix(addr, "/dnssec/ksk/rollover"):
+		resp = `{"keyTag": 12345, "ds": "` + strings.ReplaceAll(testDS, "\t", " ") + `"}`
+	case strings.HasSuffix(addr, "/dnssec/ksk/abort"):
+		f.aborted = true
+	case strings.HasSuffix(addr, "/dnssec/ksk/complete"):
+		var req struct {
+			KeyTag uint16 `json:"keyTag"`
+		}
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			return nil, err
+		}
+		f.keyTag = req.KeyTag
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(resp)),
+	}, nil
+}
+
+type fakeParentZone struct {
+	unpublished bool
+}
+
+func (f fakeParentZone) Lookup(host string) ([]net.IP, error) {
+	return nil, nil
+}
+
+func (f fakeParentZone) LookupDS(zone string) ([]string, error) {
+	if f.unpublished {
+		return nil, nil
+	}
+	// Digest is case insensitive.
+	return []string{strings.ToLower(testDS)}, nil
+}
+
+func TestKSKRollover(t *testing.T) {
+	api := &fakeDNSAPI{}
+	task := NewKSKRolloverTask("foo.bar.ge", "http://dns-api", api, fakeParentZone{}, nil)
+	done := make(chan error)
+	task.OnDone(func(err error) {
+		done <- err
+	})
+	go task.Start()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"http://dns-api/dnssec/ksk/rollover", "http://dns-api/dnssec/ksk/complete"}
+	if len(api.requests) != 2 || api.requests[0] != expected[0] || api.requests[1] != expected[1] {
+		t.Fatalf("Expected %v, got %v", expected, api.requests)
+	}
+	if api.keyTag != 12345 {
+		t.Fatalf("Expected new key to be activated, got %d", api.keyTag)
+	}
+}
+
+func TestKSKRolloverResume(t *testing.T) {
+	api := &fakeDNSAPI{}
+	p := ResumeProgress(nil, ProgressState{
+		Key:  "rollover",
+		Kind: ProgressKindKSKRollover,
+		Tasks: map[string]TaskState{
+			"0.0": {Status: StatusDone},
+		},
+		Outputs: map[string][]byte{
+			kskOutput: []byte(`{"keyTag": 12345, "ds": "` + strings.ReplaceAll(testDS, "\t", " ") + `"}`),
+		},
+	})
+	task := NewKSKRolloverTask("foo.bar.ge", "http://dns-api", api, fakeParentZone{}, p)
+	done := make(chan error)
+	task.OnDone(func(err error) {
+		done <- err
+	})
+	go task.Start()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(api.requests) != 1 || api.keyTag != 12345 {
+		t.Fatalf("Expected only recorded key to be activated, got %v", api.requests)
+	}
+}
+
+func TestWaitForDSDeadline(t *testing.T) {
+	key := dnsSecKey{KeyTag: 12345, Deadline: time.Now().Add(10 * time.Millisecond)}
+	task := WaitForDS("foo.bar.ge", "http://dns-api", &fakeDNSAPI{}, fakeParentZone{unpublished: true}, &key)
+	done := make(chan error)
+	task.OnDone(func(err error) {
+		done <- err
+	})
+	go task.Start()
+	select {
+	case err := <-done:
+		if err == nil || errors.Is(err, ErrKSKRolloverAborted) {
+			t.Fatalf("Expected deadline to pass, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected waiting for DS record to time out")
+	}
+}
+
+func TestAbortKSKRollover(t *testing.T) {
+	api := &fakeDNSAPI{}
+	if err := AbortKSKRollover("http://dns-api", api); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.requests) != 1 || api.requests[0] != "http://dns-api/dnssec/ksk/abort" {
+		t.Fatalf("Expected pending key to be aborted, got %v", api.requests)
+	}
+	key := dnsSecKey{KeyTag: 12345, Deadline: time.Now().Add(time.Hour)}
+	task := WaitForDS("foo.bar.ge", "http://dns-api", api, fakeParentZone{unpublished: true}, &key)
+	done := make(chan error)
+	task.OnDone(func(err error) {
+		done <- err
+	})
+	go task.Start()
+	if err := <-done; !errors.Is(err, ErrKSKRolloverAborted) {
+		t.Fatalf("Expected rollover to be aborted, got %v", err)
+	}
+}
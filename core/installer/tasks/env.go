@@ -27,6 +27,7 @@ type state struct {
 	appManager      *installer.AppManager
 	appsRepo        installer.AppRepository
 	infraAppManager *installer.InfraAppManager
+	progress        *Progress
 }
 
 type EnvInfoListener func(string)
@@ -41,7 +42,11 @@ func NewCreateEnvTask(
 	repoClient soft.ClientGetter,
 	mgr *installer.InfraAppManager,
 	infoListener EnvInfoListener,
+	progress *Progress,
 ) (Task, installer.EnvDNS) {
+	if progress == nil {
+		progress = NewProgress(nil, env.Id, env)
+	}
 	st := state{
 		infoListener:    infoListener,
 		nsCreator:       nsCreator,
@@ -51,6 +56,7 @@ func NewCreateEnvTask(
 		repo:            repo,
 		repoClient:      repoClient,
 		infraAppManager: mgr,
+		progress:        progress,
 	}
 	t := newSequentialParentTask(
 		"Create env",
@@ -74,8 +80,13 @@ func NewCreateEnvTask(
 		"http://fluxcd-reconciler.dodo-fluxcd-reconciler.svc.cluster.local",
 		env.Id,
 	)
-	go pr.Reconcile(rctx)
-	go er.Reconcile(rctx)
+	// Reconcilers are started only once task starts, as failed task tree
+	// restored after restart is not started until retried.
+	t.beforeStart = func() {
+		go pr.Reconcile(rctx)
+		go er.Reconcile(rctx)
+	}
+	track(t, rootTaskId, progress)
 	return t, installer.EnvDNS{
 		Zone:    env.Domain,
 		Address: fmt.Sprintf("http://dns-api.%sdns.svc.cluster.local/records-to-publish", env.NamespacePrefix),
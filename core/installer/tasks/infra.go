@@ -18,7 +18,7 @@ func CreateRepoClient(env installer.EnvConfig, st *state) Task {
 		if err != nil {
 			return err
 		}
-		appManager, err := installer.NewAppManager(r, st.nsCreator, "/apps")
+		appManager, err := installer.NewAppManager(r, st.nsCreator, nil, "/apps")
 		if err != nil {
 			return err
 		}
@@ -29,6 +29,8 @@ func CreateRepoClient(env installer.EnvConfig, st *state) Task {
 	t.beforeStart = func() {
 		st.infoListener("Setting up core infrastructure services.")
 	}
+	// Only creates clients, so is safe to run again.
+	t.rerun = true
 	return &t
 }
 
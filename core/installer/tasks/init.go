@@ -5,6 +5,8 @@ import (
 	"log"
 	"path/filepath"
 
+	"github.com/charmbracelet/keygen"
+
 	"github.com/giolekva/pcloud/core/installer"
 	"github.com/giolekva/pcloud/core/installer/io"
 	"github.com/giolekva/pcloud/core/installer/soft"
@@ -48,6 +50,7 @@ func NewCreateConfigRepoTask(env installer.EnvConfig, st *state) Task {
 			return err
 		}
 		st.ssAdminKeys = adminKeys
+		st.progress.SetOutput(configRepoAdminKeyOutput, adminKeys.RawPrivateKey())
 		keys, err := installer.NewSSHKeyPair(fmt.Sprintf("%s-config-repo-keys", env.Id))
 		if err != nil {
 			return err
@@ -60,15 +63,26 @@ func NewCreateConfigRepoTask(env installer.EnvConfig, st *state) Task {
 		})
 		return err
 	})
+	t.restore = func() error {
+		adminKeys, err := restoreKeys(st, configRepoAdminKeyOutput)
+		if err != nil {
+			return err
+		}
+		st.ssAdminKeys = adminKeys
+		return nil
+	}
 	return &t
 }
 
 func CreateGitClientTask(env installer.EnvConfig, st *state) Task {
-	t := newLeafTask("Wait git server to come up", func() error {
-		ssClient, err := st.repoClient.Get(
+	getClient := func() (soft.Client, error) {
+		return st.repoClient.Get(
 			fmt.Sprintf("soft-serve.%s.svc.cluster.local:%d", env.Id, 22),
 			st.ssAdminKeys.RawPrivateKey(),
 			log.Default())
+	}
+	t := newLeafTask("Wait git server to come up", func() error {
+		ssClient, err := getClient()
 		if err != nil {
 			return err
 		}
@@ -84,6 +98,14 @@ func CreateGitClientTask(env installer.EnvConfig, st *state) Task {
 		st.ssClient = ssClient
 		return nil
 	})
+	t.restore = func() error {
+		ssClient, err := getClient()
+		if err != nil {
+			return err
+		}
+		st.ssClient = ssClient
+		return nil
+	}
 	return &t
 }
 
@@ -95,6 +117,7 @@ func NewInitConfigRepoTask(env installer.EnvConfig, st *state) Task {
 			return err
 		}
 		st.keys = keys
+		st.progress.SetOutput(fluxKeyOutput, keys.RawPrivateKey())
 		if err := st.ssClient.AddRepository("config"); err != nil {
 			return err
 		}
@@ -126,5 +149,27 @@ func NewInitConfigRepoTask(env installer.EnvConfig, st *state) Task {
 		}
 		return nil
 	})
+	t.restore = func() error {
+		st.fluxUserName = fmt.Sprintf("flux-%s", env.Id)
+		keys, err := restoreKeys(st, fluxKeyOutput)
+		if err != nil {
+			return err
+		}
+		st.keys = keys
+		return nil
+	}
 	return &t
 }
+
+const (
+	configRepoAdminKeyOutput = "config-repo-admin-key"
+	fluxKeyOutput            = "flux-key"
+)
+
+func restoreKeys(st *state, output string) (*keygen.KeyPair, error) {
+	key, err := st.progress.Output(output)
+	if err != nil {
+		return nil, err
+	}
+	return installer.ParseSSHKeyPair(key)
+}
@@ -0,0 +1,328 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/giolekva/pcloud/core/installer"
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+type TaskState struct {
+	Title  string `json:"title,omitempty"`
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Kinds of the persisted task trees, environment creation has none for
+// compatibility with the previously recorded ones.
+const (
+	ProgressKindCreateEnv   = ""
+	ProgressKindKSKRollover = "ksk-rollover"
+)
+
+// ProgressState is the persisted state of the task tree operating on the
+// environment. Tasks are identified by their position in the tree. Outputs
+// might carry credentials and are never written to the repository.
+type ProgressState struct {
+	Key     string               `json:"key"`
+	Kind    string               `json:"kind,omitempty"`
+	Env     installer.EnvConfig  `json:"env"`
+	Tasks   map[string]TaskState `json:"tasks"`
+	Outputs map[string][]byte    `json:"-"`
+}
+
+type ProgressStore interface {
+	Save(state ProgressState) error
+	Load(key string) (ProgressState, error)
+	List() ([]ProgressState, error)
+	Delete(key string) error
+}
+
+type repoProgressStore struct {
+	repo      soft.RepoIO
+	dir       string
+	secrets   installer.SecretStore
+	namespace string
+	lock      sync.Mutex
+	// Milestones committed to the repository, keyed by progress key.
+	committed map[string]string
+}
+
+// NewRepoProgressStore keeps status of each task tree as a separate JSON
+// file in the given directory of the repository. Repository is only updated
+// when the tree starts, fails or finishes. Status of every step together
+// with the outputs is checkpointed in the Kubernetes secret in the given
+// namespace, if secrets is nil outputs are not persisted at all.
+func NewRepoProgressStore(repo soft.RepoIO, dir string, secrets installer.SecretStore, namespace string) ProgressStore {
+	return &repoProgressStore{
+		repo:      repo,
+		dir:       dir,
+		secrets:   secrets,
+		namespace: namespace,
+		committed: map[string]string{},
+	}
+}
+
+func (s *repoProgressStore) path(key string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s.json", key))
+}
+
+func (s *repoProgressStore) secretName(key string) string {
+	return fmt.Sprintf("env-tasks-%s", key)
+}
+
+const checkpointTasksKey = "tasks.json"
+
+// milestone summarizes the state changes which are worth a commit: status
+// of the whole tree and the failed steps.
+func milestone(state ProgressState) string {
+	failed := []string{}
+	for id, t := range state.Tasks {
+		if t.Status == StatusFailed {
+			failed = append(failed, id)
+		}
+	}
+	sort.Strings(failed)
+	return fmt.Sprintf("%d %s", state.Tasks[rootTaskId].Status, strings.Join(failed, ","))
+}
+
+func (s *repoProgressStore) Save(state ProgressState) error {
+	if s.secrets != nil {
+		tasks, err := json.Marshal(state.Tasks)
+		if err != nil {
+			return err
+		}
+		data := map[string][]byte{checkpointTasksKey: tasks}
+		for name, value := range state.Outputs {
+			data[fmt.Sprintf("output-%s", name)] = value
+		}
+		if err := s.secrets.Put(s.namespace, s.secretName(state.Key), data); err != nil {
+			return err
+		}
+	}
+	m := milestone(state)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if prev, ok := s.committed[state.Key]; ok && prev == m {
+		return nil
+	}
+	if err := s.repo.Do(func(r soft.RepoFS) (string, error) {
+		if err := soft.WriteJson(r, s.path(state.Key), state); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s: save env creation progress", state.Key), nil
+	}); err != nil {
+		return err
+	}
+	s.committed[state.Key] = m
+	return nil
+}
+
+func (s *repoProgressStore) Load(key string) (ProgressState, error) {
+	var ret ProgressState
+	if err := soft.ReadJson(s.repo, s.path(key), &ret); err != nil {
+		return ProgressState{}, err
+	}
+	ret.Outputs = map[string][]byte{}
+	if s.secrets == nil {
+		return ret, nil
+	}
+	data, err := s.secrets.Get(s.namespace, s.secretName(key))
+	if err != nil {
+		return ProgressState{}, err
+	}
+	// Checkpoint is at least as recent as the repository record.
+	if tasks, ok := data[checkpointTasksKey]; ok {
+		if err := json.Unmarshal(tasks, &ret.Tasks); err != nil {
+			return ProgressState{}, err
+		}
+	}
+	for name, value := range data {
+		if o, ok := strings.CutPrefix(name, "output-"); ok {
+			ret.Outputs[o] = value
+		}
+	}
+	return ret, nil
+}
+
+func (s *repoProgressStore) List() ([]ProgressState, error) {
+	files, err := s.repo.ListDir(s.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []ProgressState{}, nil
+		}
+		return nil, err
+	}
+	ret := make([]ProgressState, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		state, err := s.Load(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, state)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+	return ret, nil
+}
+
+func (s *repoProgressStore) Delete(key string) error {
+	if err := s.repo.Do(func(r soft.RepoFS) (string, error) {
+		if err := r.RemoveDir(s.path(key)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s: remove env creation progress", key), nil
+	}); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	delete(s.committed, key)
+	s.lock.Unlock()
+	if s.secrets == nil {
+		return nil
+	}
+	return s.secrets.Delete(s.namespace, s.secretName(key))
+}
+
+// Progress records status of every task of the tree together with the
+// outputs tasks produce, so that tree can be resumed after restart or
+// retried starting from the failed step.
+type Progress struct {
+	lock  sync.Mutex
+	store ProgressStore
+	state ProgressState
+}
+
+// NewProgress returns progress of the new task tree. If store is nil
+// progress is tracked in memory only.
+func NewProgress(store ProgressStore, key string, env installer.EnvConfig) *Progress {
+	return &Progress{
+		store: store,
+		state: ProgressState{
+			Key:     key,
+			Env:     env,
+			Tasks:   map[string]TaskState{},
+			Outputs: map[string][]byte{},
+		},
+	}
+}
+
+// NewKSKRolloverProgress returns progress of the new key signing key
+// rollover of the environment zone.
+func NewKSKRolloverProgress(store ProgressStore, key string, env installer.EnvConfig) *Progress {
+	p := NewProgress(store, key, env)
+	p.state.Kind = ProgressKindKSKRollover
+	return p
+}
+
+// ResumeProgress continues previously persisted progress. Tasks which have
+// not succeeded are started from scratch.
+func ResumeProgress(store ProgressStore, state ProgressState) *Progress {
+	if state.Tasks == nil {
+		state.Tasks = map[string]TaskState{}
+	}
+	if state.Outputs == nil {
+		state.Outputs = map[string][]byte{}
+	}
+	return &Progress{store: store, state: state}
+}
+
+func (p *Progress) Key() string {
+	return p.state.Key
+}
+
+func (p *Progress) Kind() string {
+	return p.state.Kind
+}
+
+func (p *Progress) Env() installer.EnvConfig {
+	return p.state.Env
+}
+
+// Status returns recorded status of the root task.
+func (p *Progress) Status() Status {
+	return p.get(rootTaskId).Status
+}
+
+func (p *Progress) get(id string) TaskState {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.state.Tasks[id]
+}
+
+func (p *Progress) done(id string) bool {
+	return p.get(id).Status == StatusDone
+}
+
+func (p *Progress) record(id, title string, err error) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err == nil {
+		p.state.Tasks[id] = TaskState{Title: title, Status: StatusDone}
+	} else {
+		p.state.Tasks[id] = TaskState{title, StatusFailed, err.Error()}
+	}
+	if p.store == nil {
+		return nil
+	}
+	return p.store.Save(p.state)
+}
+
+func (p *Progress) SetOutput(name string, value []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.state.Outputs[name] = value
+}
+
+func (p *Progress) Output(name string) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if v, ok := p.state.Outputs[name]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("Output not found: %s", name)
+}
+
+const rootTaskId = "0"
+
+type trackedTask interface {
+	leaf() *leafTask
+}
+
+type parent interface {
+	children() []Task
+}
+
+// track assigns ids to the tasks of the tree and restores their status
+// recorded by the previous run.
+func track(t Task, id string, p *Progress) {
+	tt, ok := t.(trackedTask)
+	if !ok {
+		return
+	}
+	l := tt.leaf()
+	l.id = id
+	l.progress = p
+	if s := p.get(id); s.Status == StatusDone || s.Status == StatusFailed {
+		l.status = s.Status
+		if s.Error != "" {
+			l.err = errors.New(s.Error)
+		}
+	}
+	if pt, ok := t.(parent); ok {
+		for i, c := range pt.children() {
+			track(c, fmt.Sprintf("%s.%d", id, i), p)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package tasks
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+
+	"github.com/giolekva/pcloud/core/installer"
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+type countingRepo struct {
+	soft.RepoFS
+	commits int
+}
+
+func (r *countingRepo) FullAddress() string {
+	return "infra"
+}
+
+func (r *countingRepo) Pull() error {
+	return nil
+}
+
+func (r *countingRepo) CommitAndPush(message string) error {
+	r.commits++
+	return nil
+}
+
+func (r *countingRepo) Do(op soft.DoFn, _ ...soft.DoOption) error {
+	msg, err := op(r)
+	if err != nil {
+		return err
+	}
+	return r.CommitAndPush(msg)
+}
+
+func (r *countingRepo) Revert(commit string, message string) error {
+	return nil
+}
+
+type memSecretStore map[string]map[string][]byte
+
+func (s memSecretStore) Get(namespace, name string) (map[string][]byte, error) {
+	if d, ok := s[namespace+"/"+name]; ok {
+		return d, nil
+	}
+	return map[string][]byte{}, nil
+}
+
+func (s memSecretStore) Put(namespace, name string, data map[string][]byte) error {
+	s[namespace+"/"+name] = data
+	return nil
+}
+
+func (s memSecretStore) Delete(namespace, name string) error {
+	delete(s, namespace+"/"+name)
+	return nil
+}
+
+func TestRepoProgressStoreKeepsOutputsOutOfRepo(t *testing.T) {
+	repo := &countingRepo{RepoFS: soft.NewBillyRepoFS(memfs.New())}
+	secrets := memSecretStore{}
+	store := NewRepoProgressStore(repo, "/env-tasks", secrets, "env-manager")
+	p := NewProgress(store, "env", installer.EnvConfig{})
+	p.SetOutput("key", []byte("private key"))
+	for _, id := range []string{"0.0", "0.1", "0.2"} {
+		if err := p.record(id, "step", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if repo.commits != 1 {
+		t.Fatalf("Expected single commit for successful steps, got %d", repo.commits)
+	}
+	if err := p.record(rootTaskId, "root", nil); err != nil {
+		t.Fatal(err)
+	}
+	if repo.commits != 2 {
+		t.Fatalf("Expected completion to be committed, got %d commits", repo.commits)
+	}
+	r, err := repo.Reader("/env-tasks/env.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(contents), "outputs") || !strings.Contains(string(contents), `"step"`) {
+		t.Fatalf("Unexpected progress record: %s", contents)
+	}
+	st, err := store.Load("env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(st.Outputs["key"]) != "private key" || st.Tasks["0.2"].Status != StatusDone {
+		t.Fatalf("Expected outputs and steps to be restored, got %+v", st)
+	}
+	if err := store.Delete("env"); err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 0 {
+		t.Fatalf("Expected checkpoint secret to be removed, got %+v", secrets)
+	}
+}
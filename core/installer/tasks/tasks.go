@@ -76,6 +76,17 @@ func (b *basicTask) callDoneListeners(err error) {
 type leafTask struct {
 	basicTask
 	start func() error
+	// Restores in-memory state produced by the task when it is skipped
+	// because of having succeeded during the previous run.
+	restore func() error
+	// Whether to run the task again on resume even if it has succeeded.
+	rerun    bool
+	id       string
+	progress *Progress
+}
+
+func (b *leafTask) leaf() *leafTask {
+	return b
 }
 
 func newLeafTask(title string, start func() error) leafTask {
@@ -90,11 +101,25 @@ func (b *leafTask) Subtasks() []Task {
 }
 
 func (b *leafTask) Start() {
+	if b.progress != nil && !b.rerun && b.progress.done(b.id) {
+		var err error
+		if b.restore != nil {
+			err = b.restore()
+		}
+		b.callDoneListeners(err)
+		return
+	}
 	b.status = StatusRunning
+	b.err = nil
 	if b.beforeStart != nil {
 		b.beforeStart()
 	}
 	err := b.start()
+	if b.progress != nil {
+		if perr := b.progress.record(b.id, b.title, err); err == nil {
+			err = perr
+		}
+	}
 	defer b.callDoneListeners(err)
 	if b.afterDone != nil {
 		b.afterDone()
@@ -108,11 +133,18 @@ type parentTask struct {
 }
 
 func newParentTask(title string, showChildren bool, start func() error, subtasks ...Task) parentTask {
-	return parentTask{
+	ret := parentTask{
 		leafTask:     newLeafTask(title, start),
 		subtasks:     subtasks,
 		showChildren: showChildren,
 	}
+	// Parent always runs so that its subtasks get a chance to restore.
+	ret.rerun = true
+	return ret
+}
+
+func (t *parentTask) children() []Task {
+	return t.subtasks
 }
 
 func (t *parentTask) Subtasks() []Task {
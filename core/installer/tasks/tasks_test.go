@@ -5,6 +5,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/giolekva/pcloud/core/installer"
 )
 
 func TestLeaf(t *testing.T) {
@@ -111,3 +113,95 @@ func TestConcurrentTaskSucceeds_WaitsForAllChildren(t *testing.T) {
 		t.Fatalf("Expected 2, got %d", cnt)
 	}
 }
+
+type inMemoryProgressStore struct {
+	states map[string]ProgressState
+}
+
+func (s *inMemoryProgressStore) Save(state ProgressState) error {
+	s.states[state.Key] = state
+	return nil
+}
+
+func (s *inMemoryProgressStore) Load(key string) (ProgressState, error) {
+	if st, ok := s.states[key]; ok {
+		return st, nil
+	}
+	return ProgressState{}, fmt.Errorf("not found: %s", key)
+}
+
+func (s *inMemoryProgressStore) List() ([]ProgressState, error) {
+	ret := []ProgressState{}
+	for _, st := range s.states {
+		ret = append(ret, st)
+	}
+	return ret, nil
+}
+
+func (s *inMemoryProgressStore) Delete(key string) error {
+	delete(s.states, key)
+	return nil
+}
+
+func TestRetryFromFailedStep(t *testing.T) {
+	store := &inMemoryProgressStore{map[string]ProgressState{}}
+	var oneRuns, oneRestores, twoRuns int
+	failTwo := true
+	build := func(p *Progress) Task {
+		one := newLeafTask("one", func() error {
+			oneRuns++
+			p.SetOutput("one", []byte("output"))
+			return nil
+		})
+		one.restore = func() error {
+			oneRestores++
+			if out, err := p.Output("one"); err != nil || string(out) != "output" {
+				return fmt.Errorf("Unexpected output: %s %v", out, err)
+			}
+			return nil
+		}
+		two := newLeafTask("two", func() error {
+			twoRuns++
+			if failTwo {
+				return fmt.Errorf("two")
+			}
+			return nil
+		})
+		root := newSequentialParentTask("parent", true, &one, &two)
+		track(root, rootTaskId, p)
+		return root
+	}
+	run := func(root Task) error {
+		done := make(chan error)
+		root.OnDone(func(err error) {
+			done <- err
+		})
+		go root.Start()
+		return <-done
+	}
+	if err := run(build(NewProgress(store, "env", installer.EnvConfig{}))); err == nil {
+		t.Fatal("Expected failure")
+	}
+	st, err := store.Load("env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failTwo = false
+	p := ResumeProgress(store, st)
+	if p.Status() != StatusFailed {
+		t.Fatalf("Expected failed status, got %d", p.Status())
+	}
+	root := build(p)
+	if root.Status() != StatusFailed || root.Subtasks()[0].Status() != StatusDone || root.Subtasks()[1].Err() == nil {
+		t.Fatal("Expected recorded statuses to be restored")
+	}
+	if err := run(root); err != nil {
+		t.Fatal(err)
+	}
+	if oneRuns != 1 || oneRestores != 1 || twoRuns != 2 {
+		t.Fatalf("Unexpected runs: one %d, restored %d, two %d", oneRuns, oneRestores, twoRuns)
+	}
+	if p.Status() != StatusDone {
+		t.Fatalf("Expected done status, got %d", p.Status())
+	}
+}
@@ -0,0 +1,207 @@
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationError lists all the problems found in the application
+// input values.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, f := range e.Errors {
+		msgs = append(msgs, f.Error())
+	}
+	return fmt.Sprintf("Invalid values: %s", strings.Join(msgs, "; "))
+}
+
+// ValidateValues checks given values against the schema and returns
+// ValidationError describing every field which is missing, has value of
+// the wrong kind or refers to an unknown network.
+func ValidateValues(values map[string]any, schema Schema, networks []Network) error {
+	errs := validateFields("", values, schema, networks)
+	if len(errs) == 0 {
+		return nil
+	}
+	return ValidationError{errs}
+}
+
+func validateFields(prefix string, values map[string]any, schema Schema, networks []Network) []FieldError {
+	ret := make([]FieldError, 0)
+	for _, f := range schema.Fields() {
+		p := f.Name
+		if prefix != "" {
+			p = fmt.Sprintf("%s.%s", prefix, f.Name)
+		}
+		v, ok := values[f.Name]
+		if !ok || v == nil {
+			if f.Required {
+				ret = append(ret, FieldError{p, "is required"})
+			}
+			continue
+		}
+		ret = append(ret, validateValue(p, v, f.Schema, networks)...)
+	}
+	return ret
+}
+
+func validateValue(p string, v any, schema Schema, networks []Network) []FieldError {
+	wrongKind := func(expected string) []FieldError {
+		return []FieldError{{p, fmt.Sprintf("expected %s, got %T", expected, v)}}
+	}
+	switch schema.Kind() {
+	case KindBoolean:
+		if _, ok := v.(bool); !ok {
+			return wrongKind("boolean")
+		}
+	case KindString:
+		if _, ok := v.(string); !ok {
+			return wrongKind("string")
+		}
+	case KindInt:
+		if !isInt(v) {
+			return wrongKind("integer")
+		}
+	case KindNumber:
+		if !isNumber(v) {
+			return wrongKind("number")
+		}
+	case KindArrayString:
+		switch a := v.(type) {
+		case []string:
+		case []any:
+			for i, e := range a {
+				if _, ok := e.(string); !ok {
+					return []FieldError{{fmt.Sprintf("%s[%d]", p, i), fmt.Sprintf("expected string, got %T", e)}}
+				}
+			}
+		default:
+			return wrongKind("string array")
+		}
+	case KindEnum:
+		s, ok := v.(string)
+		if !ok {
+			return wrongKind("string")
+		}
+		if e, ok := schema.(enumSchema); ok && !slices.Contains(e.Values(), s) {
+			return []FieldError{{p, fmt.Sprintf("must be one of %s", strings.Join(e.Values(), ", "))}}
+		}
+	case KindSecret:
+		if _, ok := v.(string); !ok {
+			return wrongKind("string")
+		}
+	case KindPort:
+		if !isInt(v) {
+			return wrongKind("port number")
+		}
+		if n := toFloat(v); n < 1 || n > 65535 {
+			return []FieldError{{p, fmt.Sprintf("port must be within [1, 65535], got %v", v)}}
+		}
+	case KindDuration:
+		s, ok := v.(string)
+		if !ok {
+			return wrongKind("duration")
+		}
+		if _, err := time.ParseDuration(s); err != nil {
+			return []FieldError{{p, fmt.Sprintf("invalid duration %s", s)}}
+		}
+	case KindArrayStruct:
+		items, ok := v.([]any)
+		if !ok {
+			return wrongKind("array")
+		}
+		ret := make([]FieldError, 0)
+		for i, item := range items {
+			ret = append(ret, validateStruct(fmt.Sprintf("%s[%d]", p, i), item, schema, networks)...)
+		}
+		return ret
+	case KindNetwork:
+		name, ok := v.(string)
+		if !ok {
+			return wrongKind("network name")
+		}
+		if _, err := findNetwork(networks, name); err != nil {
+			return []FieldError{{p, fmt.Sprintf("unknown network %s", name)}}
+		}
+	case KindAuth:
+		return validateStruct(p, v, AuthSchema, networks)
+	case KindSSHKey:
+		return validateStruct(p, v, SSHKeySchema, networks)
+	case KindStruct:
+		return validateStruct(p, v, schema, networks)
+	default:
+		return []FieldError{{p, "unsupported kind"}}
+	}
+	return nil
+}
+
+func validateStruct(p string, v any, schema Schema, networks []Network) []FieldError {
+	switch m := v.(type) {
+	case map[string]any:
+		return validateFields(p, m, schema, networks)
+	case map[string]string:
+		values := make(map[string]any, len(m))
+		for k, e := range m {
+			values[k] = e
+		}
+		return validateFields(p, values, schema, networks)
+	default:
+		return []FieldError{{p, fmt.Sprintf("expected object, got %T", v)}}
+	}
+}
+
+func isInt(v any) bool {
+	switch n := v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return true
+	case float32:
+		return float64(n) == math.Trunc(float64(n))
+	case float64:
+		return n == math.Trunc(n)
+	case json.Number:
+		_, err := n.Int64()
+		return err == nil
+	default:
+		return false
+	}
+}
+
+func toFloat(v any) float64 {
+	switch n := v.(type) {
+	case json.Number:
+		f, _ := n.Float64()
+		return f
+	default:
+		return reflect.ValueOf(v).Convert(reflect.TypeOf(float64(0))).Float()
+	}
+}
+
+func isNumber(v any) bool {
+	switch n := v.(type) {
+	case float32, float64:
+		return true
+	case json.Number:
+		_, err := n.Float64()
+		return err == nil
+	default:
+		return isInt(n)
+	}
+}
@@ -2,7 +2,7 @@ input: {
 	network: #Network @name(Network)
 	subdomain: string @name(Subdomain)
 	key: #SSHKey
-	sshPort: int @name(SSH Port)
+	sshPort: #Port @name(SSH Port)
 }
 
 _domain: "\(input.subdomain).\(input.network.domain)"
@@ -115,6 +115,7 @@ _sshPort: 22
 
 portForward: [#PortForward & {
 	allocator: input.network.allocatePortAddr
+	deallocator: input.network.deallocatePortAddr
 	sourcePort: input.sshPort
 	// TODO(gio): namespace part must be populated by app manager. Otherwise
 	// third-party app developer might point to a service from different namespace.
@@ -306,13 +307,15 @@ data:
 				}
 				etc: {
 					secret: {
-						ssh_host_ecdsa_key: input.key.private
 						"ssh_host_ecdsa_key.pub": input.key.public
 					}
 					existingConfigMapName: _gerritConfigMapName
 				}
 			}
 		}
+		secretValues: {
+			"gerrit.etc.secret.ssh_host_ecdsa_key": "key.private"
+		}
 	}
 	"git-volume": {
 		chart: charts.volume
@@ -93,6 +93,17 @@ helm: {
 					pullPolicy: images.api.pullPolicy
 				}
 			}
+			acl: {
+				// TODO(gio): enable once memberships API token is shared with headscale
+				enabled: false
+				membershipsAddress: "http://memberships-api.\(global.namespacePrefix)core-auth-memberships.svc.cluster.local"
+				autoApproveRoutes: [input.ipSubnet]
+				rules: [{
+					// Everyone has passthough access to private-network-proxy node
+					group: "*"
+					destinations: ["\(input.ipSubnet):*", "private-network-proxy:0"]
+				}]
+			}
 			ui: enabled: false
 		}
 	}
@@ -1,6 +1,8 @@
 input: {
 	network: #Network @name(Network)
 	subdomain: string @name(Subdomain)
+	// Instances installed before the password was generated use the hardcoded one.
+	postgresPassword: string | *"matrix" @role(secret)
 }
 
 _domain: "\(input.subdomain).\(input.network.domain)"
@@ -86,7 +88,6 @@ helm: {
 				port: 5432
 				database: "matrix"
 				user: "matrix"
-				password: "matrix"
 			}
 			certificateIssuer: issuerPublic
 			ingressClassName: ingressPublic
@@ -100,6 +101,9 @@ helm: {
 				pullPolicy: images.matrix.pullPolicy
 			}
 		}
+		secretValues: {
+			"postgresql.password": "postgresPassword"
+		}
 	}
 	postgres: {
 		chart: charts.postgres
@@ -115,11 +119,13 @@ helm: {
 				type: "ClusterIP"
 				port: 5432
 			}
+			auth: {
+				username: "matrix"
+			}
 			primary: {
 				initdb: {
 					scripts: {
 						"init.sql": """
-						CREATE USER matrix WITH PASSWORD 'matrix';
 						CREATE DATABASE matrix WITH OWNER = matrix ENCODING = UTF8 LOCALE = 'C' TEMPLATE = template0;
 						"""
 					}
@@ -142,6 +148,9 @@ helm: {
 				}
 			}
 		}
+		secretValues: {
+			"auth.password": "postgresPassword"
+		}
 	}
 }
 
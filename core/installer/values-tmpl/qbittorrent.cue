@@ -33,6 +33,11 @@ charts: {
 	}
 }
 
+backup: volumes: [{
+	name: "data"
+	release: "qbittorrent"
+}]
+
 helm: {
 	qbittorrent: {
 		chart: charts.qbittorrent
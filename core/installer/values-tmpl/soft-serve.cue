@@ -1,7 +1,7 @@
 input: {
 	network: #Network @name(Network)
 	subdomain: string @name(Subdomain)
-	sshPort: int @name(SSH Port)
+	sshPort: #Port @name(SSH Port)
 	adminKey: string @name(Admin SSH Public Key)
 }
 
@@ -48,6 +48,7 @@ ingress: {
 
 portForward: [#PortForward & {
 	allocator: input.network.allocatePortAddr
+	deallocator: input.network.deallocatePortAddr
 	sourcePort: input.sshPort
 	// TODO(gio): namespace part must be populated by app manager. Otherwise
 	// third-party app developer might point to a service from different namespace.
@@ -47,6 +47,11 @@ charts: {
     }
 }
 
+backup: volumes: [{
+	name: "url-shortener"
+	release: "url-shortener"
+}]
+
 helm: {
     "url-shortener": {
         chart: charts.urlShortener
@@ -1,6 +1,7 @@
 input: {
     network: #Network @name(Network)
     subdomain: string @name(Subdomain)
+    adminToken: string @role(secret)
 }
 
 _domain: "\(input.subdomain).\(input.network.domain)"
@@ -32,6 +33,11 @@ charts: {
 	}
 }
 
+backup: volumes: [{
+	name: "data"
+	release: "vaultwarden"
+}]
+
 helm: {
 	vaultwarden: {
 		chart: charts.vaultwarden
@@ -45,6 +51,9 @@ helm: {
 				pullPolicy: images.vaultwarden.pullPolicy
 			}
 		}
+		secretValues: {
+			adminToken: "adminToken"
+		}
 	}
 }
 
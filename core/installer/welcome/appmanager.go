@@ -4,11 +4,15 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Masterminds/sprig/v3"
@@ -30,38 +34,96 @@ var appHtmlTmpl string
 type AppManagerServer struct {
 	port       int
 	m          *installer.AppManager
+	b          *installer.BackupManager
 	r          installer.AppRepository
 	reconciler tasks.Reconciler
 	h          installer.HelmReleaseMonitor
+	hc         installer.HealthChecker
+	l          sync.Mutex
 	tasks      map[string]tasks.Task
 }
 
 func NewAppManagerServer(
 	port int,
 	m *installer.AppManager,
+	b *installer.BackupManager,
 	r installer.AppRepository,
 	reconciler tasks.Reconciler,
 	h installer.HelmReleaseMonitor,
+	hc installer.HealthChecker,
 ) *AppManagerServer {
 	return &AppManagerServer{
 		port,
 		m,
+		b,
 		r,
 		reconciler,
 		h,
+		hc,
+		sync.Mutex{},
 		map[string]tasks.Task{},
 	}
 }
 
+func (s *AppManagerServer) findTask(id string) (tasks.Task, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	t, ok := s.tasks[id]
+	return t, ok
+}
+
+// monitorRelease starts task tracking given release resources, unless
+// one is already in progress for the instance.
+func (s *AppManagerServer) monitorRelease(id string, rr installer.ReleaseResources) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.tasks[id]; ok {
+		return
+	}
+	t := tasks.NewMonitorRelease(s.h, rr)
+	t.OnDone(func(err error) {
+		s.l.Lock()
+		defer s.l.Unlock()
+		delete(s.tasks, id)
+	})
+	s.tasks[id] = t
+	go t.Start()
+}
+
 func (s *AppManagerServer) Start() error {
 	e := echo.New()
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		var verr installer.ValidationError
+		if errors.As(err, &verr) && !c.Response().Committed {
+			if err := c.JSON(http.StatusBadRequest, verr); err != nil {
+				e.Logger.Error(err)
+			}
+			return
+		}
+		var derr installer.UnresolvedDependencyError
+		if errors.As(err, &derr) && !c.Response().Committed {
+			if err := c.JSON(http.StatusConflict, derr); err != nil {
+				e.Logger.Error(err)
+			}
+			return
+		}
+		e.DefaultHTTPErrorHandler(err, c)
+	}
 	e.StaticFS("/static", echo.MustSubFS(staticAssets, "static"))
 	e.GET("/api/app-repo", s.handleAppRepo)
 	e.POST("/api/app/:slug/install", s.handleAppInstall)
 	e.GET("/api/app/:slug", s.handleApp)
+	e.GET("/api/app/:slug/dependencies", s.handleAppDependencies)
 	e.GET("/api/instance/:slug", s.handleInstance)
 	e.POST("/api/instance/:slug/update", s.handleAppUpdate)
+	e.GET("/api/instance/:slug/upgrade", s.handleAppUpgradeCheck)
+	e.POST("/api/instance/:slug/upgrade", s.handleAppUpgrade)
 	e.POST("/api/instance/:slug/remove", s.handleAppRemove)
+	e.GET("/api/instance/:slug/status", s.handleInstanceStatus)
+	e.GET("/api/instance/:slug/status/stream", s.handleInstanceStatusStream)
+	e.GET("/api/instance/:slug/backups", s.handleBackupList)
+	e.POST("/api/instance/:slug/backup", s.handleBackup)
+	e.POST("/api/instance/:slug/restore", s.handleRestore)
 	e.GET("/", s.handleIndex)
 	e.GET("/app/:slug", s.handleAppUI)
 	e.GET("/instance/:slug", s.handleInstanceUI)
@@ -99,9 +161,40 @@ func (s *AppManagerServer) handleApp(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	for i, inst := range instances {
+		instances[i] = inst.WithoutSecrets(a.Schema())
+	}
 	return c.JSON(http.StatusOK, app{a.Name(), a.Icon(), a.Description(), a.Slug(), instances})
 }
 
+type dependency struct {
+	installer.Dependency
+	Candidates []string `json:"candidates"`
+}
+
+// handleAppDependencies lists application dependencies together with the
+// instances each of them can be bound to.
+func (s *AppManagerServer) handleAppDependencies(c echo.Context) error {
+	slug := c.Param("slug")
+	a, err := installer.FindEnvApp(s.r, slug)
+	if err != nil {
+		return err
+	}
+	resp := make([]dependency, 0)
+	for _, d := range a.Dependencies() {
+		instances, err := s.m.FindAllAppInstances(d.App)
+		if err != nil {
+			return err
+		}
+		candidates := make([]string, 0, len(instances))
+		for _, inst := range instances {
+			candidates = append(candidates, inst.Id)
+		}
+		resp = append(resp, dependency{d, candidates})
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
 func (s *AppManagerServer) handleInstance(c echo.Context) error {
 	slug := c.Param("slug")
 	instance, err := s.m.FindInstance(slug)
@@ -112,7 +205,7 @@ func (s *AppManagerServer) handleInstance(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	return c.JSON(http.StatusOK, app{a.Name(), a.Icon(), a.Description(), a.Slug(), []installer.AppInstanceConfig{*instance}})
+	return c.JSON(http.StatusOK, app{a.Name(), a.Icon(), a.Description(), a.Slug(), []installer.AppInstanceConfig{instance.WithoutSecrets(a.Schema())}})
 }
 
 func (s *AppManagerServer) handleAppInstall(c echo.Context) error {
@@ -144,21 +237,34 @@ func (s *AppManagerServer) handleAppInstall(c echo.Context) error {
 	instanceId := a.Slug() + suffix
 	appDir := fmt.Sprintf("/apps/%s", instanceId)
 	namespace := fmt.Sprintf("%s%s%s", env.NamespacePrefix, a.Namespace(), suffix)
-	rr, err := s.m.Install(a, instanceId, appDir, namespace, values)
+	bindings, err := parseBindings(c)
+	if err != nil {
+		return err
+	}
+	if isDryRun(c) {
+		diff, err := s.m.InstallDryRun(a, instanceId, appDir, namespace, values, bindings)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, diff)
+	}
+	rr, err := s.m.InstallAll([]installer.InstallRequest{{
+		App:        a,
+		InstanceId: instanceId,
+		AppDir:     appDir,
+		Namespace:  namespace,
+		Values:     values,
+		Bindings:   bindings,
+	}})
 	if err != nil {
 		return err
 	}
 	ctx, _ := context.WithTimeout(context.Background(), 2*time.Minute)
 	go s.reconciler.Reconcile(ctx)
-	if _, ok := s.tasks[instanceId]; ok {
+	if _, ok := s.findTask(instanceId); ok {
 		panic("MUST NOT REACH!")
 	}
-	t := tasks.NewMonitorRelease(s.h, rr)
-	t.OnDone(func(err error) {
-		delete(s.tasks, instanceId)
-	})
-	s.tasks[instanceId] = t
-	go t.Start()
+	s.monitorRelease(instanceId, rr)
 	return c.String(http.StatusOK, fmt.Sprintf("/instance/%s", instanceId))
 }
 
@@ -180,7 +286,14 @@ func (s *AppManagerServer) handleAppUpdate(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	if _, ok := s.tasks[slug]; ok {
+	if isDryRun(c) {
+		diff, err := s.m.UpdateDryRun(a, slug, values)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, diff)
+	}
+	if _, ok := s.findTask(slug); ok {
 		return fmt.Errorf("Update already in progress")
 	}
 	rr, err := s.m.Update(a, slug, values)
@@ -189,15 +302,68 @@ func (s *AppManagerServer) handleAppUpdate(c echo.Context) error {
 	}
 	ctx, _ := context.WithTimeout(context.Background(), 2*time.Minute)
 	go s.reconciler.Reconcile(ctx)
-	t := tasks.NewMonitorRelease(s.h, rr)
-	t.OnDone(func(err error) {
-		delete(s.tasks, slug)
-	})
-	s.tasks[slug] = t
-	go t.Start()
+	s.monitorRelease(slug, rr)
 	return c.String(http.StatusOK, fmt.Sprintf("/instance/%s", slug))
 }
 
+func (s *AppManagerServer) handleAppUpgradeCheck(c echo.Context) error {
+	slug := c.Param("slug")
+	instance, err := s.m.FindInstance(slug)
+	if err != nil {
+		return err
+	}
+	upgrade, err := installer.CheckUpgrade(s.r, *instance)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, upgrade)
+}
+
+func (s *AppManagerServer) handleAppUpgrade(c echo.Context) error {
+	slug := c.Param("slug")
+	version := c.QueryParam("version")
+	if isDryRun(c) {
+		diff, err := s.m.UpgradeDryRun(s.r, slug, version)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, diff)
+	}
+	if _, ok := s.findTask(slug); ok {
+		return fmt.Errorf("Update already in progress")
+	}
+	rr, err := s.m.Upgrade(s.r, slug, version)
+	if err != nil {
+		return err
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		s.reconciler.Reconcile(ctx)
+	}()
+	s.monitorRelease(slug, rr)
+	return c.String(http.StatusOK, fmt.Sprintf("/instance/%s", slug))
+}
+
+// parseBindings reads instances chosen for application dependencies,
+// passed as bind=<dependency>:<instance> query parameters.
+func parseBindings(c echo.Context) (map[string]string, error) {
+	ret := map[string]string{}
+	for _, b := range c.QueryParams()["bind"] {
+		items := strings.SplitN(b, ":", 2)
+		if len(items) != 2 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid binding: %s", b))
+		}
+		ret[items[0]] = items[1]
+	}
+	return ret, nil
+}
+
+func isDryRun(c echo.Context) bool {
+	dryRun, err := strconv.ParseBool(c.QueryParam("dryRun"))
+	return err == nil && dryRun
+}
+
 func (s *AppManagerServer) handleAppRemove(c echo.Context) error {
 	slug := c.Param("slug")
 	if err := s.m.Remove(slug); err != nil {
@@ -208,6 +374,131 @@ func (s *AppManagerServer) handleAppRemove(c echo.Context) error {
 	return c.String(http.StatusOK, "/")
 }
 
+type taskStatus struct {
+	Title    string       `json:"title"`
+	Status   tasks.Status `json:"status"`
+	Error    string       `json:"error,omitempty"`
+	Subtasks []taskStatus `json:"subtasks,omitempty"`
+}
+
+func newTaskStatus(t tasks.Task) taskStatus {
+	ret := taskStatus{
+		Title:  t.Title(),
+		Status: t.Status(),
+	}
+	if err := t.Err(); err != nil {
+		ret.Error = err.Error()
+	}
+	for _, st := range t.Subtasks() {
+		ret.Subtasks = append(ret.Subtasks, newTaskStatus(st))
+	}
+	return ret
+}
+
+type instanceStatus struct {
+	Installing bool                     `json:"installing"`
+	Progress   *taskStatus              `json:"progress,omitempty"`
+	Health     installer.InstanceHealth `json:"health"`
+}
+
+func (s *AppManagerServer) instanceStatus(instanceId string) (instanceStatus, error) {
+	instance, err := s.m.FindInstance(instanceId)
+	if err != nil {
+		return instanceStatus{}, err
+	}
+	var ret instanceStatus
+	if t, ok := s.findTask(instanceId); ok {
+		p := newTaskStatus(t)
+		ret.Installing = t.Status() == tasks.StatusPending || t.Status() == tasks.StatusRunning
+		ret.Progress = &p
+	}
+	rr, err := s.m.InstanceResources(instanceId)
+	if err != nil {
+		return instanceStatus{}, err
+	}
+	ret.Health, err = s.hc.Check(instance.Release.Namespace, rr)
+	if err != nil {
+		return instanceStatus{}, err
+	}
+	return ret, nil
+}
+
+func (s *AppManagerServer) handleInstanceStatus(c echo.Context) error {
+	status, err := s.instanceStatus(c.Param("slug"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// handleInstanceStatusStream periodically sends instance status as server
+// sent events until client disconnects.
+func (s *AppManagerServer) handleInstanceStatusStream(c echo.Context) error {
+	slug := c.Param("slug")
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set(echo.HeaderCacheControl, "no-cache")
+	resp.Header().Set(echo.HeaderConnection, "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+	for {
+		if status, err := s.instanceStatus(slug); err != nil {
+			fmt.Fprintf(resp, "event: error\ndata: %s\n\n", err.Error())
+		} else if b, err := json.Marshal(status); err != nil {
+			return err
+		} else {
+			fmt.Fprintf(resp, "data: %s\n\n", b)
+		}
+		resp.Flush()
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *AppManagerServer) handleBackupList(c echo.Context) error {
+	slug := c.Param("slug")
+	backups, err := s.b.List(slug)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, backups)
+}
+
+func (s *AppManagerServer) handleBackup(c echo.Context) error {
+	slug := c.Param("slug")
+	backup, err := s.b.Backup(slug)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, backup)
+}
+
+func (s *AppManagerServer) handleRestore(c echo.Context) error {
+	slug := c.Param("slug")
+	backupId := c.QueryParam("backup")
+	if backupId == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "backup must be provided")
+	}
+	rr, err := s.b.Restore(s.r, slug, backupId)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	go func() {
+		defer cancel()
+		s.reconciler.Reconcile(ctx)
+	}()
+	if _, ok := s.findTask(slug); ok {
+		return c.String(http.StatusOK, fmt.Sprintf("/instance/%s", slug))
+	}
+	s.monitorRelease(slug, rr)
+	return c.String(http.StatusOK, fmt.Sprintf("/instance/%s", slug))
+}
+
 func (s *AppManagerServer) handleIndex(c echo.Context) error {
 	tmpl, err := template.ParseFS(mgrTmpl, "appmanager-tmpl/base.html", "appmanager-tmpl/index.html")
 	if err != nil {
@@ -254,6 +545,7 @@ func (s *AppManagerServer) handleAppUI(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	prepareForDisplay(a, instances)
 	err = appTmpl.Execute(c.Response(), appContext{
 		App:               a,
 		Instances:         instances,
@@ -285,11 +577,14 @@ func (s *AppManagerServer) handleInstanceUI(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	display := instance.WithoutSecrets(a.Schema())
+	instance = &display
 	instances, err := s.m.FindAllAppInstances(a.Slug())
 	if err != nil {
 		return err
 	}
-	t := s.tasks[slug]
+	prepareForDisplay(a, instances)
+	t, _ := s.findTask(slug)
 	err = appTmpl.Execute(c.Response(), appContext{
 		App:               a,
 		Instance:          instance,
@@ -300,6 +595,14 @@ func (s *AppManagerServer) handleInstanceUI(c echo.Context) error {
 	return err
 }
 
+// prepareForDisplay strips secrets from the instances. Values are shown as
+// stored, migrations are only applied by the upgrade.
+func prepareForDisplay(app installer.App, instances []installer.AppInstanceConfig) {
+	for i, inst := range instances {
+		instances[i] = inst.WithoutSecrets(app.Schema())
+	}
+}
+
 func newTemplate() *template.Template {
 	return template.New("base").Funcs(template.FuncMap(sprig.FuncMap()))
 }
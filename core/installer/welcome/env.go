@@ -6,12 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
-	"io"
-	"io/fs"
 	"log"
 	"net"
 	"net/http"
-	"net/netip"
 	"strings"
 
 	"github.com/gomarkdown/markdown"
@@ -40,6 +37,7 @@ func init() {
 type templates struct {
 	form   *template.Template
 	status *template.Template
+	admin  *template.Template
 }
 
 func parseTemplates(fs embed.FS) (templates, error) {
@@ -62,20 +60,11 @@ func parseTemplates(fs embed.FS) (templates, error) {
 	if err != nil {
 		return templates{}, err
 	}
-	return templates{form, status}, nil
-}
-
-type Status string
-
-const (
-	StatusActive   Status = "ACTIVE"
-	StatusAccepted Status = "ACCEPTED"
-)
-
-// TODO(giolekva): add CreatedAt and ValidUntil
-type invitation struct {
-	Token  string `json:"token"`
-	Status Status `json:"status"`
+	admin, err := parse("env-manager-tmpl/admin.html")
+	if err != nil {
+		return templates{}, err
+	}
+	return templates{form, status, admin}, nil
 }
 
 type EnvServer struct {
@@ -88,10 +77,16 @@ type EnvServer struct {
 	nameGenerator installer.NameGenerator
 	httpClient    phttp.Client
 	dnsClient     dns.Client
+	issuers       installer.ClusterIssuerDeleter
+	adminPassword string
+	ipam          *installer.IPAM
 	Tasks         map[string]tasks.Task
 	envInfo       map[string]template.HTML
 	dns           map[string]installer.EnvDNS
 	dnsPublished  map[string]struct{}
+	progress      tasks.ProgressStore
+	// Ids of the environments being deleted, keyed by task key.
+	deletions map[string]string
 }
 
 func NewEnvServer(
@@ -104,6 +99,11 @@ func NewEnvServer(
 	nameGenerator installer.NameGenerator,
 	httpClient phttp.Client,
 	dnsClient dns.Client,
+	issuers installer.ClusterIssuerDeleter,
+	adminPassword string,
+	ipam *installer.IPAM,
+	secrets installer.SecretStore,
+	namespace string,
 ) *EnvServer {
 	return &EnvServer{
 		port,
@@ -115,21 +115,41 @@ func NewEnvServer(
 		nameGenerator,
 		httpClient,
 		dnsClient,
+		issuers,
+		adminPassword,
+		ipam,
 		make(map[string]tasks.Task),
 		make(map[string]template.HTML),
 		make(map[string]installer.EnvDNS),
 		make(map[string]struct{}),
+		tasks.NewRepoProgressStore(repo, "/env-tasks", secrets, namespace),
+		make(map[string]string),
 	}
 }
 
 func (s *EnvServer) Start() {
+	if err := s.resumeTasks(); err != nil {
+		log.Printf("Failed to resume environment creation: %s\n", err)
+	}
 	r := mux.NewRouter()
 	r.PathPrefix("/static/").Handler(http.FileServer(http.FS(staticAssets)))
 	r.Path("/env/{key}").Methods("GET").HandlerFunc(s.monitorTask)
 	r.Path("/env/{key}").Methods("POST").HandlerFunc(s.publishDNSRecords)
+	r.Path("/env/{key}/retry").Methods("POST").HandlerFunc(s.retryTask)
+	r.Path("/delete-env/{id}").Methods("POST").HandlerFunc(s.adminOnly(s.deleteEnv))
+	r.Path("/rollover-ksk/{id}").Methods("POST").HandlerFunc(s.adminOnly(s.rolloverKSK))
+	r.Path("/abort-ksk-rollover/{id}").Methods("POST").HandlerFunc(s.adminOnly(s.abortKSKRollover))
 	r.Path("/").Methods("GET").HandlerFunc(s.createEnvForm)
 	r.Path("/").Methods("POST").HandlerFunc(s.createEnv)
-	r.Path("/create-invitation").Methods("GET").HandlerFunc(s.createInvitation)
+	r.Path("/create-invitation").Methods("GET").HandlerFunc(s.adminOnly(s.createInvitation))
+	r.Path("/admin").Methods("GET").HandlerFunc(s.adminOnly(s.adminConsole))
+	r.Path("/admin/invitations").Methods("POST").HandlerFunc(s.adminOnly(s.adminCreateInvitation))
+	r.Path("/admin/invitations/{token}/revoke").Methods("POST").HandlerFunc(s.adminOnly(s.adminRevokeInvitation))
+	r.Path("/api/envs").Methods("GET").HandlerFunc(s.adminOnly(s.handleListEnvs))
+	r.Path("/api/envs/{id}").Methods("GET").HandlerFunc(s.adminOnly(s.handleGetEnv))
+	r.Path("/api/invitations").Methods("GET").HandlerFunc(s.adminOnly(s.handleListInvitations))
+	r.Path("/api/invitations").Methods("POST").HandlerFunc(s.adminOnly(s.handleCreateInvitation))
+	r.Path("/api/invitations/{token}/revoke").Methods("POST").HandlerFunc(s.adminOnly(s.handleRevokeInvitation))
 	http.Handle("/", r)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", s.port), nil))
 }
@@ -158,6 +178,7 @@ func (s *EnvServer) monitorTask(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	data := map[string]any{
+		"Key":        key,
 		"Root":       t,
 		"EnvInfo":    s.envInfo[key],
 		"DNSRecords": dnsRecords,
@@ -208,29 +229,6 @@ func (s *EnvServer) createEnvForm(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *EnvServer) createInvitation(w http.ResponseWriter, r *http.Request) {
-	invitations, err := s.readInvitations()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	token, err := installer.NewFixedLengthRandomNameGenerator(100).Generate() // TODO(giolekva): use cryptographic tokens
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-
-	}
-	invitations = append(invitations, invitation{token, StatusActive})
-	if err := s.writeInvitations(invitations); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if _, err := w.Write([]byte("OK")); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-}
-
 type createEnvReq struct {
 	Name           string
 	ContactEmail   string `json:"contactEmail"`
@@ -239,42 +237,6 @@ type createEnvReq struct {
 	SecretToken    string `json:"secretToken"`
 }
 
-func (s *EnvServer) readInvitations() ([]invitation, error) {
-	r, err := s.repo.Reader("invitations")
-	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			return make([]invitation, 0), nil
-		}
-		return nil, err
-	}
-	defer r.Close()
-	dec := json.NewDecoder(r)
-	invitations := make([]invitation, 0)
-	for {
-		var i invitation
-		if err := dec.Decode(&i); err == io.EOF {
-			break
-		}
-		invitations = append(invitations, i)
-	}
-	return invitations, nil
-}
-
-func (s *EnvServer) writeInvitations(invitations []invitation) error {
-	w, err := s.repo.Writer("invitations")
-	if err != nil {
-		return err
-	}
-	defer w.Close()
-	enc := json.NewEncoder(w)
-	for _, i := range invitations {
-		if err := enc.Encode(i); err != nil {
-			return err
-		}
-	}
-	return s.repo.CommitAndPush("Generated new invitation")
-}
-
 func extractRequest(r *http.Request) (createEnvReq, error) {
 	var req createEnvReq
 	if err := func() error {
@@ -303,25 +265,6 @@ func extractRequest(r *http.Request) (createEnvReq, error) {
 	return req, nil
 }
 
-func (s *EnvServer) acceptInvitation(token string) error {
-	invitations, err := s.readInvitations()
-	if err != nil {
-		return err
-	}
-	found := false
-	for i := range invitations {
-		if invitations[i].Token == token && invitations[i].Status == StatusActive {
-			invitations[i].Status = StatusAccepted
-			found = true
-			break
-		}
-	}
-	if !found {
-		return fmt.Errorf("Invitation not found")
-	}
-	return s.writeInvitations(invitations)
-}
-
 func (s *EnvServer) createEnv(w http.ResponseWriter, r *http.Request) {
 	if err := s.repo.Pull(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -342,36 +285,31 @@ func (s *EnvServer) createEnv(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// if err := s.acceptInvitation(req.SecretToken); err != nil {
-	// 	http.Error(w, err.Error(), http.StatusInternalServerError)
-	// 	return
-	// }
 	if name, err := s.nameGenerator.Generate(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	} else {
 		req.Name = name
 	}
-	var cidrs installer.EnvCIDRs
-	if err := soft.ReadYaml(s.repo, "env-cidrs.yaml", &cidrs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.checkInvitation(req.SecretToken); err != nil {
+		writeInvitationError(w, err)
 		return
 	}
-	startIP, err := findNextStartIP(cidrs)
+	subnet, err := s.ipam.Allocate(req.Name)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	cidrs = append(cidrs, installer.EnvCIDR{req.Name, startIP})
-	if err := soft.WriteYaml(s.repo, "env-cidrs.yaml", cidrs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if err := s.repo.CommitAndPush(fmt.Sprintf("Allocate CIDR for %s", req.Name)); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Invitation is consumed only once the subnet is allocated, lease is
+	// released if it was accepted concurrently in the meantime.
+	if err := s.acceptInvitation(req.SecretToken, req.Name, req.ContactEmail); err != nil {
+		if rerr := s.ipam.Release(req.Name); rerr != nil {
+			err = errors.Join(err, rerr)
+		}
+		writeInvitationError(w, err)
 		return
 	}
-	envNetwork, err := installer.NewEnvNetwork(startIP)
+	envNetwork, err := installer.NewEnvNetwork(net.ParseIP(subnet.Addr().String()))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -396,11 +334,26 @@ func (s *EnvServer) createEnv(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}()
+	t := s.newCreateEnvTask(mgr, tasks.NewProgress(s.progress, key, env))
+	go t.Start()
+	http.Redirect(w, r, fmt.Sprintf("/env/%s", key), http.StatusSeeOther)
+}
+
+func writeInvitationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidInvitation) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *EnvServer) newCreateEnvTask(mgr *installer.InfraAppManager, progress *tasks.Progress) tasks.Task {
+	key := progress.Key()
 	infoUpdater := func(info string) {
 		s.envInfo[key] = template.HTML(markdown.ToHTML([]byte(info), nil, nil))
 	}
 	t, dns := tasks.NewCreateEnvTask(
-		env,
+		progress.Env(),
 		s.nsCreator,
 		s.dnsFetcher,
 		s.httpClient,
@@ -409,39 +362,274 @@ func (s *EnvServer) createEnv(w http.ResponseWriter, r *http.Request) {
 		s.repoClient,
 		mgr,
 		infoUpdater,
+		progress,
 	)
 	s.Tasks[key] = t
 	s.dns[key] = dns
+	return t
+}
+
+// restoreTask rebuilds task tree of the given kind from its progress.
+func (s *EnvServer) restoreTask(mgr *installer.InfraAppManager, progress *tasks.Progress) tasks.Task {
+	if progress.Kind() == tasks.ProgressKindKSKRollover {
+		return s.newKSKRolloverTask(progress)
+	}
+	return s.newCreateEnvTask(mgr, progress)
+}
+
+// resumeTasks restores environment creation and KSK rollover tasks
+// persisted before restart.
+// Interrupted ones are started again, skipping already succeeded steps,
+// while failed ones wait to be retried explicitly.
+func (s *EnvServer) resumeTasks() error {
+	if err := s.repo.Pull(); err != nil {
+		return err
+	}
+	states, err := s.progress.List()
+	if err != nil {
+		return err
+	}
+	mgr, err := installer.NewInfraAppManager(s.repo, s.nsCreator)
+	if err != nil {
+		return err
+	}
+	for _, st := range states {
+		p := tasks.ResumeProgress(s.progress, st)
+		t := s.restoreTask(mgr, p)
+		switch p.Status() {
+		case tasks.StatusDone, tasks.StatusFailed:
+			continue
+		default:
+			go t.Start()
+		}
+	}
+	return nil
+}
+
+func (s *EnvServer) retryTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, ok := vars["key"]
+	if !ok {
+		http.Error(w, "Task key not provided", http.StatusBadRequest)
+		return
+	}
+	if t, ok := s.Tasks[key]; !ok {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	} else if t.Status() != tasks.StatusFailed {
+		http.Error(w, "Only failed task can be retried", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.deletions[key]; ok {
+		s.adminOnly(s.retryDeletion)(w, r)
+		return
+	}
+	if err := s.repo.Pull(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	st, err := s.progress.Load(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	mgr, err := installer.NewInfraAppManager(s.repo, s.nsCreator)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Task tree is rebuilt so that succeeded steps are skipped and failed
+	// ones start from scratch.
+	t := s.restoreTask(mgr, tasks.ResumeProgress(s.progress, st))
 	go t.Start()
 	http.Redirect(w, r, fmt.Sprintf("/env/%s", key), http.StatusSeeOther)
 }
 
-func findNextStartIP(cidrs installer.EnvCIDRs) (net.IP, error) {
-	m, err := netip.ParseAddr("10.0.0.0")
+func (s *EnvServer) retryDeletion(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	envId := s.deletions[key]
+	if err := s.startDeleteEnv(key, envId); err != nil {
+		writeDeleteEnvError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/env/%s", key), http.StatusSeeOther)
+}
+
+func writeDeleteEnvError(w http.ResponseWriter, err error) {
+	if errors.Is(err, tasks.ErrUnknownEnv) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *EnvServer) deleteEnv(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	envId, ok := vars["id"]
+	if !ok {
+		http.Error(w, "Environment id not provided", http.StatusBadRequest)
+		return
+	}
+	key := func() string {
+		for {
+			key, err := s.nameGenerator.Generate()
+			if err == nil {
+				return key
+			}
+		}
+	}()
+	if err := s.startDeleteEnv(key, envId); err != nil {
+		writeDeleteEnvError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/env/%s", key), http.StatusSeeOther)
+}
+
+// startDeleteEnv runs deletion of the environment. As every step of the
+// deletion is idempotent, failed deletion is retried by starting it again.
+func (s *EnvServer) startDeleteEnv(key, envId string) error {
+	if err := s.repo.Pull(); err != nil {
+		return err
+	}
+	mgr, err := installer.NewInfraAppManager(s.repo, s.nsCreator)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	infra, err := mgr.Config()
+	if err != nil {
+		return err
+	}
+	if err := tasks.ValidateEnvDeletion(envId, infra, s.ipam, s.progress); err != nil {
+		return err
 	}
-	for _, cidr := range cidrs {
-		i, err := netip.ParseAddr(cidr.IP.String())
+	t := tasks.NewDeleteEnvTask(envId, infra, s.repo, mgr, s.nsCreator, s.issuers)
+	t.OnDone(func(err error) {
 		if err != nil {
-			return nil, err
+			return
 		}
-		if i.Compare(m) > 0 {
-			m = i
+		if err := s.forgetEnv(envId); err != nil {
+			log.Printf("Failed to remove creation progress of %s: %s\n", envId, err)
 		}
+		s.envInfo[key] = template.HTML(fmt.Sprintf("Environment %s has been deleted.", envId))
+	})
+	s.Tasks[key] = t
+	s.deletions[key] = envId
+	// There are no DNS records to publish while deleting environment.
+	s.dnsPublished[key] = struct{}{}
+	go t.Start()
+	return nil
+}
+
+// findEnv returns configuration of the environment recorded when it was
+// created.
+func (s *EnvServer) findEnv(envId string) (*installer.EnvConfig, error) {
+	states, err := s.progress.List()
+	if err != nil {
+		return nil, err
 	}
-	sl := m.AsSlice()
-	sl[2]++
-	if sl[2] == 0b11111111 {
-		sl[2] = 0
-		sl[1]++
+	for _, st := range states {
+		if st.Kind == tasks.ProgressKindCreateEnv && st.Env.Id == envId {
+			return &st.Env, nil
+		}
+	}
+	return nil, nil
+}
+
+func envDNSAPIAddr(env installer.EnvConfig) string {
+	return fmt.Sprintf("http://dns-api.%sdns.svc.cluster.local", env.NamespacePrefix)
+}
+
+// rolloverKSK replaces key signing key of the environment zone. DNS
+// records are not marked as published so that status page offers to
+// publish DS record of the new key.
+func (s *EnvServer) rolloverKSK(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	envId, ok := vars["id"]
+	if !ok {
+		http.Error(w, "Environment id not provided", http.StatusBadRequest)
+		return
+	}
+	env, err := s.findEnv(envId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if sl[1] == 0b11111111 {
-		return nil, fmt.Errorf("Can not allocate")
+	if env == nil {
+		http.Error(w, "Environment not found", http.StatusNotFound)
+		return
 	}
-	ret, ok := netip.AddrFromSlice(sl)
+	key := func() string {
+		for {
+			key, err := s.nameGenerator.Generate()
+			if err == nil {
+				return key
+			}
+		}
+	}()
+	t := s.newKSKRolloverTask(tasks.NewKSKRolloverProgress(s.progress, key, *env))
+	go t.Start()
+	http.Redirect(w, r, fmt.Sprintf("/env/%s", key), http.StatusSeeOther)
+}
+
+func (s *EnvServer) newKSKRolloverTask(progress *tasks.Progress) tasks.Task {
+	key := progress.Key()
+	env := progress.Env()
+	dnsAPIAddr := envDNSAPIAddr(env)
+	t := tasks.NewKSKRolloverTask(env.Domain, dnsAPIAddr, s.httpClient, s.dnsClient, progress)
+	t.OnDone(func(err error) {
+		if err == nil {
+			s.envInfo[key] = template.HTML(fmt.Sprintf("Key signing key of %s has been rolled over.", env.Domain))
+		}
+	})
+	s.Tasks[key] = t
+	s.dns[key] = installer.EnvDNS{
+		Zone:    env.Domain,
+		Address: fmt.Sprintf("%s/records-to-publish", dnsAPIAddr),
+	}
+	return t
+}
+
+// abortKSKRollover drops pending key signing key of the environment zone,
+// rollover task waiting for its DS record fails afterwards.
+func (s *EnvServer) abortKSKRollover(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	envId, ok := vars["id"]
 	if !ok {
-		return nil, fmt.Errorf("Must not reach")
+		http.Error(w, "Environment id not provided", http.StatusBadRequest)
+		return
+	}
+	env, err := s.findEnv(envId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if env == nil {
+		http.Error(w, "Environment not found", http.StatusNotFound)
+		return
+	}
+	if err := tasks.AbortKSKRollover(envDNSAPIAddr(*env), s.httpClient); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// forgetEnv removes tasks which were creating the given environment.
+func (s *EnvServer) forgetEnv(envId string) error {
+	states, err := s.progress.List()
+	if err != nil {
+		return err
+	}
+	for _, st := range states {
+		if st.Env.Id != envId {
+			continue
+		}
+		if err := s.progress.Delete(st.Key); err != nil {
+			return err
+		}
+		delete(s.Tasks, st.Key)
+		delete(s.dns, st.Key)
+		delete(s.envInfo, st.Key)
 	}
-	return net.ParseIP(ret.String()), nil
+	return nil
 }
@@ -0,0 +1,479 @@
+package welcome
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
+	"github.com/giolekva/pcloud/core/installer/tasks"
+)
+
+type Status string
+
+const (
+	StatusActive   Status = "ACTIVE"
+	StatusAccepted Status = "ACCEPTED"
+	StatusRevoked  Status = "REVOKED"
+	// Never persisted, reported for active invitations past their expiry.
+	StatusExpired Status = "EXPIRED"
+)
+
+const (
+	invitationsPath      = "invitations"
+	invitationTTL        = 7 * 24 * time.Hour
+	invitationTokenBytes = 32
+	adminUser            = "admin"
+)
+
+var errInvalidInvitation = errors.New("Invitation is either invalid, expired or already used")
+
+type invitation struct {
+	Token     string    `json:"token"`
+	Status    Status    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Zero value means invitation never expires, which is the case for
+	// the ones created before expiry was introduced.
+	ExpiresAt  time.Time `json:"expiresAt"`
+	AcceptedBy string    `json:"acceptedBy,omitempty"`
+	AcceptedAt time.Time `json:"acceptedAt"`
+	EnvId      string    `json:"envId,omitempty"`
+}
+
+func (i invitation) effectiveStatus(now time.Time) Status {
+	if i.Status == StatusActive && !i.ExpiresAt.IsZero() && now.After(i.ExpiresAt) {
+		return StatusExpired
+	}
+	return i.Status
+}
+
+func newInvitationToken() (string, error) {
+	b := make([]byte, invitationTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func readInvitations(repo soft.RepoFS) ([]invitation, error) {
+	r, err := repo.Reader(invitationsPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return make([]invitation, 0), nil
+		}
+		return nil, err
+	}
+	defer r.Close()
+	dec := json.NewDecoder(r)
+	invitations := make([]invitation, 0)
+	for {
+		var i invitation
+		if err := dec.Decode(&i); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, i)
+	}
+	return invitations, nil
+}
+
+func writeInvitations(repo soft.RepoFS, invitations []invitation) error {
+	w, err := repo.Writer(invitationsPath)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	enc := json.NewEncoder(w)
+	for _, i := range invitations {
+		if err := enc.Encode(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *EnvServer) updateInvitations(msg string, f func(invitations []invitation) ([]invitation, error)) error {
+	return s.repo.Do(func(r soft.RepoFS) (string, error) {
+		invitations, err := readInvitations(r)
+		if err != nil {
+			return "", err
+		}
+		invitations, err = f(invitations)
+		if err != nil {
+			return "", err
+		}
+		if err := writeInvitations(r, invitations); err != nil {
+			return "", err
+		}
+		return msg, nil
+	})
+}
+
+// listInvitations returns all the invitations, most recent first, with
+// expired ones marked as such.
+func (s *EnvServer) listInvitations() ([]invitation, error) {
+	if err := s.repo.Pull(); err != nil {
+		return nil, err
+	}
+	invitations, err := readInvitations(s.repo)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range invitations {
+		invitations[i].Status = invitations[i].effectiveStatus(now)
+	}
+	sort.SliceStable(invitations, func(i, j int) bool {
+		return invitations[i].CreatedAt.After(invitations[j].CreatedAt)
+	})
+	return invitations, nil
+}
+
+func (s *EnvServer) newInvitation(ttl time.Duration) (invitation, error) {
+	token, err := newInvitationToken()
+	if err != nil {
+		return invitation{}, err
+	}
+	now := time.Now().UTC()
+	ret := invitation{
+		Token:     token,
+		Status:    StatusActive,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := s.updateInvitations("Generated new invitation", func(invitations []invitation) ([]invitation, error) {
+		return append(invitations, ret), nil
+	}); err != nil {
+		return invitation{}, err
+	}
+	return ret, nil
+}
+
+func (s *EnvServer) revokeInvitation(token string) error {
+	return s.updateInvitations("Revoked invitation", func(invitations []invitation) ([]invitation, error) {
+		for i := range invitations {
+			if invitations[i].Token != token {
+				continue
+			}
+			if invitations[i].Status != StatusActive {
+				return nil, fmt.Errorf("Invitation is %s", invitations[i].Status)
+			}
+			invitations[i].Status = StatusRevoked
+			return invitations, nil
+		}
+		return nil, fmt.Errorf("Invitation not found")
+	})
+}
+
+// findActiveInvitation returns index of the active, not yet expired,
+// invitation with the given token.
+func findActiveInvitation(invitations []invitation, token string, now time.Time) (int, error) {
+	for i := range invitations {
+		if subtle.ConstantTimeCompare([]byte(invitations[i].Token), []byte(token)) != 1 {
+			continue
+		}
+		if invitations[i].effectiveStatus(now) != StatusActive {
+			return -1, errInvalidInvitation
+		}
+		return i, nil
+	}
+	return -1, errInvalidInvitation
+}
+
+// checkInvitation verifies that invitation can be accepted, without
+// consuming it.
+func (s *EnvServer) checkInvitation(token string) error {
+	if err := s.repo.Pull(); err != nil {
+		return err
+	}
+	invitations, err := readInvitations(s.repo)
+	if err != nil {
+		return err
+	}
+	_, err = findActiveInvitation(invitations, token, time.Now().UTC())
+	return err
+}
+
+// acceptInvitation marks invitation as used to create the given
+// environment. Only active, not yet expired, invitations can be accepted.
+func (s *EnvServer) acceptInvitation(token, envId, acceptedBy string) error {
+	return s.updateInvitations(fmt.Sprintf("%s: accepted invitation", envId), func(invitations []invitation) ([]invitation, error) {
+		now := time.Now().UTC()
+		i, err := findActiveInvitation(invitations, token, now)
+		if err != nil {
+			return nil, err
+		}
+		invitations[i].Status = StatusAccepted
+		invitations[i].AcceptedBy = acceptedBy
+		invitations[i].AcceptedAt = now
+		invitations[i].EnvId = envId
+		return invitations, nil
+	})
+}
+
+// adminOnly lets through requests authenticated with the admin password.
+// Admin endpoints are disabled when no password is configured.
+func (s *EnvServer) adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok ||
+			s.adminPassword == "" ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(adminUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(s.adminPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="env-manager"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+type envSummary struct {
+	Id           string      `json:"id"`
+	CIDR         string      `json:"cidr"`
+	Domain       string      `json:"domain,omitempty"`
+	ContactEmail string      `json:"contactEmail,omitempty"`
+	Configured   bool        `json:"configured"`
+	Status       string      `json:"status"`
+	Error        string      `json:"error,omitempty"`
+	TaskKey      string      `json:"taskKey,omitempty"`
+	DNSPublished bool        `json:"dnsPublished"`
+	Invitation   *invitation `json:"invitation,omitempty"`
+}
+
+func statusName(st tasks.Status) string {
+	switch st {
+	case tasks.StatusRunning:
+		return "running"
+	case tasks.StatusFailed:
+		return "failed"
+	case tasks.StatusDone:
+		return "done"
+	default:
+		return "pending"
+	}
+}
+
+// listEnvs combines environments allocated in env-cidrs.yaml with their
+// GitOps configuration and progress of their creation.
+func (s *EnvServer) listEnvs() ([]envSummary, error) {
+	cidrs, err := s.ipam.Leases()
+	if err != nil {
+		return nil, err
+	}
+	configured := map[string]struct{}{}
+	if kust, err := soft.ReadKustomization(s.repo, "environments/kustomization.yaml"); err == nil {
+		for _, r := range kust.Resources {
+			configured[r] = struct{}{}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	states, err := s.progress.List()
+	if err != nil {
+		return nil, err
+	}
+	byEnv := map[string]tasks.ProgressState{}
+	for _, st := range states {
+		if st.Kind == tasks.ProgressKindCreateEnv {
+			byEnv[st.Env.Id] = st
+		}
+	}
+	invitations, err := s.listInvitations()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]envSummary, 0, len(cidrs))
+	for _, c := range cidrs {
+		e := envSummary{
+			Id:     c.Name,
+			CIDR:   fmt.Sprintf("%s/24", c.IP),
+			Status: "unknown",
+		}
+		_, e.Configured = configured[c.Name]
+		if st, ok := byEnv[c.Name]; ok {
+			p := tasks.ResumeProgress(nil, st)
+			e.Domain = st.Env.Domain
+			e.ContactEmail = st.Env.ContactEmail
+			e.TaskKey = st.Key
+			if t, ok := s.Tasks[st.Key]; ok {
+				e.Status = statusName(t.Status())
+				if t.Err() != nil {
+					e.Error = t.Err().Error()
+				}
+			} else {
+				e.Status = statusName(p.Status())
+			}
+			_, published := s.dnsPublished[st.Key]
+			// Environment can not be created without its DNS records being
+			// published first.
+			e.DNSPublished = published || e.Status == statusName(tasks.StatusDone)
+		}
+		for _, i := range invitations {
+			if i.EnvId == c.Name {
+				inv := i
+				e.Invitation = &inv
+				break
+			}
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *EnvServer) handleListEnvs(w http.ResponseWriter, r *http.Request) {
+	envs, err := s.listEnvs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, envs)
+}
+
+func (s *EnvServer) handleGetEnv(w http.ResponseWriter, r *http.Request) {
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		http.Error(w, "Environment id not provided", http.StatusBadRequest)
+		return
+	}
+	envs, err := s.listEnvs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, e := range envs {
+		if e.Id == id {
+			writeJSON(w, e)
+			return
+		}
+	}
+	http.Error(w, "Environment not found", http.StatusNotFound)
+}
+
+func (s *EnvServer) handleListInvitations(w http.ResponseWriter, r *http.Request) {
+	invitations, err := s.listInvitations()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, invitations)
+}
+
+func parseInvitationTTL(r *http.Request) (time.Duration, error) {
+	v := r.FormValue("ttl")
+	if v == "" {
+		return invitationTTL, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("Invitation TTL must be positive")
+	}
+	return ttl, nil
+}
+
+func (s *EnvServer) handleCreateInvitation(w http.ResponseWriter, r *http.Request) {
+	ttl, err := parseInvitationTTL(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	inv, err := s.newInvitation(ttl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, inv)
+}
+
+func (s *EnvServer) handleRevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	token, ok := mux.Vars(r)["token"]
+	if !ok {
+		http.Error(w, "Invitation token not provided", http.StatusBadRequest)
+		return
+	}
+	if err := s.revokeInvitation(token); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *EnvServer) createInvitation(w http.ResponseWriter, r *http.Request) {
+	inv, err := s.newInvitation(invitationTTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := w.Write([]byte(inv.Token)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *EnvServer) adminConsole(w http.ResponseWriter, r *http.Request) {
+	envs, err := s.listEnvs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	invitations, err := s.listInvitations()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Envs":        envs,
+		"Invitations": invitations,
+	}
+	if err := tmplsParsed.admin.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *EnvServer) adminCreateInvitation(w http.ResponseWriter, r *http.Request) {
+	ttl, err := parseInvitationTTL(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := s.newInvitation(ttl); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (s *EnvServer) adminRevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	token, ok := mux.Vars(r)["token"]
+	if !ok {
+		http.Error(w, "Invitation token not provided", http.StatusBadRequest)
+		return
+	}
+	if err := s.revokeInvitation(token); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
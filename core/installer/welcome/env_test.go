@@ -3,15 +3,18 @@ package welcome
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"golang.org/x/crypto/ssh"
 	"io"
 	"io/fs"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
@@ -20,7 +23,9 @@ import (
 	// "github.com/go-git/go-git/v5/storage/memory"
 
 	"github.com/giolekva/pcloud/core/installer"
+	pio "github.com/giolekva/pcloud/core/installer/io"
 	"github.com/giolekva/pcloud/core/installer/soft"
+	"github.com/giolekva/pcloud/core/installer/tasks"
 )
 
 type fakeNSCreator struct {
@@ -32,6 +37,28 @@ func (f fakeNSCreator) Create(name string) error {
 	return nil
 }
 
+func (f fakeNSCreator) Exists(name string) (bool, error) {
+	return false, nil
+}
+
+func (f fakeNSCreator) Delete(name string) error {
+	f.t.Logf("Delete namespace: %s", name)
+	return nil
+}
+
+func (f fakeNSCreator) List() ([]string, error) {
+	return []string{}, nil
+}
+
+type fakeClusterIssuerDeleter struct {
+	t *testing.T
+}
+
+func (f fakeClusterIssuerDeleter) Delete(name string) error {
+	f.t.Logf("Delete cluster issuer: %s", name)
+	return nil
+}
+
 type fakeZoneStatusFetcher struct {
 	t *testing.T
 }
@@ -72,6 +99,11 @@ func (r mockRepoIO) Do(op soft.DoFn, _ ...soft.DoOption) error {
 	return r.CommitAndPush(msg)
 }
 
+func (r mockRepoIO) Revert(commit string, message string) error {
+	r.t.Logf("Revert: %s %s", commit, message)
+	return nil
+}
+
 type fakeSoftServeClient struct {
 	t     *testing.T
 	envFS billy.Filesystem
@@ -174,6 +206,19 @@ func (f fakeHttpClient) Get(addr string) (*http.Response, error) {
 	}, nil
 }
 
+func (f fakeHttpClient) Post(addr, contentType string, body io.Reader) (*http.Response, error) {
+	f.t.Logf("HTTP POST: %s", addr)
+	f.counts[addr]++
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.0",
+		ProtoMajor: 1,
+		ProtoMinor: 0,
+		Body:       io.NopCloser(strings.NewReader("{}")),
+	}, nil
+}
+
 type fakeDnsClient struct {
 	t      *testing.T
 	counts map[string]int
@@ -184,6 +229,11 @@ func (f fakeDnsClient) Lookup(host string) ([]net.IP, error) {
 	return []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("2.2.2.2")}, nil
 }
 
+func (f fakeDnsClient) LookupDS(zone string) ([]string, error) {
+	f.t.Logf("DS LOOKUP: %s", zone)
+	return []string{}, nil
+}
+
 func TestCreateNewEnv(t *testing.T) {
 	apps := installer.NewInMemoryAppRepository(installer.CreateAllApps())
 	infraFS := memfs.New()
@@ -224,7 +274,15 @@ func TestCreateNewEnv(t *testing.T) {
 		fixedNameGenerator{},
 		httpClient,
 		dnsClient,
+		fakeClusterIssuerDeleter{t},
+		"admin",
+		installer.NewIPAM(infraRepo, nil),
+		nil,
+		"",
 	)
+	if err := util.WriteFile(infraFS, "invitations", []byte(`{"token":"test","status":"ACTIVE"}`), fs.ModePerm); err != nil {
+		t.Fatal(err)
+	}
 	go s.Start()
 	req := createEnvReq{
 		Name:           "test",
@@ -277,6 +335,183 @@ func TestCreateNewEnv(t *testing.T) {
 	}
 }
 
+type listingNSCreator struct {
+	fakeNSCreator
+	namespaces []string
+	deleted    map[string]struct{}
+}
+
+func (f listingNSCreator) List() ([]string, error) {
+	return f.namespaces, nil
+}
+
+func (f listingNSCreator) Delete(name string) error {
+	f.deleted[name] = struct{}{}
+	return nil
+}
+
+func TestDeleteEnv(t *testing.T) {
+	apps := installer.NewInMemoryAppRepository(installer.CreateAllApps())
+	infraFS := memfs.New()
+	nsCreator := listingNSCreator{fakeNSCreator{t}, []string{"test", "test-dns", "testing", "other-dns", "infra", "infra-dns"}, map[string]struct{}{}}
+	infraRepo := mockRepoIO{soft.NewBillyRepoFS(infraFS), "foo.bar", t, &sync.Mutex{}}
+	infraMgr, err := installer.NewInfraAppManager(infraRepo, nsCreator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFile(infraFS, "config.yaml", []byte(infraConfig), fs.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	cidrs := installer.EnvCIDRs{{Name: "test", IP: net.ParseIP("10.1.0.0")}, {Name: "other", IP: net.ParseIP("10.1.1.0")}}
+	if err := soft.WriteYaml(infraRepo, "env-cidrs.yaml", cidrs); err != nil {
+		t.Fatal(err)
+	}
+	infra, err := infraMgr.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipam := installer.NewIPAM(infraRepo, nil)
+	progress := tasks.NewRepoProgressStore(infraRepo, "/env-tasks", nil, "")
+	if err := tasks.ValidateEnvDeletion("test", infra, ipam, progress); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"infra", "unknown"} {
+		if err := tasks.ValidateEnvDeletion(id, infra, ipam, progress); !errors.Is(err, tasks.ErrUnknownEnv) {
+			t.Fatalf("Expected %s deletion to be refused, got %v", id, err)
+		}
+	}
+	kust := pio.NewKustomization()
+	kust.AddResources("test", "other")
+	if err := soft.WriteYaml(infraRepo, "environments/kustomization.yaml", kust); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFile(infraFS, "environments/test/kustomization.yaml", []byte{}, fs.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	app, err := installer.FindInfraApp(apps, "dns-gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := infraMgr.Install(app, "/infrastructure/dns-gateway", "dns-gateway", map[string]any{
+		"servers": []installer.EnvDNS{{Zone: "test.t", Address: "10.44.1.0"}, {Zone: "other.t", Address: "10.44.1.1"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Deletion is run twice to verify that it can be retried.
+	for i := 0; i < 2; i++ {
+		task := tasks.NewDeleteEnvTask("test", infra, infraRepo, infraMgr, nsCreator, fakeClusterIssuerDeleter{t})
+		done := make(chan error)
+		task.OnDone(func(err error) {
+			done <- err
+		})
+		go task.Start()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := soft.ReadYaml(infraRepo, "env-cidrs.yaml", &cidrs); err != nil {
+		t.Fatal(err)
+	} else if len(cidrs) != 1 || cidrs[0].Name != "other" {
+		t.Fatalf("Unexpected CIDRs: %+v", cidrs)
+	}
+	if k, err := soft.ReadKustomization(infraRepo, "environments/kustomization.yaml"); err != nil {
+		t.Fatal(err)
+	} else if len(k.Resources) != 1 || k.Resources[0] != "other" {
+		t.Fatalf("Unexpected environments: %+v", k.Resources)
+	}
+	if _, err := infraFS.Stat("environments/test"); err == nil {
+		t.Fatal("Expected environment configuration to be removed")
+	}
+	cfg, err := infraMgr.FindInstance("dns-gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servers, err := json.Marshal(cfg.Values["servers"]); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(servers), "test.t") || !strings.Contains(string(servers), "other.t") {
+		t.Fatalf("Unexpected DNS servers: %s", servers)
+	}
+	if len(nsCreator.deleted) != 2 {
+		t.Fatalf("Unexpected deleted namespaces: %v", nsCreator.deleted)
+	}
+	for _, ns := range []string{"test", "test-dns"} {
+		if _, ok := nsCreator.deleted[ns]; !ok {
+			t.Fatalf("Expected %s to be deleted", ns)
+		}
+	}
+}
+
+func TestInvitations(t *testing.T) {
+	infraFS := memfs.New()
+	infraRepo := mockRepoIO{soft.NewBillyRepoFS(infraFS), "foo.bar", t, &sync.Mutex{}}
+	s := NewEnvServer(8182, nil, infraRepo, nil, fakeNSCreator{t}, nil, fixedNameGenerator{}, nil, nil, nil, "secret", installer.NewIPAM(infraRepo, nil), nil, "")
+	inv, err := s.newInvitation(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Token) < 40 {
+		t.Fatalf("Token is too short: %s", inv.Token)
+	}
+	if err := s.acceptInvitation("unknown", "env", "foo@bar.t"); !errors.Is(err, errInvalidInvitation) {
+		t.Fatalf("Expected invalid invitation, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.checkInvitation(inv.Token); err != nil {
+			t.Fatalf("Expected checking invitation not to consume it, got %v", err)
+		}
+	}
+	if err := s.acceptInvitation(inv.Token, "env", "foo@bar.t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.acceptInvitation(inv.Token, "other", "foo@bar.t"); !errors.Is(err, errInvalidInvitation) {
+		t.Fatalf("Expected invitation to be accepted only once, got %v", err)
+	}
+	revoked, err := s.newInvitation(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.revokeInvitation(revoked.Token); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.acceptInvitation(revoked.Token, "env", "foo@bar.t"); !errors.Is(err, errInvalidInvitation) {
+		t.Fatalf("Expected revoked invitation to be rejected, got %v", err)
+	}
+	expired, err := s.newInvitation(time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := s.acceptInvitation(expired.Token, "env", "foo@bar.t"); !errors.Is(err, errInvalidInvitation) {
+		t.Fatalf("Expected expired invitation to be rejected, got %v", err)
+	}
+	invitations, err := s.listInvitations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := map[string]Status{}
+	for _, i := range invitations {
+		statuses[i.Token] = i.Status
+	}
+	if statuses[inv.Token] != StatusAccepted || statuses[revoked.Token] != StatusRevoked || statuses[expired.Token] != StatusExpired {
+		t.Fatalf("Unexpected invitations: %+v", invitations)
+	}
+	for _, i := range invitations {
+		if i.Token == inv.Token && (i.AcceptedBy != "foo@bar.t" || i.EnvId != "env") {
+			t.Fatalf("Expected acceptance to be recorded: %+v", i)
+		}
+	}
+	h := s.adminOnly(func(w http.ResponseWriter, r *http.Request) {})
+	for password, expected := range map[string]int{"secret": http.StatusOK, "wrong": http.StatusUnauthorized} {
+		req := httptest.NewRequest("GET", "/api/invitations", nil)
+		req.SetBasicAuth("admin", password)
+		w := httptest.NewRecorder()
+		h(w, req)
+		if w.Code != expected {
+			t.Fatalf("Expected %d, got %d", expected, w.Code)
+		}
+	}
+}
+
 func debugFS(bfs billy.Filesystem, t *testing.T, files ...string) {
 	f := map[string]struct{}{}
 	for _, i := range files {
@@ -302,11 +302,13 @@ func (h *Handler) processCA(w http.ResponseWriter, r *http.Request) {
 }
 
 type processNodeReq struct {
-	CAPrivateKey  []byte `json:"ca_private_key"`
-	CACert        []byte `json:"ca_certificate"`
-	NodeName      string `json:"node_name"`
-	NodePublicKey []byte `json:"node_public_key,omitempty"`
-	NodeIPCidr    string `json:"node_ip_cidr"`
+	CAPrivateKey  []byte        `json:"ca_private_key"`
+	CACert        []byte        `json:"ca_certificate"`
+	NodeName      string        `json:"node_name"`
+	NodePublicKey []byte        `json:"node_public_key,omitempty"`
+	NodeIPCidr    string        `json:"node_ip_cidr"`
+	NodeGroups    []string      `json:"node_groups,omitempty"`
+	Validity      time.Duration `json:"validity,omitempty"`
 }
 
 func (h *Handler) processNode(w http.ResponseWriter, r *http.Request) {
@@ -320,7 +322,7 @@ func (h *Handler) processNode(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	node, err := SignNebulaNode(req.CAPrivateKey, req.CACert, req.NodeName, req.NodePublicKey, ipNet)
+	node, err := SignNebulaNode(req.CAPrivateKey, req.CACert, req.NodeName, req.NodePublicKey, ipNet, req.NodeGroups, req.Validity)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -332,6 +334,44 @@ func (h *Handler) processNode(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type revokeReq struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+type revokeResp struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+func (h *Handler) revoke(w http.ResponseWriter, r *http.Request) {
+	var req revokeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Fingerprint == "" {
+		http.Error(w, "Fingerprint is required", http.StatusBadRequest)
+		return
+	}
+	if err := h.mgr.Revoke(req.Fingerprint); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) revokeNode(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fingerprint, err := h.mgr.RevokeNode(vars["namespace"], vars["name"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(revokeResp{fingerprint}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 func loadConfigTemplate(path string) (map[string]interface{}, error) {
 	tmpl, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -381,6 +421,8 @@ func main() {
 	r.HandleFunc("/api/get/{name:[a-zA-z0-9-]+}", handler.get)
 	r.HandleFunc("/api/process/authority", handler.processCA)
 	r.HandleFunc("/api/process/node", handler.processNode)
+	r.HandleFunc("/api/revoke", handler.revoke).Methods(http.MethodPost)
+	r.HandleFunc("/api/revoke/{namespace:[a-zA-z0-9-]+}/{name:[a-zA-z0-9-]+}", handler.revokeNode).Methods(http.MethodPost)
 	r.HandleFunc("/node/{namespace:[a-zA-z0-9-]+}/{name:[a-zA-z0-9-]+}", handler.handleNode)
 	r.HandleFunc("/ca/{namespace:[a-zA-z0-9-]+}/{name:[a-zA-z0-9-]+}", handler.handleCA)
 	r.HandleFunc("/", handler.handleIndex)
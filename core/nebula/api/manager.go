@@ -16,6 +16,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 
 	nebulav1 "github.com/giolekva/pcloud/core/nebula/controller/apis/nebula/v1"
 	clientset "github.com/giolekva/pcloud/core/nebula/controller/generated/clientset/versioned"
@@ -97,7 +98,15 @@ func (m *Manager) CreateNode(namespace, name, caNamespace, caName, ipCidr, pubKe
 }
 
 func (m *Manager) GetNodeConfig(namespace, name string) (map[string]interface{}, error) {
-	secret, err := m.getNodeSecret(namespace, name)
+	node, err := m.nebulaClient.LekvaV1().NebulaNodes(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	secret, err := m.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), node.Spec.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ca, err := m.nebulaClient.LekvaV1().NebulaCAs(node.Spec.CANamespace).Get(context.TODO(), node.Spec.CAName, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -112,9 +121,61 @@ func (m *Manager) GetNodeConfig(namespace, name string) (map[string]interface{},
 	}
 	pki["ca"] = string(secret.Data["ca.crt"])
 	pki["cert"] = string(secret.Data["host.crt"])
+	if len(ca.Spec.Blocklist) > 0 {
+		pki["blocklist"] = ca.Spec.Blocklist
+	}
 	return c, nil
 }
 
+// Revoke adds certificate fingerprint to the blocklist of the managed CA.
+func (m *Manager) Revoke(fingerprint string) error {
+	return m.addToBlocklist(m.namespace, m.caName, fingerprint)
+}
+
+// RevokeNode blocklists current certificate of the node and stops the
+// controller from renewing it. Returns fingerprint of the revoked
+// certificate.
+func (m *Manager) RevokeNode(namespace, name string) (string, error) {
+	node, err := m.nebulaClient.LekvaV1().NebulaNodes(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	secret, err := m.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), node.Spec.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	fingerprint, err := CertificateFingerprint(secret.Data["host.crt"])
+	if err != nil {
+		return "", err
+	}
+	if err := m.addToBlocklist(node.Spec.CANamespace, node.Spec.CAName, fingerprint); err != nil {
+		return "", err
+	}
+	node.Status.State = nebulav1.NebulaNodeStateRevoked
+	node.Status.Message = fmt.Sprintf("Revoked certificate %s", fingerprint)
+	if _, err := m.nebulaClient.LekvaV1().NebulaNodes(namespace).UpdateStatus(context.TODO(), node, metav1.UpdateOptions{}); err != nil {
+		return "", err
+	}
+	return fingerprint, nil
+}
+
+func (m *Manager) addToBlocklist(namespace, name, fingerprint string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ca, err := m.nebulaClient.LekvaV1().NebulaCAs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for _, f := range ca.Spec.Blocklist {
+			if f == fingerprint {
+				return nil
+			}
+		}
+		ca.Spec.Blocklist = append(ca.Spec.Blocklist, fingerprint)
+		_, err = m.nebulaClient.LekvaV1().NebulaCAs(namespace).Update(context.TODO(), ca, metav1.UpdateOptions{})
+		return err
+	})
+}
+
 func (m *Manager) GetNodeCertQR(namespace, name string) ([]byte, error) {
 	secret, err := m.getNodeSecret(namespace, name)
 	if err != nil {
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nebulav1 "github.com/giolekva/pcloud/core/nebula/controller/apis/nebula/v1"
+	"github.com/giolekva/pcloud/core/nebula/controller/generated/clientset/versioned/fake"
+)
+
+func TestAddToBlocklistIsIdempotent(t *testing.T) {
+	nebulaClient := fake.NewSimpleClientset(&nebulav1.NebulaCA{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ca",
+			Namespace: "test",
+		},
+	})
+	m := &Manager{nebulaClient: nebulaClient, namespace: "test", caName: "ca"}
+	for _, f := range []string{"foo", "bar", "foo"} {
+		if err := m.Revoke(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ca, err := nebulaClient.LekvaV1().NebulaCAs("test").Get(context.TODO(), "ca", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ca.Spec.Blocklist) != 2 || ca.Spec.Blocklist[0] != "foo" || ca.Spec.Blocklist[1] != "bar" {
+		t.Fatalf("Expected each fingerprint to be blocklisted once, got %v", ca.Spec.Blocklist)
+	}
+}
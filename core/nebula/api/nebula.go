@@ -47,11 +47,16 @@ func CreateCertificateAuthority(name string) (*CertificateAuthority, error) {
 }
 
 type NebulaNode struct {
-	PrivateKey  []byte `json:"private_key,omitempty"`
-	Certificate []byte `json:"certificate"`
+	PrivateKey  []byte    `json:"private_key,omitempty"`
+	Certificate []byte    `json:"certificate"`
+	Fingerprint string    `json:"fingerprint"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
 }
 
-func SignNebulaNode(rawCAPrivateKey []byte, rawCACert []byte, nodeName string, nodePublicKey []byte, ip *net.IPNet) (*NebulaNode, error) {
+// SignNebulaNode issues node certificate valid for the given duration, capped
+// by the expiration of the CA. Zero validity means until the CA expires.
+func SignNebulaNode(rawCAPrivateKey []byte, rawCACert []byte, nodeName string, nodePublicKey []byte, ip *net.IPNet, groups []string, validity time.Duration) (*NebulaNode, error) {
 	caKey, _, err := cert.UnmarshalEd25519PrivateKey(rawCAPrivateKey)
 	if err != nil {
 		return nil, err
@@ -88,12 +93,17 @@ func SignNebulaNode(rawCAPrivateKey []byte, rawCACert []byte, nodeName string, n
 		priv = cert.MarshalX25519PrivateKey(rawPriv)
 	}
 	t := time.Now().Add(time.Duration(-1 * time.Second))
+	notAfter := caCert.Details.NotAfter.Add(time.Duration(-1 * time.Second))
+	if validity > 0 && t.Add(validity).Before(notAfter) {
+		notAfter = t.Add(validity)
+	}
 	nc := cert.NebulaCertificate{
 		Details: cert.NebulaCertificateDetails{
 			Name:      nodeName,
 			Ips:       []*net.IPNet{ip},
+			Groups:    groups,
 			NotBefore: t,
-			NotAfter:  caCert.Details.NotAfter.Add(time.Duration(-1 * time.Second)),
+			NotAfter:  notAfter,
 			PublicKey: pub,
 			IsCA:      false,
 			Issuer:    issuer,
@@ -109,12 +119,29 @@ func SignNebulaNode(rawCAPrivateKey []byte, rawCACert []byte, nodeName string, n
 	if err != nil {
 		return nil, err
 	}
+	fingerprint, err := nc.Sha256Sum()
+	if err != nil {
+		return nil, err
+	}
 	return &NebulaNode{
 		PrivateKey:  priv,
 		Certificate: certSerialized,
+		Fingerprint: fingerprint,
+		NotBefore:   nc.Details.NotBefore,
+		NotAfter:    nc.Details.NotAfter,
 	}, nil
 }
 
+// CertificateFingerprint returns fingerprint of the PEM encoded certificate,
+// as used by nebula blocklist.
+func CertificateFingerprint(rawCert []byte) (string, error) {
+	c, _, err := cert.UnmarshalNebulaCertificateFromPEM(rawCert)
+	if err != nil {
+		return "", err
+	}
+	return c.Sha256Sum()
+}
+
 func x25519Keypair() ([]byte, []byte, error) {
 	var pubkey, privkey [32]byte
 	if _, err := io.ReadFull(rand.Reader, privkey[:]); err != nil {
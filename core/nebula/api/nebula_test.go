@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+)
+
+func signTestNode(t *testing.T, groups []string, validity time.Duration) (*CertificateAuthority, *cert.NebulaCertificate) {
+	ca, err := CreateCertificateAuthority("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ip, err := net.ParseCIDR("10.1.0.1/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := SignNebulaNode(ca.PrivateKey, ca.Certificate, "node", nil, ip, groups, validity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, _, err := cert.UnmarshalNebulaCertificateFromPEM(node.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nc.Details.NotAfter.Equal(node.NotAfter) {
+		t.Fatalf("Expected reported expiration %s to match certificate %s", node.NotAfter, nc.Details.NotAfter)
+	}
+	return ca, nc
+}
+
+func TestSignNebulaNodeValidity(t *testing.T) {
+	_, nc := signTestNode(t, nil, time.Hour)
+	if d := nc.Details.NotAfter.Sub(nc.Details.NotBefore); d != time.Hour {
+		t.Fatalf("Expected certificate to be valid for an hour, got %s", d)
+	}
+}
+
+func TestSignNebulaNodeValidityCappedByCA(t *testing.T) {
+	ca, nc := signTestNode(t, nil, 2*8760*time.Hour)
+	caCert, _, err := cert.UnmarshalNebulaCertificateFromPEM(ca.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nc.Details.NotAfter.After(caCert.Details.NotAfter) {
+		t.Fatalf("Expected certificate to expire before CA: %s > %s", nc.Details.NotAfter, caCert.Details.NotAfter)
+	}
+	if err := nc.CheckRootConstrains(caCert); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignNebulaNodeGroups(t *testing.T) {
+	_, nc := signTestNode(t, []string{"admin", "dev"}, 0)
+	if len(nc.Details.Groups) != 2 || nc.Details.Groups[0] != "admin" || nc.Details.Groups[1] != "dev" {
+		t.Fatalf("Expected groups to be embedded in the certificate, got %v", nc.Details.Groups)
+	}
+}
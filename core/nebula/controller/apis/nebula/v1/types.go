@@ -17,6 +17,9 @@ type NebulaCA struct {
 
 type NebulaCASpec struct {
 	SecretName string `json:"secretName"`
+	// Fingerprints of revoked node certificates, distributed to all nodes as
+	// pki.blocklist.
+	Blocklist []string `json:"blocklist,omitempty"`
 }
 
 type NebulaCAStatus struct {
@@ -57,11 +60,20 @@ type NebulaNodeSpec struct {
 	PubKey      string `json:"pubKey"`
 	EncPubKey   string `json:"encPubKey"`
 	SecretName  string `json:"secretName"`
+	// Groups are embedded in the certificate and can be used in firewall
+	// rules. Changes take effect once the certificate is renewed.
+	Groups []string `json:"groups,omitempty"`
+	// How long certificate is valid for. Certificate is renewed once a
+	// quarter of it remains. If not set, certificate expires together with
+	// the CA and is never renewed.
+	Validity *metav1.Duration `json:"validity,omitempty"`
 }
 
 type NebulaNodeStatus struct {
-	State   NebulaNodeState `json:"state,omitempty"`
-	Message string          `json:"message,omitempty"`
+	State     NebulaNodeState `json:"state,omitempty"`
+	Message   string          `json:"message,omitempty"`
+	NotBefore *metav1.Time    `json:"notBefore,omitempty"`
+	NotAfter  *metav1.Time    `json:"notAfter,omitempty"`
 }
 
 type NebulaNodeState string
@@ -70,6 +82,8 @@ const (
 	NebulaNodeStateCreating NebulaNodeState = "Creating"
 	NebulaNodeStateReady    NebulaNodeState = "Ready"
 	NebulaNodeStateError    NebulaNodeState = "Error"
+	// Certificate of the node is blocklisted and will not be renewed.
+	NebulaNodeStateRevoked NebulaNodeState = "Revoked"
 )
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
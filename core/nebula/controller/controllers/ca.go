@@ -216,8 +216,10 @@ func (c *NebulaController) processNodeWithKey(key string) error {
 		}
 		return err
 	}
-	if node.Status.State == nebulav1.NebulaNodeStateReady {
-		fmt.Printf("%s Node is already in Ready state\n", node.Name)
+	if node.Status.State == nebulav1.NebulaNodeStateRevoked {
+		return nil
+	}
+	if node.Status.State == nebulav1.NebulaNodeStateReady && !needsRenewal(node, time.Now()) {
 		return nil
 	}
 	ca, err := c.caLister.NebulaCAs(node.Spec.CANamespace).Get(node.Spec.CAName)
@@ -238,30 +240,74 @@ func (c *NebulaController) processNodeWithKey(key string) error {
 	if node.Spec.PubKey != "" {
 		pubKey = []byte(node.Spec.PubKey)
 	}
-	privKey, nodeCert, err := SignNebulaNode(apiAddr(ca.Name), caSecret.Data["ca.key"], caSecret.Data["ca.crt"], node.Name, pubKey, node.Spec.IPCidr)
+	var validity time.Duration
+	if node.Spec.Validity != nil {
+		validity = node.Spec.Validity.Duration
+	}
+	signed, err := SignNebulaNode(apiAddr(ca.Name), caSecret.Data["ca.key"], caSecret.Data["ca.crt"], node.Name, pubKey, node.Spec.IPCidr, node.Spec.Groups, validity)
 	if err != nil {
 		return err
 	}
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: node.Spec.SecretName,
-		},
-		Immutable: &secretImmutable,
-		Data: map[string][]byte{
-			"ca.crt":   caSecret.Data["ca.crt"],
-			"host.crt": nodeCert,
-			"host.key": privKey,
-		},
+	data := map[string][]byte{
+		"ca.crt":   caSecret.Data["ca.crt"],
+		"host.crt": signed.Certificate,
+		"host.key": signed.PrivateKey,
 	}
-	_, err = c.kubeClient.CoreV1().Secrets(namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
-	if err != nil {
+	if err := c.writeNodeSecret(namespace, node.Spec.SecretName, data); err != nil {
 		return err
 	}
-	err = c.updateNodeStatus(node, nebulav1.NebulaNodeStateReady, "Generated credentials")
-	if err != nil {
+	cp := node.DeepCopy()
+	cp.Status.State = nebulav1.NebulaNodeStateReady
+	cp.Status.Message = fmt.Sprintf("Generated certificate %s", signed.Fingerprint)
+	notBefore := metav1.NewTime(signed.NotBefore)
+	notAfter := metav1.NewTime(signed.NotAfter)
+	cp.Status.NotBefore = &notBefore
+	cp.Status.NotAfter = &notAfter
+	_, err = c.nebulaClient.LekvaV1().NebulaNodes(cp.Namespace).UpdateStatus(context.TODO(), cp, metav1.UpdateOptions{})
+	return err
+}
+
+// needsRenewal reports whether a quarter of the node certificate validity
+// remains. Nodes are resynced periodically by the informer, which is what
+// eventually triggers the renewal. Certificates capped by the CA expiration
+// can not be extended and are left alone.
+func needsRenewal(node *nebulav1.NebulaNode, now time.Time) bool {
+	if node.Spec.Validity == nil || node.Status.NotBefore == nil || node.Status.NotAfter == nil {
+		return false
+	}
+	validity := node.Spec.Validity.Duration
+	if node.Status.NotAfter.Sub(node.Status.NotBefore.Time) < validity {
+		return false
+	}
+	return !now.Before(node.Status.NotAfter.Add(-validity / 4))
+}
+
+// writeNodeSecret creates or replaces node credentials. Secrets created by
+// previous versions of the controller are immutable and are recreated.
+func (c *NebulaController) writeNodeSecret(namespace, name string, data map[string][]byte) error {
+	secrets := c.kubeClient.CoreV1().Secrets(namespace)
+	existing, err := secrets.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
 		return err
 	}
-	return nil
+	if err == nil && (existing.Immutable == nil || !*existing.Immutable) {
+		existing.Data = data
+		_, err := secrets.Update(context.TODO(), existing, metav1.UpdateOptions{})
+		return err
+	}
+	if err == nil {
+		if err := secrets.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Data: data,
+	}
+	_, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+	return err
 }
 
 func (c *NebulaController) updateCAStatus(ca *nebulav1.NebulaCA, state nebulav1.NebulaCAState, msg string) error {
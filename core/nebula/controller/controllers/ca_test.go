@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	nebulav1 "github.com/giolekva/pcloud/core/nebula/controller/apis/nebula/v1"
+)
+
+func TestNeedsRenewal(t *testing.T) {
+	now := time.Now()
+	node := func(validity time.Duration, notBefore, notAfter time.Time) *nebulav1.NebulaNode {
+		nb := metav1.NewTime(notBefore)
+		na := metav1.NewTime(notAfter)
+		return &nebulav1.NebulaNode{
+			Spec: nebulav1.NebulaNodeSpec{
+				Validity: &metav1.Duration{Duration: validity},
+			},
+			Status: nebulav1.NebulaNodeStatus{
+				NotBefore: &nb,
+				NotAfter:  &na,
+			},
+		}
+	}
+	for _, test := range []struct {
+		name     string
+		node     *nebulav1.NebulaNode
+		expected bool
+	}{
+		{"no validity", &nebulav1.NebulaNode{}, false},
+		{"fresh", node(4*time.Hour, now, now.Add(4*time.Hour)), false},
+		{"before threshold", node(4*time.Hour, now.Add(-2*time.Hour), now.Add(2*time.Hour)), false},
+		{"at threshold", node(4*time.Hour, now.Add(-3*time.Hour), now.Add(time.Hour)), true},
+		{"expired", node(4*time.Hour, now.Add(-5*time.Hour), now.Add(-time.Hour)), true},
+		{"capped by ca", node(4*time.Hour, now.Add(-2*time.Hour), now.Add(time.Minute)), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := needsRenewal(test.node, now); actual != test.expected {
+				t.Fatalf("Expected %t, got %t", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestWriteNodeSecretRecreatesImmutable(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "node",
+			Namespace: "test",
+		},
+		Immutable: &secretImmutable,
+		Data: map[string][]byte{
+			"host.crt": []byte("old"),
+		},
+	})
+	c := &NebulaController{kubeClient: kubeClient}
+	if err := c.writeNodeSecret("test", "node", map[string][]byte{"host.crt": []byte("new")}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := kubeClient.CoreV1().Secrets("test").Get(context.TODO(), "node", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Immutable != nil && *secret.Immutable {
+		t.Fatal("Expected secret to be recreated as mutable")
+	}
+	if string(secret.Data["host.crt"]) != "new" {
+		t.Fatalf("Expected new certificate, got %s", secret.Data["host.crt"])
+	}
+	if err := c.writeNodeSecret("test", "node", map[string][]byte{"host.crt": []byte("renewed")}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err = kubeClient.CoreV1().Secrets("test").Get(context.TODO(), "node", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["host.crt"]) != "renewed" {
+		t.Fatalf("Expected renewed certificate, got %s", secret.Data["host.crt"])
+	}
+}
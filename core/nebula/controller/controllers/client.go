@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 )
 
 type createCAReq struct {
@@ -39,29 +42,36 @@ func CreateCertificateAuthority(apiAddr, name string) ([]byte, []byte, error) {
 }
 
 type signNodeReq struct {
-	CAPrivateKey  []byte `json:"ca_private_key"`
-	CACert        []byte `json:"ca_certificate"`
-	NodeName      string `json:"node_name"`
-	NodePublicKey []byte `json:"node_public_key,omitempty"`
-	NodeIPCidr    string `json:"node_ip_cidr"`
+	CAPrivateKey  []byte        `json:"ca_private_key"`
+	CACert        []byte        `json:"ca_certificate"`
+	NodeName      string        `json:"node_name"`
+	NodePublicKey []byte        `json:"node_public_key,omitempty"`
+	NodeIPCidr    string        `json:"node_ip_cidr"`
+	NodeGroups    []string      `json:"node_groups,omitempty"`
+	Validity      time.Duration `json:"validity,omitempty"`
 }
 
-type signNodeResp struct {
-	PrivateKey  []byte `json:"private_key,omitempty"`
-	Certificate []byte `json:"certificate"`
+type SignedNode struct {
+	PrivateKey  []byte    `json:"private_key,omitempty"`
+	Certificate []byte    `json:"certificate"`
+	Fingerprint string    `json:"fingerprint"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
 }
 
-func SignNebulaNode(apiAddr string, caPrivateKey, caCert []byte, nodeName string, nodePublicKey []byte, nodeIp string) ([]byte, []byte, error) {
+func SignNebulaNode(apiAddr string, caPrivateKey, caCert []byte, nodeName string, nodePublicKey []byte, nodeIp string, groups []string, validity time.Duration) (*SignedNode, error) {
 	req := signNodeReq{
 		caPrivateKey,
 		caCert,
 		nodeName,
 		nodePublicKey,
 		nodeIp,
+		groups,
+		validity,
 	}
 	var data bytes.Buffer
 	if err := json.NewEncoder(&data).Encode(req); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	client := &http.Client{
 		// TODO(giolekva): remove, for some reason valid certificates are not accepted on gioui android.
@@ -71,11 +81,16 @@ func SignNebulaNode(apiAddr string, caPrivateKey, caCert []byte, nodeName string
 	}
 	resp, err := client.Post(apiAddr+"/api/process/node", "application/json", &data)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	var ret signNodeResp
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Could not sign node certificate: %s %s", resp.Status, msg)
+	}
+	var ret SignedNode
 	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	return ret.PrivateKey, ret.Certificate, nil
+	return &ret, nil
 }
@@ -66,6 +66,7 @@ func newServer(port int, client client) *server {
 
 func (s *server) Start() error {
 	s.r.HandleFunc("/api/allocate", s.handleAllocate)
+	s.r.HandleFunc("/api/remove", s.handleRemove)
 	if err := s.s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return err
 	}
@@ -142,6 +143,21 @@ func addPort(pm map[string]any, req allocateReq) error {
 	return nil
 }
 
+var errPortNotFound = fmt.Errorf("port not found")
+
+func removePort(pm map[string]any, req allocateReq) error {
+	sourcePortStr := strconv.Itoa(req.SourcePort)
+	v, ok := pm[sourcePortStr]
+	if !ok {
+		return errPortNotFound
+	}
+	if v != fmt.Sprintf("%s:%d", req.TargetService, req.TargetPort) {
+		return fmt.Errorf("port %d is mapped to %v", req.SourcePort, v)
+	}
+	delete(pm, sourcePortStr)
+	return nil
+}
+
 func (s *server) handleAllocate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "only post method is supported", http.StatusBadRequest)
@@ -190,6 +206,45 @@ func (s *server) handleAllocate(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *server) handleRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only post method is supported", http.StatusBadRequest)
+		return
+	}
+	req, err := extractAllocateReq(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ingressRel, err := s.client.ReadRelease()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tcp, udp, err := extractPorts(ingressRel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pm := tcp
+	if req.Protocol == "udp" {
+		pm = udp
+	}
+	if err := removePort(pm, req); err != nil {
+		if err == errPortNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+		return
+	}
+	commitMsg := fmt.Sprintf("ingress: remove port map %d %s", req.SourcePort, req.Protocol)
+	if err := s.client.WriteRelease(ingressRel, commitMsg); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // TODO(gio): deduplicate
 func createRepoClient(addr string, keyPath string) (soft.RepoIO, error) {
 	sshKey, err := os.ReadFile(keyPath)
@@ -163,3 +163,80 @@ func TestAllocate443Taken(t *testing.T) {
 		t.Fatalf("Expected %d, got %d", http.StatusConflict, resp.StatusCode)
 	}
 }
+
+func TestRemoveSucceeds(t *testing.T) {
+	c := &fakeClient{map[string]any{
+		"spec": map[string]any{
+			"values": map[string]any{
+				"tcp": map[string]any{
+					"2222": "foo:22",
+				},
+			},
+		},
+	}}
+	s := newServer(8080, c) // TODO(gio): run using unix socket
+	go func() {
+		s.Start()
+	}()
+	defer s.Close()
+	var buf bytes.Buffer
+	req := allocateReq{
+		Protocol:      "TCP",
+		SourcePort:    2222,
+		TargetService: "foo",
+		TargetPort:    22,
+	}
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post("http://localhost:8080/api/remove", "application/json", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(os.Stdout, resp.Body)
+		t.Fatalf("Expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	expected := map[string]any{
+		"spec": map[string]any{
+			"values": map[string]any{
+				"tcp": map[string]any{},
+				"udp": map[string]any{},
+			},
+		},
+	}
+	if !reflect.DeepEqual(expected, c.contents) {
+		t.Fatalf("Expected %v, got %v", expected, c.contents)
+	}
+}
+
+func TestRemoveNotFound(t *testing.T) {
+	c := &fakeClient{map[string]any{
+		"spec": map[string]any{
+			"values": map[string]any{},
+		},
+	}}
+	s := newServer(8080, c) // TODO(gio): run using unix socket
+	go func() {
+		s.Start()
+	}()
+	defer s.Close()
+	var buf bytes.Buffer
+	req := allocateReq{
+		Protocol:      "TCP",
+		SourcePort:    2222,
+		TargetService: "foo",
+		TargetPort:    22,
+	}
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post("http://localhost:8080/api/remove", "application/json", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		io.Copy(os.Stdout, resp.Body)
+		t.Fatalf("Expected %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}